@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/z0rr0/ssf/encrypt/age"
+	"github.com/z0rr0/ssf/encrypt/recipient"
+	"github.com/z0rr0/ssf/encrypt/stream"
+)
+
+const (
+	// downloadPath is a URL path of item downloads, it's a base of share links.
+	downloadPath = "/download"
+	// rawPath is a URL path of files encrypted by clients.
+	rawPath = "/raw"
+	// e2ePath is a URL path of the browser page for files encrypted by clients, it's a base of their share links.
+	e2ePath = "/e2e"
+	// recipientsPath is a URL path of recipient stanzas of files.
+	recipientsPath = "/recipients"
+	// passwordHeader is a header with the password of raw downloads of files with recipients.
+	passwordHeader = "Ssf-Password"
+	// keySize is a size of a key of files encrypted by the client.
+	keySize = 32
+	// ageFormat is a format of files which are stored and downloaded as age files.
+	ageFormat = "age"
+)
+
+// apiError is an error response of the service.
+type apiError struct {
+	Code    int
+	Message string `json:"error"`
+}
+
+// Error returns error message.
+func (e *apiError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("server error: %s", http.StatusText(e.Code))
+	}
+	return fmt.Sprintf("server error: %s (%s)", e.Message, http.StatusText(e.Code))
+}
+
+// share is a created item.
+type share struct {
+	ID       string    `json:"id"`
+	Password string    `json:"password"`
+	Expired  time.Time `json:"expired"`
+	Times    int       `json:"times"`
+	// Recipients is a number of public keys which can decrypt the file without the password.
+	Recipients int `json:"recipients"`
+}
+
+// shareParams are optional parameters of a new item.
+type shareParams struct {
+	ttl        int
+	times      int
+	password   string
+	generator  string
+	recipients recipientsFlag
+	format     string
+}
+
+// recipientsFlag is a repeatable flag of recipient public keys.
+type recipientsFlag []string
+
+// String returns comma separated recipients.
+func (f *recipientsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+// Set checks and adds a recipient.
+func (f *recipientsFlag) Set(value string) error {
+	if _, err := recipient.ParseRecipient(value); err != nil {
+		return err
+	}
+	*f = append(*f, value)
+	return nil
+}
+
+// fields returns not empty parameters as form values.
+func (p *shareParams) fields() url.Values {
+	values := url.Values{}
+	if p.ttl > 0 {
+		values.Set("ttl", fmt.Sprint(p.ttl))
+	}
+	if p.times > 0 {
+		values.Set("times", fmt.Sprint(p.times))
+	}
+	if p.password != "" {
+		values.Set("password", p.password)
+	}
+	if p.generator != "" {
+		values.Set("generator", p.generator)
+	}
+	for _, r := range p.recipients {
+		values.Add("recipient", r)
+	}
+	if p.format != "" {
+		values.Set("format", p.format)
+	}
+	return values
+}
+
+// client is HTTP API client of the service.
+type client struct {
+	server *url.URL
+	http   *http.Client
+}
+
+// newClient returns a new client for the server base URL.
+func newClient(server string) (*client, error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return nil, fmt.Errorf("server url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("server url %q must be absolute http(s) one", server)
+	}
+	return &client{server: u, http: &http.Client{}}, nil
+}
+
+// endpoint returns a full URL of API path.
+func (c *client) endpoint(path string) string {
+	u := *c.server
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawQuery = ""
+	return u.String()
+}
+
+// link returns a share link of the item, it contains the password if it's known.
+func (c *client) link(s *share) string {
+	u, _ := url.Parse(c.endpoint(downloadPath))
+	values := url.Values{"id": {s.ID}}
+	if s.Password != "" {
+		values.Set("password", s.Password)
+	}
+	u.RawQuery = values.Encode()
+	return u.String()
+}
+
+// rawLink returns a share link of the item encrypted by the client, the key is in URL fragment.
+// The fragment is never sent to the server.
+func (c *client) rawLink(s *share, key []byte) string {
+	u, _ := url.Parse(c.endpoint(e2ePath))
+	u.RawQuery = url.Values{"id": {s.ID}}.Encode()
+	u.Fragment = base64.RawURLEncoding.EncodeToString(key)
+	return u.String()
+}
+
+// put uploads the file content from src with the name.
+func (c *client) put(src io.Reader, name string, params *shareParams) (*share, error) {
+	pr, pw := io.Pipe()
+	w := multipart.NewWriter(pw)
+	go func() {
+		// item parameters must be sent before the file
+		err := writeFields(w, params.fields())
+		if err == nil {
+			var part io.Writer
+			if part, err = w.CreateFormFile("file", name); err == nil {
+				if _, err = io.Copy(part, src); err == nil {
+					err = w.Close()
+				}
+			}
+		}
+		pw.CloseWithError(err)
+	}()
+	resp, err := c.http.Post(c.endpoint("/upload"), w.FormDataContentType(), pr)
+	if err != nil {
+		_ = pr.CloseWithError(err)
+		return nil, fmt.Errorf("upload request: %w", err)
+	}
+	return readShare(resp)
+}
+
+// putRaw encrypts the content from src by the key and uploads it, the server never gets the key.
+func (c *client) putRaw(src io.Reader, key []byte, params *shareParams) (*share, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(stream.Encrypt(src, pw, key))
+	}()
+	u, _ := url.Parse(c.endpoint(rawPath))
+	u.RawQuery = params.fields().Encode()
+	resp, err := c.http.Post(u.String(), "application/octet-stream", pr)
+	if err != nil {
+		_ = pr.CloseWithError(err)
+		return nil, fmt.Errorf("upload request: %w", err)
+	}
+	return readShare(resp)
+}
+
+// note creates a new text note.
+func (c *client) note(text string, params *shareParams) (*share, error) {
+	values := params.fields()
+	values.Set("text", text)
+	resp, err := c.http.PostForm(c.endpoint("/note"), values)
+	if err != nil {
+		return nil, fmt.Errorf("note request: %w", err)
+	}
+	return readShare(resp)
+}
+
+// get writes item content to dst. The progress is called with a total size from the response,
+// it's -1 if the size is unknown. Not empty format requests the stored file in this format without decryption.
+func (c *client) get(id, password, format string, dst io.Writer, progress func(total int64) io.Writer) error {
+	values := url.Values{"id": {id}, "password": {password}}
+	if format != "" {
+		values.Set("format", format)
+	}
+	resp, err := c.http.PostForm(c.endpoint(downloadPath), values)
+	if err != nil {
+		return fmt.Errorf("download request: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return readError(resp)
+	}
+	if progress != nil {
+		dst = io.MultiWriter(dst, progress(resp.ContentLength))
+	}
+	if _, err = io.Copy(dst, resp.Body); err != nil {
+		return fmt.Errorf("download content: %w", err)
+	}
+	return nil
+}
+
+// getRaw writes the item content decrypted by the key to dst, it's a stream key or age file key.
+// The password is required for files with recipients and a password, it's empty for other ones.
+// Only authenticated stream and age formats are accepted, the progress is called with encrypted content size.
+func (c *client) getRaw(id, password string, key []byte, dst io.Writer, progress func(total int64) io.Writer) error {
+	u, _ := url.Parse(c.endpoint(rawPath))
+	u.RawQuery = url.Values{"id": {id}}.Encode()
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return fmt.Errorf("download request: %w", err)
+	}
+	if password != "" {
+		req.Header.Set(passwordHeader, password)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("download request: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return readError(resp)
+	}
+	var src io.Reader = resp.Body
+	if progress != nil {
+		src = io.TeeReader(src, progress(resp.ContentLength))
+	}
+	buffered := bufio.NewReader(src)
+	if prefix, e := buffered.Peek(len(age.Magic)); e == nil && string(prefix) == age.Magic {
+		if err = age.Decrypt(buffered, dst, key); err != nil {
+			return fmt.Errorf("decrypt age content: %w", err)
+		}
+		return nil
+	}
+	header, err := buffered.Peek(stream.HeaderSize)
+	if err != nil {
+		return fmt.Errorf("read header: %w", err)
+	}
+	if err = stream.CheckHeader(header); err != nil {
+		return err
+	}
+	if err = stream.Decrypt(buffered, dst, key); err != nil {
+		return fmt.Errorf("decrypt content: %w", err)
+	}
+	return nil
+}
+
+// getRecipients returns the file key unwrapped by any of the identities from recipient stanzas of the item.
+func (c *client) getRecipients(id string, identities []*recipient.Identity) ([]byte, error) {
+	u, _ := url.Parse(c.endpoint(recipientsPath))
+	u.RawQuery = url.Values{"id": {id}}.Encode()
+	resp, err := c.http.Get(u.String())
+	if err != nil {
+		return nil, fmt.Errorf("recipients request: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, readError(resp)
+	}
+	stanzas, err := recipient.Unmarshal(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read recipients: %w", err)
+	}
+	return recipient.Unwrap(stanzas, identities)
+}
+
+// remove deletes the item.
+func (c *client) remove(id, password string) error {
+	resp, err := c.http.PostForm(c.endpoint("/delete"), url.Values{"id": {id}, "password": {password}})
+	if err != nil {
+		return fmt.Errorf("delete request: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusNoContent {
+		return readError(resp)
+	}
+	return nil
+}
+
+// writeFields writes form fields to the multipart writer.
+func writeFields(w *multipart.Writer, values url.Values) error {
+	for name, list := range values {
+		for _, value := range list {
+			if err := w.WriteField(name, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readShare returns created item from the response.
+func readShare(resp *http.Response) (*share, error) {
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusCreated {
+		return nil, readError(resp)
+	}
+	s := &share{}
+	if err := json.NewDecoder(resp.Body).Decode(s); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return s, nil
+}
+
+// readError returns API error from the response.
+func readError(resp *http.Response) error {
+	e := &apiError{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(e); err != nil {
+		e.Message = ""
+	}
+	e.Code = resp.StatusCode
+	return e
+}
+
+// shareLink contains share link parameters, the key is set only for items encrypted by the client.
+// The password is empty for files which can be decrypted only by recipients.
+type shareLink struct {
+	server   string
+	id       string
+	password string
+	key      []byte
+}
+
+// parseLink returns share link parameters. It can be a download link with a password
+// or a link of an item encrypted by the client with the key in URL fragment.
+func parseLink(link string) (*shareLink, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, fmt.Errorf("share link: %w", err)
+	}
+	s := &shareLink{id: u.Query().Get("id")}
+	switch {
+	case strings.HasSuffix(u.Path, e2ePath):
+		if s.key, err = base64.RawURLEncoding.DecodeString(u.Fragment); err != nil || len(s.key) != keySize {
+			return nil, errors.New("share link must contain a valid key")
+		}
+		u.Path = strings.TrimSuffix(u.Path, e2ePath)
+	case strings.HasSuffix(u.Path, downloadPath):
+		s.password = u.Query().Get("password")
+		u.Path = strings.TrimSuffix(u.Path, downloadPath)
+	default:
+		return nil, errors.New("share link must contain download path")
+	}
+	if s.id == "" {
+		return nil, errors.New("share link must contain id")
+	}
+	u.RawQuery, u.Fragment = "", ""
+	s.server = u.String()
+	return s, nil
+}
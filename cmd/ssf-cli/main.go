@@ -0,0 +1,476 @@
+package main
+
+// Package main is a command line client of the safe share files HTTP service.
+//
+// Usage:
+//
+//	ssf-cli [-server URL] [-q] put [-ttl N] [-times N] [-password P | -generator G | -e2e] [-recipient R...] [-age] [FILE]
+//	ssf-cli [-server URL] [-q] get [-o FILE] [-i IDENTITY_FILE | -age] LINK
+//	ssf-cli [-server URL] [-q] note [-ttl N] [-times N] [-password P | -generator G] [TEXT]
+//	ssf-cli [-server URL] [-q] delete [-password P] ID|LINK
+//	ssf-cli [-q] keygen [-o FILE]
+//
+// Missing or "-" FILE and TEXT are read from stdin, downloaded content is written to stdout by default.
+// Created items are printed as share links with passwords.
+// With -e2e flag the file is encrypted by a random key before upload, the server never gets it.
+// The key is printed in the share link fragment, such links can be opened by "get" command or a browser.
+// With -recipient flags the file key is also wrapped to X25519 public keys "age1...", created by "keygen"
+// command or age-keygen tool. Without a password or generator flag the link has no password,
+// such file can be downloaded only by "get -i" command with an identity file of any recipient.
+// With -age flag the file is stored in age format, "get -age" downloads it without decryption,
+// so it can be decrypted by age tool with the password or an identity of any recipient.
+
+import (
+	"bufio"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/z0rr0/ssf/encrypt/recipient"
+)
+
+const (
+	// defaultServer is the service URL if it's not set by a flag or environment variable.
+	defaultServer = "http://localhost:8082"
+	// serverEnv is an environment variable with the service URL.
+	serverEnv = "SSF_SERVER"
+	// maxNoteSize is a max size of note text, it's the same as the service limit.
+	maxNoteSize = 64 << 10
+)
+
+// Exit codes, API errors have own codes.
+const (
+	exitOK = iota
+	exitError
+	exitUsage
+	exitSecret
+	exitNotFound
+	exitLimit
+	exitSize
+)
+
+// errUsage is an error of command line arguments.
+var errUsage = errors.New("invalid arguments")
+
+// app is a command environment.
+type app struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	server string
+	quiet  bool
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes a command with the arguments and returns the exit code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	server := os.Getenv(serverEnv)
+	if server == "" {
+		server = defaultServer
+	}
+	a := &app{stdin: stdin, stdout: stdout, stderr: stderr}
+	fs := flag.NewFlagSet("ssf-cli", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&a.server, "server", server, "service URL, "+serverEnv+" environment variable can be used")
+	fs.BoolVar(&a.quiet, "q", false, "quiet mode without progress and details")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: ssf-cli [flags] put|get|note|delete|keygen [command flags] [arguments]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	var (
+		cmd  = fs.Arg(0)
+		err  error
+		rest = fs.Args()[1:]
+	)
+	switch cmd {
+	case "put":
+		err = a.put(rest)
+	case "get":
+		err = a.get(rest)
+	case "note":
+		err = a.note(rest)
+	case "delete":
+		err = a.remove(rest)
+	case "keygen":
+		err = a.keygen(rest)
+	default:
+		err = fmt.Errorf("%w: unknown command %q", errUsage, cmd)
+	}
+	if err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(stderr, "ssf-cli %s: %v\n", cmd, err)
+		}
+		return exitCode(err)
+	}
+	return exitOK
+}
+
+// exitCode returns the exit code for the error.
+func exitCode(err error) int {
+	var e *apiError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, errUsage), errors.Is(err, flag.ErrHelp):
+		return exitUsage
+	case errors.As(err, &e):
+		switch e.Code {
+		case http.StatusForbidden:
+			return exitSecret
+		case http.StatusNotFound:
+			return exitNotFound
+		case http.StatusGone:
+			return exitLimit
+		case http.StatusRequestEntityTooLarge:
+			return exitSize
+		}
+	}
+	return exitError
+}
+
+// progress returns a progress bar for total size if it's enabled.
+func (a *app) progress(total int64) *progressBar {
+	if a.quiet || !isTerminal(a.stderr) {
+		return nil
+	}
+	return &progressBar{w: a.stderr, total: total}
+}
+
+// printShare writes the share link to stdout and its details to stderr.
+func (a *app) printShare(link string, s *share) {
+	fmt.Fprintln(a.stdout, link)
+	if !a.quiet {
+		fmt.Fprintf(a.stderr, "id: %s\nexpired: %s\ntimes: %d\n", s.ID, s.Expired.Local(), s.Times)
+		if s.Recipients > 0 {
+			fmt.Fprintf(a.stderr, "recipients: %d\n", s.Recipients)
+		}
+	}
+}
+
+// put uploads a file or stdin content.
+func (a *app) put(args []string) error {
+	fs, params := newShareFlags("put", a.stderr)
+	e2e := fs.Bool("e2e", false, "encrypt the file by the client, the server never gets the key")
+	fs.Var(&params.recipients, "recipient", `recipient public key "age1...", it can be repeated`)
+	ageFile := fs.Bool("age", false, "store the file in age format")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("%w: only one file is expected", errUsage)
+	}
+	if *e2e && (params.password != "" || params.generator != "") {
+		return fmt.Errorf("%w: password can not be used with client encryption", errUsage)
+	}
+	if *e2e && (len(params.recipients) > 0 || *ageFile) {
+		return fmt.Errorf("%w: recipients and age format can not be used with client encryption", errUsage)
+	}
+	if *ageFile {
+		params.format = ageFormat
+	}
+	c, err := newClient(a.server)
+	if err != nil {
+		return err
+	}
+	var (
+		src   = a.stdin
+		name  = "stdin"
+		total = int64(-1)
+	)
+	if len(args) == 1 && args[0] != "-" {
+		f, e := os.Open(args[0])
+		if e != nil {
+			return e
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		if info, e := f.Stat(); e == nil {
+			total = info.Size()
+		}
+		src, name = f, filepath.Base(args[0])
+	}
+	bar := a.progress(total)
+	if bar != nil {
+		src = io.TeeReader(src, bar)
+	}
+	var (
+		s    *share
+		link string
+	)
+	if *e2e {
+		key := make([]byte, keySize)
+		if _, err = rand.Read(key); err != nil {
+			return fmt.Errorf("generate key: %w", err)
+		}
+		if s, err = c.putRaw(src, key, params); err == nil {
+			link = c.rawLink(s, key)
+		}
+	} else if s, err = c.put(src, name, params); err == nil {
+		link = c.link(s)
+	}
+	if bar != nil {
+		bar.finish()
+	}
+	if err != nil {
+		return err
+	}
+	a.printShare(link, s)
+	return nil
+}
+
+// get downloads an item by its share link.
+func (a *app) get(args []string) error {
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	output := fs.String("o", "-", "output file, stdout by default")
+	identityFile := fs.String("i", "", "identity file with recipient private keys")
+	ageFile := fs.Bool("age", false, "download the stored age file without decryption")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("%w: share link is expected", errUsage)
+	}
+	link, err := parseLink(args[0])
+	if err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if link.key == nil && link.password == "" && *identityFile == "" {
+		return fmt.Errorf("%w: share link must contain password or key, or identity file is required", errUsage)
+	}
+	if *ageFile && (link.password == "" || *identityFile != "") {
+		return fmt.Errorf("%w: age file is downloaded only by share link with password", errUsage)
+	}
+	c, err := newClient(link.server)
+	if err != nil {
+		return err
+	}
+	if *identityFile != "" && link.key == nil {
+		identities, e := readIdentities(*identityFile)
+		if e != nil {
+			return e
+		}
+		if link.key, err = c.getRecipients(link.id, identities); err != nil {
+			return err
+		}
+	}
+	var bar *progressBar
+	progress := func(total int64) io.Writer {
+		if bar = a.progress(total); bar == nil {
+			return io.Discard
+		}
+		return bar
+	}
+	get := func(dst io.Writer) error {
+		if link.key != nil {
+			return c.getRaw(link.id, link.password, link.key, dst, progress)
+		}
+		var format string
+		if *ageFile {
+			format = ageFormat
+		}
+		return c.get(link.id, link.password, format, dst, progress)
+	}
+	if *output == "-" {
+		err = get(a.stdout)
+	} else {
+		err = download(*output, get)
+	}
+	if bar != nil {
+		bar.finish()
+	}
+	return err
+}
+
+// download writes item content by get function to a new file, it's removed if the download is failed.
+func download(name string, get func(io.Writer) error) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if err = get(f); err != nil {
+		_ = f.Close()
+		if e := os.Remove(name); e != nil {
+			return fmt.Errorf("%w, remove file: %v", err, e)
+		}
+		return err
+	}
+	return f.Close()
+}
+
+// readIdentities returns recipient private keys from the file.
+func readIdentities(name string) ([]*recipient.Identity, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	identities, err := recipient.ParseIdentities(f)
+	if err != nil {
+		return nil, fmt.Errorf("identity file %s: %w", name, err)
+	}
+	return identities, nil
+}
+
+// keygen creates a new identity file, its public key is printed to stderr.
+func (a *app) keygen(args []string) error {
+	fs := flag.NewFlagSet("keygen", flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	output := fs.String("o", "-", "output file, stdout by default")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		return fmt.Errorf("%w: no arguments are expected", errUsage)
+	}
+	identity, err := recipient.GenerateIdentity()
+	if err != nil {
+		return err
+	}
+	public := identity.Recipient().String()
+	content := fmt.Sprintf(
+		"# created: %s\n# public key: %s\n%s\n", time.Now().Format(time.RFC3339), public, identity.String(),
+	)
+	if *output == "-" {
+		_, err = io.WriteString(a.stdout, content)
+	} else {
+		err = download(*output, func(w io.Writer) error {
+			_, e := io.WriteString(w, content)
+			return e
+		})
+	}
+	if err != nil {
+		return err
+	}
+	if !a.quiet {
+		fmt.Fprintf(a.stderr, "Public key: %s\n", public)
+	}
+	return nil
+}
+
+// note creates a text note from the argument or stdin.
+func (a *app) note(args []string) error {
+	fs, params := newShareFlags("note", a.stderr)
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("%w: only one text is expected, use quotes", errUsage)
+	}
+	c, err := newClient(a.server)
+	if err != nil {
+		return err
+	}
+	var text string
+	if len(args) == 1 && args[0] != "-" {
+		text = args[0]
+	} else {
+		b, e := io.ReadAll(io.LimitReader(a.stdin, maxNoteSize+1))
+		if e != nil {
+			return fmt.Errorf("read stdin: %w", e)
+		}
+		text = string(b)
+	}
+	s, err := c.note(text, params)
+	if err != nil {
+		return err
+	}
+	a.printShare(c.link(s), s)
+	return nil
+}
+
+// remove deletes an item by its share link or ID and password.
+// The password is read from the first line of stdin if it's not set.
+func (a *app) remove(args []string) error {
+	fs := flag.NewFlagSet("delete", flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	password := fs.String("password", "", "item password, it is read from stdin if empty")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("%w: item ID or share link is expected", errUsage)
+	}
+	server, id := a.server, args[0]
+	if strings.Contains(id, "://") {
+		link, e := parseLink(id)
+		if e != nil {
+			return fmt.Errorf("%w: %v", errUsage, e)
+		}
+		if link.key != nil {
+			return fmt.Errorf("%w: items encrypted by the client can not be deleted", errUsage)
+		}
+		server, id, *password = link.server, link.id, link.password
+	}
+	if *password == "" {
+		line, e := bufio.NewReader(a.stdin).ReadString('\n')
+		if e != nil && e != io.EOF {
+			return fmt.Errorf("read password: %w", e)
+		}
+		*password = strings.TrimSpace(line)
+	}
+	c, err := newClient(server)
+	if err != nil {
+		return err
+	}
+	return c.remove(id, *password)
+}
+
+// newShareFlags returns command flags for new item parameters.
+func newShareFlags(name string, output io.Writer) (*flag.FlagSet, *shareParams) {
+	params := &shareParams{}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.IntVar(&params.ttl, "ttl", 0, "time to live in seconds, max allowed value by default")
+	fs.IntVar(&params.times, "times", 0, "max number of downloads, max allowed value by default")
+	fs.StringVar(&params.password, "password", "", "item password, it is generated if empty")
+	fs.StringVar(
+		&params.generator, "generator", "", `generated password type "symbols" or "words", server default if empty`,
+	)
+	return fs, params
+}
+
+// parseFlags parses flags which can be set before and after positional arguments.
+// It returns positional arguments.
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: %v", errUsage, err)
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
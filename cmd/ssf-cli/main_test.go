@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/z0rr0/ssf/config"
+	"github.com/z0rr0/ssf/encrypt/age"
+	"github.com/z0rr0/ssf/encrypt/recipient"
+	"github.com/z0rr0/ssf/handle"
+)
+
+const testConfig = `
+[server]
+host = "localhost"
+port = 8082
+timeout = 30
+debug = true
+
+[storage]
+file = "{dir}/db.sqlite"
+dir = "{dir}/storage"
+size = 2
+
+[settings]
+ttl = 60
+times = 2
+size = 1
+salt = "abc"
+gc = 1
+passlen = 8
+scrypt = 10
+shutdown = 1
+
+[settings.argon]
+time = 1
+memory = 1
+threads = 1
+`
+
+func newServer(t *testing.T) *httptest.Server {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "config.toml")
+	content := strings.ReplaceAll(testConfig, "{dir}", dir)
+	if err := os.WriteFile(fileName, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.New(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(handle.New(cfg))
+	t.Cleanup(func() {
+		server.Close()
+		if e := cfg.Close(); e != nil {
+			t.Error(e)
+		}
+	})
+	return server
+}
+
+// runCmd executes the command and returns its exit code and stdout.
+func runCmd(t *testing.T, stdin string, args ...string) (int, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	if code != exitOK {
+		t.Logf("ssf-cli %v: %s", args, stderr.String())
+	}
+	return code, stdout.String()
+}
+
+func TestPutGet(t *testing.T) {
+	server := newServer(t)
+	dir := t.TempDir()
+	content := bytes.Repeat([]byte("file content "), 10000)
+	name := filepath.Join(dir, "file.txt")
+	if err := os.WriteFile(name, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	code, out := runCmd(t, "", "-server", server.URL, "put", name, "--times", "1", "--password", "my password")
+	if code != exitOK {
+		t.Fatalf("failed put code=%d", code)
+	}
+	link := strings.TrimSpace(out)
+	if !strings.Contains(link, "password=my+password") {
+		t.Errorf("failed link=%s", link)
+	}
+	output := filepath.Join(dir, "output.txt")
+	if code, _ = runCmd(t, "", "get", "-o", output, link); code != exitOK {
+		t.Fatalf("failed get code=%d", code)
+	}
+	b, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, content) {
+		t.Error("failed downloaded content")
+	}
+	// the item was downloaded max number of times
+	output = filepath.Join(dir, "other.txt")
+	if code, _ = runCmd(t, "", "get", "-o", output, link); code != exitNotFound {
+		t.Errorf("failed get code=%d", code)
+	}
+	if _, err = os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("failed download file is not removed: %v", err)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	server := newServer(t)
+	code, out := runCmd(t, "stdin content", "-server", server.URL, "-q", "put", "-")
+	if code != exitOK {
+		t.Fatalf("failed put code=%d", code)
+	}
+	link := strings.TrimSpace(out)
+	if code, out = runCmd(t, "", "get", link); code != exitOK || out != "stdin content" {
+		t.Errorf("failed get code=%d, output=%q", code, out)
+	}
+	if code, _ = runCmd(t, "", "get", strings.Replace(link, "password=", "password=x", 1)); code != exitSecret {
+		t.Errorf("failed get code=%d for wrong password", code)
+	}
+}
+
+func TestPutGetE2E(t *testing.T) {
+	server := newServer(t)
+	content := bytes.Repeat([]byte("secret content "), 10000)
+	code, out := runCmd(t, string(content), "-server", server.URL, "-q", "put", "-e2e", "--times", "1")
+	if code != exitOK {
+		t.Fatalf("failed put code=%d", code)
+	}
+	link := strings.TrimSpace(out)
+	if !strings.Contains(link, "/e2e?id=") || strings.Contains(link, "password=") {
+		t.Errorf("failed link=%s", link)
+	}
+	parsed, err := parseLink(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.key) != keySize {
+		t.Errorf("failed key length %d", len(parsed.key))
+	}
+	// other key fails authentication and the download is counted
+	other := link[:strings.Index(link, "#")+1] + strings.Repeat("A", 43)
+	if code, _ = runCmd(t, "", "get", other); code != exitError {
+		t.Errorf("failed get code=%d for wrong key", code)
+	}
+	if code, _ = runCmd(t, "", "get", link); code != exitNotFound {
+		t.Errorf("failed get code=%d for used item", code)
+	}
+	code, out = runCmd(t, string(content), "-server", server.URL, "-q", "put", "-e2e")
+	if code != exitOK {
+		t.Fatalf("failed put code=%d", code)
+	}
+	link = strings.TrimSpace(out)
+	if code, out = runCmd(t, "", "get", link); code != exitOK || out != string(content) {
+		t.Errorf("failed get code=%d", code)
+	}
+	if code, _ = runCmd(t, "", "delete", link); code != exitUsage {
+		t.Errorf("failed delete code=%d for client encrypted item", code)
+	}
+	if code, _ = runCmd(t, "", "-server", server.URL, "put", "-e2e", "-password", "my password"); code != exitUsage {
+		t.Errorf("failed put code=%d for password", code)
+	}
+}
+
+func TestPutGetRecipients(t *testing.T) {
+	server := newServer(t)
+	dir := t.TempDir()
+	identityFile := filepath.Join(dir, "key.txt")
+	if code, _ := runCmd(t, "", "-q", "keygen", "-o", identityFile); code != exitOK {
+		t.Fatalf("failed keygen code=%d", code)
+	}
+	identities, err := readIdentities(identityFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := runCmd(t, "", "keygen", "-o", identityFile); code != exitError {
+		t.Errorf("failed keygen code=%d for existing file", code)
+	}
+	code, out := runCmd(t, "", "keygen")
+	if code != exitOK {
+		t.Fatalf("failed keygen code=%d", code)
+	}
+	other := filepath.Join(dir, "other.txt")
+	if err = os.WriteFile(other, []byte(out), 0600); err != nil {
+		t.Fatal(err)
+	}
+	public := identities[0].Recipient().String()
+	code, out = runCmd(t, "recipient content", "-server", server.URL, "-q", "put", "-recipient", public, "-times", "1")
+	if code != exitOK {
+		t.Fatalf("failed put code=%d", code)
+	}
+	link := strings.TrimSpace(out)
+	if strings.Contains(link, "password=") {
+		t.Errorf("failed link=%s", link)
+	}
+	if code, _ = runCmd(t, "", "get", link); code != exitUsage {
+		t.Errorf("failed get code=%d without identity", code)
+	}
+	if code, _ = runCmd(t, "", "get", "-i", other, link); code != exitError {
+		t.Errorf("failed get code=%d for other identity", code)
+	}
+	if code, out = runCmd(t, "", "get", "-i", identityFile, link); code != exitOK || out != "recipient content" {
+		t.Errorf("failed get code=%d, output=%q", code, out)
+	}
+	if code, _ = runCmd(t, "", "get", "-i", identityFile, link); code != exitNotFound {
+		t.Errorf("failed get code=%d for used item", code)
+	}
+	// the password link works together with recipients
+	args := []string{"-server", server.URL, "-q", "put", "-recipient", public, "-password", "my password"}
+	if code, out = runCmd(t, "content", args...); code != exitOK {
+		t.Fatalf("failed put code=%d", code)
+	}
+	link = strings.TrimSpace(out)
+	if code, out = runCmd(t, "", "get", "-i", identityFile, link); code != exitOK || out != "content" {
+		t.Errorf("failed get code=%d, output=%q", code, out)
+	}
+	if code, out = runCmd(t, "", "get", link); code != exitOK || out != "content" {
+		t.Errorf("failed get code=%d, output=%q", code, out)
+	}
+	args = []string{"-server", server.URL, "put", "-e2e", "-recipient", public}
+	if code, _ = runCmd(t, "", args...); code != exitUsage {
+		t.Errorf("failed put code=%d for client encryption", code)
+	}
+}
+
+func TestPutGetAge(t *testing.T) {
+	server := newServer(t)
+	args := []string{"-server", server.URL, "-q", "put", "-age", "-password", "age password"}
+	code, out := runCmd(t, "age content", args...)
+	if code != exitOK {
+		t.Fatalf("failed put code=%d", code)
+	}
+	link := strings.TrimSpace(out)
+	if code, out = runCmd(t, "", "get", link); code != exitOK || out != "age content" {
+		t.Errorf("failed get code=%d, output=%q", code, out)
+	}
+	code, out = runCmd(t, "", "get", "-age", link)
+	if code != exitOK || !strings.HasPrefix(out, age.Magic) {
+		t.Fatalf("failed get code=%d, output=%q", code, out)
+	}
+	passphrase, err := age.NewPassphrase("age password", age.DefaultWorkFactor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var plain bytes.Buffer
+	err = age.DecryptIdentities(strings.NewReader(out), &plain, []age.Identity{passphrase})
+	if err != nil || plain.String() != "age content" {
+		t.Errorf("failed decrypt error=%v, content=%q", err, plain.String())
+	}
+	// age files with recipients are decrypted by the client
+	identity, err := recipient.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	identityFile := filepath.Join(t.TempDir(), "key.txt")
+	if err = os.WriteFile(identityFile, []byte(identity.String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	args = []string{"-server", server.URL, "-q", "put", "-age", "-recipient", identity.Recipient().String()}
+	if code, out = runCmd(t, "recipient content", args...); code != exitOK {
+		t.Fatalf("failed put code=%d", code)
+	}
+	link = strings.TrimSpace(out)
+	if code, _ = runCmd(t, "", "get", "-age", "-i", identityFile, link); code != exitUsage {
+		t.Errorf("failed get code=%d for age without password", code)
+	}
+	if code, out = runCmd(t, "", "get", "-i", identityFile, link); code != exitOK || out != "recipient content" {
+		t.Errorf("failed get code=%d, output=%q", code, out)
+	}
+	if code, _ = runCmd(t, "", "-server", server.URL, "put", "-e2e", "-age"); code != exitUsage {
+		t.Errorf("failed put code=%d for client encryption", code)
+	}
+}
+
+func TestNote(t *testing.T) {
+	server := newServer(t)
+	for _, args := range [][]string{{"note", "secret text"}, {"note", "-ttl", "10"}} {
+		code, out := runCmd(t, "secret text", append([]string{"-server", server.URL}, args...)...)
+		if code != exitOK {
+			t.Fatalf("failed note code=%d", code)
+		}
+		if code, out = runCmd(t, "", "get", strings.TrimSpace(out)); code != exitOK || out != "secret text" {
+			t.Errorf("failed get code=%d, output=%q", code, out)
+		}
+	}
+	if code, _ := runCmd(t, "", "-server", server.URL, "note", "text", "--ttl", "1000"); code != exitError {
+		t.Errorf("failed note code=%d for invalid ttl", code)
+	}
+}
+
+func TestNoteGenerator(t *testing.T) {
+	server := newServer(t)
+	code, out := runCmd(t, "", "-server", server.URL, "note", "text", "-generator", "words")
+	if code != exitOK {
+		t.Fatalf("failed note code=%d", code)
+	}
+	link, err := parseLink(strings.TrimSpace(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if words := strings.Split(link.password, "-"); len(words) != 6 {
+		t.Errorf("failed passphrase %q", link.password)
+	}
+	if code, out = runCmd(t, "", "get", strings.TrimSpace(out)); code != exitOK || out != "text" {
+		t.Errorf("failed get code=%d, output=%q", code, out)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	server := newServer(t)
+	code, out := runCmd(t, "", "-server", server.URL, "note", "text", "-password", "my password")
+	if code != exitOK {
+		t.Fatalf("failed note code=%d", code)
+	}
+	link := strings.TrimSpace(out)
+	parsed, err := parseLink(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := parsed.id
+	if code, _ = runCmd(t, "bad password\n", "-server", server.URL, "delete", id); code != exitSecret {
+		t.Errorf("failed delete code=%d for wrong password", code)
+	}
+	if code, _ = runCmd(t, "my password\n", "-server", server.URL, "delete", id); code != exitOK {
+		t.Errorf("failed delete code=%d", code)
+	}
+	if code, _ = runCmd(t, "", "delete", link); code != exitNotFound {
+		t.Errorf("failed delete code=%d for deleted item", code)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	cases := [][]string{
+		{},
+		{"unknown"},
+		{"get"},
+		{"get", "http://localhost/download?id=1"},
+		{"put", "a", "b"},
+		{"delete"},
+		{"note", "-ttl", "abc"},
+		{"put", "-recipient", "age1invalid"},
+		{"keygen", "a"},
+	}
+	for _, args := range cases {
+		if code, _ := runCmd(t, "", args...); code != exitUsage {
+			t.Errorf("failed code=%d for %v", code, args)
+		}
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	cases := map[int]int{403: exitSecret, 404: exitNotFound, 410: exitLimit, 413: exitSize, 500: exitError}
+	for code, expected := range cases {
+		if c := exitCode(&apiError{Code: code}); c != expected {
+			t.Errorf("failed exit code=%d for status=%d", c, code)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	cases := map[int64]string{0: "0 B", 1023: "1023 B", 1536: "1.5 KiB", 5 << 20: "5.0 MiB", 3 << 30: "3.0 GiB"}
+	for n, expected := range cases {
+		if s := formatSize(n); s != expected {
+			t.Errorf("failed size=%q for %d", s, n)
+		}
+	}
+}
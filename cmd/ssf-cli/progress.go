@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// barWidth is a number of progress bar characters.
+	barWidth = 30
+	// renderPeriod is a min period between progress bar updates.
+	renderPeriod = 100 * time.Millisecond
+)
+
+// progressBar is a writer which shows a number of written bytes,
+// total size is unknown if it's negative.
+type progressBar struct {
+	w       io.Writer
+	total   int64
+	current int64
+	last    time.Time
+}
+
+// Write counts written bytes and updates the progress bar.
+func (p *progressBar) Write(b []byte) (int, error) {
+	p.current += int64(len(b))
+	if now := time.Now(); now.Sub(p.last) >= renderPeriod || p.current == p.total {
+		p.last = now
+		p.render()
+	}
+	return len(b), nil
+}
+
+// render writes the current progress bar state.
+func (p *progressBar) render() {
+	if p.total <= 0 {
+		fmt.Fprintf(p.w, "\r%s", formatSize(p.current))
+		return
+	}
+	done := int(p.current * barWidth / p.total)
+	if done > barWidth {
+		done = barWidth
+	}
+	fmt.Fprintf(
+		p.w, "\r[%s%s] %3d%% %s / %s",
+		strings.Repeat("=", done), strings.Repeat(" ", barWidth-done),
+		p.current*100/p.total, formatSize(p.current), formatSize(p.total),
+	)
+}
+
+// finish writes the final progress bar state.
+func (p *progressBar) finish() {
+	p.render()
+	fmt.Fprintln(p.w)
+}
+
+// formatSize returns human-readable size.
+func formatSize(n int64) string {
+	const unit = 1 << 10
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, prefix := float64(n)/unit, 0
+	for ; value >= unit && prefix < 3; prefix++ {
+		value /= unit
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGT"[prefix])
+}
+
+// isTerminal returns true if w is a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,118 @@
+package main
+
+// Package main is the safe share files HTTP service.
+//
+// Usage:
+//
+//	ssf [-config FILE]
+//	ssf [-config FILE] rotate-master-key
+//
+// The command rotate-master-key rewraps data keys of all stored items by the current (last) master key
+// and exits, encrypted files are not changed. Previous master keys are required for it,
+// they can be removed from the configuration after the rotation.
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/z0rr0/ssf/config"
+	"github.com/z0rr0/ssf/db"
+	"github.com/z0rr0/ssf/gc"
+	"github.com/z0rr0/ssf/handle"
+)
+
+// rotateCommand is a command to rewrap data keys by the current master key.
+const rotateCommand = "rotate-master-key"
+
+func main() {
+	configFile := flag.String("config", "config.toml", "configuration file")
+	flag.Parse()
+
+	switch command := flag.Arg(0); {
+	case command == rotateCommand && flag.NArg() == 1:
+		if err := rotateMasterKey(*configFile); err != nil {
+			log.Fatalf("rotate master key error: %v", err)
+		}
+		return
+	case flag.NArg() > 0:
+		log.Fatalf("unknown command %q, only %q is supported", command, rotateCommand)
+	}
+
+	cfg, err := config.New(*configFile)
+	if err != nil {
+		log.Fatalf("configuration error: %v", err)
+	}
+	defer func() {
+		if e := cfg.Close(); e != nil {
+			log.Printf("close configuration error: %v", e)
+		}
+	}()
+	log.Printf("storage: %v", &cfg.Storage)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server := &http.Server{
+		Addr:              cfg.Addr(),
+		Handler:           handle.New(cfg),
+		ReadHeaderTimeout: cfg.Timeout(),
+		IdleTimeout:       cfg.Timeout(),
+	}
+	gcDone := gc.Run(ctx, cfg, gc.SystemClock{})
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Printf("listen %s", cfg.Addr())
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err = <-serverErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("server error: %v", err)
+		}
+		stop()
+	case <-ctx.Done():
+		log.Println("shutdown the server")
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout())
+	defer cancel()
+	if err = server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("shutdown error: %v", err)
+	}
+	select {
+	case <-gcDone:
+	case <-shutdownCtx.Done():
+		log.Println("gc is not stopped during shutdown timeout")
+	}
+	log.Println("stopped")
+}
+
+// rotateMasterKey rewraps data keys of all items and uploads by the current master key.
+func rotateMasterKey(configFile string) error {
+	cfg, err := config.New(configFile)
+	if err != nil {
+		return fmt.Errorf("configuration: %w", err)
+	}
+	defer func() {
+		if e := cfg.Close(); e != nil {
+			log.Printf("close configuration error: %v", e)
+		}
+	}()
+	keyring := cfg.Keyring()
+	if keyring == nil {
+		return fmt.Errorf("master keys are not configured by settings.master or %s", config.MasterKeysEnv)
+	}
+	n, err := db.RotateKeys(context.Background(), cfg.Storage.Db, keyring)
+	if err != nil {
+		return err
+	}
+	log.Printf("%d data keys are wrapped by master key %q", n, keyring.Current())
+	return nil
+}
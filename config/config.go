@@ -1,43 +1,129 @@
 package config
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"io/fs"
 	"net"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3" // SQLite3 driver package
+	"github.com/pelletier/go-toml/v2"
+
+	"github.com/z0rr0/ssf/db"
+	"github.com/z0rr0/ssf/encrypt"
+	"github.com/z0rr0/ssf/encrypt/age"
+	"github.com/z0rr0/ssf/encrypt/pwgen"
+)
+
+const (
+	// defaultSalt is a salt value from the example configuration file.
+	defaultSalt = "abc"
+	// maxTTL is a cap for items time to live (seconds) - 30 days.
+	maxTTL = 30 * 24 * 60 * 60
+	// minPassLen is a minimal length of automatically created passwords.
+	minPassLen = 8
+	// maxPassLen is a max length of passwords, it's a max size of request form fields.
+	maxPassLen = 64
+	// maxStrength is a max required strength of user passwords in bits.
+	maxStrength = 128
+	// minScrypt is a min scrypt work factor of age passphrases.
+	minScrypt = 10
+	// defaultWords is a default number of words in created passphrases.
+	defaultWords = 6
+	// defaultSeparator is a default separator of words in created passphrases.
+	defaultSeparator = "-"
+	// busyTimeout is SQLite timeout to wait a database lock (milliseconds).
+	busyTimeout = 10000
+	// MasterKeysEnv is an environment variable with master keys, it's used instead of settings.master file.
+	MasterKeysEnv = "SSF_MASTER_KEYS"
+)
+
+// Names of password generators.
+const (
+	// SymbolsGenerator creates passwords from alphabet symbols.
+	SymbolsGenerator = "symbols"
+	// WordsGenerator creates passphrases from EFF word list.
+	WordsGenerator = "words"
 )
 
 var (
 	// ErrSizeLimit is an error, when storage limit is reached.
 	ErrSizeLimit = errors.New("size limit is reached")
+
+	// ErrConfig is an error, when configuration parameter is invalid.
+	ErrConfig = errors.New("invalid configuration")
 )
 
+// keyError returns ErrConfig based error with the name of failed configuration key.
+func keyError(key string, value interface{}, reason string) error {
+	return fmt.Errorf("%w: %s=%v %s", ErrConfig, key, value, reason)
+}
+
 // server is HTTP server configuration.
 type server struct {
 	Host    string `toml:"host"`
 	Port    int    `toml:"port"`
 	Timeout int    `toml:"timeout"`
+	Debug   bool   `toml:"debug"`
+}
+
+// validate checks server parameters.
+func (s *server) validate() error {
+	if s.Port < 1 || s.Port > 65535 {
+		return keyError("server.port", s.Port, "is out of range [1, 65535]")
+	}
+	if s.Timeout < 1 {
+		return keyError("server.timeout", s.Timeout, "must be positive")
+	}
+	return nil
 }
 
 // Storage is storage configuration params struct.
+// It tracks used space: committed bytes of stored files (limit)
+// and reserved bytes of files which are being uploaded now.
 type Storage struct {
 	sync.Mutex
-	File  string `toml:"file"`
-	Dir   string `toml:"dir"`
-	Size  int64  `toml:"size"`
-	limit int64
-	Db    *sql.DB
+	File     string `toml:"file"`
+	Dir      string `toml:"dir"`
+	Size     int64  `toml:"size"`
+	limit    int64
+	reserved int64
+	open     int    // number of not finished reservations
+	changes  uint64 // counter of used space changes
+	Db       *sql.DB
 }
 
 // String returns base info about Storage.
 func (s *Storage) String() string {
-	return fmt.Sprintf("database=%s, files=%s, limit=%d/%d", s.File, s.Dir, s.limit, s.Size)
+	s.Lock()
+	defer s.Unlock()
+	return fmt.Sprintf(
+		"database=%s, files=%s, limit=%d/%d, reserved=%d", s.File, s.Dir, s.limit, s.Size, s.reserved,
+	)
+}
+
+// Used returns committed and reserved bytes.
+func (s *Storage) Used() (int64, int64) {
+	s.Lock()
+	defer s.Unlock()
+	return s.limit, s.reserved
+}
+
+// check returns ErrSizeLimit if v more bytes can not be stored. It must be called under the lock.
+func (s *Storage) check(v int64) error {
+	if s.limit+s.reserved+v > s.Size {
+		return fmt.Errorf(
+			"storage limit=%d is reached [%v + %v + %v]: %w", s.Size, s.limit, s.reserved, v, ErrSizeLimit,
+		)
+	}
+	return nil
 }
 
 // Limit updates storage limit and returns and error if it's reached.
@@ -45,56 +131,448 @@ func (s *Storage) Limit(v int64) error {
 	s.Lock()
 	defer s.Unlock()
 
-	limit := s.limit + v
-	if limit > s.Size {
-		return fmt.Errorf("storage limit=%d is reached [%v + %v]: %w", s.Size, s.limit, v, ErrSizeLimit)
+	if err := s.check(v); err != nil {
+		return err
 	}
-	s.limit = limit
+	s.limit += v
+	s.changes++
 	return nil
 }
 
-// initLimits sets initial limit by current storage state.
-func (s *Storage) initLimits() error {
+// Release returns n bytes of deleted files back to the storage.
+func (s *Storage) Release(n int64) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.limit -= n
+	if s.limit < 0 {
+		s.limit = 0
+	}
+	s.changes++
+}
+
+// Reservation is a storage space reserved for a file before its size is known.
+// It must be finished by Commit or Rollback.
+type Reservation struct {
+	s    *Storage
+	size int64
+	done bool
+}
+
+// Reserve reserves n bytes or returns ErrSizeLimit if there is no enough space.
+func (s *Storage) Reserve(n int64) (*Reservation, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.check(n); err != nil {
+		return nil, err
+	}
+	s.reserved += n
+	s.open++
+	s.changes++
+	return &Reservation{s: s, size: n}, nil
+}
+
+// Commit converts the reservation to used space with real file size n.
+// If n is greater than reserved size, additional space is requested,
+// and the reservation is returned back on ErrSizeLimit.
+func (r *Reservation) Commit(n int64) error {
+	r.s.Lock()
+	defer r.s.Unlock()
+
+	if r.done {
+		return errors.New("reservation is already finished")
+	}
+	r.done = true
+	r.s.reserved -= r.size
+	r.s.open--
+	r.s.changes++
+	if err := r.s.check(n); err != nil {
+		return err
+	}
+	r.s.limit += n
+	return nil
+}
+
+// Rollback returns reserved space back to the storage.
+// It does nothing if the reservation is already finished, so it can be deferred.
+func (r *Reservation) Rollback() {
+	r.s.Lock()
+	defer r.s.Unlock()
+
+	if !r.done {
+		r.done = true
+		r.s.reserved -= r.size
+		r.s.open--
+		r.s.changes++
+	}
+}
+
+// Reconcile rescans the storage directory and sets used space by real files sizes.
+// It returns a difference between new and previous values.
+// Files of not finished reservations are already written but not committed,
+// so the storage is not reconciled while there are such reservations
+// or if used space is changed during the scan, the next call tries again.
+func (s *Storage) Reconcile() (int64, error) {
+	s.Lock()
+	open, changes := s.open, s.changes
+	s.Unlock()
+	if open > 0 {
+		return 0, nil
+	}
+	size, err := dirSize(s.Dir)
+	if err != nil {
+		return 0, err
+	}
 	s.Lock()
 	defer s.Unlock()
 
-	dirEntries, err := os.ReadDir(s.Dir)
+	if s.open > 0 || s.changes != changes {
+		return 0, nil
+	}
+	drift := size - s.limit
+	s.limit = size
+	return drift, nil
+}
+
+// validate checks storage parameters.
+func (s *Storage) validate() error {
+	if s.File == "" {
+		return keyError("storage.file", `""`, "must not be empty")
+	}
+	if s.Dir == "" {
+		return keyError("storage.dir", `""`, "must not be empty")
+	}
+	if s.Size < 1 {
+		return keyError("storage.size", s.Size, "must be positive")
+	}
+	return nil
+}
+
+// init creates storage directory, opens the database and applies its schema.
+func (s *Storage) init() error {
+	err := os.MkdirAll(s.Dir, 0700)
+	if err != nil {
+		return fmt.Errorf("storage.dir=%s: %w", s.Dir, err)
+	}
+	conn, err := sql.Open("sqlite3", dsn(s.File))
+	if err != nil {
+		return fmt.Errorf("storage.file=%s: %w", s.File, err)
+	}
+	if err = db.Migrate(context.Background(), conn); err != nil {
+		return closeOnError(conn, fmt.Errorf("storage.file=%s schema: %w", s.File, err))
+	}
+	if err = s.initLimits(); err != nil {
+		return closeOnError(conn, fmt.Errorf("storage.dir=%s limits: %w", s.Dir, err))
+	}
+	s.Db = conn
+	return nil
+}
+
+// dsn returns data source name of SQLite database file.
+// Concurrent transactions wait for the database lock instead of immediate "database is locked" errors.
+func dsn(fileName string) string {
+	sep := "?"
+	if strings.Contains(fileName, sep) {
+		sep = "&"
+	}
+	return fileName + sep + "_busy_timeout=" + fmt.Sprint(busyTimeout) + "&_txlock=immediate"
+}
+
+// closeOnError closes db and returns err joined with a closing error if it happened.
+func closeOnError(db *sql.DB, err error) error {
+	if e := db.Close(); e != nil {
+		return fmt.Errorf("%v, close database: %w", err, e)
+	}
+	return err
+}
+
+// initLimits sets initial limit by current storage state.
+func (s *Storage) initLimits() error {
+	size, err := dirSize(s.Dir)
 	if err != nil {
 		return err
 	}
+	s.Lock()
+	defer s.Unlock()
 
-	var fileInfo fs.FileInfo
 	s.Size = s.Size << 20 // megabytes -> bytes
+	s.limit = size
+	return nil
+}
 
+// dirSize returns total size of files inside the directory.
+func dirSize(dir string) (int64, error) {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		size     int64
+		fileInfo fs.FileInfo
+	)
 	for _, dirEntry := range dirEntries {
 		if dirEntry.IsDir() {
 			continue // skip directories
 		}
 		fileInfo, err = dirEntry.Info()
 		if err != nil {
-			return err
+			if errors.Is(err, fs.ErrNotExist) {
+				continue // file is deleted after directory reading
+			}
+			return 0, err
 		}
-		s.limit += fileInfo.Size()
+		size += fileInfo.Size()
 	}
-	return nil
+	return size, nil
 }
 
 // Settings struct is base service settings.
 type Settings struct {
-	TTL      int    `toml:"ttl"`
-	Times    int    `toml:"times"`
-	Size     int    `toml:"size"`
-	Salt     string `toml:"salt"`
-	GC       int    `toml:"gc"`
-	PassLen  int    `toml:"passlen"`
-	Shutdown int    `toml:"shutdown"`
+	TTL      int      `toml:"ttl"`
+	Times    int      `toml:"times"`
+	Size     int      `toml:"size"`
+	Salt     string   `toml:"salt"`
+	GC       int      `toml:"gc"`
+	PassLen  int      `toml:"passlen"`
+	Strength int      `toml:"strength"`
+	Scrypt   int      `toml:"scrypt"`
+	Master   string   `toml:"master"`
+	Compress string   `toml:"compress"`
+	Shutdown int      `toml:"shutdown"`
+	Argon    Argon    `toml:"argon"`
+	Password Password `toml:"password"`
+}
+
+// Password is a policy of automatically created passwords.
+// Symbols generator uses settings.passlen as min length, words generator creates passphrases.
+type Password struct {
+	Generator   string `toml:"generator"`
+	Entropy     int    `toml:"entropy"`
+	Alphabet    string `toml:"alphabet"`
+	Classes     bool   `toml:"classes"`
+	Unambiguous bool   `toml:"unambiguous"`
+	Words       int    `toml:"words"`
+	Separator   string `toml:"separator"`
+	Capitalize  bool   `toml:"capitalize"`
+	Insert      bool   `toml:"insert"`
+}
+
+// generators returns validated password generators by their names, min length of symbols passwords is n.
+func (p *Password) generators(n int) (map[string]pwgen.Generator, error) {
+	if p.Generator == "" {
+		p.Generator = SymbolsGenerator
+	}
+	if p.Words == 0 {
+		p.Words = defaultWords
+	}
+	if p.Separator == "" {
+		p.Separator = defaultSeparator
+	}
+	generators := map[string]pwgen.Generator{
+		SymbolsGenerator: &pwgen.Policy{
+			MinLength:   n,
+			MinEntropy:  float64(p.Entropy),
+			Alphabet:    p.Alphabet,
+			Classes:     p.Classes,
+			Unambiguous: p.Unambiguous,
+		},
+		WordsGenerator: &pwgen.PassphrasePolicy{
+			Words:      p.Words,
+			Separator:  p.Separator,
+			Capitalize: p.Capitalize,
+			Insert:     p.Insert,
+		},
+	}
+	if _, ok := generators[p.Generator]; !ok {
+		return nil, keyError("settings.password.generator", p.Generator, "is unknown")
+	}
+	for _, name := range []string{SymbolsGenerator, WordsGenerator} {
+		g := generators[name]
+		if err := g.Validate(); err != nil {
+			return nil, keyError("settings.password", *p, fmt.Sprintf("%s: %v", name, err))
+		}
+		if length := g.MaxLen(); length > maxPassLen {
+			reason := fmt.Sprintf("%s password length %d is greater than %d", name, length, maxPassLen)
+			return nil, keyError("settings.password", *p, reason)
+		}
+	}
+	return generators, nil
+}
+
+// Argon is Argon2id key derivation function parameters.
+// Zero values are replaced by encrypt.DefaultKDF ones.
+// If Tune is positive, Time is selected to derive a key during Tune milliseconds.
+type Argon struct {
+	Time    uint32 `toml:"time"`
+	Memory  uint32 `toml:"memory"`
+	Threads uint8  `toml:"threads"`
+	Tune    int    `toml:"tune"`
+}
+
+// kdf returns Argon2id parameters, memory is converted from megabytes to kilobytes.
+func (a *Argon) kdf() (encrypt.Argon2id, error) {
+	k := encrypt.DefaultKDF
+	if a.Time > 0 {
+		k.Time = a.Time
+	}
+	if a.Memory > 0 {
+		k.Memory = a.Memory << 10
+	}
+	if a.Threads > 0 {
+		k.Threads = a.Threads
+	}
+	if a.Tune < 0 {
+		return k, keyError("settings.argon.tune", a.Tune, "must not be negative")
+	}
+	if a.Tune > 0 {
+		tuned, err := encrypt.TuneArgon2id(time.Duration(a.Tune)*time.Millisecond, k.Memory, k.Threads)
+		if err != nil {
+			return k, keyError("settings.argon", *a, err.Error())
+		}
+		k = tuned
+	}
+	if err := k.Validate(); err != nil {
+		return k, keyError("settings.argon", *a, err.Error())
+	}
+	return k, nil
+}
+
+// validate checks settings, debug mode allows the default salt.
+func (s *Settings) validate(debug bool, storageSize int64) error {
+	if s.TTL < 1 || s.TTL > maxTTL {
+		return keyError("settings.ttl", s.TTL, fmt.Sprintf("is out of range [1, %d]", maxTTL))
+	}
+	if s.Times < 1 {
+		return keyError("settings.times", s.Times, "must be positive")
+	}
+	if s.Size < 1 || int64(s.Size) > storageSize {
+		return keyError("settings.size", s.Size, fmt.Sprintf("is out of range [1, %d]", storageSize))
+	}
+	if !debug && (s.Salt == "" || s.Salt == defaultSalt) {
+		return keyError("settings.salt", s.Salt, "must be replaced by a long random string")
+	}
+	if s.GC < 1 {
+		return keyError("settings.gc", s.GC, "must be positive")
+	}
+	if s.PassLen < minPassLen || s.PassLen > maxPassLen {
+		return keyError(
+			"settings.passlen", s.PassLen, fmt.Sprintf("is out of range [%d, %d]", minPassLen, maxPassLen),
+		)
+	}
+	if s.Strength < 0 || s.Strength > maxStrength {
+		return keyError("settings.strength", s.Strength, fmt.Sprintf("is out of range [0, %d]", maxStrength))
+	}
+	if s.Scrypt != 0 && (s.Scrypt < minScrypt || s.Scrypt > age.MaxWorkFactor) {
+		return keyError(
+			"settings.scrypt", s.Scrypt, fmt.Sprintf("is out of range [%d, %d]", minScrypt, age.MaxWorkFactor),
+		)
+	}
+	if s.Shutdown < 1 {
+		return keyError("settings.shutdown", s.Shutdown, "must be positive")
+	}
+	return nil
+}
+
+// masterKeys returns master keys from MasterKeysEnv environment variable or from settings.master file.
+// It returns nil if both are empty, then data are encrypted directly by password keys.
+func (s *Settings) masterKeys() (*encrypt.Keyring, error) {
+	if value := os.Getenv(MasterKeysEnv); value != "" {
+		k, err := encrypt.ParseKeyring(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s %v", ErrConfig, MasterKeysEnv, err)
+		}
+		return k, nil
+	}
+	if s.Master == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(s.Master)
+	if err != nil {
+		return nil, keyError("settings.master", s.Master, err.Error())
+	}
+	k, err := encrypt.ParseKeyring(string(b))
+	if err != nil {
+		return nil, keyError("settings.master", s.Master, err.Error())
+	}
+	return k, nil
+}
+
+// compression returns a compression of files and notes before encryption, gzip is used if it's not set.
+// Resumable uploads are not compressed, their parts are encrypted as they arrive.
+func (s *Settings) compression() (encrypt.Compression, error) {
+	if s.Compress == "" {
+		return encrypt.CompressionGzip, nil
+	}
+	c, err := encrypt.ParseCompression(s.Compress)
+	if err != nil {
+		return c, keyError("settings.compress", s.Compress, "is unknown")
+	}
+	return c, nil
 }
 
 // Config is a main configuration structure.
 type Config struct {
 	Server   server   `toml:"server"`
-	Storage  Storage  `toml:"Storage"`
+	Storage  Storage  `toml:"storage"`
 	Settings Settings `toml:"settings"`
+	kdf      encrypt.KDF
+	keyring  *encrypt.Keyring
+	compress encrypt.Compression
+	passGen  map[string]pwgen.Generator
+}
+
+// New returns new configuration from the TOML file.
+// It validates all parameters, creates the storage directory and initializes the database.
+func New(fileName string) (*Config, error) {
+	fullPath, err := filepath.Abs(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("config file path: %w", err)
+	}
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return nil, fmt.Errorf("open config file: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	c := &Config{}
+	if err = toml.NewDecoder(f).DisallowUnknownFields().Decode(c); err != nil {
+		return nil, fmt.Errorf("decode config file %s: %w", fullPath, err)
+	}
+	if err = c.validate(); err != nil {
+		return nil, err
+	}
+	kdf, err := c.Settings.Argon.kdf()
+	if err != nil {
+		return nil, err
+	}
+	c.kdf = kdf
+	if c.keyring, err = c.Settings.masterKeys(); err != nil {
+		return nil, err
+	}
+	if c.compress, err = c.Settings.compression(); err != nil {
+		return nil, err
+	}
+	if c.passGen, err = c.Settings.Password.generators(c.Settings.PassLen); err != nil {
+		return nil, err
+	}
+	if err = c.Storage.init(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// validate checks all configuration parameters.
+func (c *Config) validate() error {
+	if err := c.Server.validate(); err != nil {
+		return err
+	}
+	if err := c.Storage.validate(); err != nil {
+		return err
+	}
+	return c.Settings.validate(c.Server.Debug, c.Storage.Size)
 }
 
 // Addr returns service's net address.
@@ -117,11 +595,40 @@ func (c *Config) GCPeriod() time.Duration {
 	return time.Duration(c.Settings.GC) * time.Second
 }
 
+// TTL returns max time to live of items.
+func (c *Config) TTL() time.Duration {
+	return time.Duration(c.Settings.TTL) * time.Second
+}
+
+// ShutdownTimeout is a timeout to stop the service.
+func (c *Config) ShutdownTimeout() time.Duration {
+	return time.Duration(c.Settings.Shutdown) * time.Second
+}
+
 // MaxFileSize returns max file size.
 func (c *Config) MaxFileSize() int {
 	return c.Settings.Size << 20
 }
 
+// Options returns encryption options.
+func (c *Config) Options() *encrypt.Options {
+	return &encrypt.Options{KDF: c.kdf, WorkFactor: c.Settings.Scrypt, Keyring: c.keyring, Compression: c.compress}
+}
+
+// Keyring returns master keys, it's nil if they are not configured.
+func (c *Config) Keyring() *encrypt.Keyring {
+	return c.keyring
+}
+
+// Generator returns password generator by its name, the default one is returned for empty name.
+func (c *Config) Generator(name string) (pwgen.Generator, bool) {
+	if name == "" {
+		name = c.Settings.Password.Generator
+	}
+	g, ok := c.passGen[name]
+	return g, ok
+}
+
 // Secret returns string with salt.
 func (c *Config) Secret(p string) string {
 	return p + c.Settings.Salt
@@ -0,0 +1,497 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/z0rr0/ssf/encrypt"
+)
+
+const testConfig = `
+[server]
+host = "localhost"
+port = 8082
+timeout = 30
+debug = false
+
+[storage]
+file = "{dir}/db.sqlite"
+dir = "{dir}/storage"
+size = 512
+
+[settings]
+ttl = 604800
+times = 100
+size = 128
+salt = "some long random salt"
+gc = 10
+passlen = 15
+strength = 30
+scrypt = 10
+master = ""
+shutdown = 30
+
+[settings.argon]
+time = 1
+memory = 1
+threads = 1
+tune = 0
+
+[settings.password]
+generator = "symbols"
+entropy = 0
+alphabet = ""
+classes = false
+unambiguous = false
+words = 6
+separator = "-"
+capitalize = false
+insert = false
+`
+
+func writeConfig(t *testing.T, content string) string {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "config.toml")
+	content = strings.ReplaceAll(content, "{dir}", dir)
+	if err := os.WriteFile(fileName, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func TestNew(t *testing.T) {
+	cfg, err := New(writeConfig(t, testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if e := cfg.Close(); e != nil {
+			t.Error(e)
+		}
+	}()
+	if addr := cfg.Addr(); addr != "localhost:8082" {
+		t.Errorf("failed addr=%s", addr)
+	}
+	if size := cfg.Storage.Size; size != 512<<20 {
+		t.Errorf("failed storage size=%d", size)
+	}
+	if info, e := os.Stat(cfg.Storage.Dir); e != nil || !info.IsDir() {
+		t.Errorf("failed storage dir: %v", e)
+	}
+	var n int
+	if err = cfg.Storage.Db.QueryRow("SELECT COUNT(*) FROM `ssf`;").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("unexpected rows=%d", n)
+	}
+	if k := cfg.Keyring(); k != nil || cfg.Options().Keyring != nil {
+		t.Errorf("unexpected keyring=%v", k)
+	}
+}
+
+func TestNewError(t *testing.T) {
+	cases := []struct {
+		old, new string
+		key      string
+	}{
+		{old: "port = 8082", new: "port = 0", key: "server.port"},
+		{old: "port = 8082", new: "port = 65536", key: "server.port"},
+		{old: "timeout = 30", new: "timeout = -1", key: "server.timeout"},
+		{old: `file = "{dir}/db.sqlite"`, new: `file = ""`, key: "storage.file"},
+		{old: `dir = "{dir}/storage"`, new: `dir = ""`, key: "storage.dir"},
+		{old: "size = 512", new: "size = 0", key: "storage.size"},
+		{old: "ttl = 604800", new: "ttl = 0", key: "settings.ttl"},
+		{old: "ttl = 604800", new: "ttl = 2592001", key: "settings.ttl"},
+		{old: "times = 100", new: "times = 0", key: "settings.times"},
+		{old: "size = 128", new: "size = 1024", key: "settings.size"},
+		{old: `salt = "some long random salt"`, new: `salt = "abc"`, key: "settings.salt"},
+		{old: `salt = "some long random salt"`, new: `salt = ""`, key: "settings.salt"},
+		{old: "gc = 10", new: "gc = 0", key: "settings.gc"},
+		{old: "passlen = 15", new: "passlen = 7", key: "settings.passlen"},
+		{old: "passlen = 15", new: "passlen = 65", key: "settings.passlen"},
+		{old: "strength = 30", new: "strength = -1", key: "settings.strength"},
+		{old: "strength = 30", new: "strength = 129", key: "settings.strength"},
+		{old: "scrypt = 10", new: "scrypt = 9", key: "settings.scrypt"},
+		{old: "scrypt = 10", new: "scrypt = 23", key: "settings.scrypt"},
+		{old: "shutdown = 30", new: "shutdown = 0", key: "settings.shutdown"},
+		{old: "threads = 1", new: "threads = 255", key: "settings.argon"},
+		{old: "tune = 0", new: "tune = -1", key: "settings.argon.tune"},
+		{old: "entropy = 0", new: "entropy = -1", key: "settings.password"},
+		{old: "entropy = 0", new: "entropy = 400", key: "settings.password"},
+		{old: `alphabet = ""`, new: `alphabet = "abca"`, key: "settings.password"},
+		{old: `alphabet = ""`, new: `alphabet = "ab c"`, key: "settings.password"},
+		{old: `alphabet = ""`, new: `alphabet = "a"`, key: "settings.password"},
+		{old: `generator = "symbols"`, new: `generator = "unknown"`, key: "settings.password.generator"},
+		{old: "words = 6", new: "words = 7", key: "settings.password"},
+		{old: "words = 6", new: "words = -1", key: "settings.password"},
+		{old: `separator = "-"`, new: `separator = "\t"`, key: "settings.password"},
+	}
+	for i, c := range cases {
+		content := strings.Replace(testConfig, c.old, c.new, 1)
+		_, err := New(writeConfig(t, content))
+		if err == nil {
+			t.Errorf("expected error for case=%d", i)
+			continue
+		}
+		if !errors.Is(err, ErrConfig) {
+			t.Errorf("unexpected error type for case=%d: %v", i, err)
+		}
+		if msg := err.Error(); !strings.Contains(msg, c.key) {
+			t.Errorf("error for case=%d does not contain key %s: %v", i, c.key, msg)
+		}
+	}
+}
+
+func TestNewArgon(t *testing.T) {
+	content := strings.Replace(testConfig, "tune = 0", "tune = 5", 1)
+	content = strings.Replace(content, "time = 1\n", "", 1)
+	cfg, err := New(writeConfig(t, content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if e := cfg.Close(); e != nil {
+			t.Error(e)
+		}
+	}()
+	kdf, ok := cfg.Options().KDF.(encrypt.Argon2id)
+	if !ok {
+		t.Fatalf("unexpected kdf type %T", cfg.Options().KDF)
+	}
+	if kdf.Time < 1 || kdf.Memory != 1<<10 || kdf.Threads != 1 {
+		t.Errorf("failed kdf=%+v", kdf)
+	}
+	if wf := cfg.Options().WorkFactor; wf != 10 {
+		t.Errorf("failed work factor=%d", wf)
+	}
+}
+
+func TestNewDebug(t *testing.T) {
+	content := strings.Replace(testConfig, `salt = "some long random salt"`, `salt = "abc"`, 1)
+	content = strings.Replace(content, "debug = false", "debug = true", 1)
+	cfg, err := New(writeConfig(t, content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewPassword(t *testing.T) {
+	content := strings.Replace(testConfig, "entropy = 0", "entropy = 100", 1)
+	content = strings.Replace(content, `alphabet = ""`, `alphabet = "abcdefABCDEF012345!?"`, 1)
+	content = strings.Replace(content, "classes = false", "classes = true", 1)
+	cfg, err := New(writeConfig(t, content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if e := cfg.Close(); e != nil {
+			t.Error(e)
+		}
+	}()
+	policy, ok := cfg.Generator("")
+	if !ok {
+		t.Fatal("no default generator")
+	}
+	if n := policy.MaxLen(); n != 24 {
+		t.Errorf("failed password length=%d", n)
+	}
+	if bits := policy.Entropy(); bits < 100 {
+		t.Errorf("failed entropy=%v", bits)
+	}
+	if p := policy.New(); len(p) != 24 || strings.Trim(p, "abcdefABCDEF012345!?") != "" {
+		t.Errorf("failed password %q", p)
+	}
+}
+
+func TestNewPassphrase(t *testing.T) {
+	content := strings.Replace(testConfig, `generator = "symbols"`, `generator = "words"`, 1)
+	content = strings.Replace(content, "words = 6", "words = 5", 1)
+	content = strings.Replace(content, `separator = "-"`, `separator = " "`, 1)
+	content = strings.Replace(content, "insert = false", "insert = true", 1)
+	cfg, err := New(writeConfig(t, content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if e := cfg.Close(); e != nil {
+			t.Error(e)
+		}
+	}()
+	g, ok := cfg.Generator("")
+	if !ok {
+		t.Fatal("no default generator")
+	}
+	if bits := g.Entropy(); bits < 5*12.9 {
+		t.Errorf("failed entropy=%v", bits)
+	}
+	if words := strings.Split(g.New(), " "); len(words) != 5 {
+		t.Errorf("failed passphrase words %q", words)
+	}
+	if g, ok = cfg.Generator(SymbolsGenerator); !ok || g.MaxLen() != 15 {
+		t.Errorf("failed symbols generator %v", ok)
+	}
+	if _, ok = cfg.Generator("unknown"); ok {
+		t.Error("unexpected unknown generator")
+	}
+}
+
+func TestNewMasterKeys(t *testing.T) {
+	const keys = "# master keys\n" +
+		"k1:000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f\n" +
+		"k2:202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f\n"
+	keysFile := filepath.Join(t.TempDir(), "master.keys")
+	if err := os.WriteFile(keysFile, []byte(keys), 0600); err != nil {
+		t.Fatal(err)
+	}
+	content := strings.Replace(testConfig, `master = ""`, fmt.Sprintf("master = %q", keysFile), 1)
+	cfg, err := New(writeConfig(t, content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k := cfg.Options().Keyring; k == nil || k.Current() != "k2" {
+		t.Errorf("failed keyring=%v", k)
+	}
+	if err = cfg.Close(); err != nil {
+		t.Error(err)
+	}
+	// the environment variable is used instead of the file
+	t.Setenv(MasterKeysEnv, "env:404142434445464748494a4b4c4d4e4f505152535455565758595a5b5c5d5e5f")
+	if cfg, err = New(writeConfig(t, content)); err != nil {
+		t.Fatal(err)
+	}
+	if k := cfg.Keyring(); k == nil || k.Current() != "env" {
+		t.Errorf("failed keyring=%v", k)
+	}
+	if err = cfg.Close(); err != nil {
+		t.Error(err)
+	}
+	t.Setenv(MasterKeysEnv, "env:0001")
+	if _, err = New(writeConfig(t, content)); !errors.Is(err, ErrConfig) || !strings.Contains(err.Error(), MasterKeysEnv) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	t.Setenv(MasterKeysEnv, "")
+	for _, value := range []string{filepath.Join(t.TempDir(), "none"), writeConfig(t, "")} {
+		content = strings.Replace(testConfig, `master = ""`, fmt.Sprintf("master = %q", value), 1)
+		if _, err = New(writeConfig(t, content)); !errors.Is(err, ErrConfig) || !strings.Contains(err.Error(), "settings.master") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestNewCompress(t *testing.T) {
+	cases := map[string]encrypt.Compression{"": encrypt.CompressionGzip, "none": encrypt.CompressionNone}
+	for value, expected := range cases {
+		content := strings.Replace(testConfig, `master = ""`, fmt.Sprintf("master = \"\"\ncompress = %q", value), 1)
+		cfg, err := New(writeConfig(t, content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c := cfg.Options().Compression; c != expected {
+			t.Errorf("failed %q compression=%v", value, c)
+		}
+		if err = cfg.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+	content := strings.Replace(testConfig, `master = ""`, "master = \"\"\ncompress = \"lz4\"", 1)
+	if _, err := New(writeConfig(t, content)); !errors.Is(err, ErrConfig) || !strings.Contains(err.Error(), "settings.compress") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNewUnknownKey(t *testing.T) {
+	content := strings.Replace(testConfig, "gc = 10", "gc = 10\nunknown = 1", 1)
+	if _, err := New(writeConfig(t, content)); err == nil {
+		t.Error("expected error for unknown key")
+	}
+}
+
+func TestStorageReserve(t *testing.T) {
+	const n = 100
+	s := &Storage{Size: n * 10}
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r, err := s.Reserve(10)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer r.Rollback()
+			if i%2 == 0 {
+				if err = r.Commit(10); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if limit, reserved := s.Used(); limit != n*5 || reserved != 0 {
+		t.Errorf("failed limit=%d, reserved=%d", limit, reserved)
+	}
+}
+
+func TestStorageReserveLimit(t *testing.T) {
+	const n = 20
+	var (
+		s       = &Storage{Size: 1000}
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		success int
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.Reserve(100)
+			if err != nil {
+				if !errors.Is(err, ErrSizeLimit) {
+					t.Error(err)
+				}
+				return
+			}
+			mu.Lock()
+			success++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if success != 10 {
+		t.Errorf("failed success reservations=%d", success)
+	}
+	if limit, reserved := s.Used(); limit != 0 || reserved != 1000 {
+		t.Errorf("failed limit=%d, reserved=%d", limit, reserved)
+	}
+}
+
+func TestStorageCommit(t *testing.T) {
+	s := &Storage{Size: 100}
+	r, err := s.Reserve(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Limit(85); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Commit(20); !errors.Is(err, ErrSizeLimit) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err = r.Commit(10); err == nil {
+		t.Error("expected error for finished reservation")
+	}
+	r.Rollback()
+	if limit, reserved := s.Used(); limit != 85 || reserved != 0 {
+		t.Errorf("failed limit=%d, reserved=%d", limit, reserved)
+	}
+}
+
+func TestStorageRelease(t *testing.T) {
+	const n = 100
+	s := &Storage{Size: n}
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := s.Limit(1); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			s.Release(1)
+		}()
+	}
+	wg.Wait()
+	// release can happen before limit, then the value is bounded by zero
+	if limit, _ := s.Used(); limit < 0 || limit > n {
+		t.Errorf("failed limit=%d", limit)
+	}
+	s.Release(n * 2)
+	if limit, _ := s.Used(); limit != 0 {
+		t.Errorf("failed limit=%d", limit)
+	}
+}
+
+func TestStorageReconcile(t *testing.T) {
+	const n = 10
+	s := &Storage{Dir: t.TempDir(), Size: 1 << 20}
+	for i := 0; i < n; i++ {
+		name := filepath.Join(s.Dir, fmt.Sprintf("file%d", i))
+		if err := os.WriteFile(name, make([]byte, 100), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Limit(50); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := s.Reconcile(); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			r, err := s.Reserve(10)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			r.Rollback()
+		}()
+	}
+	wg.Wait()
+	drift, err := s.Reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if drift != 0 {
+		t.Errorf("failed drift=%d", drift)
+	}
+	if limit, reserved := s.Used(); limit != n*100 || reserved != 0 {
+		t.Errorf("failed limit=%d, reserved=%d", limit, reserved)
+	}
+}
+
+func TestStorageReconcileReservation(t *testing.T) {
+	s := &Storage{Dir: t.TempDir(), Size: 1 << 20}
+	r, err := s.Reserve(200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the file is written but the reservation is not committed yet
+	if err = os.WriteFile(filepath.Join(s.Dir, "upload"), make([]byte, 100), 0600); err != nil {
+		t.Fatal(err)
+	}
+	drift, err := s.Reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limit, reserved := s.Used(); drift != 0 || limit != 0 || reserved != 200 {
+		t.Errorf("failed drift=%d, limit=%d, reserved=%d", drift, limit, reserved)
+	}
+	if err = r.Commit(100); err != nil {
+		t.Fatal(err)
+	}
+	if drift, err = s.Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+	if limit, reserved := s.Used(); drift != 0 || limit != 100 || reserved != 0 {
+		t.Errorf("failed drift=%d, limit=%d, reserved=%d", drift, limit, reserved)
+	}
+}
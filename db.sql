@@ -1,30 +1,62 @@
-DROP TABLE IF EXISTS `ssf`;
 CREATE TABLE IF NOT EXISTS `ssf`
 (
     `id`        VARCHAR(64) PRIMARY KEY,
-    `file`      TEXT,
-    `meta`      TEXT,
-    `number`    INTEGER      NOT NULL DEFAULT 1,
-    `salt_file` VARCHAR(256) NOT NULL,
-    `salt_meta` VARCHAR(256) NOT NULL,
-    `hash_file` VARCHAR(64)  NOT NULL,
-    `hash_meta` VARCHAR(64)  NOT NULL,
+    `kind`      VARCHAR(8)   NOT NULL DEFAULT 'file',
+    `file`      TEXT         NOT NULL DEFAULT '',
+    `msg`       TEXT         NOT NULL DEFAULT '',
+    `meta`      TEXT         NOT NULL DEFAULT '',
+    `number`    INTEGER      NOT NULL DEFAULT 0,
+    `times`     INTEGER      NOT NULL DEFAULT 1,
     `created`   DATETIME     NOT NULL,
     `updated`   DATETIME     NOT NULL,
     `expired`   DATETIME     NOT NULL
 );
 CREATE INDEX IF NOT EXISTS `expired` ON `ssf` (`expired`, `number`);
 
+CREATE TABLE IF NOT EXISTS `upload`
+(
+    `id`      VARCHAR(64) PRIMARY KEY,
+    `file`    TEXT         NOT NULL,
+    `msg`     TEXT         NOT NULL,
+    `meta`    TEXT         NOT NULL,
+    `tail`    TEXT         NOT NULL,
+    `size`    INTEGER      NOT NULL,
+    `offset`  INTEGER      NOT NULL DEFAULT 0,
+    `ttl`     INTEGER      NOT NULL,
+    `times`   INTEGER      NOT NULL,
+    `created` DATETIME     NOT NULL,
+    `updated` DATETIME     NOT NULL,
+    `expired` DATETIME     NOT NULL
+);
+CREATE INDEX IF NOT EXISTS `upload_expired` ON `upload` (`expired`);
+
 /*
 id - unique identifier UUID v4
-file - relative path to an encrypted file
-meta - encrypted file meta data, JSON {name, size, type, check sum}
+kind - item type: "file", "note", "recipients" or "raw" (a file encrypted by a client, it has no messages)
+file - relative path to an encrypted file, empty for notes
+msg - message envelope token of the file (without its name) or the encrypted text of a note:
+      cipher, compression, KDF parameters, salt, key hash, check sum of content and data key wrapped by a master key
+meta - message envelope token of encrypted file meta data, JSON {name, size, type, check sum}
 number - usage file counter
-salt_file - random salt for data
-salt_meta - random salt for file name
-hash_file - hash of file
-hash_meta - hash of meta data
+times - max number of usages
 created - timestamp of item create
 updated - timestamp of item update
 expired - timestamp of item expiration
+
+upload - not completed resumable uploads, an item with the same id is created after the last part
+id - unique identifier UUID v4, it's used as item id
+file - relative path to a partially encrypted file
+msg - message envelope token of the file key: KDF parameters, salt, key hash and data key wrapped by a master key
+meta - file meta data encrypted by the file key
+tail - encrypted received data which are not written to the file yet
+size - expected file size
+offset - received data size
+ttl - item time to live in seconds
+times - item max number of usages
+created - timestamp of upload create
+updated - timestamp of the last received part
+expired - timestamp of upload expiration
+
+It's the schema of new databases, existing ones are updated by migrations of db package,
+PRAGMA user_version is a number of applied migrations.
  */
\ No newline at end of file
@@ -0,0 +1,281 @@
+package db
+
+// Package db contains methods to store and read shared items.
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/z0rr0/ssf/encrypt"
+)
+
+const (
+	// KindFile is a kind of items with encrypted files.
+	KindFile = "file"
+	// KindNote is a kind of items with encrypted text notes.
+	KindNote = "note"
+	// KindRaw is a kind of items with files encrypted by clients, the server doesn't know their keys.
+	KindRaw = "raw"
+	// KindRecipients is a kind of items with encrypted files only for recipients, their random passwords are not known.
+	KindRecipients = "recipients"
+)
+
+var (
+	// ErrNotFound is an error when item is not found or expired.
+	ErrNotFound = errors.New("item not found")
+
+	// ErrLimit is an error when item is used max number of times.
+	ErrLimit = errors.New("item usage limit is reached")
+)
+
+// Item is a shared item. Msg and Meta are encrypted messages of the content and file meta data,
+// they are stored as envelope tokens. The file name is kept in File, so Msg.Value is empty for files.
+// Notes have no file and meta data, raw items have no messages.
+type Item struct {
+	ID      string
+	Kind    string
+	File    string
+	Msg     *encrypt.Msg
+	Meta    *encrypt.Msg
+	Number  int
+	Times   int
+	Created time.Time
+	Updated time.Time
+	Expired time.Time
+}
+
+// Time returns t in the form it is stored in the database.
+// Values are truncated to seconds in UTC, so they can be compared as strings.
+func Time(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Second)
+}
+
+// NewItem returns new item for the encrypted file message m.
+// It expires after ttl since now or after times usages.
+func NewItem(id string, m *encrypt.Msg, now time.Time, ttl time.Duration, times int) *Item {
+	now = Time(now)
+	msg := *m
+	msg.Value = ""
+	return &Item{
+		ID:      id,
+		Kind:    KindFile,
+		File:    filepath.Base(m.Value),
+		Msg:     &msg,
+		Times:   times,
+		Created: now,
+		Updated: now,
+		Expired: Time(now.Add(ttl)),
+	}
+}
+
+// NewNote returns new item for the encrypted text message m.
+// It expires after ttl since now or after times usages.
+func NewNote(id string, m *encrypt.Msg, now time.Time, ttl time.Duration, times int) *Item {
+	now = Time(now)
+	return &Item{
+		ID:      id,
+		Kind:    KindNote,
+		Msg:     m,
+		Times:   times,
+		Created: now,
+		Updated: now,
+		Expired: Time(now.Add(ttl)),
+	}
+}
+
+// NewRaw returns new item for the file message m which is encrypted by a client.
+// It has no messages, it expires after ttl since now or after times usages.
+func NewRaw(id string, m *encrypt.Msg, now time.Time, ttl time.Duration, times int) *Item {
+	now = Time(now)
+	return &Item{
+		ID:      id,
+		Kind:    KindRaw,
+		File:    filepath.Base(m.Value),
+		Times:   times,
+		Created: now,
+		Updated: now,
+		Expired: Time(now.Add(ttl)),
+	}
+}
+
+// HasFile returns true if the item content is stored in a file.
+func (item *Item) HasFile() bool {
+	return item.File != ""
+}
+
+// FileMsg returns encrypted file message, dir is a storage directory.
+// Messages of raw items contain only the file name.
+func (item *Item) FileMsg(dir string) *encrypt.Msg {
+	m := &encrypt.Msg{Cipher: encrypt.CipherStream}
+	if item.Msg != nil {
+		*m = *item.Msg
+	}
+	m.Value = filepath.Join(dir, item.File)
+	return m
+}
+
+// token returns the message as envelope token, it's empty for nil message.
+func token(m *encrypt.Msg) (string, error) {
+	if m == nil {
+		return "", nil
+	}
+	return m.Token()
+}
+
+// parseToken returns a message from envelope token, it's nil for empty token.
+func parseToken(token string) (*encrypt.Msg, error) {
+	if token == "" {
+		return nil, nil
+	}
+	return encrypt.ParseToken(token)
+}
+
+// execer is a database or a transaction to execute queries.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Save inserts the item to the database.
+func (item *Item) Save(ctx context.Context, db *sql.DB) error {
+	return item.save(ctx, db)
+}
+
+// save inserts the item using the database or a transaction.
+func (item *Item) save(ctx context.Context, db execer) error {
+	msg, err := token(item.Msg)
+	if err != nil {
+		return fmt.Errorf("item message: %w", err)
+	}
+	meta, err := token(item.Meta)
+	if err != nil {
+		return fmt.Errorf("item meta data: %w", err)
+	}
+	const query = "INSERT INTO `ssf` " +
+		"(`id`, `kind`, `file`, `msg`, `meta`, `number`, `times`, `created`, `updated`, `expired`) " +
+		"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);"
+	_, err = db.ExecContext(
+		ctx, query,
+		item.ID, item.Kind, item.File, msg, meta, item.Number, item.Times, item.Created, item.Updated, item.Expired,
+	)
+	if err != nil {
+		return fmt.Errorf("insert item: %w", err)
+	}
+	return nil
+}
+
+// Get returns not expired item by its ID.
+func Get(ctx context.Context, db *sql.DB, id string, now time.Time) (*Item, error) {
+	const query = "SELECT `id`, `kind`, `file`, `msg`, `meta`, `number`, `times`, `created`, `updated`, `expired` " +
+		"FROM `ssf` WHERE `id`=? AND `expired`>?;"
+	var (
+		item      = &Item{}
+		msg, meta string
+	)
+	err := db.QueryRowContext(ctx, query, id, Time(now)).Scan(
+		&item.ID, &item.Kind, &item.File, &msg, &meta, &item.Number, &item.Times, &item.Created, &item.Updated, &item.Expired,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("select item: %w", err)
+	}
+	if item.Msg, err = parseToken(msg); err != nil {
+		return nil, fmt.Errorf("item message: %w", err)
+	}
+	if item.Meta, err = parseToken(meta); err != nil {
+		return nil, fmt.Errorf("item meta data: %w", err)
+	}
+	if item.Msg == nil && item.Kind != KindRaw {
+		return nil, fmt.Errorf("item %s has no message", item.ID)
+	}
+	return item, nil
+}
+
+// Use increases usage counter of not expired item in a transaction.
+// It returns ErrLimit if the item is already used max number of times,
+// so concurrent requests can not use it more times.
+// The returned flag is true if it was the last allowed usage.
+func Use(ctx context.Context, db *sql.DB, id string, now time.Time) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("begin transaction: %w", err)
+	}
+	last, err := use(ctx, tx, id, Time(now))
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			return false, fmt.Errorf("%v, rollback: %w", err, e)
+		}
+		return false, err
+	}
+	if err = tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit transaction: %w", err)
+	}
+	return last, nil
+}
+
+// use increases usage counter inside the transaction.
+func use(ctx context.Context, tx *sql.Tx, id string, now time.Time) (bool, error) {
+	var number, times int
+	const query = "SELECT `number`, `times` FROM `ssf` WHERE `id`=? AND `expired`>?;"
+	err := tx.QueryRowContext(ctx, query, id, now).Scan(&number, &times)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, ErrNotFound
+		}
+		return false, fmt.Errorf("select item usage: %w", err)
+	}
+	if number >= times {
+		return false, ErrLimit
+	}
+	const update = "UPDATE `ssf` SET `number`=`number`+1, `updated`=? WHERE `id`=? AND `number`<`times`;"
+	result, err := tx.ExecContext(ctx, update, now, id)
+	if err != nil {
+		return false, fmt.Errorf("update item usage: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("update item usage result: %w", err)
+	}
+	if n == 0 {
+		return false, ErrLimit
+	}
+	return number+1 >= times, nil
+}
+
+// Delete removes item by its ID.
+func Delete(ctx context.Context, db *sql.DB, id string) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM `ssf` WHERE `id`=?;", id)
+	if err != nil {
+		return fmt.Errorf("delete item: %w", err)
+	}
+	return nil
+}
+
+// Expired returns items which are expired or used max number of times.
+func Expired(ctx context.Context, db *sql.DB, now time.Time) ([]*Item, error) {
+	const query = "SELECT `id`, `kind`, `file` FROM `ssf` WHERE `expired`<=? OR `number`>=`times`;"
+	rows, err := db.QueryContext(ctx, query, Time(now))
+	if err != nil {
+		return nil, fmt.Errorf("select expired items: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var items []*Item
+	for rows.Next() {
+		item := &Item{}
+		if err = rows.Scan(&item.ID, &item.Kind, &item.File); err != nil {
+			return nil, fmt.Errorf("scan expired item: %w", err)
+		}
+		items = append(items, item)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("read expired items: %w", err)
+	}
+	return items, nil
+}
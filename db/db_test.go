@@ -0,0 +1,181 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3" // SQLite3 driver package
+
+	"github.com/z0rr0/ssf/encrypt"
+)
+
+func newDB(t *testing.T) *sql.DB {
+	dsn := filepath.Join(t.TempDir(), "db.sqlite") + "?_busy_timeout=10000&_txlock=immediate"
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Migrate(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if e := db.Close(); e != nil {
+			t.Error(e)
+		}
+	})
+	return db
+}
+
+// testOpts are encryption options with fast KDF.
+var testOpts = &encrypt.Options{KDF: encrypt.Argon2id{Time: 1, Memory: 1024, Threads: 1}}
+
+func newNote(t *testing.T, value string, opts *encrypt.Options) *encrypt.Msg {
+	m, err := encrypt.Text("secret", value, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestItem(t *testing.T) {
+	ctx := context.Background()
+	db := newDB(t)
+	now := time.Now()
+	opts := &encrypt.Options{KDF: testOpts.KDF, Compression: encrypt.CompressionGzip}
+	m, err := encrypt.File("secret", strings.NewReader("content"), t.TempDir(), "file", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := NewItem("id", m, now, time.Minute, 2)
+	if err = item.Save(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := Get(ctx, db, item.ID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.File != "file" || saved.Meta != nil || saved.Msg.Salt != m.Salt || saved.Msg.KeyHash != m.KeyHash || saved.Msg.DataHash != m.DataHash {
+		t.Errorf("failed saved item=%+v", saved)
+	}
+	// the file name is stored only in the item
+	if saved.Msg.Value != "" {
+		t.Errorf("failed message value=%s", saved.Msg.Value)
+	}
+	if !saved.Expired.Equal(item.Expired) {
+		t.Errorf("failed expired=%v, expected %v", saved.Expired, item.Expired)
+	}
+	if fm := saved.FileMsg("/base"); fm.Value != "/base/file" || fm.Compression != encrypt.CompressionGzip {
+		t.Errorf("failed file message value=%s, compression=%v", fm.Value, fm.Compression)
+	}
+	// expired
+	if _, err = Get(ctx, db, item.ID, now.Add(time.Minute)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err = db.Exec("UPDATE `ssf` SET `msg`='SSFM' WHERE `id`=?;", item.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Get(ctx, db, item.ID, now); !errors.Is(err, encrypt.ErrEnvelope) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err = Delete(ctx, db, item.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Get(ctx, db, item.ID, now); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNote(t *testing.T) {
+	ctx := context.Background()
+	db := newDB(t)
+	now := time.Now()
+	m := newNote(t, "value", testOpts)
+
+	item := NewNote("id", m, now, time.Minute, 2)
+	if err := item.Save(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := Get(ctx, db, item.ID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Kind != KindNote || saved.HasFile() {
+		t.Errorf("failed kind=%s, file=%s", saved.Kind, saved.File)
+	}
+	if nm := saved.Msg; nm.Value != m.Value || nm.Salt != m.Salt || nm.KeyHash != m.KeyHash || nm.Cipher != m.Cipher {
+		t.Errorf("failed note message=%+v", nm)
+	}
+	if value, e := encrypt.DecryptText("secret", saved.Msg, testOpts); e != nil || value != "value" {
+		t.Errorf("failed note value=%s, error=%v", value, e)
+	}
+	for i := 1; i <= item.Times; i++ {
+		last, e := Use(ctx, db, item.ID, now)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if last != (i == item.Times) {
+			t.Errorf("failed last flag=%v for usage=%d", last, i)
+		}
+	}
+	if _, err = Use(ctx, db, item.ID, now); !errors.Is(err, ErrLimit) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err = Use(ctx, db, "unknown", now); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err = Use(ctx, db, item.ID, now.Add(time.Minute)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	items, err := Expired(ctx, db, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].ID != item.ID {
+		t.Errorf("failed expired items=%v", items)
+	}
+}
+
+func TestUseConcurrent(t *testing.T) {
+	const n = 10
+	ctx := context.Background()
+	db := newDB(t)
+	now := time.Now()
+	item := NewNote("id", &encrypt.Msg{}, now, time.Minute, 3)
+	if err := item.Save(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		wg            sync.WaitGroup
+		mu            sync.Mutex
+		success, last int
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			isLast, err := Use(ctx, db, item.ID, now)
+			if err != nil {
+				if !errors.Is(err, ErrLimit) {
+					t.Error(err)
+				}
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			success++
+			if isLast {
+				last++
+			}
+		}()
+	}
+	wg.Wait()
+	if success != item.Times || last != 1 {
+		t.Errorf("failed success=%d, last=%d", success, last)
+	}
+}
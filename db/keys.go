@@ -0,0 +1,103 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/z0rr0/ssf/encrypt"
+)
+
+// wrappedKeys are tables and their columns with message tokens, their data keys are wrapped by master keys.
+var wrappedKeys = []struct {
+	table  string
+	column string
+}{
+	{"ssf", "msg"},
+	{"ssf", "meta"},
+	{"upload", "msg"},
+}
+
+// RotateKeys rewraps data keys of all items and uploads by the current master key of the keyring
+// in a transaction. Encrypted files and texts are not changed, keys encrypted directly by passwords are skipped.
+// It returns a number of rewrapped keys.
+func RotateKeys(ctx context.Context, db *sql.DB, keyring *encrypt.Keyring) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin transaction: %w", err)
+	}
+	var total int
+	for _, wk := range wrappedKeys {
+		n, e := rotateKeys(ctx, tx, keyring, wk.table, wk.column)
+		if e != nil {
+			if re := tx.Rollback(); re != nil {
+				return 0, fmt.Errorf("%v, rollback: %w", e, re)
+			}
+			return 0, e
+		}
+		total += n
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit transaction: %w", err)
+	}
+	return total, nil
+}
+
+// rotateKeys rewraps not empty keys of message tokens in the table column inside the transaction.
+func rotateKeys(ctx context.Context, tx *sql.Tx, keyring *encrypt.Keyring, table, column string) (int, error) {
+	tokens, err := selectTokens(ctx, tx, table, column)
+	if err != nil {
+		return 0, err
+	}
+	update := fmt.Sprintf("UPDATE `%s` SET `%s`=? WHERE `id`=?;", table, column)
+	var n int
+	for id, t := range tokens {
+		m, e := encrypt.ParseToken(t)
+		if e != nil {
+			return 0, fmt.Errorf("parse %s.%s id=%s: %w", table, column, id, e)
+		}
+		if m.WrappedKey == "" {
+			continue
+		}
+		rewrapped, changed, e := keyring.Rewrap(m.WrappedKey)
+		if e != nil {
+			return 0, fmt.Errorf("rewrap %s.%s id=%s: %w", table, column, id, e)
+		}
+		if !changed {
+			continue
+		}
+		m.WrappedKey = rewrapped
+		if t, e = m.Token(); e != nil {
+			return 0, fmt.Errorf("token %s.%s id=%s: %w", table, column, id, e)
+		}
+		if _, err = tx.ExecContext(ctx, update, t, id); err != nil {
+			return 0, fmt.Errorf("update %s.%s: %w", table, column, err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// selectTokens returns not empty message tokens of the table column by row IDs.
+func selectTokens(ctx context.Context, tx *sql.Tx, table, column string) (map[string]string, error) {
+	query := fmt.Sprintf("SELECT `id`, `%s` FROM `%s` WHERE `%s`!='';", column, table, column)
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("select %s.%s: %w", table, column, err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	tokens := make(map[string]string)
+	for rows.Next() {
+		var id, t string
+		if err = rows.Scan(&id, &t); err != nil {
+			return nil, fmt.Errorf("scan %s.%s: %w", table, column, err)
+		}
+		tokens[id] = t
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("read %s.%s: %w", table, column, err)
+	}
+	return tokens, nil
+}
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/z0rr0/ssf/encrypt"
+)
+
+const (
+	testMasterKey1 = "old:000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	testMasterKey2 = "new:202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f"
+)
+
+func TestRotateKeys(t *testing.T) {
+	const secret = "secret"
+	ctx := context.Background()
+	db := newDB(t)
+	now := time.Now()
+	oldKeys, err := encrypt.ParseKeyring(testMasterKey1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &encrypt.Options{KDF: encrypt.Argon2id{Time: 1, Memory: 1024, Threads: 1}, Keyring: oldKeys}
+	m, err := encrypt.Text(secret, "note", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	note := NewNote("note", m, now, time.Hour, 1)
+	meta, err := (&encrypt.Meta{Name: "file"}).Encrypt(secret, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	note.Meta = meta
+	// items encrypted by password keys are not changed
+	legacy := NewNote("legacy", newNote(t, "value", testOpts), now, time.Hour, 1)
+	upload := NewUpload("upload", &encrypt.Upload{Msg: *m, Size: 10}, now, now.Add(time.Hour), time.Hour, 1)
+	for _, save := range []func() error{
+		func() error { return note.Save(ctx, db) },
+		func() error { return legacy.Save(ctx, db) },
+		func() error { return upload.Save(ctx, db) },
+	} {
+		if err = save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	newKeys, err := encrypt.ParseKeyring(testMasterKey2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = RotateKeys(ctx, db, newKeys); !errors.Is(err, encrypt.ErrMasterKey) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	bothKeys, err := encrypt.ParseKeyring(testMasterKey1 + "\n" + testMasterKey2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := RotateKeys(ctx, db, bothKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("failed rotated keys=%d", n)
+	}
+	if n, err = RotateKeys(ctx, db, bothKeys); err != nil || n != 0 {
+		t.Errorf("failed repeated rotation n=%d, error=%v", n, err)
+	}
+	saved, err := Get(ctx, db, note.ID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(saved.Msg.WrappedKey, "new:") || !strings.HasPrefix(saved.Meta.WrappedKey, "new:") {
+		t.Errorf("failed keys file=%s, meta=%s", saved.Msg.WrappedKey, saved.Meta.WrappedKey)
+	}
+	newOpts := &encrypt.Options{Keyring: newKeys}
+	if value, e := encrypt.DecryptText(secret, saved.Msg, newOpts); e != nil || value != "note" {
+		t.Errorf("failed note=%s, error=%v", value, e)
+	}
+	if e := (&encrypt.Meta{}).Decrypt(secret, saved.Meta, newOpts); e != nil {
+		t.Error(e)
+	}
+	if saved, err = Get(ctx, db, legacy.ID, now); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Msg.WrappedKey != "" || saved.Meta != nil {
+		t.Errorf("failed legacy item=%+v", saved)
+	}
+	savedUpload, err := GetUpload(ctx, db, upload.ID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(savedUpload.Msg.WrappedKey, "new:") {
+		t.Errorf("failed upload key=%s", savedUpload.Msg.WrappedKey)
+	}
+}
@@ -0,0 +1,181 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/z0rr0/ssf"
+	"github.com/z0rr0/ssf/encrypt"
+)
+
+// migration updates the database schema to the next version inside a transaction.
+type migration func(ctx context.Context, tx *sql.Tx) error
+
+// migrations are updates of existing databases, the number of applied ones is stored as PRAGMA user_version.
+// The first one updates the initial schema without version, it had only files protected by passwords.
+// Databases of not released schemas between them are not supported.
+// ssf.Schema creates a new database of the last version.
+var migrations = []migration{
+	// items kinds and usage limits, the initial usage counter was 1 by default without any limit
+	queries(
+		"ALTER TABLE `ssf` ADD COLUMN `kind` VARCHAR(8) NOT NULL DEFAULT 'file';",
+		"ALTER TABLE `ssf` ADD COLUMN `times` INTEGER NOT NULL DEFAULT 1;",
+		"UPDATE `ssf` SET `number`=0;",
+	),
+	// resumable uploads
+	queries(
+		"CREATE TABLE `upload` (`id` VARCHAR(64) PRIMARY KEY, `file` TEXT NOT NULL, `msg` TEXT NOT NULL, "+
+			"`meta` TEXT NOT NULL, `tail` TEXT NOT NULL, `size` INTEGER NOT NULL, `offset` INTEGER NOT NULL DEFAULT 0, "+
+			"`ttl` INTEGER NOT NULL, `times` INTEGER NOT NULL, `created` DATETIME NOT NULL, "+
+			"`updated` DATETIME NOT NULL, `expired` DATETIME NOT NULL);",
+		"CREATE INDEX `upload_expired` ON `upload` (`expired`);",
+	),
+	// message envelopes instead of salt and hash columns
+	messageTokens,
+}
+
+// Migrate creates the database schema or updates it to the last version in a transaction.
+// Databases without version and the items table are new ones, they are created by ssf.Schema.
+func Migrate(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	if err = migrate(ctx, tx); err != nil {
+		if e := tx.Rollback(); e != nil {
+			return fmt.Errorf("%v, rollback: %w", err, e)
+		}
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+// migrate applies not applied migrations inside the transaction.
+func migrate(ctx context.Context, tx *sql.Tx) error {
+	var version int
+	if err := tx.QueryRowContext(ctx, "PRAGMA user_version;").Scan(&version); err != nil {
+		return fmt.Errorf("select schema version: %w", err)
+	}
+	switch {
+	case version > len(migrations):
+		return fmt.Errorf("schema version %d is newer than %d", version, len(migrations))
+	case version == len(migrations):
+		return nil
+	case version == 0:
+		var n int
+		const query = "SELECT COUNT(*) FROM `sqlite_master` WHERE `type`='table' AND `name`='ssf';"
+		if err := tx.QueryRowContext(ctx, query).Scan(&n); err != nil {
+			return fmt.Errorf("select tables: %w", err)
+		}
+		if n == 0 {
+			if _, err := tx.ExecContext(ctx, ssf.Schema); err != nil {
+				return fmt.Errorf("create schema: %w", err)
+			}
+			return setVersion(ctx, tx, len(migrations))
+		}
+	}
+	for i := version; i < len(migrations); i++ {
+		if err := migrations[i](ctx, tx); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+	}
+	return setVersion(ctx, tx, len(migrations))
+}
+
+// setVersion saves the schema version, PRAGMA doesn't support query parameters.
+func setVersion(ctx context.Context, tx *sql.Tx, version int) error {
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version=%d;", version)); err != nil {
+		return fmt.Errorf("update schema version: %w", err)
+	}
+	return nil
+}
+
+// queries returns a migration which executes SQL queries one by one.
+func queries(items ...string) migration {
+	return func(ctx context.Context, tx *sql.Tx) error {
+		for _, query := range items {
+			if _, err := tx.ExecContext(ctx, query); err != nil {
+				return fmt.Errorf("query %q: %w", query, err)
+			}
+		}
+		return nil
+	}
+}
+
+// legacyItem contains salts and hashes of an item before message envelopes.
+type legacyItem struct {
+	id       string
+	meta     string
+	saltFile string
+	saltMeta string
+	hashFile string
+	hashMeta string
+}
+
+// messageTokens replaces salts and hashes of items by message tokens.
+// Messages have no cipher, so the format is detected by encrypted data.
+func messageTokens(ctx context.Context, tx *sql.Tx) error {
+	err := queries(
+		"ALTER TABLE `ssf` ADD COLUMN `msg` TEXT NOT NULL DEFAULT '';",
+		"UPDATE `ssf` SET `file`='' WHERE `file` IS NULL;",
+		"UPDATE `ssf` SET `meta`='' WHERE `meta` IS NULL;",
+	)(ctx, tx)
+	if err != nil {
+		return err
+	}
+	items, err := legacyItems(ctx, tx)
+	if err != nil {
+		return err
+	}
+	const update = "UPDATE `ssf` SET `msg`=?, `meta`=? WHERE `id`=?;"
+	for _, item := range items {
+		msg, e := token(&encrypt.Msg{Salt: item.saltFile, KeyHash: item.hashFile})
+		if e != nil {
+			return fmt.Errorf("item %s message: %w", item.id, e)
+		}
+		var meta string
+		if item.meta != "" {
+			meta, e = token(&encrypt.Msg{Salt: item.saltMeta, Value: item.meta, KeyHash: item.hashMeta})
+			if e != nil {
+				return fmt.Errorf("item %s meta data: %w", item.id, e)
+			}
+		}
+		if _, err = tx.ExecContext(ctx, update, msg, meta, item.id); err != nil {
+			return fmt.Errorf("update item %s: %w", item.id, err)
+		}
+	}
+	return queries(
+		"ALTER TABLE `ssf` DROP COLUMN `salt_file`;",
+		"ALTER TABLE `ssf` DROP COLUMN `salt_meta`;",
+		"ALTER TABLE `ssf` DROP COLUMN `hash_file`;",
+		"ALTER TABLE `ssf` DROP COLUMN `hash_meta`;",
+	)(ctx, tx)
+}
+
+// legacyItems returns salts and hashes of all items.
+func legacyItems(ctx context.Context, tx *sql.Tx) ([]*legacyItem, error) {
+	const query = "SELECT `id`, `meta`, `salt_file`, `salt_meta`, `hash_file`, `hash_meta` FROM `ssf`;"
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("select legacy items: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var items []*legacyItem
+	for rows.Next() {
+		item := &legacyItem{}
+		if err = rows.Scan(&item.id, &item.meta, &item.saltFile, &item.saltMeta, &item.hashFile, &item.hashMeta); err != nil {
+			return nil, fmt.Errorf("scan legacy item: %w", err)
+		}
+		items = append(items, item)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("read legacy items: %w", err)
+	}
+	return items, nil
+}
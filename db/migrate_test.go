@@ -0,0 +1,163 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/z0rr0/ssf/encrypt"
+)
+
+// baselineSchema is the initial database schema without version.
+const baselineSchema = "CREATE TABLE `ssf` (`id` VARCHAR(64) PRIMARY KEY, `file` TEXT, `meta` TEXT, " +
+	"`number` INTEGER NOT NULL DEFAULT 1, `salt_file` VARCHAR(256) NOT NULL, `salt_meta` VARCHAR(256) NOT NULL, " +
+	"`hash_file` VARCHAR(64) NOT NULL, `hash_meta` VARCHAR(64) NOT NULL, `created` DATETIME NOT NULL, " +
+	"`updated` DATETIME NOT NULL, `expired` DATETIME NOT NULL);" +
+	"CREATE INDEX `expired` ON `ssf` (`expired`, `number`);"
+
+func columns(t *testing.T, db *sql.DB, table string) []string {
+	rows, err := db.Query("SELECT `name` FROM pragma_table_info(?) ORDER BY `name`;", table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func schemaVersion(t *testing.T, db *sql.DB) int {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version;").Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	return version
+}
+
+func TestMigrate(t *testing.T) {
+	const secret = "secret"
+	ctx := context.Background()
+	now := time.Now()
+	dir := t.TempDir()
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "db.sqlite")+"?_busy_timeout=10000&_txlock=immediate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if e := db.Close(); e != nil {
+			t.Error(e)
+		}
+	}()
+	if _, err = db.Exec(baselineSchema); err != nil {
+		t.Fatal(err)
+	}
+	// legacy salts have no KDF parameters
+	opts := &encrypt.Options{KDF: encrypt.PBKDF2{Iter: 65536}, AD: []byte("id")}
+	m, err := encrypt.File(secret, strings.NewReader("content"), dir, "file", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := (&encrypt.Meta{Name: "name.txt"}).Encrypt(secret, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const insert = "INSERT INTO `ssf` (`id`, `file`, `meta`, `number`, `salt_file`, `salt_meta`, `hash_file`, `hash_meta`, " +
+		"`created`, `updated`, `expired`) VALUES (?, ?, ?, 0, ?, ?, ?, ?, ?, ?, ?);"
+	_, err = db.Exec(insert, "id", "file", meta.Value, m.Salt, meta.Salt, m.KeyHash, meta.KeyHash, Time(now), Time(now), Time(now.Add(time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(insert, "empty", nil, nil, m.Salt, "", m.KeyHash, "", Time(now), Time(now), Time(now.Add(time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the usage counter has default value
+	const insertDefault = "INSERT INTO `ssf` (`id`, `file`, `meta`, `salt_file`, `salt_meta`, `hash_file`, `hash_meta`, " +
+		"`created`, `updated`, `expired`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);"
+	_, err = db.Exec(
+		insertDefault, "default", "file", meta.Value, m.Salt, meta.Salt, m.KeyHash, meta.KeyHash,
+		Time(now), Time(now), Time(now.Add(time.Hour)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Migrate(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	if v := schemaVersion(t, db); v != len(migrations) {
+		t.Errorf("failed version=%d", v)
+	}
+	item, err := Get(ctx, db, "id", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Kind != KindFile || item.File != "file" || item.Times != 1 || item.Msg.Cipher != encrypt.CipherUnknown {
+		t.Errorf("failed item=%+v", item)
+	}
+	if err = encrypt.CheckSecret(secret, item.FileMsg(dir), opts); err != nil {
+		t.Error(err)
+	}
+	if err = encrypt.CheckSecret("bad", item.FileMsg(dir), opts); !errors.Is(err, encrypt.ErrSecret) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	decrypted := &encrypt.Meta{}
+	if err = decrypted.Decrypt(secret, item.Meta, opts); err != nil || decrypted.Name != "name.txt" {
+		t.Errorf("failed meta=%+v, error=%v", decrypted, err)
+	}
+	if item, err = Get(ctx, db, "empty", now); err != nil {
+		t.Fatal(err)
+	}
+	if item.HasFile() || item.Meta != nil {
+		t.Errorf("failed empty item=%+v", item)
+	}
+	if item, err = Get(ctx, db, "default", now); err != nil {
+		t.Fatal(err)
+	}
+	if item.Number != 0 || item.Times != 1 {
+		t.Errorf("failed default item number=%d, times=%d", item.Number, item.Times)
+	}
+	// not used items are not expired
+	expired, err := Expired(ctx, db, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 0 {
+		t.Errorf("failed expired items=%d", len(expired))
+	}
+	// migrated and new databases have the same columns
+	newSchema := newDB(t)
+	for _, table := range []string{"ssf", "upload"} {
+		if c, expected := columns(t, db, table), columns(t, newSchema, table); !reflect.DeepEqual(c, expected) {
+			t.Errorf("failed %s columns=%v, expected %v", table, c, expected)
+		}
+	}
+	if v := schemaVersion(t, newSchema); v != len(migrations) {
+		t.Errorf("failed new version=%d", v)
+	}
+	// the last version is not changed
+	if err = Migrate(ctx, db); err != nil {
+		t.Error(err)
+	}
+	if _, err = db.Exec("PRAGMA user_version=100;"); err != nil {
+		t.Fatal(err)
+	}
+	if err = Migrate(ctx, db); err == nil {
+		t.Error("expected error for unknown version")
+	}
+}
@@ -0,0 +1,203 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/z0rr0/ssf/encrypt"
+)
+
+// ErrConflict is an error when upload state is already changed by another request.
+var ErrConflict = errors.New("upload state conflict")
+
+// Upload is a not completed resumable upload of a file.
+// Item parameters are kept until the last part is received.
+// Msg is the file key message, it's stored as envelope token without the file name.
+type Upload struct {
+	ID      string
+	File    string
+	Msg     *encrypt.Msg
+	Meta    string
+	Tail    string
+	Size    int64
+	Offset  int64
+	TTL     time.Duration
+	Times   int
+	Created time.Time
+	Updated time.Time
+	Expired time.Time
+}
+
+// NewUpload returns new upload for the encryption state m, it expires at expired time.
+// Item parameters ttl and times are applied after the upload is completed.
+func NewUpload(id string, m *encrypt.Upload, now, expired time.Time, ttl time.Duration, times int) *Upload {
+	now = Time(now)
+	msg := m.Msg
+	msg.Value = ""
+	return &Upload{
+		ID:      id,
+		File:    filepath.Base(m.Msg.Value),
+		Msg:     &msg,
+		Meta:    m.Meta,
+		Tail:    m.Tail,
+		Size:    m.Size,
+		Offset:  m.Offset,
+		TTL:     ttl,
+		Times:   times,
+		Created: now,
+		Updated: now,
+		Expired: Time(expired),
+	}
+}
+
+// State returns encryption state of the upload, dir is a storage directory.
+func (u *Upload) State(dir string) *encrypt.Upload {
+	state := &encrypt.Upload{
+		Msg:    *u.Msg,
+		Meta:   u.Meta,
+		Tail:   u.Tail,
+		Size:   u.Size,
+		Offset: u.Offset,
+	}
+	state.Msg.Value = filepath.Join(dir, u.File)
+	return state
+}
+
+// Save inserts the upload to the database.
+func (u *Upload) Save(ctx context.Context, db *sql.DB) error {
+	msg, err := token(u.Msg)
+	if err != nil {
+		return fmt.Errorf("upload message: %w", err)
+	}
+	const query = "INSERT INTO `upload` " +
+		"(`id`, `file`, `msg`, `meta`, `tail`, `size`, `offset`, `ttl`, `times`, `created`, `updated`, `expired`) " +
+		"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);"
+	_, err = db.ExecContext(
+		ctx, query,
+		u.ID, u.File, msg, u.Meta, u.Tail, u.Size, u.Offset, int64(u.TTL/time.Second), u.Times,
+		u.Created, u.Updated, u.Expired,
+	)
+	if err != nil {
+		return fmt.Errorf("insert upload: %w", err)
+	}
+	return nil
+}
+
+// Update saves new encryption state m of the upload and sets its new expiration time.
+// It returns ErrConflict if the upload offset is already changed by another request.
+func (u *Upload) Update(ctx context.Context, db *sql.DB, m *encrypt.Upload, now, expired time.Time) error {
+	now, expired = Time(now), Time(expired)
+	const query = "UPDATE `upload` SET `offset`=?, `tail`=?, `updated`=?, `expired`=? WHERE `id`=? AND `offset`=?;"
+	result, err := db.ExecContext(ctx, query, m.Offset, m.Tail, now, expired, u.ID, u.Offset)
+	if err != nil {
+		return fmt.Errorf("update upload: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("update upload result: %w", err)
+	}
+	if n == 0 {
+		return ErrConflict
+	}
+	u.Offset, u.Tail, u.Updated, u.Expired = m.Offset, m.Tail, now, expired
+	return nil
+}
+
+// Complete saves the item created from the upload and deletes the upload in a transaction.
+// It returns ErrConflict if the upload offset is already changed by another request.
+func (u *Upload) Complete(ctx context.Context, db *sql.DB, item *Item) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	if err = u.complete(ctx, tx, item); err != nil {
+		if e := tx.Rollback(); e != nil {
+			return fmt.Errorf("%v, rollback: %w", err, e)
+		}
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+// complete saves the item and deletes the upload inside the transaction.
+func (u *Upload) complete(ctx context.Context, tx *sql.Tx, item *Item) error {
+	result, err := tx.ExecContext(ctx, "DELETE FROM `upload` WHERE `id`=? AND `offset`=?;", u.ID, u.Offset)
+	if err != nil {
+		return fmt.Errorf("delete upload: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("delete upload result: %w", err)
+	}
+	if n == 0 {
+		return ErrConflict
+	}
+	return item.save(ctx, tx)
+}
+
+// GetUpload returns not expired upload by its ID.
+func GetUpload(ctx context.Context, db *sql.DB, id string, now time.Time) (*Upload, error) {
+	const query = "SELECT `id`, `file`, `msg`, `meta`, `tail`, `size`, `offset`, `ttl`, `times`, " +
+		"`created`, `updated`, `expired` " +
+		"FROM `upload` WHERE `id`=? AND `expired`>?;"
+	var (
+		u   = &Upload{}
+		msg string
+		ttl int64
+	)
+	err := db.QueryRowContext(ctx, query, id, Time(now)).Scan(
+		&u.ID, &u.File, &msg, &u.Meta, &u.Tail, &u.Size, &u.Offset, &ttl, &u.Times,
+		&u.Created, &u.Updated, &u.Expired,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("select upload: %w", err)
+	}
+	if u.Msg, err = encrypt.ParseToken(msg); err != nil {
+		return nil, fmt.Errorf("upload message: %w", err)
+	}
+	u.TTL = time.Duration(ttl) * time.Second
+	return u, nil
+}
+
+// DeleteUpload removes upload by its ID.
+func DeleteUpload(ctx context.Context, db *sql.DB, id string) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM `upload` WHERE `id`=?;", id)
+	if err != nil {
+		return fmt.Errorf("delete upload: %w", err)
+	}
+	return nil
+}
+
+// ExpiredUploads returns uploads which are not completed before their expiration.
+func ExpiredUploads(ctx context.Context, db *sql.DB, now time.Time) ([]*Upload, error) {
+	const query = "SELECT `id`, `file` FROM `upload` WHERE `expired`<=?;"
+	rows, err := db.QueryContext(ctx, query, Time(now))
+	if err != nil {
+		return nil, fmt.Errorf("select expired uploads: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var uploads []*Upload
+	for rows.Next() {
+		u := &Upload{}
+		if err = rows.Scan(&u.ID, &u.File); err != nil {
+			return nil, fmt.Errorf("scan expired upload: %w", err)
+		}
+		uploads = append(uploads, u)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("read expired uploads: %w", err)
+	}
+	return uploads, nil
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/z0rr0/ssf/encrypt"
+)
+
+func TestUpload(t *testing.T) {
+	ctx := context.Background()
+	db := newDB(t)
+	now := time.Now()
+	m, err := encrypt.NewUpload("secret", t.TempDir(), 100, &encrypt.Meta{Name: "file"}, testOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := NewUpload("id", m, now, now.Add(time.Hour), time.Minute, 2)
+	if err = u.Save(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := GetUpload(ctx, db, u.ID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.File != u.File || saved.Meta != u.Meta || saved.Size != u.Size || saved.TTL != u.TTL || saved.Msg.Value != "" {
+		t.Errorf("failed saved upload=%+v", saved)
+	}
+	state := saved.State("/base")
+	if state.Msg.Value != "/base/"+u.File || state.Msg.Salt != m.Msg.Salt || state.Msg.KeyHash != m.Msg.KeyHash || state.Size != m.Size {
+		t.Errorf("failed upload state=%+v", state)
+	}
+	// the first request updates the state, the second one is based on the old offset
+	state.Offset, state.Tail = 50, "tail"
+	other := *saved
+	if err = saved.Update(ctx, db, state, now, now.Add(2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err = other.Update(ctx, db, state, now, now.Add(2*time.Hour)); !errors.Is(err, ErrConflict) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if saved, err = GetUpload(ctx, db, u.ID, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Offset != 50 || saved.Tail != "tail" {
+		t.Errorf("failed updated upload=%+v", saved)
+	}
+	uploads, err := ExpiredUploads(ctx, db, now.Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uploads) != 1 || uploads[0].ID != u.ID {
+		t.Errorf("failed expired uploads=%v", uploads)
+	}
+	item := NewItem(u.ID, &m.Msg, now, u.TTL, u.Times)
+	if err = other.Complete(ctx, db, item); !errors.Is(err, ErrConflict) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err = saved.Complete(ctx, db, item); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = GetUpload(ctx, db, u.ID, now); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err = Get(ctx, db, u.ID, now); err != nil {
+		t.Errorf("completed item is not found: %v", err)
+	}
+	if err = DeleteUpload(ctx, db, u.ID); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,281 @@
+package age
+
+// Package age reads and writes files in age v1 format (https://age-encryption.org/v1),
+// so they can be decrypted or created by age tools.
+//
+//	header  = "age-encryption.org/v1" LF | stanzas | "---" SP base64(HMAC-SHA256 of previous bytes) LF
+//	payload = nonce (16 bytes) | ChaCha20-Poly1305 chunks of ChunkSize plaintext bytes, the last one can be shorter
+//	chunk nonce = chunk counter (11 bytes, big endian) | last chunk flag (1 byte)
+//
+// A random file key is wrapped by X25519 stanzas of recipient package or by a single scrypt stanza of a passphrase.
+// The header MAC key and the payload key are derived from the file key by HKDF-SHA256.
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/z0rr0/ssf/encrypt/internal/aead"
+	"github.com/z0rr0/ssf/encrypt/recipient"
+)
+
+const (
+	// Magic is the first line of age files.
+	Magic = "age-encryption.org/v1\n"
+	// FileKeySize is a size of file keys.
+	FileKeySize = 16
+	// MaxHeaderSize is a max size of a header which can be read.
+	MaxHeaderSize = 64 << 10
+	// ScryptType is a type of passphrase stanzas.
+	ScryptType = "scrypt"
+	// DefaultWorkFactor is log2 of scrypt N parameter of new passphrase stanzas.
+	DefaultWorkFactor = 18
+	// MaxWorkFactor is a max log2 of scrypt N parameter, age tools don't decrypt stanzas with greater value.
+	MaxWorkFactor = 22
+
+	// footer is a prefix of the header's last line.
+	footer = "---"
+	// macSize is a size of the header MAC.
+	macSize = sha256.Size
+	// scryptLabel is a prefix of scrypt salt.
+	scryptLabel = "age-encryption.org/v1/scrypt"
+	// scryptSaltSize is a size of random scrypt salt.
+	scryptSaltSize = 16
+)
+
+var (
+	// ErrFormat is an error when age file has invalid format.
+	ErrFormat = errors.New("invalid age format")
+	// ErrAuth is an error when age header or payload is modified or truncated.
+	ErrAuth = errors.New("age authentication failed")
+)
+
+// Identity unwraps a file key from a stanza, recipient.ErrNoIdentity is returned for stanzas of others.
+// It's implemented by recipient.Identity and Passphrase.
+type Identity interface {
+	Unwrap(s *recipient.Stanza) ([]byte, error)
+}
+
+// NewFileKey returns a new random file key.
+func NewFileKey() ([]byte, error) {
+	fileKey := make([]byte, FileKeySize)
+	if _, err := io.ReadFull(rand.Reader, fileKey); err != nil {
+		return nil, fmt.Errorf("random file key: %w", err)
+	}
+	return fileKey, nil
+}
+
+// Passphrase wraps and unwraps file keys by scrypt stanzas.
+type Passphrase struct {
+	passphrase string
+	workFactor int
+}
+
+// NewPassphrase returns a passphrase with scrypt work factor, it's log2 of N parameter.
+// New stanzas use the work factor, stanzas with greater one are not unwrapped.
+func NewPassphrase(passphrase string, workFactor int) (*Passphrase, error) {
+	if passphrase == "" {
+		return nil, errors.New("empty passphrase")
+	}
+	if workFactor < 1 || workFactor > MaxWorkFactor {
+		return nil, fmt.Errorf("scrypt work factor %d is out of range [1, %d]", workFactor, MaxWorkFactor)
+	}
+	return &Passphrase{passphrase: passphrase, workFactor: workFactor}, nil
+}
+
+// key returns a wrapping key of the passphrase.
+func (p *Passphrase) key(salt []byte, workFactor int) ([]byte, error) {
+	s := make([]byte, 0, len(scryptLabel)+len(salt))
+	s = append(append(s, scryptLabel...), salt...)
+	key, err := scrypt.Key([]byte(p.passphrase), s, 1<<workFactor, 8, 1, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, fmt.Errorf("scrypt key: %w", err)
+	}
+	return key, nil
+}
+
+// Wrap encrypts the file key by the passphrase and returns it as scrypt stanza.
+func (p *Passphrase) Wrap(fileKey []byte) (*recipient.Stanza, error) {
+	salt := make([]byte, scryptSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, fmt.Errorf("random scrypt salt: %w", err)
+	}
+	key, err := p.key(salt, p.workFactor)
+	if err != nil {
+		return nil, err
+	}
+	body, err := aead.Seal(key, fileKey)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{aead.B64.EncodeToString(salt), strconv.Itoa(p.workFactor)}
+	return &recipient.Stanza{Type: ScryptType, Args: args, Body: body}, nil
+}
+
+// Unwrap decrypts the file key from scrypt stanza.
+// recipient.ErrNoIdentity is returned for other stanza types and if the passphrase is wrong.
+func (p *Passphrase) Unwrap(s *recipient.Stanza) ([]byte, error) {
+	if s.Type != ScryptType {
+		return nil, recipient.ErrNoIdentity
+	}
+	if len(s.Args) != 2 {
+		return nil, fmt.Errorf("%w: scrypt stanza must have two arguments", ErrFormat)
+	}
+	salt, err := aead.B64.DecodeString(s.Args[0])
+	if err != nil || len(salt) != scryptSaltSize {
+		return nil, fmt.Errorf("%w: invalid scrypt salt", ErrFormat)
+	}
+	workFactor, err := strconv.Atoi(s.Args[1])
+	if err != nil || workFactor < 1 || strconv.Itoa(workFactor) != s.Args[1] {
+		return nil, fmt.Errorf("%w: invalid scrypt work factor", ErrFormat)
+	}
+	if workFactor > p.workFactor {
+		return nil, fmt.Errorf("scrypt work factor %d is greater than %d", workFactor, p.workFactor)
+	}
+	if len(s.Body) != FileKeySize+chacha20poly1305.Overhead {
+		return nil, fmt.Errorf("%w: invalid scrypt stanza body", ErrFormat)
+	}
+	key, err := p.key(salt, workFactor)
+	if err != nil {
+		return nil, err
+	}
+	fileKey, err := aead.Open(key, s.Body)
+	if err != nil {
+		return nil, recipient.ErrNoIdentity
+	}
+	return fileKey, nil
+}
+
+// Header is age file header.
+type Header struct {
+	Stanzas []*recipient.Stanza
+	MAC     []byte
+}
+
+// NewHeader returns a header with the stanzas authenticated by the file key.
+// Scrypt stanza can't be used with other ones.
+func NewHeader(fileKey []byte, stanzas []*recipient.Stanza) (*Header, error) {
+	if len(fileKey) != FileKeySize {
+		return nil, fmt.Errorf("file key size %d, expected %d", len(fileKey), FileKeySize)
+	}
+	h := &Header{Stanzas: stanzas}
+	if err := h.check(); err != nil {
+		return nil, err
+	}
+	h.MAC = h.mac(fileKey)
+	return h, nil
+}
+
+// check returns ErrFormat if the header has no stanzas or scrypt stanza is not alone.
+func (h *Header) check() error {
+	if len(h.Stanzas) == 0 {
+		return fmt.Errorf("%w: no stanzas", ErrFormat)
+	}
+	for _, s := range h.Stanzas {
+		if s.Type == ScryptType && len(h.Stanzas) != 1 {
+			return fmt.Errorf("%w: scrypt stanza must be alone", ErrFormat)
+		}
+	}
+	return nil
+}
+
+// prefix returns the header bytes which are authenticated by MAC.
+func (h *Header) prefix() string {
+	var b strings.Builder
+	b.WriteString(Magic)
+	for _, s := range h.Stanzas {
+		b.WriteString(s.String())
+	}
+	b.WriteString(footer)
+	return b.String()
+}
+
+// mac returns HMAC-SHA256 of the header by a key derived from the file key.
+func (h *Header) mac(fileKey []byte) []byte {
+	m := hmac.New(sha256.New, aead.DeriveKey(fileKey, nil, "header"))
+	m.Write([]byte(h.prefix()))
+	return m.Sum(nil)
+}
+
+// String returns text form of the header.
+func (h *Header) String() string {
+	return h.prefix() + " " + aead.B64.EncodeToString(h.MAC) + "\n"
+}
+
+// Verify returns ErrAuth if the header MAC doesn't match the file key.
+func (h *Header) Verify(fileKey []byte) error {
+	if len(fileKey) != FileKeySize || !hmac.Equal(h.mac(fileKey), h.MAC) {
+		return fmt.Errorf("header MAC: %w", ErrAuth)
+	}
+	return nil
+}
+
+// Unwrap returns the file key from the first stanza which can be decrypted by any of the identities.
+// The header is verified by the found file key.
+func (h *Header) Unwrap(identities []Identity) ([]byte, error) {
+	if err := h.check(); err != nil {
+		return nil, err
+	}
+	for _, s := range h.Stanzas {
+		for _, i := range identities {
+			fileKey, err := i.Unwrap(s)
+			if errors.Is(err, recipient.ErrNoIdentity) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if err = h.Verify(fileKey); err != nil {
+				return nil, err
+			}
+			return fileKey, nil
+		}
+	}
+	return nil, recipient.ErrNoIdentity
+}
+
+// ReadHeader reads the header from src, it returns the header and a reader of the payload after it.
+// Headers longer than MaxHeaderSize are not read.
+func ReadHeader(src io.Reader) (*Header, io.Reader, error) {
+	limited := &io.LimitedReader{R: src, N: MaxHeaderSize}
+	br := bufio.NewReader(limited)
+	line, err := br.ReadString('\n')
+	if err != nil || line != Magic {
+		return nil, nil, fmt.Errorf("%w: header signature", ErrFormat)
+	}
+	h := &Header{}
+	for {
+		line, err = br.ReadString('\n')
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: header is truncated or too long", ErrFormat)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if strings.HasPrefix(line, footer) {
+			mac, e := aead.B64.DecodeString(strings.TrimPrefix(line, footer+" "))
+			if e != nil || len(mac) != macSize || !strings.HasPrefix(line, footer+" ") {
+				return nil, nil, fmt.Errorf("%w: malformed header MAC", ErrFormat)
+			}
+			h.MAC = mac
+			break
+		}
+		s, e := recipient.ReadStanza(br, line)
+		if e != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrFormat, e)
+		}
+		h.Stanzas = append(h.Stanzas, s)
+	}
+	if err = h.check(); err != nil {
+		return nil, nil, err
+	}
+	// buffered bytes are read before the rest of src
+	return h, io.MultiReader(br, src), nil
+}
@@ -0,0 +1,184 @@
+package age
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/z0rr0/ssf/encrypt/recipient"
+)
+
+// Test files are created by age tool, the scrypt one uses work factor 10.
+const (
+	testText       = "age interop test\n"
+	testPassphrase = "password"
+	testScrypt     = "YWdlLWVuY3J5cHRpb24ub3JnL3YxCi0+IHNjcnlwdCBUcEdSdzJlOWlDcUloKy96T2U2S0h3IDEwClhJTW9PSHQ0aThQWFJ6eDMw" +
+		"QjNzb2FmdVZhYVdFT2Z1OUpoVTMzRW81eGsKLS0tIHhKZW05Z1FqUnlNSlN3NXRGQjRBTTRpVWhhV1VzLytDN0d2aWxNd1M1d2cK2WviKi+j" +
+		"CtLKoB/GLDkmRx4n0H83iKS35FB4X8KCO0TAa7DKLRjo0iBfNSBVz1HLDw=="
+	testX25519 = "YWdlLWVuY3J5cHRpb24ub3JnL3YxCi0+IFgyNTUxOSBrbEJnMjJ6aHd2bEthVWVTSzU3K0JWa09PejRIV3puczVSdkt0bW9UeG04" +
+		"Cmw1c0U2UVlHTGs1LzRQMnBjWDlDRndpMjNJOFExU1doNXRVWmpPVmlXZTgKLS0tIGQzSkFqK0owWjJRNjdIMUN6dmplNytvRUd5UzNSQ2hG" +
+		"eDRkWUt3ajcvWHMK0NlfcbOMB7qThe1qiXTYqSXXnsPHpOYIPB6PevPLm/9mnVRDmfQctMTG3TaOHbRd8w=="
+	testIdentity = "AGE-SECRET-KEY-16SNKDNTR87E8FQYPWK2P9LHQERT5QQQV3DHMSNKNX775LEGUK7ZQJWTEMG"
+)
+
+func decodeFile(t *testing.T, s string) []byte {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func newPassphrase(t *testing.T, passphrase string) *Passphrase {
+	p, err := NewPassphrase(passphrase, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestDecryptIdentities(t *testing.T) {
+	identity, err := recipient.ParseIdentity(testIdentity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		file     string
+		identity Identity
+	}{
+		{file: testScrypt, identity: newPassphrase(t, testPassphrase)},
+		{file: testX25519, identity: identity},
+	}
+	for i, c := range cases {
+		var dst bytes.Buffer
+		err = DecryptIdentities(bytes.NewReader(decodeFile(t, c.file)), &dst, []Identity{c.identity})
+		if err != nil {
+			t.Fatalf("failed case=%d: %v", i, err)
+		}
+		if s := dst.String(); s != testText {
+			t.Errorf("failed case=%d, text=%q", i, s)
+		}
+	}
+	// wrong identities
+	other, err := recipient.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, file := range []string{testScrypt, testX25519} {
+		identities := []Identity{newPassphrase(t, "other"), other}
+		err = DecryptIdentities(bytes.NewReader(decodeFile(t, file)), &bytes.Buffer{}, identities)
+		if !errors.Is(err, recipient.ErrNoIdentity) {
+			t.Errorf("failed case=%d, error=%v", i, err)
+		}
+	}
+}
+
+func TestPassphrase(t *testing.T) {
+	fileKey, err := NewFileKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newPassphrase(t, testPassphrase)
+	s, err := p.Wrap(fileKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Type != ScryptType || len(s.Args) != 2 || s.Args[1] != "10" {
+		t.Fatalf("failed stanza %v", s)
+	}
+	key, err := p.Unwrap(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, fileKey) {
+		t.Errorf("failed file key=%x", key)
+	}
+	// decryption is limited by max work factor of the passphrase
+	weak, err := NewPassphrase(testPassphrase, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = weak.Unwrap(s); err == nil {
+		t.Error("expected error for high work factor")
+	}
+	stanzas := []*recipient.Stanza{
+		{Type: ScryptType, Args: s.Args[:1], Body: s.Body},
+		{Type: ScryptType, Args: []string{"salt", "10"}, Body: s.Body},
+		{Type: ScryptType, Args: []string{s.Args[0], "010"}, Body: s.Body},
+		{Type: ScryptType, Args: s.Args, Body: s.Body[1:]},
+	}
+	for i, stanza := range stanzas {
+		if _, err = p.Unwrap(stanza); !errors.Is(err, ErrFormat) {
+			t.Errorf("failed case=%d, error=%v", i, err)
+		}
+	}
+	if _, err = p.Unwrap(&recipient.Stanza{Type: recipient.StanzaType}); !errors.Is(err, recipient.ErrNoIdentity) {
+		t.Errorf("failed error=%v", err)
+	}
+	for _, wf := range []int{0, MaxWorkFactor + 1} {
+		if _, err = NewPassphrase(testPassphrase, wf); err == nil {
+			t.Errorf("expected error for work factor %d", wf)
+		}
+	}
+	if _, err = NewPassphrase("", DefaultWorkFactor); err == nil {
+		t.Error("expected error for empty passphrase")
+	}
+}
+
+func TestHeader(t *testing.T) {
+	file := decodeFile(t, testX25519)
+	h, payload, err := ReadHeader(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := h.String()
+	if !strings.HasPrefix(string(file), header) {
+		t.Errorf("failed header %q", header)
+	}
+	var rest bytes.Buffer
+	if _, err = rest.ReadFrom(payload); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rest.Bytes(), file[len(header):]) {
+		t.Error("failed payload")
+	}
+	if err = h.Verify(make([]byte, FileKeySize)); !errors.Is(err, ErrAuth) {
+		t.Errorf("failed error=%v", err)
+	}
+	lines := strings.SplitAfter(header, "\n")
+	cases := []string{
+		"",
+		"age-encryption.org/v2\n" + strings.Join(lines[1:], ""),
+		lines[0] + lines[3],
+		strings.Join(lines[:3], ""),
+		strings.Join(lines[:3], "") + "---\n",
+		strings.Join(lines[:3], "") + "--- AAAA\n",
+		strings.Join(lines[:3], "") + strings.Replace(lines[3], " ", "", 1),
+		lines[0] + strings.Repeat("-> X a\n\n", MaxHeaderSize/8) + lines[3],
+	}
+	for i, c := range cases {
+		if _, _, err = ReadHeader(strings.NewReader(c)); !errors.Is(err, ErrFormat) {
+			t.Errorf("failed case=%d, error=%v", i, err)
+		}
+	}
+	// scrypt stanza must be alone
+	fileKey, err := NewFileKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := newPassphrase(t, testPassphrase).Wrap(fileKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewHeader(fileKey, []*recipient.Stanza{s, h.Stanzas[0]}); !errors.Is(err, ErrFormat) {
+		t.Errorf("failed error=%v", err)
+	}
+	if _, err = NewHeader(fileKey, nil); !errors.Is(err, ErrFormat) {
+		t.Errorf("failed error=%v", err)
+	}
+	if _, err = NewHeader(fileKey[1:], []*recipient.Stanza{s}); err == nil {
+		t.Error("expected error for short file key")
+	}
+}
@@ -0,0 +1,351 @@
+package age
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"github.com/z0rr0/ssf/encrypt/internal/aead"
+	"github.com/z0rr0/ssf/encrypt/recipient"
+)
+
+const (
+	// ChunkSize is a max size of plaintext chunk.
+	ChunkSize = 64 << 10
+	// Overhead is a size of authentication tag of every chunk.
+	Overhead = chacha20poly1305.Overhead
+	// NonceSize is a size of random payload nonce.
+	NonceSize = 16
+)
+
+// newAEAD returns ChaCha20-Poly1305 cipher with a payload key derived from the file key and the nonce.
+func newAEAD(fileKey, nonce []byte) (cipher.AEAD, error) {
+	return chacha20poly1305.New(aead.DeriveKey(fileKey, nonce, "payload"))
+}
+
+// chunkNonce returns a nonce of chunk with number counter.
+func chunkNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// writer encrypts payload by chunks, the last chunk is written by Close.
+type writer struct {
+	dst     io.Writer
+	aead    cipher.AEAD
+	counter uint64
+	buf     []byte
+	out     []byte
+	closed  bool
+}
+
+// NewWriter writes the header with the stanzas to dst and returns a writer which encrypts payload by the file key.
+// Close must be called to write the last chunk.
+func NewWriter(dst io.Writer, fileKey []byte, stanzas []*recipient.Stanza) (io.WriteCloser, error) {
+	h, err := NewHeader(fileKey, stanzas)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, NonceSize)
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("random nonce: %w", err)
+	}
+	aead, err := newAEAD(fileKey, nonce)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.WriteString(dst, h.String()); err != nil {
+		return nil, fmt.Errorf("write header: %w", err)
+	}
+	if _, err = dst.Write(nonce); err != nil {
+		return nil, fmt.Errorf("write nonce: %w", err)
+	}
+	w := &writer{
+		dst:  dst,
+		aead: aead,
+		buf:  make([]byte, 0, ChunkSize),
+		out:  make([]byte, 0, ChunkSize+Overhead),
+	}
+	return w, nil
+}
+
+// Write encrypts p. A full chunk is written only when the next data is received,
+// because the last chunk has to be marked.
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to closed payload")
+	}
+	var n int
+	for len(p) > 0 {
+		if len(w.buf) == ChunkSize {
+			if err := w.flush(false); err != nil {
+				return n, err
+			}
+		}
+		k := ChunkSize - len(w.buf)
+		if k > len(p) {
+			k = len(p)
+		}
+		w.buf = append(w.buf, p[:k]...)
+		p = p[k:]
+		n += k
+	}
+	return n, nil
+}
+
+// Close writes the last chunk.
+func (w *writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.flush(true)
+}
+
+// flush encrypts and writes the buffered chunk.
+func (w *writer) flush(last bool) error {
+	w.out = w.aead.Seal(w.out[:0], chunkNonce(w.counter, last), w.buf, nil)
+	if _, err := w.dst.Write(w.out); err != nil {
+		return fmt.Errorf("write chunk: %w", err)
+	}
+	w.buf = w.buf[:0]
+	w.counter++
+	return nil
+}
+
+// reader decrypts payload by chunks.
+type reader struct {
+	src     *bufio.Reader
+	aead    cipher.AEAD
+	counter uint64
+	buf     []byte
+	plain   []byte
+	done    bool
+}
+
+// NewReader reads the header from src, verifies it by the file key and returns a reader of decrypted payload.
+// Every chunk plaintext is returned only after its authentication.
+func NewReader(src io.Reader, fileKey []byte) (io.Reader, error) {
+	h, payload, err := ReadHeader(src)
+	if err != nil {
+		return nil, err
+	}
+	if err = h.Verify(fileKey); err != nil {
+		return nil, err
+	}
+	return newReader(payload, fileKey)
+}
+
+// newReader returns a reader of payload after the header.
+func newReader(payload io.Reader, fileKey []byte) (io.Reader, error) {
+	nonce := make([]byte, NonceSize)
+	if _, err := io.ReadFull(payload, nonce); err != nil {
+		return nil, fmt.Errorf("read nonce: %w", ErrAuth)
+	}
+	aead, err := newAEAD(fileKey, nonce)
+	if err != nil {
+		return nil, err
+	}
+	r := &reader{
+		src:  bufio.NewReaderSize(payload, ChunkSize+Overhead),
+		aead: aead,
+		buf:  make([]byte, ChunkSize+Overhead),
+	}
+	return r, nil
+}
+
+// Read reads decrypted data.
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// next reads and decrypts a chunk.
+func (r *reader) next() error {
+	var last bool
+	n, err := io.ReadFull(r.src, r.buf)
+	switch {
+	case err == io.EOF:
+		// no more chunks, but the last one was not found
+		return fmt.Errorf("chunk %d is missing: %w", r.counter, ErrAuth)
+	case err == io.ErrUnexpectedEOF:
+		last = true
+	case err != nil:
+		return fmt.Errorf("read chunk: %w", err)
+	default:
+		_, err = r.src.Peek(1)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("read chunk: %w", err)
+		}
+		last = err == io.EOF
+	}
+	r.plain, err = r.aead.Open(r.buf[:0], chunkNonce(r.counter, last), r.buf[:n], nil)
+	if err != nil {
+		return fmt.Errorf("chunk %d: %w", r.counter, ErrAuth)
+	}
+	if last && len(r.plain) == 0 && r.counter > 0 {
+		return fmt.Errorf("%w: empty last chunk", ErrFormat)
+	}
+	r.counter++
+	r.done = last
+	return nil
+}
+
+// Encrypt writes age file with content from src to dst, the file key is wrapped by the stanzas.
+func Encrypt(src io.Reader, dst io.Writer, fileKey []byte, stanzas []*recipient.Stanza) error {
+	w, err := NewWriter(dst, fileKey, stanzas)
+	if err != nil {
+		return fmt.Errorf("encrypt age: %w", err)
+	}
+	if _, err = io.Copy(w, src); err != nil {
+		return fmt.Errorf("copy for encryption: %w", err)
+	}
+	if err = w.Close(); err != nil {
+		return fmt.Errorf("close age payload: %w", err)
+	}
+	return nil
+}
+
+// Decrypt writes decrypted payload of age file src to dst by the file key.
+func Decrypt(src io.Reader, dst io.Writer, fileKey []byte) error {
+	r, err := NewReader(src, fileKey)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(dst, r); err != nil {
+		return fmt.Errorf("copy for decryption: %w", err)
+	}
+	return nil
+}
+
+// DecryptIdentities writes decrypted payload of age file src to dst,
+// the file key is unwrapped by any of the identities.
+func DecryptIdentities(src io.Reader, dst io.Writer, identities []Identity) error {
+	h, payload, err := ReadHeader(src)
+	if err != nil {
+		return err
+	}
+	fileKey, err := h.Unwrap(identities)
+	if err != nil {
+		return err
+	}
+	r, err := newReader(payload, fileKey)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(dst, r); err != nil {
+		return fmt.Errorf("copy for decryption: %w", err)
+	}
+	return nil
+}
+
+// EncryptedSize returns a size of age file with the header size for plaintext with the size.
+func EncryptedSize(headerSize, size int64) int64 {
+	chunks := (size + ChunkSize - 1) / ChunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+	return headerSize + NonceSize + size + chunks*Overhead
+}
+
+// PlainSize returns plaintext size of the payload with the size, it includes the nonce.
+func PlainSize(size int64) (int64, error) {
+	chunks, err := chunkCount(size)
+	if err != nil {
+		return 0, err
+	}
+	return size - NonceSize - chunks*Overhead, nil
+}
+
+// chunkCount returns a number of chunks of the payload with the size.
+func chunkCount(size int64) (int64, error) {
+	const sealedSize = ChunkSize + Overhead
+	body := size - NonceSize
+	if body < Overhead {
+		return 0, fmt.Errorf("payload size %d: %w", size, ErrAuth)
+	}
+	chunks := (body + sealedSize - 1) / sealedSize
+	if last := body - (chunks-1)*sealedSize; last < Overhead || last == Overhead && chunks > 1 {
+		return 0, fmt.Errorf("payload size %d: %w", size, ErrAuth)
+	}
+	return chunks, nil
+}
+
+// DecryptRange decrypts n bytes from the offset off of age file src with the size to dst by the file key.
+// Only required chunks are decrypted, each of them is authenticated.
+func DecryptRange(src io.ReaderAt, size int64, dst io.Writer, fileKey []byte, off, n int64) error {
+	if off < 0 || n < 0 {
+		return fmt.Errorf("invalid range offset=%d, length=%d", off, n)
+	}
+	h, _, err := ReadHeader(io.NewSectionReader(src, 0, size))
+	if err != nil {
+		return err
+	}
+	if err = h.Verify(fileKey); err != nil {
+		return err
+	}
+	headerSize := int64(len(h.String()))
+	chunks, err := chunkCount(size - headerSize)
+	if err != nil {
+		return err
+	}
+	if plainSize := size - headerSize - NonceSize - chunks*Overhead; off+n > plainSize {
+		return fmt.Errorf("range [%d, %d) is out of size %d", off, off+n, plainSize)
+	}
+	if n == 0 {
+		return nil
+	}
+	nonce := make([]byte, NonceSize)
+	if _, err = src.ReadAt(nonce, headerSize); err != nil {
+		return fmt.Errorf("read nonce: %w", err)
+	}
+	aead, err := newAEAD(fileKey, nonce)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, ChunkSize+Overhead)
+	for i := off / ChunkSize; n > 0; i++ {
+		start := headerSize + NonceSize + i*(ChunkSize+Overhead)
+		sealed, last := buf, i == chunks-1
+		if last {
+			sealed = buf[:size-start]
+		}
+		if _, err = src.ReadAt(sealed, start); err != nil {
+			return fmt.Errorf("read chunk: %w", err)
+		}
+		plain, err := aead.Open(sealed[:0], chunkNonce(uint64(i), last), sealed, nil)
+		if err != nil {
+			return fmt.Errorf("chunk %d: %w", i, ErrAuth)
+		}
+		// the first chunk can be partial
+		plain = plain[off-i*ChunkSize:]
+		if int64(len(plain)) > n {
+			plain = plain[:n]
+		}
+		if _, err = dst.Write(plain); err != nil {
+			return fmt.Errorf("write range: %w", err)
+		}
+		off += int64(len(plain))
+		n -= int64(len(plain))
+	}
+	return nil
+}
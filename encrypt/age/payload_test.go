@@ -0,0 +1,146 @@
+package age
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/z0rr0/ssf/encrypt/recipient"
+)
+
+func encrypt(t *testing.T, plainText, fileKey []byte) []byte {
+	identity, err := recipient.ParseIdentity(testIdentity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := identity.Recipient().Wrap(fileKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dst bytes.Buffer
+	if err = Encrypt(bytes.NewReader(plainText), &dst, fileKey, []*recipient.Stanza{s}); err != nil {
+		t.Fatal(err)
+	}
+	return dst.Bytes()
+}
+
+func TestEncryptSizes(t *testing.T) {
+	fileKey, err := NewFileKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity, err := recipient.ParseIdentity(testIdentity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []int{0, 1, ChunkSize - 1, ChunkSize, ChunkSize + 1, 3 * ChunkSize, 3*ChunkSize + 7}
+	for _, n := range cases {
+		plainText := bytes.Repeat([]byte{'a'}, n)
+		file := encrypt(t, plainText, fileKey)
+		h, _, err := ReadHeader(bytes.NewReader(file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		headerSize := int64(len(h.String()))
+		if size := EncryptedSize(headerSize, int64(n)); size != int64(len(file)) {
+			t.Errorf("failed size=%d, expected %d for n=%d", size, len(file), n)
+		}
+		if size, e := PlainSize(int64(len(file)) - headerSize); e != nil || size != int64(n) {
+			t.Errorf("failed plain size=%d for n=%d: %v", size, n, e)
+		}
+		var dst bytes.Buffer
+		if err = Decrypt(bytes.NewReader(file), &dst, fileKey); err != nil {
+			t.Fatalf("failed n=%d: %v", n, err)
+		}
+		if !bytes.Equal(dst.Bytes(), plainText) {
+			t.Errorf("failed decrypted value for n=%d", n)
+		}
+		dst.Reset()
+		if err = DecryptIdentities(bytes.NewReader(file), &dst, []Identity{identity}); err != nil {
+			t.Fatalf("failed n=%d: %v", n, err)
+		}
+		if !bytes.Equal(dst.Bytes(), plainText) {
+			t.Errorf("failed decrypted value for n=%d", n)
+		}
+	}
+}
+
+func TestDecryptError(t *testing.T) {
+	fileKey, err := NewFileKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := encrypt(t, bytes.Repeat([]byte{'a'}, 2*ChunkSize+10), fileKey)
+	h, _, err := ReadHeader(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	headerSize := len(h.String())
+	modified := append([]byte{}, file...)
+	modified[len(modified)-1] ^= 1
+	chunk := headerSize + NonceSize + ChunkSize + Overhead
+	cases := [][]byte{
+		file[:len(file)-1],
+		file[:chunk],
+		file[:headerSize+NonceSize],
+		file[:headerSize+3],
+		modified,
+		append(append([]byte{}, file[:chunk]...), file[chunk+ChunkSize+Overhead:]...),
+	}
+	for i, c := range cases {
+		if err = Decrypt(bytes.NewReader(c), &bytes.Buffer{}, fileKey); !errors.Is(err, ErrAuth) {
+			t.Errorf("failed case=%d, error=%v", i, err)
+		}
+	}
+	other, err := NewFileKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Decrypt(bytes.NewReader(file), &bytes.Buffer{}, other); !errors.Is(err, ErrAuth) {
+		t.Errorf("failed error=%v", err)
+	}
+	// the full last chunk can't be followed by an empty one
+	empty := encrypt(t, bytes.Repeat([]byte{'a'}, ChunkSize), fileKey)
+	if _, err = PlainSize(int64(len(empty)-headerSize) + Overhead); !errors.Is(err, ErrAuth) {
+		t.Errorf("failed error=%v", err)
+	}
+}
+
+func TestDecryptRange(t *testing.T) {
+	fileKey, err := NewFileKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plainText := make([]byte, 3*ChunkSize+100)
+	for i := range plainText {
+		plainText[i] = byte(i % 251)
+	}
+	file := encrypt(t, plainText, fileKey)
+	size := int64(len(file))
+	cases := [][2]int64{
+		{0, 0},
+		{0, 10},
+		{ChunkSize - 5, 10},
+		{ChunkSize, ChunkSize},
+		{10, int64(len(plainText)) - 10},
+		{int64(len(plainText)) - 1, 1},
+	}
+	for _, c := range cases {
+		var dst bytes.Buffer
+		if err = DecryptRange(bytes.NewReader(file), size, &dst, fileKey, c[0], c[1]); err != nil {
+			t.Fatalf("failed range %v: %v", c, err)
+		}
+		if !bytes.Equal(dst.Bytes(), plainText[c[0]:c[0]+c[1]]) {
+			t.Errorf("failed range %v", c)
+		}
+	}
+	if err = DecryptRange(bytes.NewReader(file), size, &bytes.Buffer{}, fileKey, 0, size); err == nil {
+		t.Error("expected error for out of size range")
+	}
+	modified := append([]byte{}, file...)
+	modified[len(modified)-5] ^= 1
+	err = DecryptRange(bytes.NewReader(modified), size, &bytes.Buffer{}, fileKey, int64(len(plainText))-1, 1)
+	if !errors.Is(err, ErrAuth) {
+		t.Errorf("failed error=%v", err)
+	}
+}
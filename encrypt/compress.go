@@ -0,0 +1,229 @@
+package encrypt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/z0rr0/ssf/encrypt/stream"
+)
+
+// Compression is an algorithm which compresses plaintext before encryption.
+type Compression uint8
+
+// Compressions of messages, encrypted data don't have any signs of it,
+// so it's stored in the authenticated message envelope.
+const (
+	// CompressionNone means that plaintext is not compressed.
+	CompressionNone Compression = iota
+	// CompressionGzip is gzip (RFC 1952) compression.
+	CompressionGzip
+)
+
+// compressionNames are names of compressions in configuration and JSON envelopes.
+var compressionNames = [...]string{
+	CompressionNone: "none",
+	CompressionGzip: "gzip",
+}
+
+// incompressibleTypes are media types of already compressed data.
+var incompressibleTypes = map[string]bool{
+	"application/epub+zip":         true,
+	"application/gzip":             true,
+	"application/java-archive":     true,
+	"application/pdf":              true,
+	"application/vnd.rar":          true,
+	"application/x-7z-compressed":  true,
+	"application/x-bzip2":          true,
+	"application/x-gzip":           true,
+	"application/x-rar-compressed": true,
+	"application/x-xz":             true,
+	"application/zip":              true,
+	"application/zstd":             true,
+	"font/woff":                    true,
+	"font/woff2":                   true,
+}
+
+// String returns the compression name.
+func (c Compression) String() string {
+	if int(c) < len(compressionNames) {
+		return compressionNames[c]
+	}
+	return fmt.Sprintf("compression(%d)", uint8(c))
+}
+
+// ParseCompression returns a compression by its name, empty name is CompressionNone.
+func ParseCompression(name string) (Compression, error) {
+	if name == "" {
+		return CompressionNone, nil
+	}
+	for i, n := range compressionNames {
+		if n == name {
+			return Compression(i), nil
+		}
+	}
+	return CompressionNone, fmt.Errorf("unknown compression %q", name)
+}
+
+// Compressible returns false for media types of already compressed data, like images, video or archives.
+// Unknown and empty types are compressible.
+func Compressible(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return true
+	}
+	switch {
+	case incompressibleTypes[mt]:
+		return false
+	case mt == "image/svg+xml" || mt == "image/bmp":
+		return true
+	case strings.HasPrefix(mt, "image/") || strings.HasPrefix(mt, "video/") || strings.HasPrefix(mt, "audio/"):
+		return false
+	case strings.HasSuffix(mt, "+zip") || strings.HasPrefix(mt, "application/vnd.openxmlformats-officedocument."):
+		return false
+	}
+	return true
+}
+
+// compressWriter returns a writer which compresses data to dst, Close must be called to flush them.
+func (c Compression) compressWriter(dst io.Writer) (io.WriteCloser, error) {
+	switch c {
+	case CompressionGzip:
+		return gzip.NewWriter(dst), nil
+	}
+	return nil, fmt.Errorf("unknown compression %v", c)
+}
+
+// decompressReader returns a reader which decompresses data from src.
+func (c Compression) decompressReader(src io.Reader) (io.Reader, error) {
+	switch c {
+	case CompressionGzip:
+		r, err := gzip.NewReader(src)
+		if err != nil {
+			return nil, fmt.Errorf("gzip reader: %w", err)
+		}
+		return r, nil
+	}
+	return nil, fmt.Errorf("unknown compression %v", c)
+}
+
+// compress returns compressed data or nil if they are not shorter than the original ones.
+func (c Compression) compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := c.compressWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, fmt.Errorf("compress: %w", err)
+	}
+	if err = w.Close(); err != nil {
+		return nil, fmt.Errorf("compress: %w", err)
+	}
+	if buf.Len() >= len(data) {
+		return nil, nil
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress returns decompressed data.
+func (c Compression) decompress(data []byte) ([]byte, error) {
+	r, err := c.decompressReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("decompress: %w", err)
+	}
+	return b, nil
+}
+
+// encryptCompressed compresses content from src and writes it to dst encrypted by the key in stream format.
+func encryptCompressed(src io.Reader, dst io.Writer, key []byte, c Compression) error {
+	w, err := stream.NewWriter(dst, key)
+	if err != nil {
+		return err
+	}
+	cw, err := c.compressWriter(w)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(cw, src); err != nil {
+		return fmt.Errorf("compress: %w", err)
+	}
+	if err = cw.Close(); err != nil {
+		return fmt.Errorf("compress: %w", err)
+	}
+	return w.Close()
+}
+
+// decompressedReader returns a reader of decrypted and decompressed content of src in stream format.
+// Modified data are reported as ErrHash.
+func decompressedReader(src io.Reader, key []byte, c Compression) (io.Reader, error) {
+	r, err := stream.NewReader(src, key)
+	if err != nil {
+		return nil, authError(err)
+	}
+	zr, err := c.decompressReader(r)
+	if err != nil {
+		return nil, authError(err)
+	}
+	return &authReader{r: zr}, nil
+}
+
+// authReader reports authentication errors of the encrypted stream and its decompression as ErrHash.
+type authReader struct {
+	r io.Reader
+}
+
+// Read reads data from the wrapped reader.
+func (a *authReader) Read(p []byte) (int, error) {
+	n, err := a.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = authError(err)
+	}
+	return n, err
+}
+
+// authError returns ErrHash based error if err is an authentication or decompression one.
+func authError(err error) error {
+	if errors.Is(err, ErrHash) {
+		return err
+	}
+	if errors.Is(err, stream.ErrAuth) || errors.Is(err, gzip.ErrChecksum) || errors.Is(err, gzip.ErrHeader) {
+		return fmt.Errorf("%w: %v", ErrHash, err)
+	}
+	return err
+}
+
+// copyDecompressed writes decrypted and decompressed content of src to dst by the key.
+func copyDecompressed(src io.Reader, dst io.Writer, key []byte, c Compression) error {
+	r, err := decompressedReader(src, key, c)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(dst, r); err != nil {
+		return fmt.Errorf("copy for decryption: %w", err)
+	}
+	return nil
+}
+
+// decompressRange writes n decrypted and decompressed bytes from the offset off of src to dst.
+func decompressRange(src io.Reader, dst io.Writer, key []byte, c Compression, off, n int64) error {
+	r, err := decompressedReader(src, key, c)
+	if err != nil {
+		return err
+	}
+	if _, err = io.CopyN(io.Discard, r, off); err != nil {
+		return fmt.Errorf("skip range offset: %w", err)
+	}
+	if _, err = io.CopyN(dst, r, n); err != nil {
+		return fmt.Errorf("copy range for decryption: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package encrypt
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/z0rr0/ssf/encrypt/recipient"
+	"github.com/z0rr0/ssf/encrypt/stream"
+)
+
+func TestParseCompression(t *testing.T) {
+	cases := map[string]Compression{"": CompressionNone, "none": CompressionNone, "gzip": CompressionGzip}
+	for name, expected := range cases {
+		c, err := ParseCompression(name)
+		if err != nil {
+			t.Errorf("failed %q: %v", name, err)
+		}
+		if c != expected {
+			t.Errorf("failed %q compression=%v", name, c)
+		}
+	}
+	if _, err := ParseCompression("zip"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestCompressible(t *testing.T) {
+	cases := map[string]bool{
+		"":                         true,
+		"text/plain":               true,
+		"text/csv; charset=utf-8":  true,
+		"application/json":         true,
+		"application/octet-stream": true,
+		"image/svg+xml":            true,
+		"image/png":                false,
+		"video/mp4":                false,
+		"audio/mpeg":               false,
+		"application/zip":          false,
+		"application/x-gzip":       false,
+		"application/epub+zip":     false,
+		"application/vnd.openxmlformats-officedocument.wordprocessingml.document": false,
+	}
+	for contentType, expected := range cases {
+		if c := Compressible(contentType); c != expected {
+			t.Errorf("failed %q compressible=%v", contentType, c)
+		}
+	}
+}
+
+func TestTextCompression(t *testing.T) {
+	const secret = "secret"
+	opts := &Options{KDF: Argon2id{Time: 1, Memory: 1024, Threads: 1}, Compression: CompressionGzip}
+	long := strings.Repeat("2022-01-01 12:00:00 INFO request is handled\n", 100)
+	cases := map[string]Compression{"short": CompressionNone, long: CompressionGzip}
+	for plainText, expected := range cases {
+		m, err := Text(secret, plainText, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Compression != expected {
+			t.Errorf("failed compression=%v", m.Compression)
+		}
+		token, err := m.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored, err := ParseToken(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decrypted, e := DecryptText(secret, stored, opts); e != nil || decrypted != plainText {
+			t.Errorf("failed decrypted=%s, error=%v", decrypted, e)
+		}
+		// compression is authenticated by the envelope tag
+		stored.Compression = CompressionGzip - stored.Compression
+		if _, err = DecryptText(secret, stored, opts); !errors.Is(err, ErrHash) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestFileCompression(t *testing.T) {
+	const secret = "secret"
+	opts := &Options{KDF: Argon2id{Time: 1, Memory: 1024, Threads: 1}, Compression: CompressionGzip}
+	plainText := bytes.Repeat([]byte("id,name,value\n1,some name,12345\n"), 3*stream.SegmentSize/32)
+
+	m, err := File(secret, bytes.NewReader(plainText), t.TempDir(), "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Compression != CompressionGzip {
+		t.Fatalf("failed compression=%v", m.Compression)
+	}
+	info, err := os.Stat(m.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := info.Size(); size*10 > int64(len(plainText)) {
+		t.Errorf("failed stored size=%d", size)
+	}
+	var dst bytes.Buffer
+	if err = DecryptFile(secret, m, &dst, &Options{Verify: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dst.Bytes(), plainText) {
+		t.Error("failed decrypted value")
+	}
+	off, n := int64(stream.SegmentSize+10), int64(stream.SegmentSize)
+	dst.Reset()
+	if err = DecryptFileRange(secret, m, off, n, &dst, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dst.Bytes(), plainText[off:off+n]) {
+		t.Error("failed decrypted range")
+	}
+	// the file is not decompressed without the stored compression
+	notCompressed := &Msg{Salt: m.Salt, Value: m.Value, KeyHash: m.KeyHash, DataHash: m.DataHash}
+	if err = DecryptFile(secret, notCompressed, &dst, nil); !errors.Is(err, ErrHash) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	modified := *m
+	modified.Compression = CompressionNone
+	if err = DecryptFileRange(secret, &modified, off, n, &dst, nil); !errors.Is(err, ErrHash) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// modified data
+	f, err := os.OpenFile(m.Value, os.O_RDWR, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.WriteAt([]byte{0}, int64(stream.HeaderSize+1)); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = DecryptFile(secret, m, &dst, nil); !errors.Is(err, ErrHash) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestFileCompressionSkipped(t *testing.T) {
+	const secret = "secret"
+	plainText := bytes.Repeat([]byte("some text"), 1000)
+	identity, err := recipient.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []*Options{
+		{Compression: CompressionGzip, Format: FormatAge, Passphrase: secret, WorkFactor: 10},
+		{Compression: CompressionGzip, Recipients: []*recipient.Recipient{identity.Recipient()}},
+		{},
+	}
+	for i, opts := range cases {
+		opts.KDF = Argon2id{Time: 1, Memory: 1024, Threads: 1}
+		m, e := File(secret, bytes.NewReader(plainText), t.TempDir(), "", opts)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if m.Compression != CompressionNone {
+			t.Errorf("failed case=%d compression=%v", i, m.Compression)
+		}
+	}
+}
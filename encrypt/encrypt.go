@@ -3,18 +3,21 @@ package encrypt
 // Package encrypt contains methods to encrypt/decrypt texts and files.
 
 import (
+	"bufio"
 	"crypto/hmac"
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 
-	"golang.org/x/crypto/pbkdf2"
 	"golang.org/x/crypto/sha3"
 
+	"github.com/z0rr0/ssf/encrypt/age"
+	"github.com/z0rr0/ssf/encrypt/recipient"
 	"github.com/z0rr0/ssf/encrypt/stream"
 	"github.com/z0rr0/ssf/encrypt/text"
 )
@@ -26,36 +29,58 @@ const (
 	fileNameSize = 64
 	// fileCreateAttempts is a number of attempts to create new file with unique name.
 	fileCreateAttempts = 10
-	// pbkdf2Iter is number of pbkdf2 iterations.
+	// pbkdf2Iter is number of legacy pbkdf2 iterations.
 	pbkdf2Iter = 65536
 	// key length for AES-256.
 	aesKeyLength = 32
 	// hashLength is length of file hash.
 	hashLength = 32
+	// recipientsExt is an extension of a file with recipient stanzas next to the encrypted file.
+	recipientsExt = ".recipients"
+)
+
+// Formats of encrypted files, they are detected by file headers for decryption.
+const (
+	// FormatStream is the default format of encrypt/stream package.
+	FormatStream = "stream"
+	// FormatAge is age v1 format, such files can be decrypted by age tools.
+	FormatAge = "age"
 )
 
 var (
 	// ErrSecret is an error when the secret hash is incorrect.
 	ErrSecret = errors.New("failed secret")
 
+	// ErrFormat is an error when client encrypted stream has invalid format.
+	ErrFormat = errors.New("invalid stream format")
+
 	// ErrHash is an error when the hash is incorrect.
 	ErrHash = errors.New("failed singer hash")
 )
 
 // Msg is struct with base parameter/results of encryption/decryption.
+// WrappedKey is a data key wrapped by the password and a master key, it's empty for messages
+// encrypted directly by the password key. Cipher is set by encryption, it's CipherUnknown
+// for legacy messages, then the format is detected by encrypted data.
+// Compression is a compression of plaintext. Messages are stored as envelopes, see Msg.Token.
 type Msg struct {
-	Salt     string
-	Value    string
-	KeyHash  string
-	DataHash string
-	s        []byte
-	v        []byte
-	kh       []byte
-	dh       []byte
+	Salt        string
+	Value       string
+	KeyHash     string
+	DataHash    string
+	WrappedKey  string
+	Cipher      Cipher
+	Compression Compression
+	kdf         KDF
+	s           []byte
+	v           []byte
+	kh          []byte
+	dh          []byte
+	tag         []byte
 }
 
 func (m *Msg) encode(withValue bool) {
-	m.Salt = hex.EncodeToString(m.s)
+	m.Salt = encodeSalt(m.kdf, m.s)
 	m.KeyHash = hex.EncodeToString(m.kh)
 	m.DataHash = hex.EncodeToString(m.dh)
 	if withValue {
@@ -64,11 +89,11 @@ func (m *Msg) encode(withValue bool) {
 }
 
 func (m *Msg) decode(withValue bool) error {
-	b, err := hex.DecodeString(m.Salt)
+	kdf, b, err := decodeSalt(m.Salt)
 	if err != nil {
-		return fmt.Errorf("hex decode salt: %w", err)
+		return err
 	}
-	m.s = b
+	m.kdf, m.s = kdf, b
 
 	b, err = hex.DecodeString(m.KeyHash)
 	if err != nil {
@@ -105,15 +130,14 @@ type StreamSigner struct {
 // Read reads data from s.R. It's used for stream encryption.
 func (s *StreamSigner) Read(p []byte) (n int, err error) {
 	n, err = s.R.Read(p)
-	if err != nil {
-		return 0, err
-	}
-	_, err = s.rHash.Write(p[:n])
-	if err != nil {
-		return 0, err
+	if n > 0 {
+		// a reader can return some data together with an error (io.EOF)
+		if _, e := s.rHash.Write(p[:n]); e != nil {
+			return 0, e
+		}
+		s.rDone = true
 	}
-	s.rDone = s.rDone || n > 0
-	return n, nil
+	return n, err
 }
 
 // Write writes data to s.W. It's used for stream decryption.
@@ -225,52 +249,231 @@ func Hash(data []byte) []byte {
 	return b
 }
 
-// Key calculates and returns secret key and its SHA512 hash.
-func Key(secret string, salt []byte) ([]byte, []byte) {
-	key := pbkdf2.Key([]byte(secret), salt, pbkdf2Iter, aesKeyLength, sha3.New512)
+// Key calculates and returns secret key using the kdf and its hash.
+func Key(kdf KDF, secret string, salt []byte) ([]byte, []byte) {
+	key := kdf.Key(secret, salt)
 	return key, Hash(append(key, salt...))
 }
 
-// Text encrypts plaintText using the secret.
-// Cipher message will be returned as Msg.Value.
-func Text(secret, plainText string) (*Msg, error) {
+// newMsg returns a new message with random salt and its encryption key.
+// If opts contain a keyring, the key is a random data key which is wrapped to Msg.WrappedKey,
+// otherwise it is derived from the secret.
+func newMsg(secret string, opts *Options) (*Msg, []byte, error) {
 	salt, err := Salt()
 	if err != nil {
+		return nil, nil, err
+	}
+	kdf := opts.kdf()
+	key, h := Key(kdf, secret, salt)
+	m := &Msg{kdf: kdf, s: salt, kh: h}
+	if k := opts.keyring(); k != nil {
+		dataKey, wrapped, e := k.newDataKey(key, salt)
+		if e != nil {
+			return nil, nil, e
+		}
+		key, m.kh, m.WrappedKey = dataKey, Hash(append(dataKey, salt...)), wrapped
+	}
+	return m, key, nil
+}
+
+// key returns the decoded message key using the secret and the keyring from opts for wrapped keys.
+// ErrSecret is returned if the secret is wrong.
+func (m *Msg) key(secret string, opts *Options) ([]byte, error) {
+	key, hash := Key(m.kdf, secret, m.s)
+	if m.WrappedKey != "" {
+		dataKey, err := opts.keyring().openDataKey(key, m.s, m.WrappedKey)
+		if err != nil {
+			return nil, err
+		}
+		key, hash = dataKey, Hash(append(dataKey, m.s...))
+	}
+	if !hmac.Equal(hash, m.kh) {
+		return nil, ErrSecret
+	}
+	if err := m.checkTag(key); err != nil {
 		return nil, err
 	}
-	key, h := Key(secret, salt)
-	cipherText, err := text.Encrypt([]byte(plainText), key)
+	return key, nil
+}
+
+// Options are optional encryption parameters.
+type Options struct {
+	// KDF is a key derivation function for new messages, DefaultKDF is used if it's nil.
+	// Decryption uses KDF parameters stored in Msg.Salt.
+	KDF KDF
+	// AD is associated data of text messages, for example an item ID.
+	// It is not stored, so the same value is required for decryption.
+	AD []byte
+	// Verify enables file integrity check before decrypted data output.
+	Verify bool
+	// Recipients are public keys to wrap the file key, they are stored next to the encrypted file.
+	Recipients []*recipient.Recipient
+	// Format is a format of new files, FormatStream is used if it's empty.
+	Format string
+	// Passphrase wraps the file key of FormatAge files without recipients, age doesn't allow to use them together.
+	Passphrase string
+	// WorkFactor is log2 of scrypt N parameter of the passphrase, age.DefaultWorkFactor is used if it's zero.
+	WorkFactor int
+	// Keyring contains master keys to wrap data keys of new messages and to unwrap stored ones.
+	// New messages are encrypted directly by password keys if it's nil.
+	Keyring *Keyring
+	// Compression is a compression of new texts and files before encryption, CompressionNone disables it.
+	// Texts are compressed only if it makes them shorter. Files in FormatAge or with recipients are not compressed,
+	// because they are decrypted without the server.
+	Compression Compression
+}
+
+// kdf returns KDF for new messages.
+func (o *Options) kdf() KDF {
+	if o == nil || o.KDF == nil {
+		return DefaultKDF
+	}
+	return o.KDF
+}
+
+// ad returns associated data.
+func (o *Options) ad() []byte {
+	if o == nil {
+		return nil
+	}
+	return o.AD
+}
+
+// verify returns true if file integrity should be checked before output.
+func (o *Options) verify() bool {
+	return o != nil && o.Verify
+}
+
+// recipients returns public keys of file recipients.
+func (o *Options) recipients() []*recipient.Recipient {
+	if o == nil {
+		return nil
+	}
+	return o.Recipients
+}
+
+// format returns a format of new files.
+func (o *Options) format() string {
+	if o == nil || o.Format == "" {
+		return FormatStream
+	}
+	return o.Format
+}
+
+// keyring returns master keys.
+func (o *Options) keyring() *Keyring {
+	if o == nil {
+		return nil
+	}
+	return o.Keyring
+}
+
+// compression returns a compression of new messages.
+func (o *Options) compression() Compression {
+	if o == nil {
+		return CompressionNone
+	}
+	return o.Compression
+}
+
+// workFactor returns scrypt work factor of age passphrases.
+func (o *Options) workFactor() int {
+	if o == nil || o.WorkFactor == 0 {
+		return age.DefaultWorkFactor
+	}
+	return o.WorkFactor
+}
+
+// Text encrypts plaintText using the secret and associated data from opts.
+// Cipher message will be returned as Msg.Value.
+func Text(secret, plainText string, opts *Options) (*Msg, error) {
+	m, key, err := newMsg(secret, opts)
+	if err != nil {
+		return nil, err
+	}
+	data := []byte(plainText)
+	if c := opts.compression(); c != CompressionNone {
+		compressed, e := c.compress(data)
+		if e != nil {
+			return nil, e
+		}
+		if compressed != nil {
+			data, m.Compression = compressed, c
+		}
+	}
+	m.v, err = text.Seal(data, key, opts.ad())
 	if err != nil {
 		return nil, err
 	}
-	m := &Msg{v: cipherText, s: salt, kh: h}
+	m.Cipher = CipherAESGCM
+	m.setTag(key)
 	m.encode(true)
 	return m, nil
 }
 
 // DecryptText returns decrypted value from Msg.Value using the secret.
-// Salt in m.Salt is expected
-func DecryptText(secret string, m *Msg) (string, error) {
+// Salt in m.Salt is expected, associated data are taken from opts.
+// Legacy not authenticated messages are decrypted without associated data.
+func DecryptText(secret string, m *Msg, opts *Options) (string, error) {
 	err := m.decode(true)
 	if err != nil {
 		return "", err
 	}
-	key, hash := Key(secret, m.s)
-	if !hmac.Equal(hash, m.kh) {
-		return "", ErrSecret
+	key, err := m.key(secret, opts)
+	if err != nil {
+		return "", err
+	}
+	var plainText []byte
+	if text.IsSealed(m.v) {
+		plainText, err = text.Open(m.v, key, opts.ad())
+	} else {
+		plainText, err = text.Decrypt(m.v, key)
 	}
-	plainText, err := text.Decrypt(m.v, key)
 	if err != nil {
 		return "", err
 	}
+	if m.Compression != CompressionNone {
+		if plainText, err = m.Compression.decompress(plainText); err != nil {
+			return "", err
+		}
+	}
 	return string(plainText), nil
 }
 
+// MigrateText re-encrypts legacy text message m in place using the secret and opts.
+// New salt, KDF parameters and master key are used too. It returns false if m is already authenticated.
+func MigrateText(secret string, m *Msg, opts *Options) (bool, error) {
+	err := m.decode(true)
+	if err != nil {
+		return false, err
+	}
+	if text.IsSealed(m.v) {
+		return false, nil
+	}
+	plainText, err := DecryptText(secret, m, &Options{Keyring: opts.keyring()})
+	if err != nil {
+		return false, err
+	}
+	migrated, err := Text(secret, plainText, opts)
+	if err != nil {
+		return false, err
+	}
+	*m = *migrated
+	return true, nil
+}
+
 // File encrypts content from src to a new file using the secret.
 // Salt and key hash are returned as Msg.Salt and Msg.KeyHash.
-// The name if new file will be stored in m.Value.
-func File(secret string, src io.Reader, base, name string) (*Msg, error) {
-	salt, err := Salt()
+// The name if new file will be stored in m.Value. If opts contain recipients,
+// the file key is wrapped to them and saved in RecipientsFile.
+// FormatAge files use the first age.FileKeySize bytes of the key as the file key.
+// Content is compressed by opts.Compression before encryption, it's returned as Msg.Compression.
+func File(secret string, src io.Reader, base, name string, opts *Options) (*Msg, error) {
+	format := opts.format()
+	if format != FormatStream && format != FormatAge {
+		return nil, fmt.Errorf("unknown file format %q", format)
+	}
+	m, key, err := newMsg(secret, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -278,51 +481,348 @@ func File(secret string, src io.Reader, base, name string) (*Msg, error) {
 	if err != nil {
 		return nil, fmt.Errorf("open file for ecryption: %w", err)
 	}
-	key, h := Key(secret, salt)
+	fileKey := key
+	if format == FormatAge {
+		fileKey = key[:age.FileKeySize]
+	}
+	stanzas, err := wrapKey(fileKey, opts.recipients())
+	if err != nil {
+		return nil, removeFile(dst, err)
+	}
 
 	signReader := NewStreamSigner(src, nil)
-	err = stream.Encrypt(signReader, dst, key)
+	switch {
+	case format == FormatAge:
+		err = encryptAge(signReader, dst, fileKey, stanzas, opts)
+	case len(stanzas) == 0 && opts.compression() != CompressionNone:
+		m.Compression = opts.compression()
+		err = encryptCompressed(signReader, dst, fileKey, m.Compression)
+	default:
+		err = stream.Encrypt(signReader, dst, fileKey)
+	}
 	if err != nil {
-		return nil, err
+		return nil, removeFile(dst, err)
 	}
-	dh, err := signReader.ReaderHashSum()
+	m.dh, err = signReader.ReaderHashSum()
 	if err != nil {
-		return nil, err
+		return nil, removeFile(dst, err)
 	}
 
-	m := &Msg{s: salt, kh: h, dh: dh, Value: dst.Name()}
+	if err = writeRecipients(dst.Name(), stanzas); err != nil {
+		return nil, removeFile(dst, err)
+	}
+	m.Value, m.Cipher = dst.Name(), CipherStream
+	if format == FormatAge {
+		m.Cipher = CipherAge
+	}
+	m.setTag(key)
 	m.encode(false)
 	return m, dst.Close()
 }
 
+// RecipientsFile returns a name of the file with recipient stanzas of the encrypted file.
+// Recipients can decrypt the file stream by the unwrapped key without the password.
+func RecipientsFile(name string) string {
+	return name + recipientsExt
+}
+
+// encryptAge writes age file with content from src to dst. The file key is wrapped by recipient stanzas
+// or by the passphrase if there are no recipients.
+func encryptAge(src io.Reader, dst io.Writer, fileKey []byte, stanzas []*recipient.Stanza, opts *Options) error {
+	if len(stanzas) == 0 {
+		p, err := age.NewPassphrase(opts.Passphrase, opts.workFactor())
+		if err != nil {
+			return err
+		}
+		s, err := p.Wrap(fileKey)
+		if err != nil {
+			return fmt.Errorf("wrap key: %w", err)
+		}
+		stanzas = []*recipient.Stanza{s}
+	}
+	return age.Encrypt(src, dst, fileKey, stanzas)
+}
+
+// wrapKey returns stanzas of the key wrapped to every recipient.
+func wrapKey(key []byte, recipients []*recipient.Recipient) ([]*recipient.Stanza, error) {
+	stanzas := make([]*recipient.Stanza, len(recipients))
+	for i, r := range recipients {
+		s, err := r.Wrap(key)
+		if err != nil {
+			return nil, fmt.Errorf("wrap key: %w", err)
+		}
+		stanzas[i] = s
+	}
+	return stanzas, nil
+}
+
+// writeRecipients writes the stanzas to RecipientsFile of name, nothing is written without stanzas.
+func writeRecipients(name string, stanzas []*recipient.Stanza) error {
+	if len(stanzas) == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(RecipientsFile(name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("create recipients file: %w", err)
+	}
+	if err = recipient.Marshal(f, stanzas); err != nil {
+		return removeFile(f, fmt.Errorf("write recipients: %w", err))
+	}
+	return f.Close()
+}
+
+// FileFormat returns a format of the encrypted file with the name.
+func FileFormat(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", fmt.Errorf("open file: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	prefix := make([]byte, len(age.Magic))
+	if _, err = io.ReadFull(f, prefix); err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", fmt.Errorf("read header: %w", err)
+	}
+	if string(prefix) == age.Magic {
+		return FormatAge, nil
+	}
+	return FormatStream, nil
+}
+
+// Raw copies a stream which is encrypted by a client from src to a new file inside base path.
+// The key is unknown, so only the stream header and size are checked, they must be VersionGCM
+// or age ones with not empty plaintext, otherwise ErrFormat is returned.
+// The name of new file will be stored in m.Value.
+func Raw(src io.Reader, base string) (*Msg, error) {
+	buffered := bufio.NewReader(src)
+	prefix, err := buffered.Peek(len(age.Magic))
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	if string(prefix) == age.Magic {
+		return rawAge(buffered, base)
+	}
+	header := make([]byte, stream.HeaderSize)
+	if _, err = io.ReadFull(buffered, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("%w: short stream", ErrFormat)
+		}
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	if err := stream.CheckHeader(header); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	dst, err := createFile(base, "")
+	if err != nil {
+		return nil, fmt.Errorf("open file for stream: %w", err)
+	}
+	if _, err = dst.Write(header); err != nil {
+		return nil, removeFile(dst, fmt.Errorf("write header: %w", err))
+	}
+	n, err := io.Copy(dst, buffered)
+	if err != nil {
+		return nil, removeFile(dst, fmt.Errorf("copy stream: %w", err))
+	}
+	n += int64(stream.HeaderSize)
+	if size, e := stream.PlainSize(n); e != nil || size == 0 {
+		return nil, removeFile(dst, fmt.Errorf("%w: stream size %d", ErrFormat, n))
+	}
+	return &Msg{Value: dst.Name(), Cipher: CipherStream}, dst.Close()
+}
+
+// rawAge copies age file from src to a new file inside base path, its header and payload size are checked.
+func rawAge(src io.Reader, base string) (*Msg, error) {
+	h, payload, err := age.ReadHeader(src)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	dst, err := createFile(base, "")
+	if err != nil {
+		return nil, fmt.Errorf("open file for stream: %w", err)
+	}
+	if _, err = io.WriteString(dst, h.String()); err != nil {
+		return nil, removeFile(dst, fmt.Errorf("write header: %w", err))
+	}
+	n, err := io.Copy(dst, payload)
+	if err != nil {
+		return nil, removeFile(dst, fmt.Errorf("copy stream: %w", err))
+	}
+	if size, e := age.PlainSize(n); e != nil || size == 0 {
+		return nil, removeFile(dst, fmt.Errorf("%w: age payload size %d", ErrFormat, n))
+	}
+	return &Msg{Value: dst.Name(), Cipher: CipherAge}, dst.Close()
+}
+
+// removeFile closes and deletes not completed file f and its recipients file if it exists,
+// it returns err with possible cleanup errors.
+func removeFile(f *os.File, err error) error {
+	if e := f.Close(); e != nil {
+		err = fmt.Errorf("%v, close file: %w", err, e)
+	}
+	if e := os.Remove(f.Name()); e != nil {
+		err = fmt.Errorf("%v, remove file: %w", err, e)
+	}
+	if e := os.Remove(RecipientsFile(f.Name())); e != nil && !errors.Is(e, fs.ErrNotExist) {
+		err = fmt.Errorf("%v, remove recipients file: %w", err, e)
+	}
+	return err
+}
+
+// CheckSecret returns ErrSecret if the secret does not match m.KeyHash.
+// Wrapped keys are unwrapped by the keyring from opts.
+func CheckSecret(secret string, m *Msg, opts *Options) error {
+	if err := m.decode(false); err != nil {
+		return err
+	}
+	_, err := m.key(secret, opts)
+	return err
+}
+
 // DecryptFile writes decrypted content of file with path from Msg.Value,
 // checking Msg.KeyHash to dst using the secret and Msg.Salt.
-func DecryptFile(secret string, m *Msg, dst io.Writer) error {
-	err := m.decode(false)
+// If opts.Verify is set, the file is decrypted twice: the first pass checks data hash
+// without any output, so ErrHash is returned before any plaintext is written to dst.
+func DecryptFile(secret string, m *Msg, dst io.Writer, opts *Options) error {
+	f, err := OpenFile(secret, m, opts)
 	if err != nil {
 		return err
 	}
-	src, err := os.Open(m.Value)
+	defer func() {
+		_ = f.Close()
+	}()
+	if opts.verify() {
+		if err = f.Verify(); err != nil {
+			return err
+		}
+	}
+	return f.Decrypt(dst)
+}
+
+// EncryptedFile is an opened encrypted file with its checked key.
+// The file is opened once, so all its passes read the same content even if it's removed or replaced.
+type EncryptedFile struct {
+	m   *Msg
+	key []byte
+	f   *os.File
+}
+
+// OpenFile checks the secret and opens the file with path from Msg.Value for decryption.
+// Wrapped keys are unwrapped by the keyring from opts. The file must be closed after usage.
+func OpenFile(secret string, m *Msg, opts *Options) (*EncryptedFile, error) {
+	err := m.decode(false)
 	if err != nil {
-		return fmt.Errorf("open file for decryption: %w", err)
+		return nil, err
 	}
-	key, hash := Key(secret, m.s)
-	if !hmac.Equal(hash, m.kh) {
-		return ErrSecret
+	key, err := m.key(secret, opts)
+	if err != nil {
+		return nil, err
 	}
+	f, err := os.Open(m.Value)
+	if err != nil {
+		return nil, fmt.Errorf("open file for decryption: %w", err)
+	}
+	return &EncryptedFile{m: m, key: key, f: f}, nil
+}
 
-	signWriter := NewStreamSigner(nil, dst)
-	err = stream.Decrypt(src, signWriter, key)
+// Close closes the file.
+func (e *EncryptedFile) Close() error {
+	return e.f.Close()
+}
+
+// Verify decrypts the whole file without any output and checks its data hash.
+func (e *EncryptedFile) Verify() error {
+	return e.Decrypt(io.Discard)
+}
+
+// Decrypt writes decrypted content of the file from its beginning to dst and checks its hash.
+// Modified stream data are reported as ErrHash.
+func (e *EncryptedFile) Decrypt(dst io.Writer) error {
+	if _, err := e.f.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("seek file for decryption: %w", err)
+	}
+	var (
+		err        error
+		signWriter = NewStreamSigner(nil, dst)
+	)
+	if e.m.Compression != CompressionNone {
+		err = copyDecompressed(e.f, signWriter, e.key, e.m.Compression)
+	} else {
+		err = decryptStream(e.f, signWriter, e.key)
+	}
 	if err != nil {
 		return err
 	}
-
 	dh, err := signWriter.WriterHashSum()
 	if err != nil {
 		return err
 	}
-	if !hmac.Equal(dh, m.dh) {
+	if !hmac.Equal(dh, e.m.dh) {
 		return ErrHash
 	}
-	return src.Close()
+	return nil
+}
+
+// decryptStream writes decrypted content of src to dst by the key, the format is detected by the header.
+// Modified data are reported as ErrHash.
+func decryptStream(src io.Reader, dst io.Writer, key []byte) error {
+	buffered := bufio.NewReader(src)
+	prefix, err := buffered.Peek(len(age.Magic))
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("read header: %w", err)
+	}
+	if string(prefix) == age.Magic {
+		err = age.Decrypt(buffered, dst, key[:age.FileKeySize])
+	} else {
+		err = stream.Decrypt(buffered, dst, key)
+	}
+	if errors.Is(err, stream.ErrAuth) || errors.Is(err, age.ErrAuth) {
+		return fmt.Errorf("%w: %v", ErrHash, err)
+	}
+	return err
+}
+
+// DecryptFileRange writes n decrypted bytes from the offset off of file with path from Msg.Value to dst.
+// Msg.KeyHash is checked using the secret and Msg.Salt.
+func DecryptFileRange(secret string, m *Msg, off, n int64, dst io.Writer, opts *Options) error {
+	f, err := OpenFile(secret, m, opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	return f.DecryptRange(off, n, dst)
+}
+
+// DecryptRange writes n decrypted bytes from the offset off of the file to dst. The whole file hash can't be verified,
+// but every segment of authenticated stream is checked before its plaintext is written.
+// Compressed files are decompressed from the beginning to the end of the range.
+func (e *EncryptedFile) DecryptRange(off, n int64, dst io.Writer) error {
+	if e.m.Compression != CompressionNone {
+		if _, err := e.f.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("seek file for decryption: %w", err)
+		}
+		return decompressRange(e.f, dst, e.key, e.m.Compression, off, n)
+	}
+	info, err := e.f.Stat()
+	if err != nil {
+		return fmt.Errorf("stat file for decryption: %w", err)
+	}
+	prefix := make([]byte, len(age.Magic))
+	if _, err = e.f.ReadAt(prefix, 0); err != nil && err != io.EOF {
+		return fmt.Errorf("read header: %w", err)
+	}
+	if string(prefix) == age.Magic {
+		err = age.DecryptRange(e.f, info.Size(), dst, e.key[:age.FileKeySize], off, n)
+	} else {
+		err = stream.DecryptRange(e.f, info.Size(), dst, e.key, off, n)
+	}
+	if err != nil {
+		if errors.Is(err, stream.ErrAuth) || errors.Is(err, age.ErrAuth) {
+			return fmt.Errorf("%w: %v", ErrHash, err)
+		}
+		return err
+	}
+	return nil
 }
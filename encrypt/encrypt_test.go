@@ -2,17 +2,45 @@ package encrypt
 
 import (
 	"bytes"
+	"encoding/hex"
+	"errors"
 	"io"
 	"os"
 	"testing"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/sha3"
+
+	"github.com/z0rr0/ssf/encrypt/age"
+	"github.com/z0rr0/ssf/encrypt/recipient"
+	"github.com/z0rr0/ssf/encrypt/stream"
+	"github.com/z0rr0/ssf/encrypt/text"
 )
 
+// legacyText returns a message encrypted by PBKDF2 and AES-CFB as before KDF parameters and AES-GCM were added.
+func legacyText(t *testing.T, secret, plainText string) *Msg {
+	salt, err := Salt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := pbkdf2.Key([]byte(secret), salt, pbkdf2Iter, aesKeyLength, sha3.New512)
+	cipherText, err := text.Encrypt([]byte(plainText), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Msg{
+		Salt:    hex.EncodeToString(salt),
+		Value:   hex.EncodeToString(cipherText),
+		KeyHash: hex.EncodeToString(Hash(append(key, salt...))),
+	}
+}
+
 func TestText(t *testing.T) {
 	const (
 		secret    = "secret"
 		plainText = "some text"
 	)
-	m1, err := Text(secret, plainText)
+	m1, err := Text(secret, plainText, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -21,13 +49,77 @@ func TestText(t *testing.T) {
 	}
 	// decrypt
 	m2 := &Msg{Value: m1.Value, Salt: m1.Salt, KeyHash: m1.KeyHash}
-	decrypted, err := DecryptText(secret, m2)
+	decrypted, err := DecryptText(secret, m2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted != plainText {
+		t.Errorf("failed decrypted=%s", decrypted)
+	}
+}
+
+func TestTextAD(t *testing.T) {
+	const (
+		secret    = "secret"
+		plainText = "some text"
+	)
+	m1, err := Text(secret, plainText, &Options{AD: []byte("id1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m2 := &Msg{Value: m1.Value, Salt: m1.Salt, KeyHash: m1.KeyHash}
+	decrypted, err := DecryptText(secret, m2, &Options{AD: []byte("id1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted != plainText {
+		t.Errorf("failed decrypted=%s", decrypted)
+	}
+	// the message is moved to another item
+	if _, err = DecryptText(secret, m2, &Options{AD: []byte("id2")}); !errors.Is(err, text.ErrAuth) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// modified cipher text
+	b, err := hex.DecodeString(m1.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[len(b)-1] ^= 1
+	m2.Value = hex.EncodeToString(b)
+	if _, err = DecryptText(secret, m2, &Options{AD: []byte("id1")}); !errors.Is(err, text.ErrAuth) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestMigrateText(t *testing.T) {
+	const (
+		secret    = "secret"
+		plainText = "some text"
+	)
+	opts := &Options{KDF: Argon2id{Time: 1, Memory: 1024, Threads: 1}, AD: []byte("id")}
+	m := legacyText(t, secret, plainText)
+	legacySalt := m.Salt
+
+	migrated, err := MigrateText(secret, m, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !migrated || m.Salt == legacySalt {
+		t.Errorf("message is not migrated: %v", migrated)
+	}
+	decrypted, err := DecryptText(secret, &Msg{Value: m.Value, Salt: m.Salt, KeyHash: m.KeyHash}, opts)
 	if err != nil {
 		t.Fatal(err)
 	}
 	if decrypted != plainText {
 		t.Errorf("failed decrypted=%s", decrypted)
 	}
+	if migrated, err = MigrateText(secret, m, opts); err != nil || migrated {
+		t.Errorf("failed repeated migration: %v, %v", migrated, err)
+	}
+	if _, err = MigrateText("bad", legacyText(t, secret, plainText), opts); !errors.Is(err, ErrSecret) {
+		t.Errorf("unexpected error: %v", err)
+	}
 }
 
 func TestFile(t *testing.T) {
@@ -42,7 +134,7 @@ func TestFile(t *testing.T) {
 		t.Fatal(err)
 	}
 	base := os.TempDir()
-	m1, err := File(secret, &src, base, "")
+	m1, err := File(secret, &src, base, "", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -57,7 +149,7 @@ func TestFile(t *testing.T) {
 	}()
 	// decrypt
 	m2 := &Msg{Salt: m1.Salt, Value: fileName, KeyHash: m1.KeyHash, DataHash: m1.DataHash}
-	err = DecryptFile(secret, m2, &dst)
+	err = DecryptFile(secret, m2, &dst, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -90,7 +182,7 @@ func BenchmarkKey(b *testing.B) {
 	}
 	b.ResetTimer()
 	for n := 0; n < b.N; n++ {
-		k, h := Key(secret, salt)
+		k, h := Key(DefaultKDF, secret, salt)
 		if n := len(k); n != aesKeyLength {
 			b.Errorf("failed key length=%d", n)
 		}
@@ -99,3 +191,340 @@ func BenchmarkKey(b *testing.B) {
 		}
 	}
 }
+
+func TestDecryptFileVerify(t *testing.T) {
+	const secret = "secret"
+	opts := &Options{KDF: Argon2id{Time: 1, Memory: 1024, Threads: 1}}
+	plainText := bytes.Repeat([]byte("some text"), 3*stream.SegmentSize/8)
+
+	m, err := File(secret, bytes.NewReader(plainText), t.TempDir(), "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dst bytes.Buffer
+	if err = DecryptFile(secret, m, &dst, &Options{Verify: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dst.Bytes(), plainText) {
+		t.Error("failed decrypted value")
+	}
+	// modify the second segment
+	f, err := os.OpenFile(m.Value, os.O_RDWR, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset := int64(stream.HeaderSize + stream.SegmentSize + stream.Overhead + 1)
+	b := make([]byte, 1)
+	if _, err = f.ReadAt(b, offset); err != nil {
+		t.Fatal(err)
+	}
+	b[0] ^= 1
+	if _, err = f.WriteAt(b, offset); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dst.Reset()
+	if err = DecryptFile(secret, m, &dst, &Options{Verify: true}); !errors.Is(err, ErrHash) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if n := dst.Len(); n != 0 {
+		t.Errorf("data is written before verification, length=%d", n)
+	}
+	// without verification the first segment is already written
+	if err = DecryptFile(secret, m, &dst, nil); !errors.Is(err, ErrHash) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if n := dst.Len(); n != stream.SegmentSize {
+		t.Errorf("failed written length=%d", n)
+	}
+}
+
+func TestFileRecipients(t *testing.T) {
+	const secret = "secret"
+	plainText := bytes.Repeat([]byte("some text"), stream.SegmentSize/4)
+	identities := make([]*recipient.Identity, 2)
+	opts := &Options{KDF: Argon2id{Time: 1, Memory: 1024, Threads: 1}}
+	for i := range identities {
+		identity, err := recipient.GenerateIdentity()
+		if err != nil {
+			t.Fatal(err)
+		}
+		identities[i] = identity
+		opts.Recipients = append(opts.Recipients, identity.Recipient())
+	}
+	dir := t.TempDir()
+	m, err := File(secret, bytes.NewReader(plainText), dir, "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(RecipientsFile(m.Value))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stanzas, err := recipient.Unmarshal(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Close(); err != nil {
+		t.Error(err)
+	}
+	if n := len(stanzas); n != len(identities) {
+		t.Fatalf("failed stanzas number=%d", n)
+	}
+	for i, identity := range identities {
+		key, e := recipient.Unwrap(stanzas, []*recipient.Identity{identity})
+		if e != nil {
+			t.Fatalf("failed unwrap=%d: %v", i, e)
+		}
+		src, e := os.Open(m.Value)
+		if e != nil {
+			t.Fatal(e)
+		}
+		var dst bytes.Buffer
+		if e = stream.Decrypt(src, &dst, key); e != nil {
+			t.Errorf("failed decrypt=%d: %v", i, e)
+		}
+		if e = src.Close(); e != nil {
+			t.Error(e)
+		}
+		if !bytes.Equal(dst.Bytes(), plainText) {
+			t.Errorf("failed decrypted value=%d", i)
+		}
+	}
+	// the password still can be used
+	var dst bytes.Buffer
+	if err = DecryptFile(secret, m, &dst, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dst.Bytes(), plainText) {
+		t.Error("failed decrypted value by password")
+	}
+	// files without recipients don't have stanzas
+	m, err = File(secret, bytes.NewReader(plainText), dir, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(RecipientsFile(m.Value)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("unexpected recipients file: %v", err)
+	}
+}
+
+func TestFileAge(t *testing.T) {
+	const secret = "secret"
+	plainText := bytes.Repeat([]byte("some text"), age.ChunkSize/4)
+	identity, err := recipient.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	passphrase, err := age.NewPassphrase("password", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kdf := Argon2id{Time: 1, Memory: 1024, Threads: 1}
+	cases := []struct {
+		opts     *Options
+		identity age.Identity
+	}{
+		{opts: &Options{KDF: kdf, Format: FormatAge, Passphrase: "password", WorkFactor: 10}, identity: passphrase},
+		{
+			opts:     &Options{KDF: kdf, Format: FormatAge, Recipients: []*recipient.Recipient{identity.Recipient()}},
+			identity: identity,
+		},
+	}
+	dir := t.TempDir()
+	for i, c := range cases {
+		m, e := File(secret, bytes.NewReader(plainText), dir, "", c.opts)
+		if e != nil {
+			t.Fatalf("failed case=%d: %v", i, e)
+		}
+		if format, e := FileFormat(m.Value); e != nil || format != FormatAge {
+			t.Errorf("failed case=%d, format=%q: %v", i, format, e)
+		}
+		// age tools decrypt the file by the passphrase or the identity
+		src, e := os.Open(m.Value)
+		if e != nil {
+			t.Fatal(e)
+		}
+		var dst bytes.Buffer
+		if e = age.DecryptIdentities(src, &dst, []age.Identity{c.identity}); e != nil {
+			t.Errorf("failed case=%d, age decrypt: %v", i, e)
+		}
+		if e = src.Close(); e != nil {
+			t.Error(e)
+		}
+		if !bytes.Equal(dst.Bytes(), plainText) {
+			t.Errorf("failed case=%d, age decrypted value", i)
+		}
+		dst.Reset()
+		if e = DecryptFile(secret, m, &dst, &Options{Verify: true}); e != nil {
+			t.Fatalf("failed case=%d: %v", i, e)
+		}
+		if !bytes.Equal(dst.Bytes(), plainText) {
+			t.Errorf("failed case=%d, decrypted value", i)
+		}
+		dst.Reset()
+		if e = DecryptFileRange(secret, m, 10, age.ChunkSize, &dst, nil); e != nil {
+			t.Fatalf("failed case=%d: %v", i, e)
+		}
+		if !bytes.Equal(dst.Bytes(), plainText[10:10+age.ChunkSize]) {
+			t.Errorf("failed case=%d, decrypted range", i)
+		}
+	}
+	opts := &Options{KDF: kdf, Format: FormatAge}
+	if _, err = File(secret, bytes.NewReader(plainText), dir, "", opts); err == nil {
+		t.Error("expected error without passphrase")
+	}
+	opts.Format = "unknown"
+	if _, err = File(secret, bytes.NewReader(plainText), dir, "", opts); err == nil {
+		t.Error("expected error for unknown format")
+	}
+	m, err := File(secret, bytes.NewReader(plainText), dir, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format, e := FileFormat(m.Value); e != nil || format != FormatStream {
+		t.Errorf("failed format=%q: %v", format, e)
+	}
+}
+
+func TestRaw(t *testing.T) {
+	key := make([]byte, aesKeyLength)
+	plainText := bytes.Repeat([]byte("some text"), stream.SegmentSize/4)
+	var encrypted bytes.Buffer
+	if err := stream.Encrypt(bytes.NewReader(plainText), &encrypted, key); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	m, err := Raw(bytes.NewReader(encrypted.Bytes()), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(m.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, encrypted.Bytes()) {
+		t.Error("failed stored stream")
+	}
+	var empty bytes.Buffer
+	if err = stream.Encrypt(bytes.NewReader(nil), &empty, key); err != nil {
+		t.Fatal(err)
+	}
+	cases := [][]byte{
+		nil,
+		[]byte("plain text is not encrypted"),
+		encrypted.Bytes()[:stream.HeaderSize+2*(stream.SegmentSize+stream.Overhead)+5],
+		empty.Bytes(),
+	}
+	for i, c := range cases {
+		if _, err = Raw(bytes.NewReader(c), dir); !errors.Is(err, ErrFormat) {
+			t.Errorf("unexpected error for case=%d: %v", i, err)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(entries); n != 1 {
+		t.Errorf("failed files number=%d", n)
+	}
+}
+
+func TestRawAge(t *testing.T) {
+	fileKey, err := age.NewFileKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity, err := recipient.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := identity.Recipient().Wrap(fileKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypt := func(plainText []byte) []byte {
+		var dst bytes.Buffer
+		if e := age.Encrypt(bytes.NewReader(plainText), &dst, fileKey, []*recipient.Stanza{s}); e != nil {
+			t.Fatal(e)
+		}
+		return dst.Bytes()
+	}
+	encrypted := encrypt(bytes.Repeat([]byte("some text"), age.ChunkSize/4))
+	dir := t.TempDir()
+	m, err := Raw(bytes.NewReader(encrypted), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(m.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, encrypted) {
+		t.Error("failed stored age file")
+	}
+	cases := [][]byte{
+		[]byte(age.Magic),
+		[]byte(age.Magic + "-> X25519 abc\n\n--- abc\n"),
+		encrypted[:len(encrypted)-age.ChunkSize/4-age.Overhead+5],
+		encrypt(nil),
+	}
+	for i, c := range cases {
+		if _, err = Raw(bytes.NewReader(c), dir); !errors.Is(err, ErrFormat) {
+			t.Errorf("unexpected error for case=%d: %v", i, err)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(entries); n != 1 {
+		t.Errorf("failed files number=%d", n)
+	}
+}
+
+func TestOpenFile(t *testing.T) {
+	const secret = "secret"
+	content := bytes.Repeat([]byte("file content"), 1000)
+	opts := &Options{KDF: Argon2id{Time: 1, Memory: 1024, Threads: 1}}
+	m, err := File(secret, bytes.NewReader(content), t.TempDir(), "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = OpenFile("bad", m, opts); !errors.Is(err, ErrSecret) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	f, err := OpenFile(secret, m, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if e := f.Close(); e != nil {
+			t.Error(e)
+		}
+	}()
+	// the opened file is read by all passes after its removal
+	if err = os.Remove(m.Value); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	var dst bytes.Buffer
+	if err = f.Decrypt(&dst); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dst.Bytes(), content) {
+		t.Error("failed decrypted content")
+	}
+	dst.Reset()
+	if err = f.DecryptRange(10, 100, &dst); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dst.Bytes(), content[10:110]) {
+		t.Error("failed decrypted range")
+	}
+}
@@ -0,0 +1,385 @@
+package encrypt
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// Msg is serialized as a versioned self-describing envelope, so it can be stored or sent as one value.
+//
+//	binary = "SSFM" | version (1 byte) | cipher (1 byte) | compression (1 byte) | kdf | salt | key hash | data hash | tag | wrapped key | value
+//
+// Every variable field is prefixed by its length as unsigned varint. KDF parameters are a string
+// like "$argon2id$v=19$m=65536,t=3,p=4$", the value of text messages is a ciphertext,
+// and a file name for files. The compact token is base64url binary form without padding,
+// it's the stored form of messages. The tag authenticates the header by the message key,
+// so cipher, compression, KDF parameters and hashes can't be changed, the wrapped key is not
+// authenticated, because it's rewrapped by master key rotation. Legacy messages without cipher
+// have no tag, they can't be compressed.
+const (
+	// EnvelopeVersion is the version of envelopes.
+	EnvelopeVersion = 1
+
+	// envelopeMagic is a prefix of binary envelopes.
+	envelopeMagic = "SSFM"
+	// envelopeFields is a number of variable fields.
+	envelopeFields = 7
+	// tagInfo is a suffix of the message key to derive the tag key.
+	tagInfo = "\x00envelope tag"
+)
+
+// ErrEnvelope is an error when a message envelope is malformed or has unknown version.
+var ErrEnvelope = errors.New("invalid message envelope")
+
+// Cipher is an identifier of a message encryption algorithm.
+type Cipher uint8
+
+// Ciphers of messages, CipherUnknown means that the format is detected by encrypted data.
+const (
+	CipherUnknown Cipher = iota
+	// CipherAESCFB is the legacy not authenticated AES-256-CFB text format.
+	CipherAESCFB
+	// CipherAESGCM is AES-256-GCM text format of encrypt/text package.
+	CipherAESGCM
+	// CipherStream is chunked AES-256-GCM file format of encrypt/stream package.
+	CipherStream
+	// CipherAge is age v1 file format.
+	CipherAge
+)
+
+// cipherNames are names of ciphers in JSON envelopes.
+var cipherNames = [...]string{
+	CipherUnknown: "unknown",
+	CipherAESCFB:  "aes-256-cfb",
+	CipherAESGCM:  "aes-256-gcm",
+	CipherStream:  "ssf-stream",
+	CipherAge:     "age-v1",
+}
+
+// String returns the cipher name.
+func (c Cipher) String() string {
+	if int(c) < len(cipherNames) {
+		return cipherNames[c]
+	}
+	return fmt.Sprintf("cipher(%d)", uint8(c))
+}
+
+// isText returns true if the cipher encrypts text messages, their values are hex encoded ciphertexts.
+func (c Cipher) isText() bool {
+	return c == CipherAESCFB || c == CipherAESGCM
+}
+
+// parseCipher returns a cipher by its name.
+func parseCipher(name string) (Cipher, error) {
+	for i, n := range cipherNames {
+		if n == name {
+			return Cipher(i), nil
+		}
+	}
+	return CipherUnknown, fmt.Errorf("%w: unknown cipher %q", ErrEnvelope, name)
+}
+
+// envelope contains decoded message fields.
+type envelope struct {
+	version     int
+	cipher      Cipher
+	compression Compression
+	kdf         string
+	salt        []byte
+	keyHash     []byte
+	dataHash    []byte
+	tag         []byte
+	wrappedKey  string
+	value       []byte
+}
+
+// envelopeJSON is JSON form of the envelope, binary fields are base64 encoded.
+type envelopeJSON struct {
+	Version     int    `json:"version"`
+	Cipher      string `json:"cipher"`
+	Compression string `json:"compression,omitempty"`
+	KDF         string `json:"kdf,omitempty"`
+	Salt        []byte `json:"salt,omitempty"`
+	KeyHash     []byte `json:"key_hash,omitempty"`
+	DataHash    []byte `json:"data_hash,omitempty"`
+	Tag         []byte `json:"tag,omitempty"`
+	WrappedKey  string `json:"wrapped_key,omitempty"`
+	Value       string `json:"value,omitempty"`
+}
+
+// kdfParams returns explicit KDF parameters, the legacy PBKDF2 ones are not omitted unlike KDF.String.
+func kdfParams(kdf KDF) string {
+	if k, ok := kdf.(PBKDF2); ok {
+		return fmt.Sprintf("$%s$i=%d$", pbkdf2Name, k.Iter)
+	}
+	return kdf.String()
+}
+
+// envelope returns the message fields, messages without a key (files encrypted by clients) have no salt.
+func (m *Msg) envelope() (*envelope, error) {
+	e := &envelope{
+		version:     EnvelopeVersion,
+		cipher:      m.Cipher,
+		compression: m.Compression,
+		wrappedKey:  m.WrappedKey,
+		value:       []byte(m.Value),
+	}
+	if m.Salt != "" {
+		if err := m.decode(false); err != nil {
+			return nil, err
+		}
+		e.kdf, e.salt, e.keyHash, e.dataHash, e.tag = kdfParams(m.kdf), m.s, m.kh, m.dh, m.tag
+	} else if m.KeyHash != "" || m.DataHash != "" || len(m.tag) != 0 {
+		return nil, fmt.Errorf("%w: hashes without salt", ErrEnvelope)
+	}
+	if e.cipher.isText() {
+		b, err := hex.DecodeString(m.Value)
+		if err != nil {
+			return nil, fmt.Errorf("hex decode value: %w", err)
+		}
+		e.value = b
+	}
+	return e, nil
+}
+
+// msg sets the message fields from the envelope after their validation.
+func (e *envelope) msg(m *Msg) error {
+	if e.version != EnvelopeVersion {
+		return fmt.Errorf("%w: unknown version %d", ErrEnvelope, e.version)
+	}
+	if int(e.cipher) >= len(cipherNames) {
+		return fmt.Errorf("%w: unknown cipher %d", ErrEnvelope, e.cipher)
+	}
+	if int(e.compression) >= len(compressionNames) {
+		return fmt.Errorf("%w: unknown compression %d", ErrEnvelope, e.compression)
+	}
+	for _, h := range [][]byte{e.keyHash, e.dataHash, e.tag} {
+		if len(h) != 0 && len(h) != hashLength {
+			return fmt.Errorf("%w: hash size %d", ErrEnvelope, len(h))
+		}
+	}
+	if len(e.tag) == 0 && (e.cipher != CipherUnknown || e.compression != CompressionNone) && len(e.salt) != 0 {
+		return fmt.Errorf("%w: not authenticated header", ErrEnvelope)
+	}
+	result := Msg{Cipher: e.cipher, Compression: e.compression, WrappedKey: e.wrappedKey, Value: string(e.value)}
+	switch {
+	case e.kdf == "" && len(e.salt) == 0:
+		if len(e.keyHash) != 0 || len(e.dataHash) != 0 || len(e.tag) != 0 {
+			return fmt.Errorf("%w: hashes without salt", ErrEnvelope)
+		}
+		if e.compression != CompressionNone {
+			return fmt.Errorf("%w: compression without key", ErrEnvelope)
+		}
+	case e.kdf == "" || len(e.salt) == 0:
+		return fmt.Errorf("%w: kdf and salt are required together", ErrEnvelope)
+	default:
+		kdf, salt, err := decodeSalt(e.kdf + hex.EncodeToString(e.salt))
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrEnvelope, err)
+		}
+		if kdfParams(kdf) != e.kdf {
+			return fmt.Errorf("%w: not canonical kdf %q", ErrEnvelope, e.kdf)
+		}
+		// hashes can refer to unmarshaled data, they are copied
+		result.kdf, result.s = kdf, salt
+		result.kh, result.dh = append([]byte(nil), e.keyHash...), append([]byte(nil), e.dataHash...)
+		result.tag = append([]byte(nil), e.tag...)
+		result.encode(false)
+	}
+	if e.cipher.isText() {
+		result.Value = hex.EncodeToString(e.value)
+	}
+	*m = result
+	return nil
+}
+
+// headerTag returns an authentication tag of the message header by the message key.
+// The header is the envelope without the wrapped key and the value.
+func (m *Msg) headerTag(key []byte) []byte {
+	tagKey := Hash(append(append(make([]byte, 0, len(key)+len(tagInfo)), key...), tagInfo...))
+	mac := hmac.New(sha3.New256, tagKey)
+	mac.Write(append([]byte(envelopeMagic), EnvelopeVersion, byte(m.Cipher), byte(m.Compression)))
+	prefix := make([]byte, binary.MaxVarintLen64)
+	for _, f := range [][]byte{[]byte(kdfParams(m.kdf)), m.s, m.kh, m.dh} {
+		n := binary.PutUvarint(prefix, uint64(len(f)))
+		mac.Write(prefix[:n])
+		mac.Write(f)
+	}
+	return mac.Sum(nil)
+}
+
+// setTag authenticates the header of the new message by its key.
+func (m *Msg) setTag(key []byte) {
+	m.tag = m.headerTag(key)
+}
+
+// checkTag returns ErrHash if the message header doesn't match its tag.
+// Legacy messages without cipher and compression have no tag.
+func (m *Msg) checkTag(key []byte) error {
+	if len(m.tag) == 0 && m.Cipher == CipherUnknown && m.Compression == CompressionNone {
+		return nil
+	}
+	if !hmac.Equal(m.tag, m.headerTag(key)) {
+		return fmt.Errorf("%w: message header is modified", ErrHash)
+	}
+	return nil
+}
+
+// compressionName returns a name of the compression in JSON envelopes, it's empty for not compressed messages.
+func compressionName(c Compression) string {
+	if c == CompressionNone {
+		return ""
+	}
+	return c.String()
+}
+
+// MarshalBinary returns the message as binary envelope.
+func (m *Msg) MarshalBinary() ([]byte, error) {
+	e, err := m.envelope()
+	if err != nil {
+		return nil, err
+	}
+	fields := [envelopeFields][]byte{
+		[]byte(e.kdf), e.salt, e.keyHash, e.dataHash, e.tag, []byte(e.wrappedKey), e.value,
+	}
+	var (
+		b      = make([]byte, 0, len(envelopeMagic)+3+envelopeFields*binary.MaxVarintLen64+len(e.salt)+len(e.value))
+		prefix = make([]byte, binary.MaxVarintLen64)
+	)
+	b = append(b, envelopeMagic...)
+	b = append(b, byte(e.version), byte(e.cipher), byte(e.compression))
+	for _, f := range fields {
+		n := binary.PutUvarint(prefix, uint64(len(f)))
+		b = append(append(b, prefix[:n]...), f...)
+	}
+	return b, nil
+}
+
+// UnmarshalBinary sets the message from binary envelope.
+func (m *Msg) UnmarshalBinary(data []byte) error {
+	if len(data) < len(envelopeMagic)+3 || !bytes.HasPrefix(data, []byte(envelopeMagic)) {
+		return fmt.Errorf("%w: signature", ErrEnvelope)
+	}
+	data = data[len(envelopeMagic):]
+	e := &envelope{version: int(data[0]), cipher: Cipher(data[1]), compression: Compression(data[2])}
+	if e.version != EnvelopeVersion {
+		return fmt.Errorf("%w: unknown version %d", ErrEnvelope, e.version)
+	}
+	data = data[3:]
+	var fields [envelopeFields][]byte
+	for i := range fields {
+		n, k := binary.Uvarint(data)
+		if k <= 0 || n > uint64(len(data)-k) {
+			return fmt.Errorf("%w: field %d is truncated", ErrEnvelope, i)
+		}
+		fields[i], data = data[k:k+int(n)], data[k+int(n):]
+	}
+	if len(data) != 0 {
+		return fmt.Errorf("%w: %d extra bytes", ErrEnvelope, len(data))
+	}
+	e.kdf, e.salt, e.keyHash, e.dataHash = string(fields[0]), fields[1], fields[2], fields[3]
+	e.tag, e.wrappedKey, e.value = fields[4], string(fields[5]), fields[6]
+	return e.msg(m)
+}
+
+// MarshalJSON returns the message as JSON envelope.
+func (m *Msg) MarshalJSON() ([]byte, error) {
+	e, err := m.envelope()
+	if err != nil {
+		return nil, err
+	}
+	// text ciphertexts are kept hex encoded like in Msg.Value
+	return json.Marshal(&envelopeJSON{
+		Version:     e.version,
+		Cipher:      e.cipher.String(),
+		Compression: compressionName(e.compression),
+		KDF:         e.kdf,
+		Salt:        e.salt,
+		KeyHash:     e.keyHash,
+		DataHash:    e.dataHash,
+		Tag:         e.tag,
+		WrappedKey:  e.wrappedKey,
+		Value:       m.Value,
+	})
+}
+
+// UnmarshalJSON sets the message from JSON envelope.
+func (m *Msg) UnmarshalJSON(data []byte) error {
+	v := &envelopeJSON{}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrEnvelope, err)
+	}
+	c, err := parseCipher(v.Cipher)
+	if err != nil {
+		return err
+	}
+	compression, err := ParseCompression(v.Compression)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrEnvelope, err)
+	}
+	e := &envelope{
+		version:     v.Version,
+		cipher:      c,
+		compression: compression,
+		kdf:         v.KDF,
+		salt:        v.Salt,
+		keyHash:     v.KeyHash,
+		dataHash:    v.DataHash,
+		tag:         v.Tag,
+		wrappedKey:  v.WrappedKey,
+		value:       []byte(v.Value),
+	}
+	if c.isText() {
+		if e.value, err = hex.DecodeString(v.Value); err != nil {
+			return fmt.Errorf("%w: hex decode value: %v", ErrEnvelope, err)
+		}
+	}
+	return e.msg(m)
+}
+
+// MarshalText returns the message as compact token.
+func (m *Msg) MarshalText() ([]byte, error) {
+	b, err := m.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	token := make([]byte, base64.RawURLEncoding.EncodedLen(len(b)))
+	base64.RawURLEncoding.Encode(token, b)
+	return token, nil
+}
+
+// UnmarshalText sets the message from compact token.
+func (m *Msg) UnmarshalText(token []byte) error {
+	b := make([]byte, base64.RawURLEncoding.DecodedLen(len(token)))
+	n, err := base64.RawURLEncoding.Decode(b, token)
+	if err != nil {
+		return fmt.Errorf("%w: base64 decode token: %v", ErrEnvelope, err)
+	}
+	return m.UnmarshalBinary(b[:n])
+}
+
+// Token returns the message as compact token, it can be used in URLs and headers.
+func (m *Msg) Token() (string, error) {
+	b, err := m.MarshalText()
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// ParseToken returns a message from compact token.
+func ParseToken(token string) (*Msg, error) {
+	m := &Msg{}
+	if err := m.UnmarshalText([]byte(token)); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
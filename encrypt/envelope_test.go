@@ -0,0 +1,229 @@
+package encrypt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEnvelopeText(t *testing.T) {
+	const (
+		secret    = "secret"
+		plainText = "some text"
+	)
+	opts := &Options{KDF: Argon2id{Time: 1, Memory: 1024, Threads: 1}, AD: []byte("id"), Keyring: newKeyring(t, testMasterKey1)}
+	m, err := Text(secret, plainText, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Cipher != CipherAESGCM {
+		t.Errorf("failed cipher=%v", m.Cipher)
+	}
+	b, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the ciphertext is stored as bytes
+	if value, e := hex.DecodeString(m.Value); e != nil || !bytes.HasSuffix(b, value) {
+		t.Errorf("failed binary value, error=%v", e)
+	}
+	token, err := m.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.ContainsAny(token, "+/=") {
+		t.Errorf("token is not base64url: %s", token)
+	}
+	jsonData, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	if err = json.Unmarshal(jsonData, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields["version"] != float64(EnvelopeVersion) || fields["cipher"] != "aes-256-gcm" || fields["kdf"] != opts.KDF.String() {
+		t.Errorf("failed json=%s", jsonData)
+	}
+	decoders := map[string]func() (*Msg, error){
+		"binary": func() (*Msg, error) {
+			r := &Msg{}
+			return r, r.UnmarshalBinary(b)
+		},
+		"json": func() (*Msg, error) {
+			r := &Msg{}
+			return r, json.Unmarshal(jsonData, r)
+		},
+		"token": func() (*Msg, error) {
+			return ParseToken(token)
+		},
+	}
+	for name, decode := range decoders {
+		r, e := decode()
+		if e != nil {
+			t.Fatalf("failed %s decode: %v", name, e)
+		}
+		if r.Salt != m.Salt || r.KeyHash != m.KeyHash || r.Value != m.Value || r.WrappedKey != m.WrappedKey || r.Cipher != m.Cipher {
+			t.Errorf("failed %s message=%+v", name, r)
+		}
+		if decrypted, e := DecryptText(secret, r, opts); e != nil || decrypted != plainText {
+			t.Errorf("failed %s decrypted=%s, error=%v", name, decrypted, e)
+		}
+	}
+}
+
+func TestEnvelopeFile(t *testing.T) {
+	const secret = "secret"
+	content := []byte("file content")
+	opts := &Options{KDF: Argon2id{Time: 1, Memory: 1024, Threads: 1}, Format: FormatAge, Passphrase: secret, WorkFactor: 10}
+	m, err := File(secret, bytes.NewReader(content), t.TempDir(), "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Cipher != CipherAge {
+		t.Errorf("failed cipher=%v", m.Cipher)
+	}
+	token, err := m.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := ParseToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Salt != m.Salt || r.KeyHash != m.KeyHash || r.DataHash != m.DataHash || r.Value != m.Value || r.Cipher != m.Cipher {
+		t.Errorf("failed message=%+v", r)
+	}
+	var dst bytes.Buffer
+	if err = DecryptFile(secret, r, &dst, opts); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dst.Bytes(), content) {
+		t.Errorf("failed content=%s", dst.String())
+	}
+	// stored messages without cipher, legacy KDF and without keys are encoded too
+	cases := []*Msg{
+		{Salt: m.Salt, Value: m.Value, KeyHash: m.KeyHash, DataHash: m.DataHash},
+		legacyText(t, secret, "text"),
+		{Value: "/tmp/raw", Cipher: CipherStream},
+	}
+	for i, c := range cases {
+		jsonData, e := json.Marshal(c)
+		if e != nil {
+			t.Fatal(e)
+		}
+		decoded := &Msg{}
+		if e = json.Unmarshal(jsonData, decoded); e != nil {
+			t.Fatal(e)
+		}
+		if decoded.Salt != c.Salt || decoded.KeyHash != c.KeyHash || decoded.DataHash != c.DataHash || decoded.Value != c.Value {
+			t.Errorf("failed case=%d message=%+v", i, decoded)
+		}
+	}
+	if decrypted, e := DecryptText(secret, cases[1], nil); e != nil || decrypted != "text" {
+		t.Errorf("failed legacy decrypted=%s, error=%v", decrypted, e)
+	}
+}
+
+func TestEnvelopeError(t *testing.T) {
+	m, err := Text("secret", "text", &Options{KDF: Argon2id{Time: 1, Memory: 1024, Threads: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	modify := func(f func(b []byte) []byte) []byte {
+		return f(append([]byte(nil), b...))
+	}
+	cases := map[string][]byte{
+		"empty":       nil,
+		"signature":   modify(func(b []byte) []byte { b[0] = 'X'; return b }),
+		"version":     modify(func(b []byte) []byte { b[4] = 2; return b }),
+		"cipher":      modify(func(b []byte) []byte { b[5] = 100; return b }),
+		"compression": modify(func(b []byte) []byte { b[6] = 100; return b }),
+		"truncated":   b[:len(b)-1],
+		"extra":       append(append([]byte(nil), b...), 0),
+		"kdf":         modify(func(b []byte) []byte { b[8] = 'x'; return b }),
+	}
+	for name, data := range cases {
+		if e := (&Msg{}).UnmarshalBinary(data); !errors.Is(e, ErrEnvelope) {
+			t.Errorf("unexpected %s error: %v", name, e)
+		}
+	}
+	jsonCases := []string{
+		`{"version":2,"cipher":"aes-256-gcm"}`,
+		`{"version":1,"cipher":"aes-256-gcm","compression":"lz4"}`,
+		`{"version":1,"cipher":"ssf-stream","compression":"gzip"}`,
+		`{"version":1,"cipher":"ssf-stream","kdf":"$argon2id$v=19$m=1024,t=1,p=1$","salt":"AAAA"}`,
+		`{"version":1,"cipher":"unknown","salt":"AAAA","tag":"AAAA"}`,
+		`{"version":1,"cipher":"rot13"}`,
+		`{"version":1,"cipher":"unknown","kdf":"$argon2id$v=19$m=1024,t=1,p=1$"}`,
+		`{"version":1,"cipher":"unknown","salt":"AAAA"}`,
+		`{"version":1,"cipher":"unknown","key_hash":"AAAA"}`,
+		`{"version":1,"cipher":"aes-256-gcm","value":"xyz"}`,
+		`{"version":1,"cipher":"unknown","kdf":"$pbkdf2-sha3-512$i=0$","salt":"AAAA"}`,
+		`[]`,
+	}
+	for _, data := range jsonCases {
+		if e := json.Unmarshal([]byte(data), &Msg{}); !errors.Is(e, ErrEnvelope) {
+			t.Errorf("unexpected error for %s: %v", data, e)
+		}
+	}
+	if _, err = ParseToken("not+base64"); !errors.Is(err, ErrEnvelope) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err = (&Msg{KeyHash: "00"}).MarshalBinary(); !errors.Is(err, ErrEnvelope) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestEnvelopeCompression(t *testing.T) {
+	const secret = "secret"
+	plainText := strings.Repeat("compressible text ", 100)
+	opts := &Options{KDF: Argon2id{Time: 1, Memory: 1024, Threads: 1}, Compression: CompressionGzip}
+	m, err := Text(secret, plainText, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Compression != CompressionGzip {
+		t.Fatalf("failed compression=%v", m.Compression)
+	}
+	jsonData, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(jsonData, []byte(`"compression":"gzip"`)) {
+		t.Errorf("failed json=%s", jsonData)
+	}
+	token, err := m.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := ParseToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Compression != CompressionGzip {
+		t.Errorf("failed compression=%v", r.Compression)
+	}
+	if decrypted, e := DecryptText(secret, r, opts); e != nil || decrypted != plainText {
+		t.Errorf("failed decrypted=%s, error=%v", decrypted, e)
+	}
+	// the header is authenticated by the message key
+	b, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[6] = byte(CompressionNone)
+	if err = r.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = DecryptText(secret, r, opts); !errors.Is(err, ErrHash) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+package aead
+
+// Package aead contains primitives of age format which are shared by age and recipient packages:
+// ChaCha20-Poly1305 encryption of file keys, HKDF-SHA256 key derivation and base64 encoding of headers.
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// B64 is base64 encoding of age headers and stanzas without padding.
+var B64 = base64.RawStdEncoding.Strict()
+
+// DeriveKey returns ChaCha20-Poly1305 key derived from the secret by HKDF-SHA256.
+func DeriveKey(secret, salt []byte, info string) []byte {
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), key); err != nil {
+		// HKDF can't fail for this output size
+		panic(err)
+	}
+	return key
+}
+
+// Seal encrypts the plaintext by the key, every key is used only once, so the nonce is zero.
+func Seal(key, plaintext []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, make([]byte, chacha20poly1305.NonceSize), plaintext, nil), nil
+}
+
+// Open decrypts the ciphertext by the key with zero nonce.
+func Open(key, ciphertext []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, make([]byte, chacha20poly1305.NonceSize), ciphertext, nil)
+}
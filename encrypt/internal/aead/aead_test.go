@@ -0,0 +1,35 @@
+package aead
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSeal(t *testing.T) {
+	key := DeriveKey([]byte("secret"), []byte("salt"), "test")
+	if len(key) != 32 {
+		t.Fatalf("failed key length=%d", len(key))
+	}
+	if other := DeriveKey([]byte("secret"), []byte("salt"), "other"); bytes.Equal(key, other) {
+		t.Error("keys with different info are equal")
+	}
+	plaintext := []byte("file key")
+	ciphertext, err := Seal(key, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Open(key, ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, plaintext) {
+		t.Errorf("failed plaintext=%s", b)
+	}
+	ciphertext[0] ^= 1
+	if _, err = Open(key, ciphertext); err == nil {
+		t.Error("expected error")
+	}
+	if _, err = Seal(key[:16], plaintext); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,195 @@
+package encrypt
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	// kdfSeparator separates KDF parameters inside encoded salt.
+	kdfSeparator = "$"
+	// argon2idName is Argon2id identifier.
+	argon2idName = "argon2id"
+	// pbkdf2Name is PBKDF2-SHA3-512 identifier.
+	pbkdf2Name = "pbkdf2-sha3-512"
+	// tuneRounds is a number of measurements to tune KDF.
+	tuneRounds = 3
+	// maxArgon2Time is a max number of Argon2id passes, it limits tuning too.
+	maxArgon2Time = 10
+	// maxArgon2Memory is a max Argon2id memory in KiB (1 GiB).
+	maxArgon2Memory = 1 << 20
+	// maxArgon2Threads is a max Argon2id parallelism.
+	maxArgon2Threads = 16
+	// maxPBKDF2Iter is a max number of PBKDF2 iterations.
+	maxPBKDF2Iter = 1 << 22
+)
+
+// ErrKDF is an error when KDF parameters are invalid.
+var ErrKDF = errors.New("invalid kdf parameters")
+
+// DefaultKDF is a key derivation function for new items.
+// Parameters are the second recommended option of RFC 9106.
+var DefaultKDF = Argon2id{Time: 3, Memory: 64 << 10, Threads: 4}
+
+// KDF is a password based key derivation function with its parameters.
+type KDF interface {
+	// Key returns a key for AES-256 from the secret and the salt.
+	Key(secret string, salt []byte) []byte
+	// String returns encoded identifier and parameters, it's stored as a prefix of the salt.
+	String() string
+}
+
+// PBKDF2 is the legacy PBKDF2-SHA3-512 key derivation function.
+type PBKDF2 struct {
+	Iter int
+}
+
+// Key returns a key for AES-256 from the secret and the salt.
+func (k PBKDF2) Key(secret string, salt []byte) []byte {
+	return pbkdf2.Key([]byte(secret), salt, k.Iter, aesKeyLength, sha3.New512)
+}
+
+// String returns encoded identifier and parameters.
+// The legacy parameters have no prefix, so old salts are decoded without changes.
+func (k PBKDF2) String() string {
+	if k.Iter == pbkdf2Iter {
+		return ""
+	}
+	return fmt.Sprintf("$%s$i=%d$", pbkdf2Name, k.Iter)
+}
+
+// Validate checks PBKDF2 parameters, stored values must not be able to make key derivation too expensive.
+func (k PBKDF2) Validate() error {
+	if k.Iter < 1 || k.Iter > maxPBKDF2Iter {
+		return fmt.Errorf("pbkdf2 iterations=%d is out of range [1, %d]: %w", k.Iter, maxPBKDF2Iter, ErrKDF)
+	}
+	return nil
+}
+
+// Argon2id is Argon2id key derivation function, memory is in KiB.
+type Argon2id struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+// Key returns a key for AES-256 from the secret and the salt.
+func (k Argon2id) Key(secret string, salt []byte) []byte {
+	return argon2.IDKey([]byte(secret), salt, k.Time, k.Memory, k.Threads, aesKeyLength)
+}
+
+// String returns encoded identifier and parameters in PHC string format.
+func (k Argon2id) String() string {
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$", argon2idName, argon2.Version, k.Memory, k.Time, k.Threads)
+}
+
+// Validate checks Argon2id parameters, stored values must not be able to make key derivation too expensive.
+func (k Argon2id) Validate() error {
+	switch {
+	case k.Time < 1 || k.Time > maxArgon2Time:
+		return fmt.Errorf("argon2id time=%d is out of range [1, %d]: %w", k.Time, maxArgon2Time, ErrKDF)
+	case k.Threads < 1 || k.Threads > maxArgon2Threads:
+		return fmt.Errorf("argon2id threads=%d is out of range [1, %d]: %w", k.Threads, maxArgon2Threads, ErrKDF)
+	case k.Memory < 8*uint32(k.Threads):
+		return fmt.Errorf("argon2id memory=%d is less than 8*threads: %w", k.Memory, ErrKDF)
+	case k.Memory > maxArgon2Memory:
+		return fmt.Errorf("argon2id memory=%d is greater than %d: %w", k.Memory, maxArgon2Memory, ErrKDF)
+	}
+	return nil
+}
+
+// TuneArgon2id returns Argon2id parameters with fixed memory and threads,
+// its time parameter is selected to derive a key not faster than target duration on this machine.
+func TuneArgon2id(target time.Duration, memory uint32, threads uint8) (Argon2id, error) {
+	k := Argon2id{Time: 1, Memory: memory, Threads: threads}
+	if err := k.Validate(); err != nil {
+		return k, err
+	}
+	salt, err := Salt()
+	if err != nil {
+		return k, err
+	}
+	var best time.Duration
+	for i := 0; i < tuneRounds; i++ {
+		start := time.Now()
+		k.Key("secret", salt)
+		if d := time.Since(start); best == 0 || d < best {
+			best = d
+		}
+	}
+	// one pass duration is the minimal measured value, round up to reach the target
+	passes := (target + best - 1) / best
+	switch {
+	case passes < 1:
+		passes = 1
+	case passes > maxArgon2Time:
+		passes = maxArgon2Time
+	}
+	k.Time = uint32(passes)
+	return k, nil
+}
+
+// encodeSalt returns the salt with KDF parameters as a string.
+func encodeSalt(kdf KDF, salt []byte) string {
+	return kdf.String() + hex.EncodeToString(salt)
+}
+
+// decodeSalt returns KDF and the salt from a string.
+// A salt without KDF parameters is a legacy PBKDF2 one.
+func decodeSalt(s string) (KDF, []byte, error) {
+	var kdf KDF = PBKDF2{Iter: pbkdf2Iter}
+	if strings.HasPrefix(s, kdfSeparator) {
+		// "$name$params...$hex"
+		i := strings.LastIndex(s, kdfSeparator)
+		params := strings.Split(s[1:i], kdfSeparator)
+		k, err := parseKDF(params)
+		if err != nil {
+			return nil, nil, err
+		}
+		kdf, s = k, s[i+1:]
+	}
+	salt, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, nil, fmt.Errorf("hex decode salt: %w", err)
+	}
+	return kdf, salt, nil
+}
+
+// parseKDF returns KDF by its identifier and parameters.
+func parseKDF(params []string) (KDF, error) {
+	switch {
+	case len(params) == 3 && params[0] == argon2idName:
+		var (
+			version int
+			k       Argon2id
+		)
+		if _, err := fmt.Sscanf(params[1], "v=%d", &version); err != nil || version != argon2.Version {
+			return nil, fmt.Errorf("argon2id version %q: %w", params[1], ErrKDF)
+		}
+		_, err := fmt.Sscanf(params[2], "m=%d,t=%d,p=%d", &k.Memory, &k.Time, &k.Threads)
+		if err != nil {
+			return nil, fmt.Errorf("argon2id parameters %q: %w", params[2], ErrKDF)
+		}
+		if err = k.Validate(); err != nil {
+			return nil, err
+		}
+		return k, nil
+	case len(params) == 2 && params[0] == pbkdf2Name:
+		var k PBKDF2
+		if _, err := fmt.Sscanf(params[1], "i=%d", &k.Iter); err != nil {
+			return nil, fmt.Errorf("pbkdf2 parameters %q: %w", params[1], ErrKDF)
+		}
+		if err := k.Validate(); err != nil {
+			return nil, err
+		}
+		return k, nil
+	}
+	return nil, fmt.Errorf("unknown kdf %q: %w", strings.Join(params, kdfSeparator), ErrKDF)
+}
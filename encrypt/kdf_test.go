@@ -0,0 +1,150 @@
+package encrypt
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeSalt(t *testing.T) {
+	salt := []byte("salt")
+	cases := []KDF{
+		DefaultKDF,
+		Argon2id{Time: 1, Memory: 1024, Threads: 2},
+		PBKDF2{Iter: pbkdf2Iter},
+		PBKDF2{Iter: 1000},
+	}
+	for i, c := range cases {
+		s := encodeSalt(c, salt)
+		kdf, b, err := decodeSalt(s)
+		if err != nil {
+			t.Errorf("failed decode case=%d: %v", i, err)
+			continue
+		}
+		if kdf != c {
+			t.Errorf("failed kdf for case=%d: %v", i, kdf)
+		}
+		if string(b) != string(salt) {
+			t.Errorf("failed salt for case=%d: %s", i, b)
+		}
+	}
+	if s := encodeSalt(DefaultKDF, salt); s != "$argon2id$v=19$m=65536,t=3,p=4$73616c74" {
+		t.Errorf("failed encoded salt=%s", s)
+	}
+}
+
+func TestDecodeSaltError(t *testing.T) {
+	cases := []string{
+		"$argon2id$v=18$m=65536,t=3,p=4$73616c74",
+		"$argon2id$v=19$m=65536,t=0,p=4$73616c74",
+		"$argon2id$v=19$m=1,t=1,p=4$73616c74",
+		"$argon2id$v=19$t=3$73616c74",
+		"$pbkdf2-sha3-512$i=0$73616c74",
+		"$scrypt$n=1$73616c74",
+		"$argon2id$v=19$m=65536,t=3,p=4$not hex",
+	}
+	for i, c := range cases {
+		if _, _, err := decodeSalt(c); err == nil {
+			t.Errorf("expected error for case=%d", i)
+		}
+	}
+	if _, _, err := decodeSalt(cases[0]); !errors.Is(err, ErrKDF) {
+		t.Errorf("unexpected error type: %v", err)
+	}
+}
+
+func TestKDFLimits(t *testing.T) {
+	valid := []string{
+		"$argon2id$v=19$m=1048576,t=10,p=16$73616c74",
+		"$pbkdf2-sha3-512$i=4194304$73616c74",
+	}
+	for _, c := range valid {
+		if _, _, err := decodeSalt(c); err != nil {
+			t.Errorf("failed %s: %v", c, err)
+		}
+	}
+	cases := []string{
+		"$argon2id$v=19$m=1048577,t=3,p=4$73616c74",
+		"$argon2id$v=19$m=4294967295,t=3,p=4$73616c74",
+		"$argon2id$v=19$m=65536,t=11,p=4$73616c74",
+		"$argon2id$v=19$m=65536,t=4294967295,p=4$73616c74",
+		"$argon2id$v=19$m=65536,t=3,p=17$73616c74",
+		"$pbkdf2-sha3-512$i=4194305$73616c74",
+		"$pbkdf2-sha3-512$i=-1$73616c74",
+	}
+	for _, c := range cases {
+		if _, _, err := decodeSalt(c); !errors.Is(err, ErrKDF) {
+			t.Errorf("unexpected error for %s: %v", c, err)
+		}
+	}
+	kdfs := []interface{ Validate() error }{
+		Argon2id{Time: 11, Memory: 1024, Threads: 1},
+		Argon2id{Time: 1, Memory: maxArgon2Memory + 1, Threads: 1},
+		Argon2id{Time: 1, Memory: 1024, Threads: 17},
+		PBKDF2{Iter: maxPBKDF2Iter + 1},
+		PBKDF2{},
+	}
+	for i, k := range kdfs {
+		if err := k.Validate(); !errors.Is(err, ErrKDF) {
+			t.Errorf("unexpected error for case=%d: %v", i, err)
+		}
+	}
+}
+
+func TestTextKDF(t *testing.T) {
+	const (
+		secret    = "secret"
+		plainText = "some text"
+	)
+	kdf := Argon2id{Time: 1, Memory: 1024, Threads: 1}
+	m1, err := Text(secret, plainText, &Options{KDF: kdf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(m1.Salt, kdf.String()) {
+		t.Errorf("failed salt=%s", m1.Salt)
+	}
+	m2 := &Msg{Value: m1.Value, Salt: m1.Salt, KeyHash: m1.KeyHash}
+	decrypted, err := DecryptText(secret, m2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted != plainText {
+		t.Errorf("failed decrypted=%s", decrypted)
+	}
+	if _, err = DecryptText("bad", m2, nil); !errors.Is(err, ErrSecret) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDecryptTextLegacy(t *testing.T) {
+	const (
+		secret    = "secret"
+		plainText = "some text"
+	)
+	m := legacyText(t, secret, plainText)
+	decrypted, err := DecryptText(secret, m, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted != plainText {
+		t.Errorf("failed decrypted=%s", decrypted)
+	}
+}
+
+func TestTuneArgon2id(t *testing.T) {
+	k, err := TuneArgon2id(20*time.Millisecond, 1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Time < 1 || k.Time > maxArgon2Time || k.Memory != 1024 || k.Threads != 1 {
+		t.Errorf("failed tuned parameters %+v", k)
+	}
+	if _, err = TuneArgon2id(time.Millisecond, 1, 1); !errors.Is(err, ErrKDF) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err = TuneArgon2id(time.Millisecond, maxArgon2Memory+1, 1); !errors.Is(err, ErrKDF) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
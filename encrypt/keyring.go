@@ -0,0 +1,174 @@
+package encrypt
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/z0rr0/ssf/encrypt/text"
+)
+
+const (
+	// MasterKeySize is a size of server master keys.
+	MasterKeySize = 32
+	// maxKeyIDLen is a max length of master key identifiers.
+	maxKeyIDLen = 32
+	// keyIDSeparator separates a master key identifier from the key or the wrapped data key.
+	keyIDSeparator = ":"
+)
+
+// ErrMasterKey is an error when a wrapped data key can not be decrypted by master keys.
+var ErrMasterKey = errors.New("invalid master key")
+
+// Keyring contains server master keys by their identifiers.
+// Data keys of new messages are wrapped by the current key, it's the last one.
+type Keyring struct {
+	keys    map[string][]byte
+	current string
+}
+
+// ParseKeyring returns a keyring from entries "ID:HEX_KEY" separated by new lines or commas.
+// Empty lines and lines starting with "#" are skipped, the last key is the current one.
+func ParseKeyring(s string) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string][]byte)}
+	scanner := bufio.NewScanner(strings.NewReader(s))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, entry := range strings.Split(line, ",") {
+			if err := k.add(strings.TrimSpace(entry)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read master keys: %w", err)
+	}
+	if k.current == "" {
+		return nil, fmt.Errorf("%w: no keys", ErrMasterKey)
+	}
+	return k, nil
+}
+
+// add parses the entry and makes it the current key.
+func (k *Keyring) add(entry string) error {
+	id, value, ok := strings.Cut(entry, keyIDSeparator)
+	if !ok || !validKeyID(id) {
+		return fmt.Errorf("%w: entry must be ID:HEX_KEY, ID is [a-zA-Z0-9_-]{1,%d}", ErrMasterKey, maxKeyIDLen)
+	}
+	if _, ok = k.keys[id]; ok {
+		return fmt.Errorf("%w: duplicate key ID %q", ErrMasterKey, id)
+	}
+	key, err := hex.DecodeString(value)
+	if err != nil || len(key) != MasterKeySize {
+		return fmt.Errorf("%w: key %q must be %d hex encoded bytes", ErrMasterKey, id, MasterKeySize)
+	}
+	k.keys[id], k.current = key, id
+	return nil
+}
+
+// validKeyID returns true if id is not empty and contains only letters, digits, "-" and "_".
+func validKeyID(id string) bool {
+	if id == "" || len(id) > maxKeyIDLen {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Current returns ID of the current master key.
+func (k *Keyring) Current() string {
+	return k.current
+}
+
+// newDataKey returns a new random data key and its wrapped form.
+// The data key is encrypted by the password key, then the result is encrypted by the current master key,
+// so both are required to unwrap it, and the master key can be replaced without passwords.
+func (k *Keyring) newDataKey(passwordKey, salt []byte) ([]byte, string, error) {
+	dataKey, err := Random(aesKeyLength)
+	if err != nil {
+		return nil, "", fmt.Errorf("random data key: %w", err)
+	}
+	inner, err := text.Seal(dataKey, passwordKey, salt)
+	if err != nil {
+		return nil, "", err
+	}
+	wrapped, err := k.seal(k.current, inner)
+	if err != nil {
+		return nil, "", err
+	}
+	return dataKey, wrapped, nil
+}
+
+// openDataKey returns the data key from wrapped one. ErrSecret is returned if the password key is wrong.
+func (k *Keyring) openDataKey(passwordKey, salt []byte, wrapped string) ([]byte, error) {
+	_, inner, err := k.open(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := text.Open(inner, passwordKey, salt)
+	if err != nil {
+		return nil, ErrSecret
+	}
+	return dataKey, nil
+}
+
+// Rewrap returns the wrapped data key encrypted by the current master key.
+// The data key itself is not changed, so encrypted content stays valid.
+// It returns false if the key is already wrapped by the current master key.
+func (k *Keyring) Rewrap(wrapped string) (string, bool, error) {
+	id, inner, err := k.open(wrapped)
+	if err != nil {
+		return "", false, err
+	}
+	if id == k.current {
+		return wrapped, false, nil
+	}
+	if wrapped, err = k.seal(k.current, inner); err != nil {
+		return "", false, err
+	}
+	return wrapped, true, nil
+}
+
+// seal encrypts the inner wrapped key by the master key with id, the id is authenticated too.
+func (k *Keyring) seal(id string, inner []byte) (string, error) {
+	b, err := text.Seal(inner, k.keys[id], []byte(id))
+	if err != nil {
+		return "", err
+	}
+	return id + keyIDSeparator + hex.EncodeToString(b), nil
+}
+
+// open decrypts the wrapped key by the master key with its ID and returns the ID and the inner wrapped key.
+func (k *Keyring) open(wrapped string) (string, []byte, error) {
+	if k == nil {
+		return "", nil, fmt.Errorf("%w: master keys are not configured", ErrMasterKey)
+	}
+	id, value, ok := strings.Cut(wrapped, keyIDSeparator)
+	if !ok {
+		return "", nil, fmt.Errorf("%w: malformed wrapped key", ErrMasterKey)
+	}
+	key, ok := k.keys[id]
+	if !ok {
+		return "", nil, fmt.Errorf("%w: unknown key ID %q", ErrMasterKey, id)
+	}
+	b, err := hex.DecodeString(value)
+	if err != nil {
+		return "", nil, fmt.Errorf("hex decode wrapped key: %w", err)
+	}
+	inner, err := text.Open(b, key, []byte(id))
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: key %q: %v", ErrMasterKey, id, err)
+	}
+	return id, inner, nil
+}
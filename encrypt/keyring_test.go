@@ -0,0 +1,206 @@
+package encrypt
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/z0rr0/ssf/encrypt/text"
+)
+
+const (
+	testMasterKey1 = "old:000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	testMasterKey2 = "new:202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f"
+)
+
+func newKeyring(t *testing.T, s string) *Keyring {
+	k, err := ParseKeyring(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestParseKeyring(t *testing.T) {
+	cases := []struct {
+		name    string
+		value   string
+		current string
+		err     bool
+	}{
+		{name: "one", value: testMasterKey1, current: "old"},
+		{name: "lines", value: "# keys\n" + testMasterKey1 + "\n\n" + testMasterKey2 + "\n", current: "new"},
+		{name: "commas", value: testMasterKey2 + ", " + testMasterKey1, current: "old"},
+		{name: "empty", value: "# no keys\n", err: true},
+		{name: "no_id", value: strings.TrimPrefix(testMasterKey1, "old:"), err: true},
+		{name: "bad_id", value: "a.b" + strings.TrimPrefix(testMasterKey1, "old"), err: true},
+		{name: "long_id", value: strings.Repeat("a", 33) + strings.TrimPrefix(testMasterKey1, "old"), err: true},
+		{name: "short_key", value: "id:0001", err: true},
+		{name: "hex", value: "id:" + strings.Repeat("x", 64), err: true},
+		{name: "duplicate", value: testMasterKey1 + "," + testMasterKey1, err: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			k, err := ParseKeyring(c.value)
+			if c.err {
+				if !errors.Is(err, ErrMasterKey) {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if k.Current() != c.current {
+				t.Errorf("failed current=%s", k.Current())
+			}
+		})
+	}
+}
+
+func TestKeyringText(t *testing.T) {
+	const (
+		secret    = "secret"
+		plainText = "some text"
+	)
+	opts := &Options{KDF: Argon2id{Time: 1, Memory: 1024, Threads: 1}, Keyring: newKeyring(t, testMasterKey1)}
+	m, err := Text(secret, plainText, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(m.WrappedKey, "old:") {
+		t.Fatalf("failed wrapped key=%s", m.WrappedKey)
+	}
+	// the key hash doesn't depend on the password key only
+	passwordKey, hash := Key(opts.KDF, secret, m.s)
+	if bytes.Equal(m.kh, hash) {
+		t.Error("key hash is calculated by the password key")
+	}
+	if _, err = text.Open(m.v, passwordKey, opts.AD); err == nil {
+		t.Error("text is encrypted by the password key")
+	}
+	load := func() *Msg {
+		return &Msg{Value: m.Value, Salt: m.Salt, KeyHash: m.KeyHash, WrappedKey: m.WrappedKey}
+	}
+	decrypted, err := DecryptText(secret, load(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted != plainText {
+		t.Errorf("failed decrypted=%s", decrypted)
+	}
+	if _, err = DecryptText("bad", load(), opts); !errors.Is(err, ErrSecret) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err = DecryptText(secret, load(), nil); !errors.Is(err, ErrMasterKey) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	other := &Options{Keyring: newKeyring(t, testMasterKey2)}
+	if _, err = DecryptText(secret, load(), other); !errors.Is(err, ErrMasterKey) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// messages without wrapped keys are decrypted by the password key
+	legacy, err := Text(secret, plainText, &Options{KDF: opts.KDF})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if legacy.WrappedKey != "" {
+		t.Errorf("failed wrapped key=%s", legacy.WrappedKey)
+	}
+	if decrypted, err = DecryptText(secret, legacy, opts); err != nil || decrypted != plainText {
+		t.Errorf("failed decrypted=%s, error=%v", decrypted, err)
+	}
+}
+
+func TestKeyringRewrap(t *testing.T) {
+	const secret = "secret"
+	dir := t.TempDir()
+	opts := &Options{KDF: Argon2id{Time: 1, Memory: 1024, Threads: 1}, Keyring: newKeyring(t, testMasterKey1)}
+	content := bytes.Repeat([]byte("content"), 1000)
+	m, err := File(secret, bytes.NewReader(content), dir, "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	both := newKeyring(t, testMasterKey1+"\n"+testMasterKey2)
+	rewrapped, changed, err := both.Rewrap(m.WrappedKey)
+	if err != nil || !changed {
+		t.Fatalf("failed rewrap changed=%v, error=%v", changed, err)
+	}
+	if !strings.HasPrefix(rewrapped, "new:") {
+		t.Errorf("failed rewrapped key=%s", rewrapped)
+	}
+	if again, changed, err := both.Rewrap(rewrapped); err != nil || changed || again != rewrapped {
+		t.Errorf("failed rewrap changed=%v, error=%v", changed, err)
+	}
+	if _, _, err = newKeyring(t, testMasterKey2).Rewrap(m.WrappedKey); !errors.Is(err, ErrMasterKey) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// the file is decrypted by the new master key only
+	newOpts := &Options{Keyring: newKeyring(t, testMasterKey2)}
+	stored := &Msg{Salt: m.Salt, Value: m.Value, KeyHash: m.KeyHash, DataHash: m.DataHash, WrappedKey: rewrapped}
+	var dst bytes.Buffer
+	if err = DecryptFile(secret, stored, &dst, newOpts); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dst.Bytes(), content) {
+		t.Error("failed decrypted content")
+	}
+	dst.Reset()
+	if err = DecryptFileRange(secret, stored, 10, 20, &dst, newOpts); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dst.Bytes(), content[10:30]) {
+		t.Errorf("failed range=%q", dst.String())
+	}
+	if err = CheckSecret("bad", stored, newOpts); !errors.Is(err, ErrSecret) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err = CheckSecret(secret, stored, opts); !errors.Is(err, ErrMasterKey) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, _, err = both.Rewrap("new:00"); !errors.Is(err, ErrMasterKey) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, _, err = both.Rewrap("none"); !errors.Is(err, ErrMasterKey) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestKeyringUpload(t *testing.T) {
+	const secret = "secret"
+	opts := &Options{
+		KDF:     Argon2id{Time: 1, Memory: 1024, Threads: 1},
+		AD:      []byte("id"),
+		Keyring: newKeyring(t, testMasterKey1),
+	}
+	plainText := bytes.Repeat([]byte("upload"), 100)
+	u, err := NewUpload(secret, t.TempDir(), int64(len(plainText)), &Meta{Name: "file"}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(u.Msg.WrappedKey, "old:") {
+		t.Fatalf("failed wrapped key=%s", u.Msg.WrappedKey)
+	}
+	state := Upload{Msg: Msg{Salt: u.Msg.Salt, Value: u.Msg.Value, KeyHash: u.Msg.KeyHash, WrappedKey: u.Msg.WrappedKey}, Meta: u.Meta, Size: u.Size}
+	if _, err = state.Write("bad", state.Offset, bytes.NewReader(plainText), opts); !errors.Is(err, ErrSecret) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err = state.Write(secret, state.Offset, bytes.NewReader(plainText), opts); err != nil {
+		t.Fatal(err)
+	}
+	m, _, err := state.Finish(secret, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.WrappedKey != u.Msg.WrappedKey {
+		t.Errorf("failed wrapped key=%s", m.WrappedKey)
+	}
+	var dst bytes.Buffer
+	if err = DecryptFile(secret, m, &dst, opts); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dst.Bytes(), plainText) {
+		t.Error("failed decrypted content")
+	}
+}
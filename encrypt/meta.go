@@ -0,0 +1,63 @@
+package encrypt
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Meta is file meta data, it's stored encrypted.
+type Meta struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	Type string `json:"type"`
+	Sum  string `json:"sum"`
+}
+
+// Encrypt returns encrypted JSON meta data using the secret.
+// It uses own random salt, so the result is independent of the file's Msg.
+// Meta data are not compressed, only compression of items content is stored.
+func (meta *Meta) Encrypt(secret string, opts *Options) (*Msg, error) {
+	b, err := marshalMeta(meta)
+	if err != nil {
+		return nil, err
+	}
+	var metaOpts Options
+	if opts != nil {
+		metaOpts = *opts
+	}
+	metaOpts.Compression = CompressionNone
+	return Text(secret, string(b), &metaOpts)
+}
+
+// Decrypt fills meta data from encrypted m using the secret.
+func (meta *Meta) Decrypt(secret string, m *Msg, opts *Options) error {
+	plainText, err := DecryptText(secret, m, opts)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal([]byte(plainText), meta); err != nil {
+		return fmt.Errorf("meta unmarshal: %w", err)
+	}
+	return nil
+}
+
+// marshalMeta returns JSON meta data, nil meta is marshaled as empty one.
+func marshalMeta(meta *Meta) ([]byte, error) {
+	if meta == nil {
+		meta = &Meta{}
+	}
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return nil, fmt.Errorf("meta marshal: %w", err)
+	}
+	return b, nil
+}
+
+// unmarshalMeta returns meta data from JSON.
+func unmarshalMeta(b []byte) (*Meta, error) {
+	meta := &Meta{}
+	if err := json.Unmarshal(b, meta); err != nil {
+		return nil, fmt.Errorf("meta unmarshal: %w", err)
+	}
+	return meta, nil
+}
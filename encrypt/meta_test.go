@@ -0,0 +1,32 @@
+package encrypt
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/z0rr0/ssf/encrypt/text"
+)
+
+func TestMeta(t *testing.T) {
+	const secret = "secret"
+	opts := &Options{KDF: Argon2id{Time: 1, Memory: 1024, Threads: 1}, AD: []byte("id")}
+	meta := &Meta{Name: "файл.txt", Size: 100, Type: "text/plain", Sum: "abc"}
+
+	m, err := meta.Encrypt(secret, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted := &Meta{}
+	if err = decrypted.Decrypt(secret, &Msg{Value: m.Value, Salt: m.Salt, KeyHash: m.KeyHash}, opts); err != nil {
+		t.Fatal(err)
+	}
+	if *decrypted != *meta {
+		t.Errorf("failed decrypted meta=%+v", decrypted)
+	}
+	if err = decrypted.Decrypt("bad", m, opts); !errors.Is(err, ErrSecret) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err = decrypted.Decrypt(secret, m, &Options{AD: []byte("other")}); !errors.Is(err, text.ErrAuth) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -5,29 +5,88 @@ package pwgen
 import (
 	crand "crypto/rand"
 	"encoding/binary"
-	"math/rand"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
 )
 
 // Alphabet is all allowed for password generation symbols
 const Alphabet = `#%&+-3479@CFHJKLMNPRTVWXbcdfghjkmnpqrstvwxz`
 
+// Ambiguous are symbols which can be confused with others.
+const Ambiguous = "0Oo1Il|5S2Z8B`'\""
+
+// maxEntropy is a max allowed policy entropy in bits.
+const maxEntropy = 512
+
+// ErrPolicy is an error when password policy parameters are invalid.
+var ErrPolicy = errors.New("invalid password policy")
+
+// Generator creates random passwords by a policy.
+type Generator interface {
+	// New returns a new random password.
+	New() string
+	// Entropy returns an entropy estimate of passwords in bits.
+	Entropy() float64
+	// MaxLen returns max length of passwords.
+	MaxLen() int
+	// Validate checks policy parameters.
+	Validate() error
+}
+
+// classes are checks of character classes: lower and upper case letters, digits and other symbols.
+var classes = []func(c byte) bool{
+	func(c byte) bool { return c >= 'a' && c <= 'z' },
+	func(c byte) bool { return c >= 'A' && c <= 'Z' },
+	func(c byte) bool { return c >= '0' && c <= '9' },
+	func(c byte) bool {
+		return !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9')
+	},
+}
+
 // CryptoRandSource represents a source of uniformly-distributed random int64 values in the range [0, 1<<63).
 type CryptoRandSource struct{}
 
 // Int63 returns a non-negative random 63-bit integer as an int64 from CryptoRandSource.
 func (CryptoRandSource) Int63() int64 {
 	var b [8]byte
-	_, err := crand.Read(b[:])
-	if err != nil {
+	readRandom(b[:])
+	return int64(binary.LittleEndian.Uint64(b[:]) & (1<<63 - 1))
+}
+
+// Seed is fake CryptoRandSource Seed implementation for Source interface.
+func (CryptoRandSource) Seed(int64) {}
+
+// readRandom fills b by random bytes.
+func readRandom(b []byte) {
+	if _, err := crand.Read(b); err != nil {
 		// fail - can't continue
 		// there is no possibility to return an error
 		panic(err)
 	}
-	return int64(binary.LittleEndian.Uint64(b[:]) & (1<<63 - 1))
 }
 
-// Seed is fake CryptoRandSource Seed implementation for Source interface.
-func (CryptoRandSource) Seed(int64) {}
+// sample fills dst by uniformly selected symbols of the alphabet, it must contain from 1 to 256 symbols.
+// Random bytes are rejected if they are not less than the largest multiple of the alphabet size,
+// so there is no modulo bias.
+func sample(dst []byte, alphabet string) {
+	size := len(alphabet)
+	limit := 256 - 256%size
+	buf := make([]byte, len(dst)+8)
+	for i := 0; i < len(dst); {
+		readRandom(buf)
+		for _, b := range buf {
+			if i == len(dst) {
+				break
+			}
+			if int(b) < limit {
+				dst[i] = alphabet[int(b)%size]
+				i++
+			}
+		}
+	}
+}
 
 // New returns a new random string with length `n` bytes.
 // String alphabet can empty, then default alphabet will be used.
@@ -36,15 +95,177 @@ func New(n int, alphabet string) string {
 	if alphabet == "" {
 		alphabet = Alphabet
 	}
+	container := make([]byte, n)
+	sample(container, alphabet)
+	return string(container)
+}
 
-	source := &CryptoRandSource{}
-	random := rand.New(source)
+// Policy is a password generation policy.
+// The length of passwords is MinLength or more to reach MinEntropy bits.
+type Policy struct {
+	// MinLength is a min length of passwords.
+	MinLength int
+	// MinEntropy is a min entropy of passwords in bits.
+	MinEntropy float64
+	// Alphabet is a set of printable ASCII symbols, the default one is used if it's empty.
+	Alphabet string
+	// Classes requires at least one symbol of every character class of the alphabet.
+	Classes bool
+	// Unambiguous excludes Ambiguous symbols from the alphabet.
+	Unambiguous bool
+}
 
-	sourceLen := len(alphabet)
-	container := make([]byte, n)
+// alphabet returns policy symbols.
+func (p *Policy) alphabet() string {
+	alphabet := p.Alphabet
+	if alphabet == "" {
+		alphabet = Alphabet
+	}
+	if !p.Unambiguous {
+		return alphabet
+	}
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(Ambiguous, r) {
+			return -1
+		}
+		return r
+	}, alphabet)
+}
 
-	for i := range container {
-		container[i] = alphabet[random.Intn(sourceLen)]
+// classSizes returns numbers of alphabet symbols of every not empty character class.
+func classSizes(alphabet string) []int {
+	var sizes []int
+	for _, class := range classes {
+		n := 0
+		for i := 0; i < len(alphabet); i++ {
+			if class(alphabet[i]) {
+				n++
+			}
+		}
+		if n > 0 {
+			sizes = append(sizes, n)
+		}
 	}
-	return string(container)
+	return sizes
+}
+
+// bits returns entropy of passwords with length n in bits.
+// If classes are required, a number of valid passwords is counted by inclusion-exclusion principle:
+// passwords without symbols of every subset of classes are excluded or included by the subset size.
+func (p *Policy) bits(alphabet string, n int) float64 {
+	size := float64(len(alphabet))
+	total := float64(n) * math.Log2(size)
+	if !p.Classes {
+		return total
+	}
+	sizes := classSizes(alphabet)
+	if n < len(sizes) {
+		return 0
+	}
+	var share float64
+	for subset := 0; subset < 1<<len(sizes); subset++ {
+		excluded, sign := 0, 1.0
+		for i, s := range sizes {
+			if subset&(1<<i) != 0 {
+				excluded += s
+				sign = -sign
+			}
+		}
+		share += sign * math.Pow((size-float64(excluded))/size, float64(n))
+	}
+	if share <= 0 {
+		return 0
+	}
+	return total + math.Log2(share)
+}
+
+// length returns password length for the alphabet.
+func (p *Policy) length(alphabet string) int {
+	n := p.MinLength
+	if p.Classes {
+		if k := len(classSizes(alphabet)); n < k {
+			n = k
+		}
+	}
+	for p.bits(alphabet, n) < p.MinEntropy {
+		n++
+	}
+	return n
+}
+
+// Len returns a length of generated passwords.
+func (p *Policy) Len() int {
+	return p.length(p.alphabet())
+}
+
+// MaxLen returns max length of generated passwords, it's the same as Len.
+func (p *Policy) MaxLen() int {
+	return p.Len()
+}
+
+// Entropy returns an entropy estimate of generated passwords in bits.
+func (p *Policy) Entropy() float64 {
+	alphabet := p.alphabet()
+	return p.bits(alphabet, p.length(alphabet))
+}
+
+// Validate checks policy parameters.
+func (p *Policy) Validate() error {
+	if p.MinLength < 0 {
+		return fmt.Errorf("%w: min length %d is negative", ErrPolicy, p.MinLength)
+	}
+	if p.MinEntropy < 0 || p.MinEntropy > maxEntropy {
+		return fmt.Errorf("%w: min entropy %v is out of range [0, %d]", ErrPolicy, p.MinEntropy, maxEntropy)
+	}
+	alphabet := p.alphabet()
+	if len(alphabet) < 2 {
+		return fmt.Errorf("%w: alphabet %q must contain at least 2 symbols", ErrPolicy, alphabet)
+	}
+	for i := 0; i < len(alphabet); i++ {
+		c := alphabet[i]
+		if c <= ' ' || c > '~' {
+			return fmt.Errorf("%w: alphabet must contain only printable ASCII symbols, found %q", ErrPolicy, c)
+		}
+		if strings.IndexByte(alphabet[i+1:], c) >= 0 {
+			return fmt.Errorf("%w: alphabet contains duplicate symbol %q", ErrPolicy, c)
+		}
+	}
+	if p.length(alphabet) == 0 {
+		return fmt.Errorf("%w: password length is zero", ErrPolicy)
+	}
+	return nil
+}
+
+// New returns a new random password by the policy, it must be valid.
+// Passwords without symbols of required classes are rejected,
+// so all valid passwords have the same probability.
+func (p *Policy) New() string {
+	alphabet := p.alphabet()
+	container := make([]byte, p.length(alphabet))
+	for {
+		sample(container, alphabet)
+		if !p.Classes || hasClasses(container, alphabet) {
+			return string(container)
+		}
+	}
+}
+
+// hasClasses returns true if the password contains a symbol of every character class of the alphabet.
+func hasClasses(password []byte, alphabet string) bool {
+	for _, class := range classes {
+		if strings.IndexFunc(alphabet, func(r rune) bool { return class(byte(r)) }) < 0 {
+			continue
+		}
+		found := false
+		for _, c := range password {
+			if class(c) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
 }
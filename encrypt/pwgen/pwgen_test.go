@@ -1,6 +1,11 @@
 package pwgen
 
-import "testing"
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
 
 func TestNew(t *testing.T) {
 	var p string
@@ -25,3 +30,93 @@ func BenchmarkNew(b *testing.B) {
 		}
 	}
 }
+
+func TestNewUniform(t *testing.T) {
+	// every symbol of the alphabet has the same probability
+	const n = 3 * 20000
+	counts := make(map[rune]int)
+	for _, c := range New(n, "abc") {
+		counts[c]++
+	}
+	for _, c := range "abc" {
+		if k := counts[c]; k < n/3*95/100 || k > n/3*105/100 {
+			t.Errorf("failed count of %q: %d", c, k)
+		}
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	cases := []struct {
+		policy  Policy
+		length  int
+		entropy float64
+	}{
+		{policy: Policy{MinLength: 10, Alphabet: "ab"}, length: 10, entropy: 10},
+		{policy: Policy{MinLength: 2, Alphabet: "aA", Classes: true}, length: 2, entropy: 1},
+		{policy: Policy{MinLength: 1, Alphabet: "aA1-", Classes: true}, length: 4, entropy: 4.584962500721156},
+		{policy: Policy{MinLength: 8, MinEntropy: 64, Alphabet: "0123456789abcdef"}, length: 16, entropy: 64},
+		{policy: Policy{MinLength: 1, MinEntropy: 20, Alphabet: "0123456789abcdefO", Unambiguous: true}, length: 6},
+	}
+	for i, c := range cases {
+		if err := c.policy.Validate(); err != nil {
+			t.Errorf("failed case=%d: %v", i, err)
+			continue
+		}
+		if n := c.policy.Len(); n != c.length {
+			t.Errorf("failed case=%d length=%d, expected %d", i, n, c.length)
+		}
+		bits := c.policy.Entropy()
+		if c.entropy > 0 && math.Abs(bits-c.entropy) > 1e-9 {
+			t.Errorf("failed case=%d entropy=%v, expected %v", i, bits, c.entropy)
+		}
+		if bits < c.policy.MinEntropy {
+			t.Errorf("failed case=%d entropy=%v is less than %v", i, bits, c.policy.MinEntropy)
+		}
+		alphabet := c.policy.alphabet()
+		for j := 0; j < 100; j++ {
+			p := c.policy.New()
+			if len(p) != c.length || strings.Trim(p, alphabet) != "" {
+				t.Errorf("failed case=%d password %q", i, p)
+			}
+			if c.policy.Classes && !hasClasses([]byte(p), alphabet) {
+				t.Errorf("failed case=%d password %q without required classes", i, p)
+			}
+			if c.policy.Unambiguous && strings.ContainsAny(p, Ambiguous) {
+				t.Errorf("failed case=%d password %q with ambiguous symbols", i, p)
+			}
+		}
+	}
+}
+
+func TestPolicyDefault(t *testing.T) {
+	p := &Policy{MinLength: 15}
+	if err := p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if bits, expected := p.Entropy(), 15*math.Log2(float64(len(Alphabet))); bits != expected {
+		t.Errorf("failed entropy=%v, expected %v", bits, expected)
+	}
+	if s := p.New(); len(s) != 15 || strings.Trim(s, Alphabet) != "" {
+		t.Errorf("failed password %q", s)
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	cases := []Policy{
+		{MinLength: -1},
+		{MinLength: 8, MinEntropy: -1},
+		{MinLength: 8, MinEntropy: maxEntropy + 1},
+		{MinLength: 8, Alphabet: "a"},
+		{MinLength: 8, Alphabet: "0O1l", Unambiguous: true},
+		{MinLength: 8, Alphabet: "abca"},
+		{MinLength: 8, Alphabet: "ab\tc"},
+		{MinLength: 8, Alphabet: "abcж"},
+		{},
+	}
+	for i, c := range cases {
+		err := c.Validate()
+		if !errors.Is(err, ErrPolicy) {
+			t.Errorf("failed case=%d error: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,565 @@
+package pwgen
+
+import (
+	"bufio"
+	_ "embed" // embed common passwords list
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Names of password patterns.
+const (
+	PatternCommon   = "common password"
+	PatternWord     = "dictionary word"
+	PatternKeyboard = "keyboard pattern"
+	PatternRepeat   = "repeat"
+	PatternSequence = "sequence"
+	PatternDate     = "date"
+)
+
+const (
+	// minMatchLen is a min length of dictionary words, keyboard patterns and sequences.
+	minMatchLen = 3
+	// minGuesses is a min number of guesses of a pattern.
+	minGuesses = 10
+	// keyboardKeys is a number of keyboard keys to start a pattern.
+	keyboardKeys = 47
+	// keyboardDegree is an average number of neighbors of a keyboard key.
+	keyboardDegree = 4
+	// minPassLen is a length of passwords which are short for any symbols.
+	minPassLen = 8
+	// minYearSpace is a min number of years to guess a date.
+	minYearSpace = 20
+	// dateSeparators are allowed separators of date parts.
+	dateSeparators = " /\\_.-"
+)
+
+// commonPasswords is a list of frequently used passwords ordered by their popularity.
+//
+//go:embed common_passwords.txt
+var commonPasswords string
+
+// warnings are explanations of patterns.
+var warnings = map[string]string{
+	PatternCommon:   "it is a common password",
+	PatternWord:     "dictionary words are easy to guess",
+	PatternKeyboard: "keyboard patterns like qwerty are easy to guess",
+	PatternRepeat:   `repeats like "aaa" or "abcabc" are easy to guess`,
+	PatternSequence: "sequences like abc or 6543 are easy to guess",
+	PatternDate:     "dates and years are easy to guess",
+}
+
+// leet are common substitutions of letters, the alternative one is used for symbols with two meanings.
+var (
+	leet = map[byte]byte{
+		'4': 'a', '@': 'a', '8': 'b', '(': 'c', '3': 'e', '6': 'g', '1': 'i', '!': 'i',
+		'|': 'i', '0': 'o', '$': 's', '5': 's', '7': 't', '+': 't', '2': 'z',
+	}
+	leetAlt = map[byte]byte{'1': 'l', '|': 'l', '7': 'l'}
+)
+
+// keyboardRows are rows of US keyboard layout and their offsets in keys.
+var (
+	keyboardRows    = []string{"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./"}
+	keyboardOffsets = []float64{0, 1.5, 1.75, 2.25}
+	keyboardShift   = map[byte]byte{
+		'~': '`', '!': '1', '@': '2', '#': '3', '$': '4', '%': '5', '^': '6', '&': '7', '*': '8', '(': '9',
+		')': '0', '_': '-', '+': '=', '{': '[', '}': ']', '|': '\\', ':': ';', '"': '\'', '<': ',', '>': '.', '?': '/',
+	}
+)
+
+// keyPosition is a position of a key on the keyboard.
+type keyPosition struct {
+	row int
+	x   float64
+}
+
+// entry is a dictionary word.
+type entry struct {
+	guesses float64
+	pattern string
+}
+
+var (
+	dictOnce    sync.Once
+	dictionary  map[string]entry
+	dictMaxLen  int
+	keyboardMap map[byte]keyPosition
+)
+
+// loadDictionary initializes dictionary words and keyboard layout.
+// Common passwords are ranked by their popularity, words of EFF list have the same rank.
+func loadDictionary() {
+	dictOnce.Do(func() {
+		words := EFFLarge()
+		dictionary = make(map[string]entry, len(words))
+		for _, w := range words {
+			dictionary[w] = entry{guesses: float64(len(words)), pattern: PatternWord}
+		}
+		scanner := bufio.NewScanner(strings.NewReader(commonPasswords))
+		for rank := 1; scanner.Scan(); {
+			if w := strings.TrimSpace(scanner.Text()); w != "" {
+				dictionary[w] = entry{guesses: float64(rank), pattern: PatternCommon}
+				rank++
+			}
+		}
+		for w := range dictionary {
+			if len(w) > dictMaxLen {
+				dictMaxLen = len(w)
+			}
+		}
+		keyboardMap = make(map[byte]keyPosition)
+		for row, keys := range keyboardRows {
+			for i := 0; i < len(keys); i++ {
+				keyboardMap[keys[i]] = keyPosition{row: row, x: keyboardOffsets[row] + float64(i)}
+			}
+		}
+	})
+}
+
+// Strength is an estimated strength of a password.
+type Strength struct {
+	// Guesses is an estimated number of guesses to find the password.
+	Guesses float64
+	// Entropy is a binary logarithm of guesses.
+	Entropy float64
+	// Patterns are names of found patterns.
+	Patterns []string
+	// Warning explains the main weakness of the password.
+	Warning string
+}
+
+// match is a pattern found in the password bytes [i, j).
+type match struct {
+	i, j    int
+	guesses float64
+	pattern string
+}
+
+// Estimate returns password strength like zxcvbn does: the password is split into dictionary words,
+// keyboard patterns, repeats, sequences, dates and other symbols with min total number of guesses.
+func Estimate(password string) *Strength {
+	loadDictionary()
+	guesses, matches := estimate(password)
+	s := &Strength{Guesses: guesses, Entropy: math.Log2(guesses)}
+	covered := make(map[string]int)
+	for _, m := range matches {
+		if covered[m.pattern] == 0 {
+			s.Patterns = append(s.Patterns, m.pattern)
+		}
+		covered[m.pattern] += m.j - m.i
+	}
+	maxCovered := 0
+	for _, name := range s.Patterns {
+		if n := covered[name]; n > maxCovered {
+			maxCovered, s.Warning = n, warnings[name]
+		}
+	}
+	if s.Warning == "" && len(password) < minPassLen {
+		s.Warning = "short passwords are easy to guess"
+	}
+	return s
+}
+
+// estimate returns min number of guesses of the password and its patterns.
+// Not matched symbols are guessed by their character class cardinality.
+func estimate(password string) (float64, []match) {
+	n := len(password)
+	byEnd := make([][]match, n+1)
+	for _, m := range findMatches(password) {
+		byEnd[m.j] = append(byEnd[m.j], m)
+	}
+	best := make([]float64, n+1)
+	prev := make([]*match, n+1)
+	best[0] = 1
+	for j := 1; j <= n; j++ {
+		best[j] = best[j-1] * cardinality(password[j-1])
+		for k := range byEnd[j] {
+			m := &byEnd[j][k]
+			if g := best[m.i] * math.Max(m.guesses, minGuesses); g < best[j] {
+				best[j], prev[j] = g, m
+			}
+		}
+	}
+	var matches []match
+	for j := n; j > 0; {
+		if m := prev[j]; m != nil {
+			matches = append(matches, *m)
+			j = m.i
+		} else {
+			j--
+		}
+	}
+	for i, k := 0, len(matches)-1; i < k; i, k = i+1, k-1 {
+		matches[i], matches[k] = matches[k], matches[i]
+	}
+	return best[n], matches
+}
+
+// findMatches returns all found patterns of the password.
+func findMatches(password string) []match {
+	var matches []match
+	matches = append(matches, dictionaryMatches(password)...)
+	matches = append(matches, keyboardMatches(password)...)
+	matches = append(matches, repeatMatches(password)...)
+	matches = append(matches, sequenceMatches(password)...)
+	matches = append(matches, dateMatches(password)...)
+	return matches
+}
+
+// cardinality returns a number of symbols in the character class of c.
+func cardinality(c byte) float64 {
+	switch {
+	case c >= '0' && c <= '9':
+		return 10
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		return 26
+	case c >= ' ' && c <= '~':
+		return 33
+	}
+	return 100
+}
+
+// lower returns the string with ASCII letters in lower case, other bytes are not changed.
+func lower(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if c >= 'A' && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+	return string(b)
+}
+
+// unleet returns the string with replaced substitutions.
+func unleet(s string, alt bool) string {
+	b := []byte(s)
+	for i, c := range b {
+		if r, ok := leetAlt[c]; ok && alt {
+			b[i] = r
+		} else if r, ok = leet[c]; ok {
+			b[i] = r
+		}
+	}
+	return string(b)
+}
+
+// reverse returns the string with reversed bytes.
+func reverse(s string) string {
+	b := []byte(s)
+	for i, k := 0, len(b)-1; i < k; i, k = i+1, k-1 {
+		b[i], b[k] = b[k], b[i]
+	}
+	return string(b)
+}
+
+// binomial returns a number of k-combinations from n elements.
+func binomial(n, k int) float64 {
+	result := 1.0
+	for i := 1; i <= k; i++ {
+		result = result * float64(n-k+i) / float64(i)
+	}
+	return result
+}
+
+// upperVariations returns a number of upper case variants of the word,
+// capitalized or fully upper case words have only two variants.
+func upperVariations(word string) float64 {
+	var upper, low int
+	for i := 0; i < len(word); i++ {
+		switch c := word[i]; {
+		case c >= 'A' && c <= 'Z':
+			upper++
+		case c >= 'a' && c <= 'z':
+			low++
+		}
+	}
+	if upper == 0 {
+		return 1
+	}
+	first, last := word[0], word[len(word)-1]
+	if low == 0 || upper == 1 && (first >= 'A' && first <= 'Z' || last >= 'A' && last <= 'Z') {
+		return 2
+	}
+	k := upper
+	if low < k {
+		k = low
+	}
+	var variations float64
+	for i := 1; i <= k; i++ {
+		variations += binomial(upper+low, i)
+	}
+	return variations
+}
+
+// dictionaryMatches returns common passwords and dictionary words of the password.
+// Upper case letters, leet substitutions and reversed words are allowed.
+func dictionaryMatches(password string) []match {
+	var (
+		matches []match
+		n       = len(password)
+		low     = lower(password)
+	)
+	variants := []struct {
+		s       string
+		factor  float64
+		reverse bool
+	}{{s: low, factor: 1}, {s: reverse(low), factor: 2, reverse: true}}
+	for _, alt := range []bool{false, true} {
+		if s := unleet(low, alt); s != low {
+			variants = append(variants, struct {
+				s       string
+				factor  float64
+				reverse bool
+			}{s: s, factor: 2})
+		}
+	}
+	for _, v := range variants {
+		for i := 0; i < n; i++ {
+			for j := i + minMatchLen; j <= n && j-i <= dictMaxLen; j++ {
+				e, ok := dictionary[v.s[i:j]]
+				if !ok {
+					continue
+				}
+				m := match{i: i, j: j, pattern: e.pattern}
+				if v.reverse {
+					m.i, m.j = n-j, n-i
+				}
+				m.guesses = e.guesses * v.factor * upperVariations(password[m.i:m.j])
+				matches = append(matches, m)
+			}
+		}
+	}
+	return matches
+}
+
+// keyPos returns a keyboard position of the symbol and true if it's typed with shift.
+func keyPos(c byte) (keyPosition, bool, bool) {
+	shifted := false
+	if c >= 'A' && c <= 'Z' {
+		c, shifted = c+'a'-'A', true
+	} else if base, ok := keyboardShift[c]; ok {
+		c, shifted = base, true
+	}
+	p, ok := keyboardMap[c]
+	return p, shifted, ok
+}
+
+// keyboardMatches returns sequences of adjacent keyboard keys like "qwerty" or "1qaz".
+func keyboardMatches(password string) []match {
+	var matches []match
+	n := len(password)
+	for i := 0; i < n-minMatchLen+1; i++ {
+		prev, shifted, ok := keyPos(password[i])
+		if !ok {
+			continue
+		}
+		var (
+			turns   int
+			dr      int
+			dx      float64
+			shifts  = 0
+			j       = i + 1
+			started = false
+		)
+		if shifted {
+			shifts++
+		}
+		for ; j < n; j++ {
+			cur, s, ok := keyPos(password[j])
+			if !ok {
+				break
+			}
+			r, x := cur.row-prev.row, cur.x-prev.x
+			if r < -1 || r > 1 || r == 0 && math.Abs(x) != 1 || r != 0 && math.Abs(x) > 1 {
+				break
+			}
+			if !started || r != dr || math.Signbit(x) != math.Signbit(dx) {
+				turns++
+			}
+			started, dr, dx, prev = true, r, x, cur
+			if s {
+				shifts++
+			}
+		}
+		if length := j - i; length >= minMatchLen {
+			guesses := keyboardKeys * float64(length) * math.Pow(keyboardDegree, float64(turns))
+			if shifts > 0 && shifts < length {
+				guesses *= 2
+			}
+			matches = append(matches, match{i: i, j: j, guesses: guesses, pattern: PatternKeyboard})
+		}
+	}
+	return matches
+}
+
+// repeatMatches returns repeated symbols like "aaa" and repeated blocks like "abcabc".
+func repeatMatches(password string) []match {
+	var matches []match
+	n := len(password)
+	for i := 0; i < n; i++ {
+		j := i + 1
+		for j < n && password[j] == password[i] {
+			j++
+		}
+		if j-i >= minMatchLen {
+			guesses := cardinality(password[i]) * float64(j-i)
+			matches = append(matches, match{i: i, j: j, guesses: guesses, pattern: PatternRepeat})
+		}
+		for k := 2; i+2*k <= n; k++ {
+			block := password[i : i+k]
+			count := 1
+			for i+(count+1)*k <= n && password[i+count*k:i+(count+1)*k] == block {
+				count++
+			}
+			if count > 1 && strings.Count(block, block[:1]) != k {
+				base, _ := estimate(block)
+				matches = append(matches, match{
+					i: i, j: i + count*k, guesses: base * float64(count), pattern: PatternRepeat,
+				})
+			}
+		}
+	}
+	return matches
+}
+
+// sameClass returns true if both symbols are digits, lower or upper case letters.
+func sameClass(a, b byte) bool {
+	class := func(c byte) int {
+		switch {
+		case c >= '0' && c <= '9':
+			return 1
+		case c >= 'a' && c <= 'z':
+			return 2
+		case c >= 'A' && c <= 'Z':
+			return 3
+		}
+		return 0
+	}
+	return class(a) != 0 && class(a) == class(b)
+}
+
+// sequenceMatches returns ascending and descending sequences like "abcd" or "9876".
+func sequenceMatches(password string) []match {
+	var matches []match
+	n := len(password)
+	for i := 0; i < n-minMatchLen+1; i++ {
+		delta := int(password[i+1]) - int(password[i])
+		if delta != 1 && delta != -1 || !sameClass(password[i], password[i+1]) {
+			continue
+		}
+		j := i + 2
+		for j < n && int(password[j])-int(password[j-1]) == delta && sameClass(password[j-1], password[j]) {
+			j++
+		}
+		if j-i < minMatchLen {
+			continue
+		}
+		base := cardinality(password[i])
+		if strings.IndexByte("aAzZ019", password[i]) >= 0 {
+			base = 4
+		}
+		guesses := base * float64(j-i)
+		if delta < 0 {
+			guesses *= 2
+		}
+		matches = append(matches, match{i: i, j: j, guesses: guesses, pattern: PatternSequence})
+	}
+	return matches
+}
+
+// yearSpace returns a number of years to guess the year.
+func yearSpace(year int) float64 {
+	return math.Max(math.Abs(float64(year-time.Now().Year())), minYearSpace)
+}
+
+// parseYear returns a year from 2 or 4 digits.
+func parseYear(s string) (int, bool) {
+	y, err := strconv.Atoi(s)
+	switch {
+	case err != nil:
+		return 0, false
+	case len(s) == 2:
+		if y > 50 {
+			return 1900 + y, true
+		}
+		return 2000 + y, true
+	case len(s) == 4 && y >= 1000 && y <= 2099:
+		return y, true
+	}
+	return 0, false
+}
+
+// validDate returns a year if the parts are a day, a month and a year in some order.
+func validDate(parts []string) (int, bool) {
+	orders := [][3]int{{0, 1, 2}, {1, 0, 2}, {2, 1, 0}, {1, 2, 0}, {2, 0, 1}}
+	for _, o := range orders {
+		day, e1 := strconv.Atoi(parts[o[0]])
+		month, e2 := strconv.Atoi(parts[o[1]])
+		year, ok := parseYear(parts[o[2]])
+		if e1 == nil && e2 == nil && ok && len(parts[o[0]]) <= 2 && len(parts[o[1]]) <= 2 &&
+			day >= 1 && day <= 31 && month >= 1 && month <= 12 {
+			return year, true
+		}
+	}
+	return 0, false
+}
+
+// parseDate returns a year of the date with or without separators, a single year is a date too.
+func parseDate(s string) (int, bool, bool) {
+	if i := strings.IndexAny(s, dateSeparators); i >= 0 {
+		parts := strings.Split(s, s[i:i+1])
+		for _, p := range parts {
+			if p == "" || strings.Trim(p, "0123456789") != "" {
+				return 0, false, false
+			}
+		}
+		if len(parts) != 3 {
+			return 0, false, false
+		}
+		year, ok := validDate(parts)
+		return year, true, ok
+	}
+	if strings.Trim(s, "0123456789") != "" {
+		return 0, false, false
+	}
+	if len(s) == 4 {
+		if y, err := strconv.Atoi(s); err == nil && y >= 1900 && y <= 2099 {
+			return y, false, true
+		}
+	}
+	for a := 1; a <= 4 && a < len(s); a++ {
+		for b := 1; b <= 4 && a+b < len(s); b++ {
+			if year, ok := validDate([]string{s[:a], s[a : a+b], s[a+b:]}); ok && len(s)-a-b <= 4 {
+				return year, false, true
+			}
+		}
+	}
+	return 0, false, false
+}
+
+// dateMatches returns dates like "13.05.1987", "130587" or years like "1987".
+func dateMatches(password string) []match {
+	var matches []match
+	n := len(password)
+	for i := 0; i < n; i++ {
+		for j := i + 4; j <= n && j-i <= 10; j++ {
+			year, sep, ok := parseDate(password[i:j])
+			if !ok {
+				continue
+			}
+			guesses := yearSpace(year)
+			if j-i > 4 {
+				guesses *= 365
+			}
+			if sep {
+				guesses *= 4
+			}
+			matches = append(matches, match{i: i, j: j, guesses: guesses, pattern: PatternDate})
+		}
+	}
+	return matches
+}
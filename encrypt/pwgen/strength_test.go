@@ -0,0 +1,107 @@
+package pwgen
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestEstimate(t *testing.T) {
+	year := time.Now().Year()
+	cases := []struct {
+		password string
+		pattern  string
+		max      float64
+	}{
+		{password: "password", pattern: PatternCommon, max: 5},
+		{password: "P@ssw0rd", pattern: PatternCommon, max: 5},
+		{password: "drowssap", pattern: PatternCommon, max: 5},
+		{password: "iloveyou", pattern: PatternCommon, max: 10},
+		{password: "walrus", pattern: PatternWord, max: 14},
+		{password: "mju7nhy6", pattern: PatternKeyboard, max: 20},
+		{password: "zxcvbnm,./", pattern: PatternKeyboard, max: 20},
+		{password: "qwertgfdsa", pattern: PatternKeyboard, max: 20},
+		{password: "zzzzzzzzzz", pattern: PatternRepeat, max: 10},
+		{password: "xk7xk7xk7xk7", pattern: PatternRepeat, max: 20},
+		{password: "abcdefghij", pattern: PatternSequence, max: 10},
+		{password: "98765432", pattern: PatternSequence, max: 10},
+		{password: "13.05.1987", pattern: PatternDate, max: 20},
+		{password: "130587", pattern: PatternDate, max: 20},
+		{password: fmt.Sprint(year), pattern: PatternDate, max: 5},
+	}
+	for i, c := range cases {
+		s := Estimate(c.password)
+		if s.Entropy > c.max {
+			t.Errorf("failed case=%d, entropy %v is greater than %v", i, s.Entropy, c.max)
+		}
+		if len(s.Patterns) != 1 || s.Patterns[0] != c.pattern {
+			t.Errorf("failed case=%d, patterns=%v", i, s.Patterns)
+		}
+		if s.Warning != warnings[c.pattern] {
+			t.Errorf("failed case=%d, warning=%q", i, s.Warning)
+		}
+		if e := math.Log2(s.Guesses); e != s.Entropy {
+			t.Errorf("failed case=%d, entropy=%v, guesses=%v", i, s.Entropy, s.Guesses)
+		}
+	}
+}
+
+func TestEstimateMixed(t *testing.T) {
+	s := Estimate("Gravity1987qwerty")
+	expected := []string{PatternWord, PatternDate, PatternCommon}
+	if len(s.Patterns) != len(expected) {
+		t.Fatalf("failed patterns=%v", s.Patterns)
+	}
+	for i, p := range expected {
+		if s.Patterns[i] != p {
+			t.Errorf("failed pattern=%d: %q", i, s.Patterns[i])
+		}
+	}
+	if s.Entropy > 40 {
+		t.Errorf("failed entropy=%v", s.Entropy)
+	}
+}
+
+func TestEstimateStrong(t *testing.T) {
+	cases := []struct {
+		password string
+		min      float64
+	}{
+		{password: "kZ7vQp2xW9rT4mNs", min: 60},
+		{password: "correct-horse-battery-staple", min: 50},
+		{password: New(20, ""), min: 60},
+		{password: Passphrase(6, " ", nil), min: 70},
+		{password: "Ωмега-пароль", min: 50},
+	}
+	for i, c := range cases {
+		if s := Estimate(c.password); s.Entropy < c.min {
+			t.Errorf("failed case=%d, entropy %v is less than %v: %+v", i, s.Entropy, c.min, s)
+		}
+	}
+	if s := Estimate("x7#"); s.Warning == "" || len(s.Patterns) != 0 {
+		t.Errorf("failed short password warning=%q, patterns=%v", s.Warning, s.Patterns)
+	}
+	if s := Estimate(""); s.Guesses != 1 || s.Entropy != 0 {
+		t.Errorf("failed empty password %+v", s)
+	}
+}
+
+func TestUpperVariations(t *testing.T) {
+	cases := []struct {
+		word     string
+		expected float64
+	}{
+		{word: "word", expected: 1},
+		{word: "Word", expected: 2},
+		{word: "worD", expected: 2},
+		{word: "WORD", expected: 2},
+		{word: "wOrd", expected: 4},
+		{word: "wORd", expected: 10},
+	}
+	for _, c := range cases {
+		if v := upperVariations(c.word); v != c.expected {
+			t.Errorf("failed %q variations=%v", c.word, v)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package pwgen
+
+import (
+	"bufio"
+	_ "embed" // embed EFF word list
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+)
+
+// Symbols are inserted to passphrases with a digit.
+const Symbols = "!#$%&*+=?@^_~"
+
+// Digits are inserted to passphrases with a symbol.
+const Digits = "0123456789"
+
+// effLarge is EFF large word list for five dice rolls, every line contains the rolls and a word.
+// It's published by Electronic Frontier Foundation under CC BY 3.0 US license,
+// https://www.eff.org/deeplinks/2016/07/new-wordlists-random-passphrases
+//
+//go:embed eff_large_wordlist.txt
+var effLarge string
+
+var (
+	effOnce sync.Once
+	effList WordList
+)
+
+// WordList is a list of unique words for passphrases.
+type WordList []string
+
+// EFFLarge returns EFF large word list with 7776 words, every word adds about 12.9 bits of entropy.
+func EFFLarge() WordList {
+	effOnce.Do(func() {
+		scanner := bufio.NewScanner(strings.NewReader(effLarge))
+		for scanner.Scan() {
+			if fields := strings.Fields(scanner.Text()); len(fields) == 2 {
+				effList = append(effList, fields[1])
+			}
+		}
+	})
+	return effList
+}
+
+// Entropy returns entropy of a passphrase with n words in bits.
+func (l WordList) Entropy(n int) float64 {
+	return float64(n) * math.Log2(float64(len(l)))
+}
+
+// maxLen returns max length of the list words.
+func (l WordList) maxLen() int {
+	n := 0
+	for _, w := range l {
+		if len(w) > n {
+			n = len(w)
+		}
+	}
+	return n
+}
+
+// index returns uniformly selected random number in range [0, n), n must be positive.
+// Random values are rejected if they are not less than the largest multiple of n, so there is no modulo bias.
+func index(n int) int {
+	limit := uint64(1<<63) - uint64(1<<63)%uint64(n)
+	for {
+		if v := uint64(CryptoRandSource{}.Int63()); v < limit {
+			return int(v % uint64(n))
+		}
+	}
+}
+
+// Passphrase returns a new random passphrase with the number of words from the list,
+// they are joined by the separator. EFFLarge list is used if the list is empty.
+func Passphrase(words int, sep string, list WordList) string {
+	if len(list) == 0 {
+		list = EFFLarge()
+	}
+	result := make([]string, words)
+	for i := range result {
+		result[i] = list[index(len(list))]
+	}
+	return strings.Join(result, sep)
+}
+
+// PassphrasePolicy is a passphrase generation policy.
+type PassphrasePolicy struct {
+	// Words is a number of words.
+	Words int
+	// Separator is a string between words.
+	Separator string
+	// Capitalize changes the first letter of every word to upper case.
+	Capitalize bool
+	// Insert appends a random digit and a symbol to a random word.
+	Insert bool
+	// List is a word list, EFFLarge is used if it's empty.
+	List WordList
+}
+
+// list returns policy word list.
+func (p *PassphrasePolicy) list() WordList {
+	if len(p.List) == 0 {
+		return EFFLarge()
+	}
+	return p.List
+}
+
+// Entropy returns an entropy estimate of generated passphrases in bits.
+// Capitalization doesn't add entropy, the insertion adds a choice of the word, the digit and the symbol.
+func (p *PassphrasePolicy) Entropy() float64 {
+	bits := p.list().Entropy(p.Words)
+	if p.Insert {
+		bits += math.Log2(float64(p.Words)) + math.Log2(float64(len(Digits))) + math.Log2(float64(len(Symbols)))
+	}
+	return bits
+}
+
+// MaxLen returns max length of generated passphrases.
+func (p *PassphrasePolicy) MaxLen() int {
+	n := p.Words*p.list().maxLen() + (p.Words-1)*len(p.Separator)
+	if p.Insert {
+		n += 2
+	}
+	return n
+}
+
+// Validate checks policy parameters.
+func (p *PassphrasePolicy) Validate() error {
+	if p.Words < 1 {
+		return fmt.Errorf("%w: number of words %d must be positive", ErrPolicy, p.Words)
+	}
+	if p.Entropy() > maxEntropy {
+		return fmt.Errorf("%w: entropy %.0f is greater than %d", ErrPolicy, p.Entropy(), maxEntropy)
+	}
+	list := p.list()
+	if len(list) < 2 {
+		return fmt.Errorf("%w: word list must contain at least 2 words", ErrPolicy)
+	}
+	unique := make(map[string]struct{}, len(list))
+	for _, w := range list {
+		if w == "" {
+			return fmt.Errorf("%w: word list contains an empty word", ErrPolicy)
+		}
+		if _, ok := unique[w]; ok {
+			return fmt.Errorf("%w: word list contains duplicate word %q", ErrPolicy, w)
+		}
+		unique[w] = struct{}{}
+	}
+	for i := 0; i < len(p.Separator); i++ {
+		if c := p.Separator[i]; c < ' ' || c > '~' {
+			return fmt.Errorf("%w: separator must contain only printable ASCII symbols", ErrPolicy)
+		}
+	}
+	return nil
+}
+
+// New returns a new random passphrase by the policy, it must be valid.
+func (p *PassphrasePolicy) New() string {
+	list := p.list()
+	words := make([]string, p.Words)
+	for i := range words {
+		w := list[index(len(list))]
+		if p.Capitalize {
+			w = strings.ToUpper(w[:1]) + w[1:]
+		}
+		words[i] = w
+	}
+	if p.Insert {
+		i := index(len(words))
+		words[i] += string(Digits[index(len(Digits))]) + string(Symbols[index(len(Symbols))])
+	}
+	return strings.Join(words, p.Separator)
+}
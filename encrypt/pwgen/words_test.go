@@ -0,0 +1,104 @@
+package pwgen
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+func TestEFFLarge(t *testing.T) {
+	list := EFFLarge()
+	if n := len(list); n != 7776 {
+		t.Fatalf("failed word list size=%d", n)
+	}
+	if list[0] != "abacus" || list[len(list)-1] != "zoom" {
+		t.Errorf("failed words %q, %q", list[0], list[len(list)-1])
+	}
+	if n := list.maxLen(); n != 9 {
+		t.Errorf("failed max word length=%d", n)
+	}
+	if err := (&PassphrasePolicy{Words: 1, List: list}).Validate(); err != nil {
+		t.Error(err)
+	}
+	if bits := list.Entropy(6); math.Abs(bits-77.548) > 0.001 {
+		t.Errorf("failed entropy=%v", bits)
+	}
+}
+
+func TestPassphrase(t *testing.T) {
+	words := strings.Split(Passphrase(5, " ", nil), " ")
+	if len(words) != 5 {
+		t.Fatalf("failed words %q", words)
+	}
+	list := WordList{"one", "two", "three"}
+	for i := 0; i < 100; i++ {
+		for _, w := range strings.Split(Passphrase(4, "-", list), "-") {
+			if w != "one" && w != "two" && w != "three" {
+				t.Errorf("unknown word %q", w)
+			}
+		}
+	}
+}
+
+func TestPassphrasePolicy(t *testing.T) {
+	list := WordList{"one", "two", "three", "four"}
+	cases := []struct {
+		policy  PassphrasePolicy
+		entropy float64
+		maxLen  int
+	}{
+		{policy: PassphrasePolicy{Words: 3, Separator: "-", List: list}, entropy: 6, maxLen: 17},
+		{policy: PassphrasePolicy{Words: 2, Separator: " ", Capitalize: true, List: list}, entropy: 4, maxLen: 11},
+		{
+			policy:  PassphrasePolicy{Words: 4, Insert: true, List: list},
+			entropy: 8 + 2 + math.Log2(float64(len(Digits))) + math.Log2(float64(len(Symbols))),
+			maxLen:  22,
+		},
+	}
+	for i, c := range cases {
+		if err := c.policy.Validate(); err != nil {
+			t.Errorf("failed case=%d: %v", i, err)
+			continue
+		}
+		if bits := c.policy.Entropy(); math.Abs(bits-c.entropy) > 1e-9 {
+			t.Errorf("failed case=%d entropy=%v, expected %v", i, bits, c.entropy)
+		}
+		if n := c.policy.MaxLen(); n != c.maxLen {
+			t.Errorf("failed case=%d max length=%d, expected %d", i, n, c.maxLen)
+		}
+		for j := 0; j < 100; j++ {
+			p := c.policy.New()
+			if len(p) > c.maxLen {
+				t.Errorf("failed case=%d passphrase %q is too long", i, p)
+			}
+			if c.policy.Insert != strings.ContainsAny(p, Digits) || c.policy.Insert != strings.ContainsAny(p, Symbols) {
+				t.Errorf("failed case=%d passphrase %q insertion", i, p)
+			}
+			if c.policy.Capitalize != unicode.IsUpper(rune(p[0])) {
+				t.Errorf("failed case=%d passphrase %q capitalization", i, p)
+			}
+			if c.policy.Separator != "" && strings.Count(p, c.policy.Separator) != c.policy.Words-1 {
+				t.Errorf("failed case=%d passphrase %q separators", i, p)
+			}
+		}
+	}
+}
+
+func TestPassphrasePolicyValidate(t *testing.T) {
+	cases := []PassphrasePolicy{
+		{},
+		{Words: -1},
+		{Words: 50},
+		{Words: 2, List: WordList{"one"}},
+		{Words: 2, List: WordList{"one", "one"}},
+		{Words: 2, List: WordList{"one", ""}},
+		{Words: 2, Separator: "\n"},
+	}
+	for i, c := range cases {
+		if err := c.Validate(); !errors.Is(err, ErrPolicy) {
+			t.Errorf("failed case=%d error: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package recipient
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBech32(t *testing.T) {
+	valid := []struct {
+		s   string
+		hrp string
+	}{
+		{s: "A12UEL5L", hrp: "a"},
+		{s: "a12uel5l", hrp: "a"},
+		{s: "abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw", hrp: "abcdef"},
+		{s: "split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w", hrp: "split"},
+		{s: "?1ezyfcl", hrp: "?"},
+	}
+	for _, c := range valid {
+		if hrp, _, err := decodeBech32(c.s); err != nil || hrp != c.hrp {
+			t.Errorf("failed %q: hrp=%q, %v", c.s, hrp, err)
+		}
+	}
+	invalid := []string{
+		"",
+		"1nwldj5",
+		"pzry9x0s0muk",
+		"x1b4n0q5v",
+		"li1dgmt3",
+		"A1G7SGD8",
+		"10a06t8",
+		"1qzzfhee",
+		"a12UEL5L",
+		"a12uel5m",
+	}
+	for _, s := range invalid {
+		if _, _, err := decodeBech32(s); err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+}
+
+func TestBech32Data(t *testing.T) {
+	data := []byte("0123456789abcdef0123456789abcdef")
+	s, err := encodeBech32("test", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hrp, decoded, err := decodeBech32(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hrp != "test" || !bytes.Equal(decoded, data) {
+		t.Errorf("failed hrp=%q, data=%q", hrp, decoded)
+	}
+}
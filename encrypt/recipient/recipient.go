@@ -0,0 +1,311 @@
+package recipient
+
+// Package recipient wraps file keys to X25519 public keys like age tool does.
+//
+// Keys are encoded as age ones: "age1..." recipients and "AGE-SECRET-KEY-1..." identities.
+// A wrapped key is a stanza:
+//
+//	-> X25519 <base64 ephemeral share>
+//	<base64 ChaCha20-Poly1305 encrypted file key, lines of 64 columns>
+//
+// The wrapping key is HKDF-SHA256 of X25519 shared secret with the ephemeral share and the recipient as a salt.
+
+import (
+	"bufio"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/curve25519"
+
+	"github.com/z0rr0/ssf/encrypt/internal/aead"
+)
+
+const (
+	// StanzaType is a type of X25519 stanzas.
+	StanzaType = "X25519"
+	// recipientPrefix is Bech32 human-readable part of recipients.
+	recipientPrefix = "age"
+	// identityPrefix is Bech32 human-readable part of identities.
+	identityPrefix = "AGE-SECRET-KEY-"
+	// label is HKDF info of wrapping keys.
+	label = "age-encryption.org/v1/X25519"
+	// stanzaPrefix is a prefix of stanza's first line.
+	stanzaPrefix = "->"
+	// columns is a max length of stanza's body lines.
+	columns = 64
+	// maxBodySize is a max size of stanza's body.
+	maxBodySize = 1 << 10
+)
+
+var (
+	// ErrRecipient is an error when a recipient is invalid.
+	ErrRecipient = errors.New("invalid recipient")
+	// ErrIdentity is an error when an identity is invalid.
+	ErrIdentity = errors.New("invalid identity")
+	// ErrStanza is an error when a stanza has invalid format.
+	ErrStanza = errors.New("invalid stanza")
+	// ErrNoIdentity is an error when no identity can unwrap a file key.
+	ErrNoIdentity = errors.New("no identity matched any of the recipients")
+)
+
+// Recipient is X25519 public key.
+type Recipient struct {
+	key []byte
+}
+
+// ParseRecipient returns a recipient from "age1..." string.
+func ParseRecipient(s string) (*Recipient, error) {
+	hrp, key, err := decodeBech32(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRecipient, err)
+	}
+	if hrp != recipientPrefix || strings.ToLower(s) != s {
+		return nil, fmt.Errorf("%w: prefix must be %q", ErrRecipient, recipientPrefix)
+	}
+	if len(key) != curve25519.PointSize {
+		return nil, fmt.Errorf("%w: key length %d", ErrRecipient, len(key))
+	}
+	return &Recipient{key: key}, nil
+}
+
+// String returns Bech32 encoded recipient.
+func (r *Recipient) String() string {
+	s, _ := encodeBech32(recipientPrefix, r.key)
+	return s
+}
+
+// Wrap encrypts the file key by a new ephemeral key and returns it as a stanza.
+func (r *Recipient) Wrap(fileKey []byte) (*Stanza, error) {
+	ephemeral := make([]byte, curve25519.ScalarSize)
+	if _, err := io.ReadFull(rand.Reader, ephemeral); err != nil {
+		return nil, fmt.Errorf("ephemeral key: %w", err)
+	}
+	share, err := curve25519.X25519(ephemeral, curve25519.Basepoint)
+	if err != nil {
+		return nil, fmt.Errorf("ephemeral share: %w", err)
+	}
+	secret, err := curve25519.X25519(ephemeral, r.key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRecipient, err)
+	}
+	body, err := aead.Seal(wrappingKey(secret, share, r.key), fileKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Stanza{Type: StanzaType, Args: []string{aead.B64.EncodeToString(share)}, Body: body}, nil
+}
+
+// Identity is X25519 private key.
+type Identity struct {
+	secret    []byte
+	recipient *Recipient
+}
+
+// GenerateIdentity returns a new random identity.
+func GenerateIdentity() (*Identity, error) {
+	secret := make([]byte, curve25519.ScalarSize)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return nil, fmt.Errorf("random key: %w", err)
+	}
+	return newIdentity(secret)
+}
+
+// newIdentity returns an identity of the secret key.
+func newIdentity(secret []byte) (*Identity, error) {
+	key, err := curve25519.X25519(secret, curve25519.Basepoint)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrIdentity, err)
+	}
+	return &Identity{secret: secret, recipient: &Recipient{key: key}}, nil
+}
+
+// ParseIdentity returns an identity from "AGE-SECRET-KEY-1..." string.
+func ParseIdentity(s string) (*Identity, error) {
+	hrp, secret, err := decodeBech32(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrIdentity, err)
+	}
+	if hrp != strings.ToLower(identityPrefix) {
+		return nil, fmt.Errorf("%w: prefix must be %q", ErrIdentity, identityPrefix)
+	}
+	if len(secret) != curve25519.ScalarSize {
+		return nil, fmt.Errorf("%w: key length %d", ErrIdentity, len(secret))
+	}
+	return newIdentity(secret)
+}
+
+// ParseIdentities returns identities from a keys file, empty lines and comments after "#" are skipped.
+func ParseIdentities(r io.Reader) ([]*Identity, error) {
+	var (
+		identities []*Identity
+		scanner    = bufio.NewScanner(r)
+	)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		identity, err := ParseIdentity(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		identities = append(identities, identity)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read identities: %w", err)
+	}
+	if len(identities) == 0 {
+		return nil, fmt.Errorf("%w: no identities found", ErrIdentity)
+	}
+	return identities, nil
+}
+
+// String returns Bech32 encoded identity in upper case.
+func (i *Identity) String() string {
+	s, _ := encodeBech32(identityPrefix, i.secret)
+	return strings.ToUpper(s)
+}
+
+// Recipient returns public key of the identity.
+func (i *Identity) Recipient() *Recipient {
+	return i.recipient
+}
+
+// Unwrap decrypts the file key from X25519 stanza. ErrNoIdentity is returned if the stanza is for other identity.
+func (i *Identity) Unwrap(s *Stanza) ([]byte, error) {
+	if s.Type != StanzaType {
+		return nil, ErrNoIdentity
+	}
+	if len(s.Args) != 1 {
+		return nil, fmt.Errorf("%w: X25519 stanza must have one argument", ErrStanza)
+	}
+	share, err := aead.B64.DecodeString(s.Args[0])
+	if err != nil || len(share) != curve25519.PointSize {
+		return nil, fmt.Errorf("%w: invalid X25519 share", ErrStanza)
+	}
+	secret, err := curve25519.X25519(i.secret, share)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrStanza, err)
+	}
+	fileKey, err := aead.Open(wrappingKey(secret, share, i.recipient.key), s.Body)
+	if err != nil {
+		return nil, ErrNoIdentity
+	}
+	return fileKey, nil
+}
+
+// Unwrap returns the file key from the first stanza which can be decrypted by any of the identities.
+func Unwrap(stanzas []*Stanza, identities []*Identity) ([]byte, error) {
+	for _, s := range stanzas {
+		for _, i := range identities {
+			fileKey, err := i.Unwrap(s)
+			if err == nil {
+				return fileKey, nil
+			}
+			if !errors.Is(err, ErrNoIdentity) {
+				return nil, err
+			}
+		}
+	}
+	return nil, ErrNoIdentity
+}
+
+// wrappingKey returns a key to encrypt file keys.
+func wrappingKey(secret, share, recipient []byte) []byte {
+	salt := make([]byte, 0, len(share)+len(recipient))
+	salt = append(append(salt, share...), recipient...)
+	return aead.DeriveKey(secret, salt, label)
+}
+
+// Stanza is a wrapped file key with its type and arguments.
+type Stanza struct {
+	Type string
+	Args []string
+	Body []byte
+}
+
+// Marshal writes the stanzas in text format.
+func Marshal(w io.Writer, stanzas []*Stanza) error {
+	for _, s := range stanzas {
+		if _, err := io.WriteString(w, s.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// String returns text form of the stanza, the last body line is shorter than 64 columns, it can be empty.
+func (s *Stanza) String() string {
+	var b strings.Builder
+	b.WriteString(stanzaPrefix)
+	for _, arg := range append([]string{s.Type}, s.Args...) {
+		b.WriteByte(' ')
+		b.WriteString(arg)
+	}
+	b.WriteByte('\n')
+	body := aead.B64.EncodeToString(s.Body)
+	for len(body) >= columns {
+		b.WriteString(body[:columns])
+		b.WriteByte('\n')
+		body = body[columns:]
+	}
+	b.WriteString(body)
+	b.WriteByte('\n')
+	return b.String()
+}
+
+// Unmarshal reads stanzas in text format until the end of r.
+func Unmarshal(r io.Reader) ([]*Stanza, error) {
+	var (
+		stanzas []*Stanza
+		br      = bufio.NewReader(r)
+	)
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return stanzas, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrStanza, err)
+		}
+		s, err := ReadStanza(br, strings.TrimSuffix(line, "\n"))
+		if err != nil {
+			return nil, err
+		}
+		stanzas = append(stanzas, s)
+	}
+}
+
+// ReadStanza reads a stanza body from r, the first stanza line is already read without the line break.
+func ReadStanza(r *bufio.Reader, first string) (*Stanza, error) {
+	fields := strings.Split(first, " ")
+	if len(fields) < 2 || fields[0] != stanzaPrefix {
+		return nil, fmt.Errorf("%w: malformed first line", ErrStanza)
+	}
+	for _, f := range fields[1:] {
+		if f == "" {
+			return nil, fmt.Errorf("%w: empty argument", ErrStanza)
+		}
+	}
+	s := &Stanza{Type: fields[1], Args: fields[2:]}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("%w: body: %v", ErrStanza, err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		b, err := aead.B64.DecodeString(line)
+		if err != nil || len(line) > columns {
+			return nil, fmt.Errorf("%w: malformed body line", ErrStanza)
+		}
+		if s.Body = append(s.Body, b...); len(s.Body) > maxBodySize {
+			return nil, fmt.Errorf("%w: body is too long", ErrStanza)
+		}
+		if len(line) < columns {
+			return s, nil
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package recipient
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Test keys and the stanza are created by age tool.
+const (
+	testIdentity  = "AGE-SECRET-KEY-16SNKDNTR87E8FQYPWK2P9LHQERT5QQQV3DHMSNKNX775LEGUK7ZQJWTEMG"
+	testRecipient = "age1kfdfwsvsm7vgf73dz2f33vd2zfdhnx0czuphytzg92rkhen4gfpsx2q8ch"
+	testStanza    = "-> X25519 m56pUGSgwqgrpF/nfOtATvauMeOwUe0FMNCdOW8bviA\nL5FV6ngo63DYr3GHN6ORy0+s5Dc53Nu/cTKA7rA4bWw\n"
+	testFileKey   = "YELLOW SUBMARINE"
+)
+
+func TestParse(t *testing.T) {
+	identity, err := ParseIdentity(testIdentity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := identity.String(); s != testIdentity {
+		t.Errorf("failed identity=%s", s)
+	}
+	if s := identity.Recipient().String(); s != testRecipient {
+		t.Errorf("failed recipient=%s", s)
+	}
+	r, err := ParseRecipient(testRecipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := r.String(); s != testRecipient {
+		t.Errorf("failed recipient=%s", s)
+	}
+	stanzas, err := Unmarshal(strings.NewReader(testStanza))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileKey, err := Unwrap(stanzas, []*Identity{identity})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(fileKey) != testFileKey {
+		t.Errorf("failed file key=%q", fileKey)
+	}
+}
+
+func TestParseError(t *testing.T) {
+	short, err := encodeBech32(recipientPrefix, make([]byte, 31))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipients := []string{
+		"",
+		strings.ToUpper(testRecipient),
+		testIdentity,
+		testRecipient[:len(testRecipient)-1] + "q",
+		short,
+	}
+	for i, s := range recipients {
+		if _, err = ParseRecipient(s); !errors.Is(err, ErrRecipient) {
+			t.Errorf("failed case=%d, error=%v", i, err)
+		}
+	}
+	identities := []string{"", testRecipient, testIdentity[:len(testIdentity)-1] + "Q"}
+	for i, s := range identities {
+		if _, err = ParseIdentity(s); !errors.Is(err, ErrIdentity) {
+			t.Errorf("failed case=%d, error=%v", i, err)
+		}
+	}
+}
+
+func TestParseIdentities(t *testing.T) {
+	other, err := GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := "# created: 2022-06-01\n# public key: " + testRecipient + "\n" + testIdentity + "\n\n" + other.String()
+	identities, err := ParseIdentities(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(identities) != 2 || identities[1].String() != other.String() {
+		t.Errorf("failed identities %v", identities)
+	}
+	for i, s := range []string{"", "# comment\n", testIdentity + "\nbad"} {
+		if _, err = ParseIdentities(strings.NewReader(s)); !errors.Is(err, ErrIdentity) {
+			t.Errorf("failed case=%d, error=%v", i, err)
+		}
+	}
+}
+
+func TestWrap(t *testing.T) {
+	fileKey := []byte("0123456789abcdef0123456789abcdef")
+	identities := make([]*Identity, 3)
+	stanzas := make([]*Stanza, 0, 2)
+	for i := range identities {
+		identity, err := GenerateIdentity()
+		if err != nil {
+			t.Fatal(err)
+		}
+		identities[i] = identity
+		if i == 0 {
+			// the first identity is not a recipient
+			continue
+		}
+		s, err := identity.Recipient().Wrap(fileKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stanzas = append(stanzas, s)
+	}
+	var buf bytes.Buffer
+	if err := Marshal(&buf, stanzas); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := Unmarshal(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != len(stanzas) {
+		t.Fatalf("failed stanzas=%d", len(parsed))
+	}
+	for i, identity := range identities[1:] {
+		key, e := Unwrap(parsed, []*Identity{identity})
+		if e != nil {
+			t.Fatalf("failed identity=%d: %v", i+1, e)
+		}
+		if !bytes.Equal(key, fileKey) {
+			t.Errorf("failed identity=%d, key=%x", i+1, key)
+		}
+	}
+	if _, err = Unwrap(parsed, identities[:1]); !errors.Is(err, ErrNoIdentity) {
+		t.Errorf("failed error=%v", err)
+	}
+}
+
+func TestUnmarshalError(t *testing.T) {
+	long := strings.Repeat("A", columns)
+	cases := []string{
+		"X25519 abc\nAAAA\n",
+		"->\nAAAA\n",
+		"-> X25519  abc\nAAAA\n",
+		"-> X25519 abc\n",
+		"-> X25519 abc\n" + long + "\n",
+		"-> X25519 abc\nAAAA=\n",
+		"-> X25519 abc\n" + long + "A\n\n",
+		"-> X25519 abc\n" + strings.Repeat(long+"\n", maxBodySize/48+1) + "\n",
+	}
+	for i, c := range cases {
+		if _, err := Unmarshal(strings.NewReader(c)); !errors.Is(err, ErrStanza) {
+			t.Errorf("failed case=%d, error=%v", i, err)
+		}
+	}
+	identity, err := ParseIdentity(testIdentity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stanzas := []*Stanza{
+		{Type: StanzaType, Body: []byte("body")},
+		{Type: StanzaType, Args: []string{"bad base64"}, Body: []byte("body")},
+	}
+	for i, s := range stanzas {
+		if _, err = identity.Unwrap(s); !errors.Is(err, ErrStanza) {
+			t.Errorf("failed stanza=%d, error=%v", i, err)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package shamir
+
+// Package shamir implements Shamir's secret sharing over GF(256).
+//
+// Every secret byte is a free coefficient of a random polynomial of degree k-1,
+// a share contains values of the polynomials at its point x:
+//
+//	share = y (secret length) | x (1 byte)
+//
+// Any k shares restore the secret by Lagrange interpolation, k-1 shares reveal nothing about it.
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// MaxShares is a max number of shares, every share has a unique not zero point.
+	MaxShares = 255
+	// MinThreshold is a min number of shares to restore a secret.
+	MinThreshold = 2
+	// polynomial is a reduction polynomial x^8 + x^4 + x^3 + x + 1 of GF(256) as in AES, without x^8.
+	polynomial = 0x1b
+)
+
+var (
+	// ErrParams is an error when split parameters are invalid.
+	ErrParams = errors.New("invalid split parameters")
+	// ErrShares is an error when shares can't be combined.
+	ErrShares = errors.New("invalid shares")
+)
+
+// mul returns a product of a and b in GF(256).
+// It doesn't use lookup tables and branches depending on values, so its time is constant.
+func mul(a, b byte) byte {
+	var p byte
+	for i := 0; i < 8; i++ {
+		p ^= -(b & 1) & a
+		a = a<<1 ^ -(a>>7)&polynomial
+		b >>= 1
+	}
+	return p
+}
+
+// inverse returns a multiplicative inverse of a in GF(256), it's a^254.
+// Zero has no inverse, zero is returned.
+func inverse(a byte) byte {
+	result, base := byte(1), a
+	for e := 254; e > 0; e >>= 1 {
+		if e&1 == 1 {
+			result = mul(result, base)
+		}
+		base = mul(base, base)
+	}
+	return result
+}
+
+// evaluate returns a value of the polynomial with free coefficient s and other coefficients at the point x.
+func evaluate(s byte, coefficients []byte, x byte) byte {
+	var y byte
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		y = mul(y, x) ^ coefficients[i]
+	}
+	return mul(y, x) ^ s
+}
+
+// Split splits the secret into n shares, any k of them restore the secret.
+// Threshold k must be in range [MinThreshold, n] and n must not be greater than MaxShares.
+func Split(secret []byte, n, k int) ([][]byte, error) {
+	switch {
+	case len(secret) == 0:
+		return nil, fmt.Errorf("%w: empty secret", ErrParams)
+	case n < MinThreshold || n > MaxShares:
+		return nil, fmt.Errorf("%w: number of shares %d is out of range [%d, %d]", ErrParams, n, MinThreshold, MaxShares)
+	case k < MinThreshold || k > n:
+		return nil, fmt.Errorf("%w: threshold %d is out of range [%d, %d]", ErrParams, k, MinThreshold, n)
+	}
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][len(secret)] = byte(i + 1)
+	}
+	coefficients := make([]byte, k-1)
+	defer func() {
+		for i := range coefficients {
+			coefficients[i] = 0
+		}
+	}()
+	for j, s := range secret {
+		if _, err := io.ReadFull(rand.Reader, coefficients); err != nil {
+			return nil, fmt.Errorf("random coefficients: %w", err)
+		}
+		for _, share := range shares {
+			share[j] = evaluate(s, coefficients, share[len(secret)])
+		}
+	}
+	return shares, nil
+}
+
+// Combine restores the secret from the shares by Lagrange interpolation at zero.
+// Shares must have the same length and unique points. If less than threshold shares are used,
+// a wrong secret is returned, so it should be checked by the caller.
+func Combine(shares [][]byte) ([]byte, error) {
+	if len(shares) < MinThreshold {
+		return nil, fmt.Errorf("%w: at least %d shares are required", ErrShares, MinThreshold)
+	}
+	size := len(shares[0])
+	if size < 2 {
+		return nil, fmt.Errorf("%w: share is too short", ErrShares)
+	}
+	var points [MaxShares + 1]bool
+	for _, share := range shares {
+		if len(share) != size {
+			return nil, fmt.Errorf("%w: shares have different lengths", ErrShares)
+		}
+		x := share[size-1]
+		if x == 0 || points[x] {
+			return nil, fmt.Errorf("%w: duplicate or zero point %d", ErrShares, x)
+		}
+		points[x] = true
+	}
+	secret := make([]byte, size-1)
+	for i, share := range shares {
+		// Lagrange basis polynomial at zero, subtraction is XOR in GF(256)
+		basis, xi := byte(1), share[size-1]
+		for j, other := range shares {
+			if i != j {
+				xj := other[size-1]
+				basis = mul(basis, mul(xj, inverse(xj^xi)))
+			}
+		}
+		for b := range secret {
+			secret[b] ^= mul(share[b], basis)
+		}
+	}
+	return secret, nil
+}
@@ -0,0 +1,145 @@
+package shamir
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestMul(t *testing.T) {
+	cases := []struct {
+		a, b, expected byte
+	}{
+		{a: 0, b: 0x57, expected: 0},
+		{a: 1, b: 0x57, expected: 0x57},
+		{a: 0x57, b: 0x83, expected: 0xc1},
+		{a: 0x57, b: 0x13, expected: 0xfe},
+		{a: 0xff, b: 0xff, expected: 0x13},
+	}
+	for _, c := range cases {
+		if p := mul(c.a, c.b); p != c.expected {
+			t.Errorf("failed %#x * %#x = %#x", c.a, c.b, p)
+		}
+		if p := mul(c.b, c.a); p != c.expected {
+			t.Errorf("failed %#x * %#x = %#x", c.b, c.a, p)
+		}
+	}
+	for a := 1; a < 256; a++ {
+		if p := mul(byte(a), inverse(byte(a))); p != 1 {
+			t.Errorf("failed inverse of %#x, product=%#x", a, p)
+		}
+	}
+}
+
+// subsets returns all subsets of the shares with k elements.
+func subsets(shares [][]byte, k int) [][][]byte {
+	if k == 0 {
+		return [][][]byte{nil}
+	}
+	var result [][][]byte
+	for i := 0; i <= len(shares)-k; i++ {
+		for _, s := range subsets(shares[i+1:], k-1) {
+			result = append(result, append([][]byte{shares[i]}, s...))
+		}
+	}
+	return result
+}
+
+func TestSplitCombine(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	cases := []struct {
+		n, k int
+	}{
+		{n: 2, k: 2},
+		{n: 3, k: 2},
+		{n: 5, k: 3},
+		{n: 7, k: 7},
+	}
+	for i, c := range cases {
+		shares, err := Split(secret, c.n, c.k)
+		if err != nil {
+			t.Fatalf("failed case=%d: %v", i, err)
+		}
+		if len(shares) != c.n {
+			t.Fatalf("failed case=%d, shares=%d", i, len(shares))
+		}
+		for k := c.k; k <= c.n; k++ {
+			for _, s := range subsets(shares, k) {
+				restored, e := Combine(s)
+				if e != nil {
+					t.Fatalf("failed case=%d: %v", i, e)
+				}
+				if !bytes.Equal(restored, secret) {
+					t.Errorf("failed case=%d, k=%d restored=%x", i, k, restored)
+				}
+			}
+		}
+		if c.k > MinThreshold {
+			restored, e := Combine(shares[:c.k-1])
+			if e != nil {
+				t.Fatalf("failed case=%d: %v", i, e)
+			}
+			if bytes.Equal(restored, secret) {
+				t.Errorf("failed case=%d, secret is restored by %d shares", i, c.k-1)
+			}
+		}
+	}
+}
+
+func TestSplitRandom(t *testing.T) {
+	secret := bytes.Repeat([]byte{0}, 16)
+	a, err := Split(secret, 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Split(secret, 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range a {
+		if bytes.Equal(a[i], b[i]) {
+			t.Errorf("the same share=%d", i)
+		}
+		if bytes.Equal(a[i][:len(secret)], secret) {
+			t.Errorf("share=%d contains the secret", i)
+		}
+	}
+}
+
+func TestSplitError(t *testing.T) {
+	cases := []struct {
+		secret []byte
+		n, k   int
+	}{
+		{secret: nil, n: 3, k: 2},
+		{secret: []byte("secret"), n: 1, k: 1},
+		{secret: []byte("secret"), n: 256, k: 2},
+		{secret: []byte("secret"), n: 3, k: 1},
+		{secret: []byte("secret"), n: 3, k: 4},
+	}
+	for i, c := range cases {
+		if _, err := Split(c.secret, c.n, c.k); !errors.Is(err, ErrParams) {
+			t.Errorf("failed case=%d, error=%v", i, err)
+		}
+	}
+}
+
+func TestCombineError(t *testing.T) {
+	shares, err := Split([]byte("secret"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := [][][]byte{
+		nil,
+		shares[:1],
+		{shares[0], shares[0]},
+		{shares[0], shares[1][:4]},
+		{{1}, {2}},
+		{shares[0], append(shares[1][:6:6], 0)},
+	}
+	for i, c := range cases {
+		if _, err = Combine(c); !errors.Is(err, ErrShares) {
+			t.Errorf("failed case=%d, error=%v", i, err)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package stream
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+)
+
+// Sealer encrypts segments of VersionGCM stream one by one,
+// so encryption of a stream can be continued later, for example, after a restart.
+type Sealer struct {
+	aead   cipher.AEAD
+	header []byte
+}
+
+// NewSealer returns a new Sealer by a key for a stream with the header.
+// A new random header is generated if it's nil.
+func NewSealer(key, header []byte) (*Sealer, error) {
+	if header == nil {
+		header = make([]byte, HeaderSize)
+		copy(header, magic)
+		header[len(magic)] = VersionGCM
+		if _, err := io.ReadFull(rand.Reader, header[len(magic)+1:]); err != nil {
+			return nil, fmt.Errorf("nonce prefix: %w", err)
+		}
+	}
+	if err := CheckHeader(header); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key, header)
+	if err != nil {
+		return nil, err
+	}
+	return &Sealer{aead: aead, header: header}, nil
+}
+
+// Header returns the stream header, it must be written before the first segment.
+func (s *Sealer) Header() []byte {
+	return s.header
+}
+
+// Seal appends encrypted segment number i to dst and returns the updated slice.
+// All segments except the last one must have SegmentSize bytes.
+func (s *Sealer) Seal(dst, plain []byte, i uint32, last bool) []byte {
+	return s.aead.Seal(dst, segmentNonce(s.header, i, last), plain, s.header)
+}
@@ -0,0 +1,42 @@
+package stream
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSealer(t *testing.T) {
+	key := buildKey([]byte("abc"))
+	plainText := bytes.Repeat([]byte{'a', 'b', 'c'}, SegmentSize)
+
+	sealer, err := NewSealer(key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := append([]byte(nil), sealer.Header()...)
+	// continue encryption with a new sealer for the same header
+	sealer, err = NewSealer(key, encrypted[:HeaderSize])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var i uint32
+	for p := plainText; len(p) > 0; i++ {
+		n := SegmentSize
+		if n > len(p) {
+			n = len(p)
+		}
+		encrypted = sealer.Seal(encrypted, p[:n], i, n == len(p))
+		p = p[n:]
+	}
+	var dst bytes.Buffer
+	if err = Decrypt(bytes.NewReader(encrypted), &dst, key); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dst.Bytes(), plainText) {
+		t.Error("failed decrypted value")
+	}
+	if _, err = NewSealer(key, []byte("short")); !errors.Is(err, ErrVersion) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -1,33 +1,431 @@
 package stream
 
 // Package stream contains method to encrypt/decrypt io streams.
+//
+// The current format (VersionGCM) is a chunked AES-256-GCM stream:
+//
+//	header  = magic (4 bytes) | version (1 byte) | nonce prefix (7 bytes)
+//	segment = AES-GCM(plaintext chunk of SegmentSize bytes, the last one can be shorter)
+//	nonce   = nonce prefix (7 bytes) | segment counter (4 bytes, big endian) | last segment flag (1 byte)
+//
+// Every segment is authenticated with the header as associated data,
+// so truncation, reordering and modification are detected before a segment plaintext is released.
+// Streams without the header are decrypted as the legacy AES-OFB format (VersionOFB).
 
 import (
+	"bufio"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// VersionOFB is the legacy not authenticated AES-OFB format without a header.
+	VersionOFB byte = 1
+	// VersionGCM is the chunked AES-256-GCM format.
+	VersionGCM byte = 2
+
+	// SegmentSize is a max size of plaintext segment.
+	SegmentSize = 64 << 10
+	// Overhead is a size of authentication tag of every segment.
+	Overhead = 16
+	// HeaderSize is a size of stream header.
+	HeaderSize = len(magic) + 1 + noncePrefixSize
+
+	// noncePrefixSize is a size of random nonce prefix.
+	noncePrefixSize = 7
+	// nonceSize is a size of AES-GCM nonce.
+	nonceSize = noncePrefixSize + 4 + 1
+	// keyInfo is HKDF info to derive segments key.
+	keyInfo = "ssf stream"
+	// magic is a signature of stream header.
+	magic = "\x89SSF"
+)
+
+var (
+	// ErrAuth is an error when stream data is modified, truncated or reordered.
+	ErrAuth = errors.New("stream authentication failed")
+
+	// ErrVersion is an error when stream version is unknown.
+	ErrVersion = errors.New("unknown stream version")
 )
 
+// newAEAD returns AES-GCM cipher with a key derived from the key and stream header.
+func newAEAD(key, header []byte) (cipher.AEAD, error) {
+	segmentKey := make([]byte, len(key))
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, header, []byte(keyInfo)), segmentKey); err != nil {
+		return nil, fmt.Errorf("segment key: %w", err)
+	}
+	block, err := aes.NewCipher(segmentKey)
+	if err != nil {
+		return nil, fmt.Errorf("segment cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// segmentNonce returns a nonce of segment with number counter.
+func segmentNonce(header []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, nonceSize)
+	copy(nonce, header[HeaderSize-noncePrefixSize:])
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], counter)
+	if last {
+		nonce[nonceSize-1] = 1
+	}
+	return nonce
+}
+
+// writer encrypts data by segments, the last segment is written by Close.
+type writer struct {
+	dst     io.Writer
+	aead    cipher.AEAD
+	header  []byte
+	counter uint32
+	buf     []byte
+	out     []byte
+	closed  bool
+}
+
+// NewWriter returns a writer which encrypts data to dst by a key.
+// The header is written immediately, and Close must be called to write the last segment.
+func NewWriter(dst io.Writer, key []byte) (io.WriteCloser, error) {
+	sealer, err := NewSealer(key, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = dst.Write(sealer.header); err != nil {
+		return nil, fmt.Errorf("write header: %w", err)
+	}
+	w := &writer{
+		dst:    dst,
+		aead:   sealer.aead,
+		header: sealer.header,
+		buf:    make([]byte, 0, SegmentSize),
+		out:    make([]byte, 0, SegmentSize+Overhead),
+	}
+	return w, nil
+}
+
+// Write encrypts p. A full segment is written only when the next data is received,
+// because the last segment has to be marked.
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to closed stream")
+	}
+	var n int
+	for len(p) > 0 {
+		if len(w.buf) == SegmentSize {
+			if err := w.flush(false); err != nil {
+				return n, err
+			}
+		}
+		k := SegmentSize - len(w.buf)
+		if k > len(p) {
+			k = len(p)
+		}
+		w.buf = append(w.buf, p[:k]...)
+		p = p[k:]
+		n += k
+	}
+	return n, nil
+}
+
+// Close writes the last segment.
+func (w *writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.flush(true)
+}
+
+// flush encrypts and writes the buffered segment.
+func (w *writer) flush(last bool) error {
+	if w.counter == math.MaxUint32 {
+		return errors.New("stream segments counter overflow")
+	}
+	w.out = w.aead.Seal(w.out[:0], segmentNonce(w.header, w.counter, last), w.buf, w.header)
+	if _, err := w.dst.Write(w.out); err != nil {
+		return fmt.Errorf("write segment: %w", err)
+	}
+	w.buf = w.buf[:0]
+	w.counter++
+	return nil
+}
+
+// reader decrypts data by segments.
+type reader struct {
+	src     *bufio.Reader
+	aead    cipher.AEAD
+	header  []byte
+	counter uint32
+	buf     []byte
+	plain   []byte
+	done    bool
+}
+
+// NewReader returns a reader which decrypts VersionGCM stream from src by a key.
+// Every segment plaintext is returned only after its authentication.
+func NewReader(src io.Reader, key []byte) (io.Reader, error) {
+	header := make([]byte, HeaderSize)
+	if _, err := io.ReadFull(src, header); err != nil {
+		return nil, fmt.Errorf("read header: %w", ErrAuth)
+	}
+	if string(header[:len(magic)]) != magic {
+		return nil, fmt.Errorf("header signature: %w", ErrVersion)
+	}
+	if v := header[len(magic)]; v != VersionGCM {
+		return nil, fmt.Errorf("version %d: %w", v, ErrVersion)
+	}
+	aead, err := newAEAD(key, header)
+	if err != nil {
+		return nil, err
+	}
+	r := &reader{
+		src:    bufio.NewReaderSize(src, SegmentSize+Overhead),
+		aead:   aead,
+		header: header,
+		buf:    make([]byte, SegmentSize+Overhead),
+	}
+	return r, nil
+}
+
+// Read reads decrypted data.
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// next reads and decrypts a segment.
+func (r *reader) next() error {
+	var last bool
+	n, err := io.ReadFull(r.src, r.buf)
+	switch {
+	case err == io.EOF:
+		// no more segments, but the last one was not found
+		return fmt.Errorf("segment %d is missing: %w", r.counter, ErrAuth)
+	case err == io.ErrUnexpectedEOF:
+		last = true
+	case err != nil:
+		return fmt.Errorf("read segment: %w", err)
+	default:
+		_, err = r.src.Peek(1)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("read segment: %w", err)
+		}
+		last = err == io.EOF
+	}
+	nonce := segmentNonce(r.header, r.counter, last)
+	r.plain, err = r.aead.Open(r.buf[:0], nonce, r.buf[:n], r.header)
+	if err != nil {
+		return fmt.Errorf("segment %d: %w", r.counter, ErrAuth)
+	}
+	if !last && r.counter == math.MaxUint32 {
+		return errors.New("stream segments counter overflow")
+	}
+	r.counter++
+	r.done = last
+	return nil
+}
+
+// EncryptedSize returns a size of VersionGCM stream for plaintext with the size.
+func EncryptedSize(size int64) int64 {
+	segments := (size + SegmentSize - 1) / SegmentSize
+	if segments == 0 {
+		segments = 1
+	}
+	return int64(HeaderSize) + size + segments*Overhead
+}
+
+// CheckHeader returns an error if the header is not a valid VersionGCM stream header.
+func CheckHeader(header []byte) error {
+	if len(header) != HeaderSize || string(header[:len(magic)]) != magic {
+		return fmt.Errorf("header signature: %w", ErrVersion)
+	}
+	if v := header[len(magic)]; v != VersionGCM {
+		return fmt.Errorf("version %d: %w", v, ErrVersion)
+	}
+	return nil
+}
+
+// PlainSize returns plaintext size of VersionGCM stream with the size.
+func PlainSize(size int64) (int64, error) {
+	segments, err := segmentCount(size)
+	if err != nil {
+		return 0, err
+	}
+	return size - int64(HeaderSize) - segments*Overhead, nil
+}
+
+// segmentCount returns a number of segments of VersionGCM stream with the size.
+func segmentCount(size int64) (int64, error) {
+	const sealedSize = SegmentSize + Overhead
+	body := size - int64(HeaderSize)
+	if body < Overhead {
+		return 0, fmt.Errorf("stream size %d: %w", size, ErrAuth)
+	}
+	segments := (body + sealedSize - 1) / sealedSize
+	if last := body - (segments-1)*sealedSize; last < Overhead {
+		return 0, fmt.Errorf("stream size %d: %w", size, ErrAuth)
+	}
+	return segments, nil
+}
+
+// DecryptRange decrypts n bytes from the offset off of src stream with the size to the dst by a key.
+// VersionGCM streams are decrypted only by required segments, each of them is authenticated.
+// Legacy streams are decrypted from the beginning.
+func DecryptRange(src io.ReaderAt, size int64, dst io.Writer, key []byte, off, n int64) error {
+	if off < 0 || n < 0 {
+		return fmt.Errorf("invalid range offset=%d, length=%d", off, n)
+	}
+	prefix := make([]byte, len(magic))
+	if _, err := src.ReadAt(prefix, 0); err != nil && err != io.EOF {
+		return fmt.Errorf("read header: %w", err)
+	}
+	if string(prefix) != magic {
+		w := &rangeWriter{w: dst, skip: off, n: n}
+		err := decryptOFB(io.NewSectionReader(src, 0, size), w, key)
+		if err != nil && !errors.Is(err, errRangeDone) {
+			return err
+		}
+		return nil
+	}
+	segments, err := segmentCount(size)
+	if err != nil {
+		return err
+	}
+	if plainSize := size - int64(HeaderSize) - segments*Overhead; off+n > plainSize {
+		return fmt.Errorf("range [%d, %d) is out of size %d", off, off+n, plainSize)
+	}
+	if n == 0 {
+		return nil
+	}
+	header := make([]byte, HeaderSize)
+	if _, err = src.ReadAt(header, 0); err != nil {
+		return fmt.Errorf("read header: %w", err)
+	}
+	if v := header[len(magic)]; v != VersionGCM {
+		return fmt.Errorf("version %d: %w", v, ErrVersion)
+	}
+	aead, err := newAEAD(key, header)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, SegmentSize+Overhead)
+	for i := off / SegmentSize; n > 0; i++ {
+		start := int64(HeaderSize) + i*(SegmentSize+Overhead)
+		sealed, last := buf, i == segments-1
+		if last {
+			sealed = buf[:size-start]
+		}
+		if _, err = src.ReadAt(sealed, start); err != nil {
+			return fmt.Errorf("read segment: %w", err)
+		}
+		plain, err := aead.Open(sealed[:0], segmentNonce(header, uint32(i), last), sealed, header)
+		if err != nil {
+			return fmt.Errorf("segment %d: %w", i, ErrAuth)
+		}
+		// the first segment can be partial
+		plain = plain[off-i*SegmentSize:]
+		if int64(len(plain)) > n {
+			plain = plain[:n]
+		}
+		if _, err = dst.Write(plain); err != nil {
+			return fmt.Errorf("write range: %w", err)
+		}
+		off += int64(len(plain))
+		n -= int64(len(plain))
+	}
+	return nil
+}
+
+// errRangeDone is an internal error to stop legacy stream decryption after required range.
+var errRangeDone = errors.New("range is done")
+
+// rangeWriter writes n bytes to w after skip bytes.
+type rangeWriter struct {
+	w    io.Writer
+	skip int64
+	n    int64
+}
+
+// Write writes required part of p.
+func (rw *rangeWriter) Write(p []byte) (int, error) {
+	size := len(p)
+	if rw.skip > 0 {
+		if int64(len(p)) <= rw.skip {
+			rw.skip -= int64(len(p))
+			return size, nil
+		}
+		p = p[rw.skip:]
+		rw.skip = 0
+	}
+	if int64(len(p)) > rw.n {
+		p = p[:rw.n]
+	}
+	if _, err := rw.w.Write(p); err != nil {
+		return 0, err
+	}
+	rw.n -= int64(len(p))
+	if rw.n == 0 {
+		return size, errRangeDone
+	}
+	return size, nil
+}
+
 // Encrypt encrypts content from src-reader to the dst by a key.
 func Encrypt(src io.Reader, dst io.Writer, key []byte) error {
-	block, err := aes.NewCipher(key)
+	w, err := NewWriter(dst, key)
 	if err != nil {
-		return fmt.Errorf("ecrypt cipher: %w", err)
+		return fmt.Errorf("ecrypt stream: %w", err)
 	}
-	// the key is unique for each cipher-text, then it's ok to use a zero IV.
-	var iv [aes.BlockSize]byte
-	stream := cipher.NewOFB(block, iv[:])
-
-	writer := &cipher.StreamWriter{S: stream, W: dst}
-	if _, err = io.Copy(writer, src); err != nil {
+	if _, err = io.Copy(w, src); err != nil {
 		return fmt.Errorf("copy for ecryption: %w", err)
 	}
+	if err = w.Close(); err != nil {
+		return fmt.Errorf("close ecrypt stream: %w", err)
+	}
 	return nil
 }
 
 // Decrypt decrypts content of src to the dst by a key.
+// A stream version is detected by its header.
 func Decrypt(src io.Reader, dst io.Writer, key []byte) error {
+	buffered := bufio.NewReader(src)
+	prefix, err := buffered.Peek(len(magic))
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("read header: %w", err)
+	}
+	if string(prefix) != magic {
+		return decryptOFB(buffered, dst, key)
+	}
+	reader, err := NewReader(buffered, key)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(dst, reader); err != nil {
+		return fmt.Errorf("copy for decryption: %w", err)
+	}
+	return nil
+}
+
+// decryptOFB decrypts content of legacy VersionOFB stream src to the dst by a key.
+func decryptOFB(src io.Reader, dst io.Writer, key []byte) error {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return fmt.Errorf("decrypt cipher: %w", err)
@@ -38,7 +436,7 @@ func Decrypt(src io.Reader, dst io.Writer, key []byte) error {
 
 	reader := &cipher.StreamReader{S: stream, R: src}
 	if _, err = io.Copy(dst, reader); err != nil {
-		return fmt.Errorf("copy for decryption: %dst", err)
+		return fmt.Errorf("copy for decryption: %w", err)
 	}
 	return nil
 }
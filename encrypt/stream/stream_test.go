@@ -2,6 +2,9 @@ package stream
 
 import (
 	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
 	"io"
 	"testing"
 )
@@ -35,10 +38,7 @@ func TestEncrypt(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	encrypted, err := dst.ReadString('\n')
-	if err != nil && err != io.EOF {
-		t.Error(err)
-	}
+	encrypted := dst.String()
 	if encrypted == secret {
 		t.Errorf("failed encrypted value=%s", encrypted)
 	}
@@ -62,6 +62,254 @@ func TestEncrypt(t *testing.T) {
 	}
 }
 
+func encrypt(t *testing.T, plainText, key []byte) []byte {
+	var dst bytes.Buffer
+	if err := Encrypt(bytes.NewReader(plainText), &dst, key); err != nil {
+		t.Fatal(err)
+	}
+	return dst.Bytes()
+}
+
+func segment(i int) (int, int) {
+	start := HeaderSize + i*(SegmentSize+Overhead)
+	return start, start + SegmentSize + Overhead
+}
+
+func TestEncryptSizes(t *testing.T) {
+	key := buildKey([]byte("abc"))
+	cases := []int{0, 1, SegmentSize - 1, SegmentSize, SegmentSize + 1, 3 * SegmentSize, 3*SegmentSize + 7}
+	for i, c := range cases {
+		plainText := bytes.Repeat([]byte{'a'}, c)
+		encrypted := encrypt(t, plainText, key)
+		segments := c/SegmentSize + 1
+		if c > 0 && c%SegmentSize == 0 {
+			segments--
+		}
+		if n, expected := len(encrypted), HeaderSize+c+segments*Overhead; n != expected {
+			t.Errorf("failed encrypted length=%d for case=%d, expected %d", n, i, expected)
+		}
+		if n := EncryptedSize(int64(c)); n != int64(len(encrypted)) {
+			t.Errorf("failed encrypted size=%d for case=%d", n, i)
+		}
+		if err := CheckHeader(encrypted[:HeaderSize]); err != nil {
+			t.Errorf("failed header for case=%d: %v", i, err)
+		}
+		var dst bytes.Buffer
+		if err := Decrypt(bytes.NewReader(encrypted), &dst, key); err != nil {
+			t.Errorf("failed decrypt case=%d: %v", i, err)
+			continue
+		}
+		if !bytes.Equal(dst.Bytes(), plainText) {
+			t.Errorf("failed decrypted value for case=%d", i)
+		}
+	}
+}
+
+func TestDecryptLegacy(t *testing.T) {
+	const secret = "secret stream content"
+	key := buildKey([]byte("abc"))
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var iv [aes.BlockSize]byte
+	encrypted := make([]byte, len(secret))
+	cipher.NewOFB(block, iv[:]).XORKeyStream(encrypted, []byte(secret))
+
+	var dst bytes.Buffer
+	if err = Decrypt(bytes.NewReader(encrypted), &dst, key); err != nil {
+		t.Fatal(err)
+	}
+	if s := dst.String(); s != secret {
+		t.Errorf("failed decrypted value=%s", s)
+	}
+}
+
+func TestDecryptRange(t *testing.T) {
+	key := buildKey([]byte("abc"))
+	plainText := make([]byte, 3*SegmentSize+100)
+	for i := range plainText {
+		plainText[i] = byte(i % 251)
+	}
+	encrypted := encrypt(t, plainText, key)
+	size := int64(len(encrypted))
+	if n, err := PlainSize(size); err != nil || n != int64(len(plainText)) {
+		t.Fatalf("failed plain size=%d: %v", n, err)
+	}
+	cases := []struct {
+		name   string
+		off, n int
+	}{
+		{name: "empty", off: 10, n: 0},
+		{name: "first byte", off: 0, n: 1},
+		{name: "inside segment", off: 100, n: 1000},
+		{name: "segment", off: SegmentSize, n: SegmentSize},
+		{name: "cross segments", off: SegmentSize - 10, n: SegmentSize + 20},
+		{name: "last byte", off: len(plainText) - 1, n: 1},
+		{name: "tail", off: 2*SegmentSize + 5, n: SegmentSize + 95},
+		{name: "all", off: 0, n: len(plainText)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			var dst bytes.Buffer
+			err := DecryptRange(bytes.NewReader(encrypted), size, &dst, key, int64(c.off), int64(c.n))
+			if err != nil {
+				tt.Fatal(err)
+			}
+			if !bytes.Equal(dst.Bytes(), plainText[c.off:c.off+c.n]) {
+				tt.Error("failed decrypted range")
+			}
+		})
+	}
+	var dst bytes.Buffer
+	if err := DecryptRange(bytes.NewReader(encrypted), size, &dst, key, int64(len(plainText)), 1); err == nil {
+		t.Error("expected error for range out of size")
+	}
+	// the modified segment is not used
+	tampered := append([]byte(nil), encrypted...)
+	start, _ := segment(2)
+	tampered[start+1] ^= 1
+	if err := DecryptRange(bytes.NewReader(tampered), size, &dst, key, 10, SegmentSize); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	dst.Reset()
+	err := DecryptRange(bytes.NewReader(tampered), size, &dst, key, 2*SegmentSize-10, 20)
+	if !errors.Is(err, ErrAuth) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if n := dst.Len(); n != 10 {
+		t.Errorf("failed written length=%d", n)
+	}
+	if _, err = PlainSize(int64(HeaderSize + Overhead - 1)); !errors.Is(err, ErrAuth) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDecryptRangeLegacy(t *testing.T) {
+	const secret = "secret stream content"
+	key := buildKey([]byte("abc"))
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var iv [aes.BlockSize]byte
+	encrypted := make([]byte, len(secret))
+	cipher.NewOFB(block, iv[:]).XORKeyStream(encrypted, []byte(secret))
+
+	var dst bytes.Buffer
+	if err = DecryptRange(bytes.NewReader(encrypted), int64(len(encrypted)), &dst, key, 7, 6); err != nil {
+		t.Fatal(err)
+	}
+	if s := dst.String(); s != secret[7:13] {
+		t.Errorf("failed decrypted value=%s", s)
+	}
+}
+
+func TestDecryptTampered(t *testing.T) {
+	key := buildKey([]byte("abc"))
+	plainText := make([]byte, 3*SegmentSize+100)
+	for i := range plainText {
+		plainText[i] = byte(i)
+	}
+	encrypted := encrypt(t, plainText, key)
+
+	s1, e1 := segment(1)
+	s2, e2 := segment(2)
+	cases := []struct {
+		name     string
+		value    func() []byte
+		released int
+	}{
+		{
+			name: "bit flip",
+			value: func() []byte {
+				v := append([]byte{}, encrypted...)
+				v[s1+10] ^= 1
+				return v
+			},
+			released: SegmentSize,
+		},
+		{
+			name: "header flip",
+			value: func() []byte {
+				v := append([]byte{}, encrypted...)
+				v[HeaderSize-1] ^= 1
+				return v
+			},
+		},
+		{
+			name: "truncation at segment boundary",
+			value: func() []byte {
+				return append([]byte{}, encrypted[:e2]...)
+			},
+			released: 2 * SegmentSize,
+		},
+		{
+			name: "truncation inside segment",
+			value: func() []byte {
+				return append([]byte{}, encrypted[:e2-1]...)
+			},
+			released: 2 * SegmentSize,
+		},
+		{
+			name: "header only",
+			value: func() []byte {
+				return append([]byte{}, encrypted[:HeaderSize]...)
+			},
+		},
+		{
+			name: "reordering",
+			value: func() []byte {
+				v := append([]byte{}, encrypted[:s1]...)
+				v = append(v, encrypted[s2:e2]...)
+				v = append(v, encrypted[s1:e1]...)
+				return append(v, encrypted[e2:]...)
+			},
+			released: SegmentSize,
+		},
+		{
+			name: "appended data",
+			value: func() []byte {
+				return append(append([]byte{}, encrypted...), 0)
+			},
+			released: 3 * SegmentSize,
+		},
+		{
+			name: "unknown version",
+			value: func() []byte {
+				v := append([]byte{}, encrypted...)
+				v[len(magic)] = 0xFF
+				return v
+			},
+		},
+	}
+	for _, c := range cases {
+		var dst bytes.Buffer
+		err := Decrypt(bytes.NewReader(c.value()), &dst, key)
+		if !errors.Is(err, ErrAuth) && !errors.Is(err, ErrVersion) {
+			t.Errorf("unexpected error for case %q: %v", c.name, err)
+		}
+		if n := dst.Len(); n != c.released {
+			t.Errorf("failed released length=%d for case %q, expected %d", n, c.name, c.released)
+		}
+		if !bytes.Equal(dst.Bytes(), plainText[:dst.Len()]) {
+			t.Errorf("not authenticated data is released for case %q", c.name)
+		}
+	}
+}
+
+func TestDecryptWrongKey(t *testing.T) {
+	encrypted := encrypt(t, []byte("secret"), buildKey([]byte("abc")))
+	var dst bytes.Buffer
+	err := Decrypt(bytes.NewReader(encrypted), &dst, buildKey([]byte("abd")))
+	if !errors.Is(err, ErrAuth) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if n := dst.Len(); n != 0 {
+		t.Errorf("failed released length=%d", n)
+	}
+}
+
 func BenchmarkEncrypt(b *testing.B) {
 	const secret = "secret stream content"
 	var (
@@ -78,7 +326,7 @@ func BenchmarkEncrypt(b *testing.B) {
 		if err != nil {
 			b.Fatal(err)
 		}
-		value, _ = dst.ReadString('\n')
+		value = dst.String()
 		// decrypt
 		src.Reset()
 		dst.Reset()
@@ -90,7 +338,8 @@ func BenchmarkEncrypt(b *testing.B) {
 		if err != nil {
 			b.Fatal(err)
 		}
-		value, _ = dst.ReadString('\n')
+		value = dst.String()
+		dst.Reset()
 		if value != secret {
 			b.Errorf("failed decrypted value=%s", value)
 		}
@@ -1,6 +1,12 @@
 package text
 
 // Package text contains method to encrypt/decrypt text messages.
+//
+// Sealed messages are authenticated by AES-GCM with associated data:
+//
+//	message = magic (4 bytes) | version (1 byte) | nonce (12 bytes) | cipher text with tag
+//
+// Messages without the prefix are legacy not authenticated AES-CFB ones.
 
 import (
 	"crypto/aes"
@@ -11,10 +17,89 @@ import (
 	"io"
 )
 
-// ErrEmpty is an error, when encrypted/decrypted text is empty.
-var ErrEmpty = errors.New("empty text")
+const (
+	// VersionCFB is the legacy not authenticated AES-CFB format without a prefix.
+	VersionCFB byte = 1
+	// VersionGCM is the authenticated AES-GCM format.
+	VersionGCM byte = 2
 
-// Encrypt encrypts text using AES cipher by a key.
+	// magic is a signature of sealed message.
+	magic = "\x89SSF"
+	// prefixSize is a size of sealed message prefix.
+	prefixSize = len(magic) + 1
+	// nonceSize is a size of AES-GCM nonce.
+	nonceSize = 12
+)
+
+var (
+	// ErrEmpty is an error, when encrypted/decrypted text is empty.
+	ErrEmpty = errors.New("empty text")
+
+	// ErrAuth is an error, when sealed message or its associated data are modified.
+	ErrAuth = errors.New("text authentication failed")
+)
+
+// IsSealed returns true if the cipherText is an authenticated message created by Seal.
+func IsSealed(cipherText []byte) bool {
+	return len(cipherText) > prefixSize && string(cipherText[:len(magic)]) == magic &&
+		cipherText[len(magic)] == VersionGCM
+}
+
+// newGCM returns AES-GCM cipher by a key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("new gcm cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal encrypts and authenticates text and associated data ad using AES-GCM cipher by a key.
+// The same associated data, for example an item ID, is required to open the message.
+func Seal(plainText, key, ad []byte) ([]byte, error) {
+	if len(plainText) == 0 {
+		return nil, ErrEmpty
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	cipherText := make([]byte, prefixSize+nonceSize, prefixSize+nonceSize+len(plainText)+aead.Overhead())
+	copy(cipherText, magic)
+	cipherText[len(magic)] = VersionGCM
+
+	nonce := cipherText[prefixSize:]
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("nonce random generation: %w", err)
+	}
+	return aead.Seal(cipherText, nonce, plainText, ad), nil
+}
+
+// Open returns decrypted and authenticated value of the message created by Seal.
+func Open(cipherText, key, ad []byte) ([]byte, error) {
+	if len(cipherText) == 0 {
+		return nil, ErrEmpty
+	}
+	if !IsSealed(cipherText) {
+		return nil, fmt.Errorf("unknown message format: %w", ErrAuth)
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(cipherText) < prefixSize+nonceSize+aead.Overhead() {
+		return nil, fmt.Errorf("short message: %w", ErrAuth)
+	}
+	nonce := cipherText[prefixSize : prefixSize+nonceSize]
+	plainText, err := aead.Open(nil, nonce, cipherText[prefixSize+nonceSize:], ad)
+	if err != nil {
+		return nil, ErrAuth
+	}
+	return plainText, nil
+}
+
+// Encrypt encrypts text using AES-CFB cipher by a key.
+// It's the legacy format, use Seal for new messages.
 func Encrypt(plainText []byte, key []byte) ([]byte, error) {
 	if len(plainText) == 0 {
 		return nil, ErrEmpty
@@ -35,7 +120,7 @@ func Encrypt(plainText []byte, key []byte) ([]byte, error) {
 	return cipherText, nil
 }
 
-// Decrypt returns decrypted value from text by a key.
+// Decrypt returns decrypted value from the legacy AES-CFB text by a key.
 func Decrypt(cipherText []byte, key []byte) ([]byte, error) {
 	if len(cipherText) == 0 {
 		return nil, ErrEmpty
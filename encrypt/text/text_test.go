@@ -3,6 +3,7 @@ package text
 import (
 	"bytes"
 	"crypto/aes"
+	"errors"
 	"testing"
 )
 
@@ -72,6 +73,58 @@ func TestDecrypt(t *testing.T) {
 	}
 }
 
+func TestSeal(t *testing.T) {
+	key := buildKey([]byte("abc"))
+	ad := []byte("id")
+	cases := []string{
+		"text",
+		"other text",
+		"other long long text",
+	}
+	for i, c := range cases {
+		cb := []byte(c)
+		e, err := Seal(cb, key, ad)
+		if err != nil {
+			t.Errorf("failed seal case=%d: %v", i, err)
+			continue
+		}
+		if !IsSealed(e) {
+			t.Errorf("not sealed case=%d", i)
+		}
+		if n := len(e); n != prefixSize+nonceSize+len(cb)+16 {
+			t.Errorf("unexpected length=%d for case=%d", n, i)
+		}
+		d, err := Open(e, key, ad)
+		if err != nil {
+			t.Errorf("failed open case=%d: %v", i, err)
+		}
+		if !bytes.Equal(d, cb) {
+			t.Errorf("failed compare open case=%d", i)
+		}
+		if _, err = Open(e, key, []byte("other")); !errors.Is(err, ErrAuth) {
+			t.Errorf("unexpected error for other ad case=%d: %v", i, err)
+		}
+		e[len(e)-1] ^= 1
+		if _, err = Open(e, key, ad); !errors.Is(err, ErrAuth) {
+			t.Errorf("unexpected error for modified case=%d: %v", i, err)
+		}
+	}
+	if _, err := Seal(nil, key, ad); !errors.Is(err, ErrEmpty) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestOpenLegacy(t *testing.T) {
+	key := buildKey([]byte("abc"))
+	e, err := Encrypt([]byte("text"), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Open(e, key, nil); !errors.Is(err, ErrAuth) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
 func BenchmarkEncrypt(b *testing.B) {
 	key := buildKey([]byte("abc"))
 	msg := []byte("some secret text")
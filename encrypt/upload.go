@@ -0,0 +1,339 @@
+package encrypt
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/z0rr0/ssf/encrypt/stream"
+	"github.com/z0rr0/ssf/encrypt/text"
+)
+
+const (
+	// sniffLen is a number of the first plaintext bytes to detect content type.
+	sniffLen = 512
+	// tailAD is associated data suffix of encrypted upload tail.
+	tailAD = "\x00tail\x00"
+	// metaAD is associated data suffix of encrypted upload meta data.
+	metaAD = "\x00meta"
+)
+
+var (
+	// ErrUploadSize is an error when more data than expected upload size are received.
+	ErrUploadSize = errors.New("upload size is exceeded")
+
+	// ErrUploadState is an error when upload is not completed or its state doesn't match the file.
+	ErrUploadState = errors.New("invalid upload state")
+)
+
+// Upload is a state of resumable file encryption, plaintext is received by parts.
+// Only full stream segments are written to the file Msg.Value,
+// the rest of received plaintext is kept encrypted in Tail until the next part.
+// Meta data are encrypted by the file key, they are completed by Finish.
+// A segment nonce depends only on its number, so written segments are never sealed again:
+// if the file has segments after the stored state, the upload can't be continued.
+type Upload struct {
+	Msg    Msg
+	Meta   string
+	Tail   string
+	Size   int64
+	Offset int64
+}
+
+// NewUpload creates a new file for resumable encryption with expected plaintext size
+// and returns its state. The file contains only stream header, opts.Compression is not used.
+func NewUpload(secret, base string, size int64, meta *Meta, opts *Options) (*Upload, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("upload size %d: %w", size, ErrUploadSize)
+	}
+	m, key, err := newMsg(secret, opts)
+	if err != nil {
+		return nil, err
+	}
+	sealer, err := stream.NewSealer(key, nil)
+	if err != nil {
+		return nil, err
+	}
+	b, err := marshalMeta(meta)
+	if err != nil {
+		return nil, err
+	}
+	sealedMeta, err := text.Seal(b, key, uploadAD(opts, metaAD))
+	if err != nil {
+		return nil, err
+	}
+	dst, err := createFile(base, "")
+	if err != nil {
+		return nil, fmt.Errorf("open file for ecryption: %w", err)
+	}
+	if _, err = dst.Write(sealer.Header()); err != nil {
+		return nil, removeFile(dst, fmt.Errorf("write header: %w", err))
+	}
+	u := &Upload{
+		Msg:  *m,
+		Meta: hex.EncodeToString(sealedMeta),
+		Size: size,
+	}
+	u.Msg.Value, u.Msg.Cipher = dst.Name(), CipherStream
+	u.Msg.setTag(key)
+	u.Msg.encode(false)
+	return u, dst.Close()
+}
+
+// Write encrypts data from src to the upload file using the secret until EOF or the expected size.
+// The offset is a position of src data, it must be equal to the stored state offset.
+// The state is updated by received data even if src returns an error, so the upload can be continued.
+// It returns a number of received bytes and ErrUploadSize if src has more data than expected.
+func (u *Upload) Write(secret string, offset int64, src io.Reader, opts *Options) (int64, error) {
+	if offset != u.Offset {
+		return 0, fmt.Errorf("offset %d is not upload offset %d: %w", offset, u.Offset, ErrUploadState)
+	}
+	key, err := u.key(secret, opts)
+	if err != nil {
+		return 0, err
+	}
+	tail, err := u.openTail(key, opts)
+	if err != nil {
+		return 0, err
+	}
+	f, sealer, segment, _, err := u.open(key, len(tail))
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	var (
+		buf       = make([]byte, stream.SegmentSize)
+		k         = copy(buf, tail)
+		remaining = u.Size - u.Offset
+		received  int64
+		readErr   error
+	)
+	for received < remaining {
+		n := len(buf) - k
+		if r := remaining - received; r < int64(n) {
+			n = int(r)
+		}
+		n, readErr = io.ReadFull(src, buf[k:k+n])
+		k += n
+		received += int64(n)
+		if k == len(buf) && u.Offset+received < u.Size {
+			// a full segment is not the last one, it's written immediately
+			if _, err = f.Write(sealer.Seal(nil, buf, segment, false)); err != nil {
+				return 0, fmt.Errorf("write segment: %w", err)
+			}
+			segment++
+			k = 0
+		}
+		if readErr != nil {
+			if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+				readErr = nil
+			}
+			break
+		}
+	}
+	if readErr == nil && received == remaining {
+		// all expected data are received, src must be empty
+		if n, _ := io.ReadFull(src, make([]byte, 1)); n > 0 {
+			readErr = ErrUploadSize
+		}
+	}
+	if err = f.Sync(); err != nil {
+		return 0, fmt.Errorf("sync file: %w", err)
+	}
+	if err = u.sealTail(key, buf[:k], u.Offset+received, opts); err != nil {
+		return 0, err
+	}
+	u.Offset += received
+	if readErr != nil && readErr != ErrUploadSize {
+		readErr = fmt.Errorf("read upload data: %w", readErr)
+	}
+	return received, readErr
+}
+
+// Finish writes the last segment of completed upload and checks the whole file by the secret.
+// It returns the file message with data hash, like File does,
+// and meta data with size, check sum and content type detected by the first bytes if it's empty.
+func (u *Upload) Finish(secret string, opts *Options) (*Msg, *Meta, error) {
+	if u.Offset != u.Size {
+		return nil, nil, fmt.Errorf("received %d of %d bytes: %w", u.Offset, u.Size, ErrUploadState)
+	}
+	key, err := u.key(secret, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	meta, err := u.openMeta(key, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	tail, err := u.openTail(key, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, sealer, segment, finished, err := u.open(key, len(tail))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	if !finished {
+		if _, err = f.Write(sealer.Seal(nil, tail, segment, true)); err != nil {
+			return nil, nil, fmt.Errorf("write segment: %w", err)
+		}
+		if err = f.Sync(); err != nil {
+			return nil, nil, fmt.Errorf("sync file: %w", err)
+		}
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, fmt.Errorf("seek file: %w", err)
+	}
+	// the hash is calculated by decrypted data, so the file is checked too
+	head := &headWriter{}
+	signWriter := NewStreamSigner(nil, head)
+	if err = stream.Decrypt(f, signWriter, key); err != nil {
+		if errors.Is(err, stream.ErrAuth) {
+			return nil, nil, fmt.Errorf("%w: %v", ErrHash, err)
+		}
+		return nil, nil, err
+	}
+	dh, err := signWriter.WriterHashSum()
+	if err != nil {
+		return nil, nil, err
+	}
+	m := &Msg{
+		kdf: u.Msg.kdf, s: u.Msg.s, kh: u.Msg.kh, dh: dh, Value: u.Msg.Value, WrappedKey: u.Msg.WrappedKey, Cipher: CipherStream,
+	}
+	m.setTag(key)
+	m.encode(false)
+	meta.Size, meta.Sum = u.Size, m.DataHash
+	if meta.Type == "" {
+		meta.Type = http.DetectContentType(head.buf)
+	}
+	return m, meta, nil
+}
+
+// key returns the file key if the secret is correct.
+func (u *Upload) key(secret string, opts *Options) ([]byte, error) {
+	if err := u.Msg.decode(false); err != nil {
+		return nil, err
+	}
+	return u.Msg.key(secret, opts)
+}
+
+// open opens the upload file after its written segments.
+// It returns the file, its sealer, a number of the next segment
+// and true if the last segment is already written by Finish.
+// The file must not have other data after the stored state, they could be sealed by the same nonces.
+func (u *Upload) open(key []byte, tailSize int) (*os.File, *stream.Sealer, uint32, bool, error) {
+	sealed := u.Offset - int64(tailSize)
+	if sealed < 0 || sealed%stream.SegmentSize != 0 {
+		return nil, nil, 0, false, fmt.Errorf("offset %d, tail %d: %w", u.Offset, tailSize, ErrUploadState)
+	}
+	segment := sealed / stream.SegmentSize
+	f, err := os.OpenFile(u.Msg.Value, os.O_RDWR, 0600)
+	if err != nil {
+		return nil, nil, 0, false, fmt.Errorf("open file for encryption: %w", err)
+	}
+	header := make([]byte, stream.HeaderSize)
+	if _, err = io.ReadFull(f, header); err != nil {
+		return nil, nil, 0, false, closeFile(f, fmt.Errorf("read header: %w", err))
+	}
+	sealer, err := stream.NewSealer(key, header)
+	if err != nil {
+		return nil, nil, 0, false, closeFile(f, err)
+	}
+	size := int64(stream.HeaderSize) + segment*(stream.SegmentSize+stream.Overhead)
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, 0, false, closeFile(f, fmt.Errorf("stat file: %w", err))
+	}
+	finished := u.Offset == u.Size && info.Size() == size+int64(tailSize+stream.Overhead)
+	if info.Size() != size && !finished {
+		return nil, nil, 0, false, closeFile(
+			f, fmt.Errorf("file size %d, expected %d: %w", info.Size(), size, ErrUploadState),
+		)
+	}
+	if _, err = f.Seek(size, io.SeekStart); err != nil {
+		return nil, nil, 0, false, closeFile(f, fmt.Errorf("seek file: %w", err))
+	}
+	return f, sealer, uint32(segment), finished, nil
+}
+
+// openTail returns decrypted not written plaintext.
+func (u *Upload) openTail(key []byte, opts *Options) ([]byte, error) {
+	if u.Tail == "" {
+		return nil, nil
+	}
+	b, err := hex.DecodeString(u.Tail)
+	if err != nil {
+		return nil, fmt.Errorf("hex decode tail: %w", err)
+	}
+	tail, err := text.Open(b, key, uploadAD(opts, tailAD+strconv.FormatInt(u.Offset, 10)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: tail %v", ErrHash, err)
+	}
+	return tail, nil
+}
+
+// sealTail encrypts not written plaintext for the upload offset.
+func (u *Upload) sealTail(key, tail []byte, offset int64, opts *Options) error {
+	if len(tail) == 0 {
+		u.Tail = ""
+		return nil
+	}
+	b, err := text.Seal(tail, key, uploadAD(opts, tailAD+strconv.FormatInt(offset, 10)))
+	if err != nil {
+		return err
+	}
+	u.Tail = hex.EncodeToString(b)
+	return nil
+}
+
+// openMeta returns decrypted upload meta data.
+func (u *Upload) openMeta(key []byte, opts *Options) (*Meta, error) {
+	b, err := hex.DecodeString(u.Meta)
+	if err != nil {
+		return nil, fmt.Errorf("hex decode meta: %w", err)
+	}
+	plainText, err := text.Open(b, key, uploadAD(opts, metaAD))
+	if err != nil {
+		return nil, fmt.Errorf("%w: meta %v", ErrHash, err)
+	}
+	return unmarshalMeta(plainText)
+}
+
+// uploadAD returns associated data for upload state parts.
+func uploadAD(opts *Options, suffix string) []byte {
+	ad := opts.ad()
+	return append(ad[:len(ad):len(ad)], suffix...)
+}
+
+// closeFile closes the file and returns err with possible close error.
+func closeFile(f *os.File, err error) error {
+	if e := f.Close(); e != nil {
+		return fmt.Errorf("%v, close file: %w", err, e)
+	}
+	return err
+}
+
+// headWriter keeps the first sniffLen written bytes.
+type headWriter struct {
+	buf []byte
+}
+
+// Write saves the beginning of p if the head is not full yet.
+func (w *headWriter) Write(p []byte) (int, error) {
+	if n := sniffLen - len(w.buf); n > 0 {
+		if n > len(p) {
+			n = len(p)
+		}
+		w.buf = append(w.buf, p[:n]...)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,186 @@
+package encrypt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/z0rr0/ssf/encrypt/stream"
+)
+
+// failReader returns data from r and then the error instead of EOF.
+type failReader struct {
+	r   io.Reader
+	err error
+}
+
+func (f *failReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err == io.EOF {
+		return n, f.err
+	}
+	return n, err
+}
+
+func TestUpload(t *testing.T) {
+	const secret = "secret"
+	opts := &Options{KDF: Argon2id{Time: 1, Memory: 1024, Threads: 1}, AD: []byte("id")}
+	sizes := []int{1, 100, stream.SegmentSize, 2*stream.SegmentSize + 100}
+	for _, size := range sizes {
+		plainText := make([]byte, size)
+		for i := range plainText {
+			plainText[i] = byte(i % 251)
+		}
+		u, err := NewUpload(secret, t.TempDir(), int64(size), &Meta{Name: "file.bin"}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the state is restored from strings as after a restart
+		state := Upload{Msg: Msg{Salt: u.Msg.Salt, Value: u.Msg.Value, KeyHash: u.Msg.KeyHash}, Meta: u.Meta, Size: u.Size}
+		for _, part := range [][]byte{plainText[:size/3], plainText[size/3 : size/2], plainText[size/2:]} {
+			restored := state
+			n, err := restored.Write(secret, restored.Offset, bytes.NewReader(part), opts)
+			if err != nil {
+				t.Fatalf("failed write size=%d: %v", size, err)
+			}
+			if n != int64(len(part)) {
+				t.Errorf("failed received size=%d", n)
+			}
+			state = Upload{Msg: state.Msg, Meta: state.Meta, Size: state.Size, Tail: restored.Tail, Offset: restored.Offset}
+		}
+		m, meta, err := state.Finish(secret, opts)
+		if err != nil {
+			t.Fatalf("failed finish size=%d: %v", size, err)
+		}
+		if meta.Name != "file.bin" || meta.Size != int64(size) || meta.Sum != m.DataHash || meta.Type != "application/octet-stream" {
+			t.Errorf("failed meta=%+v", meta)
+		}
+		// finish can be repeated
+		if _, _, err = state.Finish(secret, opts); err != nil {
+			t.Errorf("failed repeated finish size=%d: %v", size, err)
+		}
+		var dst bytes.Buffer
+		if err = DecryptFile(secret, m, &dst, &Options{Verify: true}); err != nil {
+			t.Fatalf("failed decrypt size=%d: %v", size, err)
+		}
+		if !bytes.Equal(dst.Bytes(), plainText) {
+			t.Errorf("failed decrypted value size=%d", size)
+		}
+	}
+}
+
+func TestUploadError(t *testing.T) {
+	const secret = "secret"
+	opts := &Options{KDF: Argon2id{Time: 1, Memory: 1024, Threads: 1}}
+	plainText := bytes.Repeat([]byte("some text"), stream.SegmentSize/4)
+	size := int64(len(plainText))
+
+	if _, err := NewUpload(secret, t.TempDir(), 0, nil, opts); !errors.Is(err, ErrUploadSize) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	u, err := NewUpload(secret, t.TempDir(), size, nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = u.Write("bad", 0, bytes.NewReader(plainText), opts); !errors.Is(err, ErrSecret) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, _, err = u.Finish(secret, opts); !errors.Is(err, ErrUploadState) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// received data are saved even if the reader is failed
+	src := &failReader{r: bytes.NewReader(plainText[:stream.SegmentSize+10]), err: errors.New("connection reset")}
+	n, err := u.Write(secret, 0, src, opts)
+	if err == nil {
+		t.Error("expected error")
+	}
+	if n != stream.SegmentSize+10 || u.Offset != n {
+		t.Errorf("failed received size=%d, offset=%d", n, u.Offset)
+	}
+	// extra data are not saved
+	n, err = u.Write(secret, u.Offset, bytes.NewReader(append(plainText[u.Offset:], 'x')), opts)
+	if !errors.Is(err, ErrUploadSize) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if u.Offset != size || n != size-stream.SegmentSize-10 {
+		t.Errorf("failed received size=%d, offset=%d", n, u.Offset)
+	}
+	m, meta, err := u.Finish(secret, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Type != "text/plain; charset=utf-8" {
+		t.Errorf("failed content type=%q", meta.Type)
+	}
+	var dst bytes.Buffer
+	if err = DecryptFile(secret, m, &dst, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dst.Bytes(), plainText) {
+		t.Error("failed decrypted value")
+	}
+	// modified tail is detected
+	tail, err := hex.DecodeString(u.Tail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tail[len(tail)-1] ^= 1
+	u.Tail = hex.EncodeToString(tail)
+	if _, _, err = u.Finish(secret, opts); !errors.Is(err, ErrHash) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err = os.Remove(u.Msg.Value); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUploadResume(t *testing.T) {
+	const secret = "secret"
+	opts := &Options{KDF: Argon2id{Time: 1, Memory: 1024, Threads: 1}}
+	plainText := bytes.Repeat([]byte("some text"), stream.SegmentSize/4)
+	size := int64(len(plainText))
+
+	u, err := NewUpload(secret, t.TempDir(), size, nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := *u
+	if _, err = u.Write(secret, 10, bytes.NewReader(plainText), opts); !errors.Is(err, ErrUploadState) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// the first segment is written, but the state is not saved
+	if _, err = u.Write(secret, 0, bytes.NewReader(plainText[:stream.SegmentSize+10]), opts); err != nil {
+		t.Fatal(err)
+	}
+	// the written segment is not sealed again with other data
+	lost := saved
+	_, err = lost.Write(secret, 0, bytes.NewReader(bytes.Repeat([]byte("x"), stream.SegmentSize+10)), opts)
+	if !errors.Is(err, ErrUploadState) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err = u.Write(secret, u.Offset, bytes.NewReader(plainText[u.Offset:]), opts); err != nil {
+		t.Fatal(err)
+	}
+	// the last segment is written, but the item is not saved
+	completed := *u
+	if _, _, err = u.Finish(secret, opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = completed.Write(secret, completed.Offset, bytes.NewReader(nil), opts); err != nil {
+		t.Fatal(err)
+	}
+	m, _, err := completed.Finish(secret, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dst bytes.Buffer
+	if err = DecryptFile(secret, m, &dst, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dst.Bytes(), plainText) {
+		t.Error("failed decrypted value")
+	}
+}
@@ -0,0 +1,185 @@
+package gc
+
+// Package gc contains "garbage collector" to delete expired items.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/z0rr0/ssf/config"
+	"github.com/z0rr0/ssf/db"
+	"github.com/z0rr0/ssf/encrypt"
+)
+
+// Clock is a source of current time and periodic ticks.
+type Clock interface {
+	Now() time.Time
+	Tick(d time.Duration) (<-chan time.Time, func())
+}
+
+// SystemClock is a Clock based on the system time.
+type SystemClock struct{}
+
+// Now returns current local time.
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Tick returns a channel with ticks every period d and a function to stop them.
+func (SystemClock) Tick(d time.Duration) (<-chan time.Time, func()) {
+	ticker := time.NewTicker(d)
+	return ticker.C, ticker.Stop
+}
+
+// Run starts a worker which deletes expired items every GC period
+// and reconciles used storage space with the storage directory.
+// The returned channel is closed when the worker is stopped after ctx cancellation.
+func Run(ctx context.Context, cfg *config.Config, clock Clock) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tick, stop := clock.Tick(cfg.GCPeriod())
+		defer stop()
+		for {
+			select {
+			case <-ctx.Done():
+				log.Println("gc is stopped")
+				return
+			case <-tick:
+				n, err := Collect(ctx, cfg, clock.Now())
+				if err != nil {
+					log.Printf("gc error: %v", err)
+				}
+				if n > 0 {
+					log.Printf("gc deleted %d item(s)", n)
+				}
+				drift, err := cfg.Storage.Reconcile()
+				if err != nil {
+					log.Printf("gc storage reconcile error: %v", err)
+				}
+				if drift != 0 {
+					log.Printf("gc storage reconcile drift=%d bytes", drift)
+				}
+			}
+		}
+	}()
+	return done
+}
+
+// Collect deletes items which are expired by now or have reached their max usage number,
+// and not completed uploads which are expired.
+// Their files are removed from the storage, and the space is returned to the storage limit.
+// A failed removal doesn't stop the collection, such items are tried again by the next call.
+// It returns a number of deleted items and uploads and the first removal error with a number of other ones.
+func Collect(ctx context.Context, cfg *config.Config, now time.Time) (int, error) {
+	items, err := db.Expired(ctx, cfg.Storage.Db, now)
+	if err != nil {
+		return 0, err
+	}
+	var (
+		n    int
+		errs []error
+	)
+	for _, item := range items {
+		if err = ctx.Err(); err != nil {
+			return n, collectError(append([]error{err}, errs...))
+		}
+		if err = Remove(ctx, cfg, item); err != nil {
+			log.Printf("gc: remove item %s error: %v", item.ID, err)
+			errs = append(errs, fmt.Errorf("item %s: %w", item.ID, err))
+			continue
+		}
+		n++
+	}
+	uploads, err := db.ExpiredUploads(ctx, cfg.Storage.Db, now)
+	if err != nil {
+		return n, collectError(append([]error{err}, errs...))
+	}
+	for _, u := range uploads {
+		if err = ctx.Err(); err != nil {
+			return n, collectError(append([]error{err}, errs...))
+		}
+		if err = RemoveUpload(ctx, cfg, u); err != nil {
+			log.Printf("gc: remove upload %s error: %v", u.ID, err)
+			errs = append(errs, fmt.Errorf("upload %s: %w", u.ID, err))
+			continue
+		}
+		n++
+	}
+	return n, collectError(errs)
+}
+
+// collectError wraps the first error and counts the rest, all of them are already logged.
+func collectError(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return fmt.Errorf("%w (and %d more errors)", errs[0], len(errs)-1)
+}
+
+// Remove deletes item's file with its recipient stanzas and database record,
+// the files space is returned to the storage limit.
+func Remove(ctx context.Context, cfg *config.Config, item *db.Item) error {
+	if item.HasFile() {
+		if err := removeFile(cfg, item.File, item.ID); err != nil {
+			return err
+		}
+		if err := removeRecipients(cfg, item.File); err != nil {
+			return err
+		}
+	}
+	return db.Delete(ctx, cfg.Storage.Db, item.ID)
+}
+
+// RemoveUpload deletes not completed upload's file and database record,
+// the file space is returned to the storage limit.
+func RemoveUpload(ctx context.Context, cfg *config.Config, u *db.Upload) error {
+	if err := removeFile(cfg, u.File, u.ID); err != nil {
+		return err
+	}
+	return db.DeleteUpload(ctx, cfg.Storage.Db, u.ID)
+}
+
+// removeFile deletes a file from the storage and releases its space.
+func removeFile(cfg *config.Config, name, id string) error {
+	fullPath := filepath.Join(cfg.Storage.Dir, name)
+	info, err := os.Stat(fullPath)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		log.Printf("gc: file of %s is already deleted", id)
+	case err != nil:
+		return fmt.Errorf("stat file: %w", err)
+	default:
+		if err = os.Remove(fullPath); err != nil {
+			return fmt.Errorf("remove file: %w", err)
+		}
+		cfg.Storage.Release(info.Size())
+	}
+	return nil
+}
+
+// removeRecipients deletes recipient stanzas of the file if they exist and releases their space.
+func removeRecipients(cfg *config.Config, name string) error {
+	fullPath := encrypt.RecipientsFile(filepath.Join(cfg.Storage.Dir, name))
+	info, err := os.Stat(fullPath)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return nil
+	case err != nil:
+		return fmt.Errorf("stat recipients file: %w", err)
+	}
+	if err = os.Remove(fullPath); err != nil {
+		return fmt.Errorf("remove recipients file: %w", err)
+	}
+	cfg.Storage.Release(info.Size())
+	return nil
+}
@@ -0,0 +1,277 @@
+package gc
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/z0rr0/ssf/config"
+	"github.com/z0rr0/ssf/db"
+	"github.com/z0rr0/ssf/encrypt"
+)
+
+const testConfig = `
+[server]
+host = "localhost"
+port = 8082
+timeout = 30
+debug = true
+
+[storage]
+file = "{dir}/db.sqlite"
+dir = "{dir}/storage"
+size = 2
+
+[settings]
+ttl = 60
+times = 2
+size = 1
+salt = "abc"
+gc = 1
+passlen = 8
+shutdown = 1
+
+[settings.argon]
+time = 1
+memory = 1
+threads = 1
+`
+
+// fakeClock is a Clock with manual time and ticks control.
+type fakeClock struct {
+	sync.Mutex
+	now  time.Time
+	tick chan time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Tick(time.Duration) (<-chan time.Time, func()) {
+	return c.tick, func() {}
+}
+
+// advance moves the clock and sends a tick, it's blocked until the worker receives it.
+func (c *fakeClock) advance(d time.Duration) {
+	c.Lock()
+	c.now = c.now.Add(d)
+	now := c.now
+	c.Unlock()
+	c.tick <- now
+}
+
+func newConfig(t *testing.T) *config.Config {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "config.toml")
+	content := strings.ReplaceAll(testConfig, "{dir}", dir)
+	if err := os.WriteFile(fileName, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.New(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if e := cfg.Close(); e != nil {
+			t.Error(e)
+		}
+	})
+	return cfg
+}
+
+func newItem(t *testing.T, cfg *config.Config, now time.Time, number int) *db.Item {
+	m, err := encrypt.File("secret", strings.NewReader("content"), cfg.Storage.Dir, "", cfg.Options())
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(m.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.Storage.Limit(info.Size()); err != nil {
+		t.Fatal(err)
+	}
+	item := db.NewItem(filepath.Base(m.Value), m, now, cfg.TTL(), cfg.Settings.Times)
+	item.Number = number
+	if err = item.Save(context.Background(), cfg.Storage.Db); err != nil {
+		t.Fatal(err)
+	}
+	return item
+}
+
+func newNote(t *testing.T, cfg *config.Config, now time.Time) *db.Item {
+	m, err := encrypt.Text("secret", "note", cfg.Options())
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := db.NewNote("note", m, now, cfg.TTL(), cfg.Settings.Times)
+	if err = item.Save(context.Background(), cfg.Storage.Db); err != nil {
+		t.Fatal(err)
+	}
+	return item
+}
+
+func newUpload(t *testing.T, cfg *config.Config, now time.Time, expired time.Time) *db.Upload {
+	m, err := encrypt.NewUpload("secret", cfg.Storage.Dir, 100, nil, cfg.Options())
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(m.Msg.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.Storage.Limit(info.Size()); err != nil {
+		t.Fatal(err)
+	}
+	u := db.NewUpload(filepath.Base(m.Msg.Value), m, now, expired, cfg.TTL(), cfg.Settings.Times)
+	if err = u.Save(context.Background(), cfg.Storage.Db); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func uploadExists(t *testing.T, cfg *config.Config, u *db.Upload) bool {
+	_, err := db.GetUpload(context.Background(), cfg.Storage.Db, u.ID, time.Time{})
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		t.Fatal(err)
+	}
+	_, statErr := os.Stat(filepath.Join(cfg.Storage.Dir, u.File))
+	if (err == nil) != (statErr == nil) {
+		t.Errorf("inconsistent upload=%s state: %v, %v", u.ID, err, statErr)
+	}
+	return err == nil
+}
+
+func exists(t *testing.T, cfg *config.Config, item *db.Item) bool {
+	_, err := db.Get(context.Background(), cfg.Storage.Db, item.ID, time.Time{})
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		t.Fatal(err)
+	}
+	_, statErr := os.Stat(filepath.Join(cfg.Storage.Dir, item.File))
+	if (err == nil) != (statErr == nil) {
+		t.Errorf("inconsistent item=%s state: %v, %v", item.ID, err, statErr)
+	}
+	return err == nil
+}
+
+func TestCollect(t *testing.T) {
+	cfg := newConfig(t)
+	now := time.Now()
+	empty := cfg.Storage.String()
+
+	active := newItem(t, cfg, now, 0)
+	used := newItem(t, cfg, now, cfg.Settings.Times)
+	old := newItem(t, cfg, now.Add(-cfg.TTL()), 0)
+	note := newNote(t, cfg, now.Add(-cfg.TTL()))
+	activeUpload := newUpload(t, cfg, now, now.Add(cfg.TTL()))
+	oldUpload := newUpload(t, cfg, now.Add(-cfg.TTL()), now)
+
+	n, err := Collect(context.Background(), cfg, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Errorf("failed deleted number=%d", n)
+	}
+	if _, err = db.Get(context.Background(), cfg.Storage.Db, note.ID, time.Time{}); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("expired note is not deleted: %v", err)
+	}
+	if !exists(t, cfg, active) {
+		t.Error("active item is deleted")
+	}
+	if exists(t, cfg, used) || exists(t, cfg, old) {
+		t.Error("expired item is not deleted")
+	}
+	if !uploadExists(t, cfg, activeUpload) || uploadExists(t, cfg, oldUpload) {
+		t.Error("failed uploads state")
+	}
+	if n, err = Collect(context.Background(), cfg, now.Add(cfg.TTL())); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || exists(t, cfg, active) || uploadExists(t, cfg, activeUpload) {
+		t.Errorf("failed deleted number=%d", n)
+	}
+	if s := cfg.Storage.String(); s != empty {
+		t.Errorf("storage limit is not released: %s", s)
+	}
+}
+
+func TestCollectError(t *testing.T) {
+	cfg := newConfig(t)
+	now := time.Now()
+
+	broken := newItem(t, cfg, now.Add(-cfg.TTL()), 0)
+	old := newItem(t, cfg, now.Add(-cfg.TTL()), 0)
+	oldUpload := newUpload(t, cfg, now.Add(-cfg.TTL()), now)
+	// a not empty directory instead of the file can't be removed
+	fullPath := filepath.Join(cfg.Storage.Dir, broken.File)
+	if err := os.Remove(fullPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(fullPath, "dir"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	n, err := Collect(context.Background(), cfg, now)
+	if err == nil || !strings.Contains(err.Error(), broken.ID) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("failed deleted number=%d", n)
+	}
+	if exists(t, cfg, old) || uploadExists(t, cfg, oldUpload) {
+		t.Error("expired item is not deleted after failed removal")
+	}
+	if _, err = db.Get(context.Background(), cfg.Storage.Db, broken.ID, time.Time{}); err != nil {
+		t.Errorf("item with failed removal is deleted: %v", err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	cfg := newConfig(t)
+	clock := &fakeClock{now: time.Now(), tick: make(chan time.Time)}
+	item := newItem(t, cfg, clock.Now(), 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := Run(ctx, cfg, clock)
+
+	clock.advance(cfg.TTL() / 2)
+	// the second tick is received only after the first one processing
+	clock.advance(0)
+	if !exists(t, cfg, item) {
+		t.Error("active item is deleted")
+	}
+	clock.advance(cfg.TTL() / 2)
+	clock.advance(0)
+	if exists(t, cfg, item) {
+		t.Error("expired item is not deleted")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(cfg.ShutdownTimeout()):
+		t.Error("gc is not stopped")
+	}
+}
+
+func TestCollectErrorWrap(t *testing.T) {
+	first, second := errors.New("first"), errors.New("second")
+	if err := collectError(nil); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := collectError([]error{first}); err != first {
+		t.Errorf("unexpected error: %v", err)
+	}
+	err := collectError([]error{first, second, second})
+	if !errors.Is(err, first) || errors.Is(err, second) || err.Error() != "first (and 2 more errors)" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -3,8 +3,10 @@ module github.com/z0rr0/ssf
 go 1.18
 
 require (
-	github.com/google/uuid v1.3.0 // indirect
-	github.com/mattn/go-sqlite3 v1.14.13 // indirect
-	github.com/pelletier/go-toml/v2 v2.0.1 // indirect
-	golang.org/x/crypto v0.0.0-20220518034528-6f7dac969898 // indirect
+	github.com/google/uuid v1.3.0
+	github.com/mattn/go-sqlite3 v1.14.13
+	github.com/pelletier/go-toml/v2 v2.0.1
+	golang.org/x/crypto v0.0.0-20220518034528-6f7dac969898
 )
+
+require golang.org/x/sys v0.0.0-20210615035016-665e8c7367d1 // indirect
@@ -0,0 +1,89 @@
+package handle
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/z0rr0/ssf/config"
+	"github.com/z0rr0/ssf/db"
+	"github.com/z0rr0/ssf/encrypt"
+)
+
+const (
+	// formatField is a name of form field with a format of stored file: "stream" (default) or "age".
+	formatField = "format"
+	// ageExt is an extension of downloaded age files.
+	ageExt = ".age"
+)
+
+var (
+	// errNoteFormat is an error when a format is set for a note.
+	errNoteFormat = fmt.Errorf("field %q can be used only for files", formatField)
+	// errNotAge is an error when age file is requested for an item in other format.
+	errNotAge = errors.New("item is not stored in age format")
+)
+
+// setFormat sets a format of the stored file. Age files with a password can be decrypted by age tools with it,
+// files with recipients - by their identities.
+func (p *shareParams) setFormat(values url.Values) error {
+	switch format := values.Get(formatField); format {
+	case "", encrypt.FormatStream, encrypt.FormatAge:
+		p.format = format
+		return nil
+	default:
+		return newError(http.StatusBadRequest, fmt.Errorf("unknown %q value %q", formatField, format))
+	}
+}
+
+// downloadAge writes the stored age file of the item without decryption, the download is counted.
+func downloadAge(w http.ResponseWriter, r *http.Request, cfg *config.Config, item *db.Item, meta *encrypt.Meta) error {
+	if format := r.FormValue(formatField); format != encrypt.FormatAge {
+		return newError(http.StatusBadRequest, fmt.Errorf("unknown %q value %q", formatField, format))
+	}
+	name := item.FileMsg(cfg.Storage.Dir).Value
+	format, err := encrypt.FileFormat(name)
+	if err != nil {
+		return err
+	}
+	if format != encrypt.FormatAge {
+		return newError(http.StatusBadRequest, errNotAge)
+	}
+	// the file is opened before the download is counted, it can be read after the item removal
+	f, err := os.Open(name)
+	if err != nil {
+		return openError(fmt.Errorf("open age file: %w", err))
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("stat age file: %w", err)
+	}
+	last, err := db.Use(r.Context(), cfg.Storage.Db, item.ID, time.Now())
+	if err != nil {
+		return err
+	}
+	if last {
+		defer removeUsed(cfg, item)
+	}
+	fileName := item.ID
+	if meta != nil && meta.Name != "" {
+		fileName = meta.Name
+	}
+	w.Header().Set("Content-Type", defaultContentType)
+	w.Header().Set("Content-Length", fmt.Sprint(info.Size()))
+	w.Header().Set(
+		"Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileName + ageExt}),
+	)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -0,0 +1,217 @@
+package handle
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/z0rr0/ssf/encrypt/age"
+	"github.com/z0rr0/ssf/encrypt/recipient"
+)
+
+func getAge(t *testing.T, server *httptest.Server, id, password string) (*http.Response, []byte) {
+	values := url.Values{"id": {id}, "password": {password}, formatField: {"age"}}
+	resp, err := http.PostForm(server.URL+"/download", values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+func TestUploadAge(t *testing.T) {
+	cfg := newConfig(t)
+	server := httptest.NewServer(New(cfg))
+	defer server.Close()
+
+	content := bytes.Repeat([]byte("age content "), 10000)
+	resp, result := uploadFileWith(t, server, content, url.Values{formatField: {"age"}, timesField: {"2"}})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("failed upload status=%d", resp.StatusCode)
+	}
+	// partial reads are not counted
+	r, body := downloadWith(t, server, result.ID, result.Password, http.Header{"Range": {"bytes=10-19"}})
+	if r.StatusCode != http.StatusPartialContent || !bytes.Equal(body, content[10:20]) {
+		t.Errorf("failed range status=%d, body=%q", r.StatusCode, body)
+	}
+	r, body = download(t, server, result.ID, result.Password)
+	if r.StatusCode != http.StatusOK || !bytes.Equal(body, content) {
+		t.Fatalf("failed download status=%d", r.StatusCode)
+	}
+	// the stored file is decrypted by age tools with the password
+	if r, body = getAge(t, server, result.ID, "bad password"); r.StatusCode != http.StatusForbidden {
+		t.Errorf("failed age download status=%d for wrong password", r.StatusCode)
+	}
+	r, body = getAge(t, server, result.ID, result.Password)
+	if r.StatusCode != http.StatusOK {
+		t.Fatalf("failed age download status=%d", r.StatusCode)
+	}
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Disposition"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := params["filename"]; name != "test.txt.age" {
+		t.Errorf("failed file name %q", name)
+	}
+	passphrase, err := age.NewPassphrase(result.Password, age.MaxWorkFactor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dst bytes.Buffer
+	if err = age.DecryptIdentities(bytes.NewReader(body), &dst, []age.Identity{passphrase}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dst.Bytes(), content) {
+		t.Error("failed age decrypted content")
+	}
+	// the item is removed after max number of downloads
+	if r, _ = download(t, server, result.ID, result.Password); r.StatusCode != http.StatusNotFound {
+		t.Errorf("failed download status=%d for used item", r.StatusCode)
+	}
+}
+
+func TestUploadAgeRecipients(t *testing.T) {
+	cfg := newConfig(t)
+	server := httptest.NewServer(New(cfg))
+	defer server.Close()
+
+	identity, err := recipient.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("age content for recipients")
+	fields := url.Values{formatField: {"age"}, recipientField: {identity.Recipient().String()}}
+	resp, result := uploadFileWith(t, server, content, fields)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("failed upload status=%d", resp.StatusCode)
+	}
+	if result.Password != "" || result.Recipients != 1 {
+		t.Fatalf("failed password=%q, recipients=%d", result.Password, result.Recipients)
+	}
+	r, body := downloadRaw(t, server, result.ID)
+	if r.StatusCode != http.StatusOK {
+		t.Fatalf("failed raw download status=%d", r.StatusCode)
+	}
+	var dst bytes.Buffer
+	if err = age.DecryptIdentities(bytes.NewReader(body), &dst, []age.Identity{identity}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dst.Bytes(), content) {
+		t.Error("failed age decrypted content")
+	}
+	// the file key from recipient stanzas decrypts the file too
+	if b := decryptRecipientAge(t, server, result.ID, identity); !bytes.Equal(b, content) {
+		t.Errorf("failed recipient content %q", b)
+	}
+}
+
+// decryptRecipientAge returns age file content decrypted by the file key from recipient stanzas.
+func decryptRecipientAge(t *testing.T, server *httptest.Server, id string, identity *recipient.Identity) []byte {
+	resp, stanzas := getRecipients(t, server, id)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed recipients status=%d", resp.StatusCode)
+	}
+	fileKey, err := recipient.Unwrap(stanzas, []*recipient.Identity{identity})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, body := downloadRaw(t, server, id)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed raw download status=%d", resp.StatusCode)
+	}
+	var dst bytes.Buffer
+	if err = age.Decrypt(bytes.NewReader(body), &dst, fileKey); err != nil {
+		t.Fatal(err)
+	}
+	return dst.Bytes()
+}
+
+func TestRawAge(t *testing.T) {
+	cfg := newConfig(t)
+	server := httptest.NewServer(New(cfg))
+	defer server.Close()
+
+	passphrase, err := age.NewPassphrase("password", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileKey, err := age.NewFileKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := passphrase.Wrap(fileKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var encrypted bytes.Buffer
+	err = age.Encrypt(bytes.NewReader([]byte("pre-encrypted")), &encrypted, fileKey, []*recipient.Stanza{s})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, result := uploadRaw(t, server, encrypted.Bytes(), url.Values{timesField: {"1"}})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("failed upload status=%d", resp.StatusCode)
+	}
+	// age files are served unchanged, but they can't be downloaded by a password
+	if r, _ := getAge(t, server, result.ID, "password"); r.StatusCode != http.StatusBadRequest {
+		t.Errorf("failed age download status=%d", r.StatusCode)
+	}
+	r, body := downloadRaw(t, server, result.ID)
+	if r.StatusCode != http.StatusOK || !bytes.Equal(body, encrypted.Bytes()) {
+		t.Errorf("failed raw download status=%d", r.StatusCode)
+	}
+	truncated := encrypted.Bytes()[:encrypted.Len()-20]
+	if r, _ = uploadRaw(t, server, truncated, nil); r.StatusCode != http.StatusBadRequest {
+		t.Errorf("failed upload status=%d for truncated file", r.StatusCode)
+	}
+}
+
+func TestAgeError(t *testing.T) {
+	cfg := newConfig(t)
+	server := httptest.NewServer(New(cfg))
+	defer server.Close()
+
+	content := []byte("content")
+	if resp, _ := uploadFileWith(t, server, content, url.Values{formatField: {"zip"}}); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("failed upload status=%d for unknown format", resp.StatusCode)
+	}
+	resp, err := http.PostForm(server.URL+"/note", url.Values{noteField: {"text"}, formatField: {"age"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = resp.Body.Close(); err != nil {
+		t.Error(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("failed note status=%d", resp.StatusCode)
+	}
+	// files in the default format can't be downloaded as age ones, the download is not counted
+	resp, result := uploadFileWith(t, server, content, url.Values{timesField: {"1"}})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("failed upload status=%d", resp.StatusCode)
+	}
+	if r, _ := getAge(t, server, result.ID, result.Password); r.StatusCode != http.StatusBadRequest {
+		t.Errorf("failed age download status=%d", r.StatusCode)
+	}
+	r, body := download(t, server, result.ID, result.Password)
+	if r.StatusCode != http.StatusOK || !bytes.Equal(body, content) {
+		t.Errorf("failed download status=%d", r.StatusCode)
+	}
+	resp, note := createNote(t, server, "text")
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("failed note status=%d", resp.StatusCode)
+	}
+	if r, _ = getAge(t, server, note.ID, note.Password); r.StatusCode != http.StatusBadRequest {
+		t.Errorf("failed age download status=%d for note", r.StatusCode)
+	}
+}
@@ -0,0 +1,410 @@
+package handle
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/z0rr0/ssf/config"
+	"github.com/z0rr0/ssf/db"
+	"github.com/z0rr0/ssf/encrypt"
+	"github.com/z0rr0/ssf/gc"
+)
+
+const (
+	// defaultContentType is a content type of files without meta data.
+	defaultContentType = "application/octet-stream"
+	// sniffLen is a number of bytes to detect content type.
+	sniffLen = 512
+)
+
+// limitReader is a reader which returns errFileSize if more than n bytes are read.
+// It counts read bytes.
+type limitReader struct {
+	r    io.Reader
+	n    int64
+	read int64
+}
+
+// Read reads data from the internal reader.
+func (l *limitReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.read > l.n {
+		return 0, errFileSize
+	}
+	return n, err
+}
+
+// uploadResponse is a JSON response of successful upload.
+// Items with split secrets have share tokens instead of the password,
+// files only for recipients have a number of recipients without the password.
+type uploadResponse struct {
+	ID         string    `json:"id"`
+	Password   string    `json:"password,omitempty"`
+	Shares     []string  `json:"shares,omitempty"`
+	Recipients int       `json:"recipients,omitempty"`
+	Expired    time.Time `json:"expired"`
+	Times      int       `json:"times"`
+}
+
+// newUploadResponse returns a response for the saved item, params are nil for items without passwords.
+func newUploadResponse(item *db.Item, params *shareParams) *uploadResponse {
+	result := &uploadResponse{ID: item.ID, Expired: item.Expired, Times: item.Times}
+	if params == nil {
+		return result
+	}
+	result.Recipients = len(params.recipients)
+	switch {
+	case params.hidden:
+	case params.shares != nil:
+		result.Shares = params.shares
+	default:
+		result.Password = params.password
+	}
+	return result
+}
+
+// options returns encryption options with item ID as associated data.
+func options(cfg *config.Config, id string) *encrypt.Options {
+	opts := cfg.Options()
+	opts.AD = []byte(id)
+	return opts
+}
+
+// Upload encrypts a file from multipart form and stores it.
+// Optional item parameters (ttl, times, password, generator, shares, threshold, recipients and format) can be set
+// as URL query parameters or form fields before the file. It returns item ID and the password or share tokens.
+func Upload(w http.ResponseWriter, r *http.Request, cfg *config.Config) error {
+	result, err := receiveUpload(w, r, cfg)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusCreated, result)
+	return nil
+}
+
+// receiveUpload reads item parameters and a file from multipart form, the file is encrypted and stored.
+func receiveUpload(w http.ResponseWriter, r *http.Request, cfg *config.Config) (*uploadResponse, error) {
+	maxSize := int64(cfg.MaxFileSize())
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+multipartOverhead)
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, newError(http.StatusBadRequest, err)
+	}
+	values := r.URL.Query()
+	for {
+		part, e := reader.NextPart()
+		if e != nil {
+			if e == io.EOF {
+				return nil, newError(http.StatusBadRequest, fmt.Errorf("no form field %q", fileField))
+			}
+			return nil, newError(http.StatusBadRequest, e)
+		}
+		switch name := part.FormName(); name {
+		case fileField:
+			params, e := newShareParams(cfg, values)
+			if e != nil {
+				return nil, e
+			}
+			if e = params.split(values); e != nil {
+				return nil, e
+			}
+			if e = params.addRecipients(values); e != nil {
+				return nil, e
+			}
+			if e = params.setFormat(values); e != nil {
+				return nil, e
+			}
+			return upload(r, cfg, part, params)
+		case ttlField, timesField, passwordField, generatorField, sharesField, thresholdField, recipientField,
+			formatField:
+			// one extra byte is read to detect too long values
+			value, e := io.ReadAll(io.LimitReader(part, maxFieldSize+1))
+			if e != nil {
+				return nil, newError(http.StatusBadRequest, e)
+			}
+			if name == recipientField {
+				values.Add(name, string(value))
+			} else {
+				values.Set(name, string(value))
+			}
+		}
+	}
+}
+
+// upload encrypts and saves file content and its meta data.
+func upload(r *http.Request, cfg *config.Config, part *multipart.Part, params *shareParams) (*uploadResponse, error) {
+	maxSize := int64(cfg.MaxFileSize())
+	reservation, err := cfg.Storage.Reserve(reserveSize(r, maxSize))
+	if err != nil {
+		return nil, err
+	}
+	defer reservation.Rollback()
+
+	src := &limitReader{r: part, n: maxSize}
+	buffered := bufio.NewReader(src)
+	contentType, err := detectContentType(part, buffered)
+	if err != nil {
+		return nil, err
+	}
+
+	id := uuid.New().String()
+	secret, opts := cfg.Secret(params.password), options(cfg, id)
+	opts.Recipients, opts.Format, opts.Passphrase = params.recipients, params.format, params.password
+	if !encrypt.Compressible(contentType) {
+		opts.Compression = encrypt.CompressionNone
+	}
+
+	m, err := encrypt.File(secret, buffered, cfg.Storage.Dir, "", opts)
+	if err != nil {
+		if errors.Is(err, encrypt.ErrHash) {
+			return nil, newError(http.StatusBadRequest, errEmptyFile)
+		}
+		return nil, err
+	}
+	size, err := storedSize(m.Value)
+	if err != nil {
+		return nil, removeFile(m.Value, err)
+	}
+	if err = reservation.Commit(size); err != nil {
+		return nil, removeFile(m.Value, err)
+	}
+	meta := &encrypt.Meta{Name: fileName(part), Size: src.read, Type: contentType, Sum: m.DataHash}
+	metaMsg, err := meta.Encrypt(secret, opts)
+	if err != nil {
+		return nil, releaseFile(cfg, m.Value, size, err)
+	}
+	item := db.NewItem(id, m, time.Now(), params.ttl, params.times)
+	item.Meta = metaMsg
+	if params.hidden {
+		item.Kind = db.KindRecipients
+	}
+	if err = item.Save(r.Context(), cfg.Storage.Db); err != nil {
+		return nil, releaseFile(cfg, m.Value, size, err)
+	}
+	return newUploadResponse(item, params), nil
+}
+
+// fileName returns a base name of uploaded file.
+func fileName(part *multipart.Part) string {
+	return baseName(part.FileName())
+}
+
+// baseName returns a base name of the file path or empty string for a root.
+func baseName(name string) string {
+	name = filepath.Base(filepath.Clean("/" + name))
+	if name == "/" || name == "." {
+		return ""
+	}
+	return name
+}
+
+// mediaType returns normalized content type value,
+// empty string is returned for invalid and default types.
+func mediaType(value string) string {
+	if value == "" {
+		return ""
+	}
+	mt, params, err := mime.ParseMediaType(value)
+	if err != nil || mt == defaultContentType {
+		return ""
+	}
+	return mime.FormatMediaType(mt, params)
+}
+
+// detectContentType returns a content type of uploaded file.
+// A valid type from the part header is used, otherwise it's detected by the first bytes.
+func detectContentType(part *multipart.Part, r *bufio.Reader) (string, error) {
+	if value := mediaType(part.Header.Get("Content-Type")); value != "" {
+		return value, nil
+	}
+	head, err := r.Peek(sniffLen)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return "", err
+	}
+	return http.DetectContentType(head), nil
+}
+
+// reserveSize returns storage space size to reserve for the request's file.
+// Request's content length is an upper bound of the file size including multipart data.
+func reserveSize(r *http.Request, maxSize int64) int64 {
+	if n := r.ContentLength; n > 0 && n < maxSize {
+		return n
+	}
+	return maxSize
+}
+
+// removeFile deletes the file with its recipient stanzas and returns err with possible removal error.
+func removeFile(name string, err error) error {
+	if e := os.Remove(name); e != nil {
+		return fmt.Errorf("%v, remove file: %w", err, e)
+	}
+	if e := os.Remove(encrypt.RecipientsFile(name)); e != nil && !errors.Is(e, fs.ErrNotExist) {
+		return fmt.Errorf("%v, remove recipients file: %w", err, e)
+	}
+	return err
+}
+
+// openError returns db.ErrNotFound if the file is already deleted with its used item by a concurrent request.
+func openError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return db.ErrNotFound
+	}
+	return err
+}
+
+// releaseFile deletes the file, frees its storage space and returns err with possible removal error.
+func releaseFile(cfg *config.Config, name string, size int64, err error) error {
+	err = removeFile(name, err)
+	cfg.Storage.Release(size)
+	return err
+}
+
+// fileHeaders sets response headers by file meta data.
+// Items without meta data are returned as binary files named by their ID.
+func fileHeaders(w http.ResponseWriter, item *db.Item, meta *encrypt.Meta) {
+	name, contentType := item.ID, defaultContentType
+	if meta != nil {
+		if meta.Name != "" {
+			name = meta.Name
+		}
+		if meta.Type != "" {
+			contentType = meta.Type
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(meta.Size))
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+}
+
+// Download writes decrypted file content or note text by item ID and password or share tokens.
+// A single byte range of a file can be requested by Range header, such partial reads are not counted.
+// Files stored in age format are returned without decryption if "format" field is "age".
+func Download(w http.ResponseWriter, r *http.Request, cfg *config.Config) error {
+	id := r.FormValue("id")
+	password, err := requestPassword(r.Form)
+	if err != nil {
+		return err
+	}
+	if id == "" || password == "" {
+		return newError(http.StatusBadRequest, errors.New("id and password are required"))
+	}
+	item, err := db.Get(r.Context(), cfg.Storage.Db, id, time.Now())
+	if err != nil {
+		return err
+	}
+	if item.Kind == db.KindRaw {
+		return newError(http.StatusBadRequest, errRawItem)
+	}
+	if item.Kind == db.KindNote && r.FormValue(formatField) != "" {
+		return newError(http.StatusBadRequest, errNotAge)
+	}
+	secret := cfg.Secret(password)
+	if item.Kind == db.KindNote {
+		return readNote(w, r, cfg, item, secret)
+	}
+	var meta *encrypt.Meta
+	if item.Meta != nil {
+		meta = &encrypt.Meta{}
+		err = meta.Decrypt(secret, item.Meta, options(cfg, item.ID))
+	} else {
+		err = encrypt.CheckSecret(secret, item.FileMsg(cfg.Storage.Dir), options(cfg, item.ID))
+	}
+	if err != nil {
+		return err
+	}
+	if r.FormValue(formatField) != "" {
+		return downloadAge(w, r, cfg, item, meta)
+	}
+	br, err := fileRange(w, r, item, meta)
+	if err != nil {
+		return err
+	}
+	// the file is opened before the download is counted, it can be read after the item removal
+	f, err := encrypt.OpenFile(secret, item.FileMsg(cfg.Storage.Dir), options(cfg, item.ID))
+	if err != nil {
+		return openError(err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	if br != nil {
+		// partial reads are not counted, every segment is authenticated separately
+		fileHeaders(w, item, meta)
+		w.Header().Set("Content-Length", fmt.Sprint(br.length))
+		w.Header().Set("Content-Range", br.contentRange(meta.Size))
+		w.WriteHeader(http.StatusPartialContent)
+		return f.DecryptRange(br.start, br.length, w)
+	}
+	// integrity is checked before the response, an error can not be reported after the first sent byte
+	if err = f.Verify(); err != nil {
+		return err
+	}
+	// the content can be served, the download is counted before it's sent
+	last, err := db.Use(r.Context(), cfg.Storage.Db, item.ID, time.Now())
+	if err != nil {
+		return err
+	}
+	if last {
+		defer removeUsed(cfg, item)
+	}
+	fileHeaders(w, item, meta)
+	return f.Decrypt(w)
+}
+
+// Delete removes an item by its ID and password or share tokens before its expiration.
+// Files encrypted by clients have no passwords, they can't be deleted.
+func Delete(w http.ResponseWriter, r *http.Request, cfg *config.Config) error {
+	id := r.PostFormValue("id")
+	password, err := requestPassword(r.PostForm)
+	if err != nil {
+		return err
+	}
+	if id == "" || password == "" {
+		return newError(http.StatusBadRequest, errors.New("id and password are required"))
+	}
+	item, err := db.Get(r.Context(), cfg.Storage.Db, id, time.Now())
+	if err != nil {
+		return err
+	}
+	var m *encrypt.Msg
+	switch item.Kind {
+	case db.KindRaw:
+		return newError(http.StatusBadRequest, errRawItem)
+	case db.KindFile, db.KindRecipients:
+		m = item.FileMsg(cfg.Storage.Dir)
+	default:
+		m = item.Msg
+	}
+	if err = encrypt.CheckSecret(cfg.Secret(password), m, options(cfg, item.ID)); err != nil {
+		return err
+	}
+	if err = gc.Remove(r.Context(), cfg, item); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// removeUsed deletes the item which is used max number of times without waiting for GC.
+func removeUsed(cfg *config.Config, item *db.Item) {
+	// request context can be already canceled
+	if err := gc.Remove(context.Background(), cfg, item); err != nil {
+		log.Printf("failed remove used item %s: %v", item.ID, err)
+	}
+}
@@ -0,0 +1,313 @@
+package handle
+
+// Package handle contains HTTP handlers of the service.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/z0rr0/ssf/config"
+	"github.com/z0rr0/ssf/db"
+	"github.com/z0rr0/ssf/encrypt"
+	"github.com/z0rr0/ssf/encrypt/pwgen"
+	"github.com/z0rr0/ssf/encrypt/recipient"
+)
+
+const (
+	// fileField is a name of multipart form field with uploaded file.
+	fileField = "file"
+	// ttlField is a name of form field with item time to live in seconds.
+	ttlField = "ttl"
+	// timesField is a name of form field with max number of item usages.
+	timesField = "times"
+	// passwordField is a name of form field with user's password, it's generated if empty.
+	passwordField = "password"
+	// generatorField is a name of form field with password generator name: "symbols" or "words".
+	generatorField = "generator"
+	// maxFieldSize is a max size of form fields except file and note text.
+	maxFieldSize = 64
+	// multipartOverhead is a max size of multipart form data except file content.
+	multipartOverhead = 1 << 20
+)
+
+var (
+	// errFileSize is an error when uploaded file is too big.
+	errFileSize = errors.New("file size limit is reached")
+	// errEmptyFile is an error when uploaded file is empty.
+	errEmptyFile = errors.New("empty file")
+)
+
+// httpError is an error with HTTP status code.
+type httpError struct {
+	code int
+	err  error
+}
+
+// Error returns error message.
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns internal error.
+func (e *httpError) Unwrap() error {
+	return e.err
+}
+
+// newError returns new error with HTTP status code.
+func newError(code int, err error) error {
+	return &httpError{code: code, err: err}
+}
+
+// weakPasswordError is an error when user's password estimated strength is less than required.
+type weakPasswordError struct {
+	strength *pwgen.Strength
+	min      int
+}
+
+// Error returns the error message with the main weakness of the password.
+func (e *weakPasswordError) Error() string {
+	msg := fmt.Sprintf("weak password: estimated strength %.0f bits is less than %d", e.strength.Entropy, e.min)
+	if e.strength.Warning != "" {
+		msg += ", " + e.strength.Warning
+	}
+	return msg
+}
+
+// passwordDetails is a JSON explanation why user's password is rejected.
+type passwordDetails struct {
+	Entropy  float64  `json:"entropy"`
+	Required int      `json:"required"`
+	Patterns []string `json:"patterns,omitempty"`
+	Warning  string   `json:"warning,omitempty"`
+}
+
+// errorResponse is a JSON response with an error message.
+type errorResponse struct {
+	Error    string           `json:"error"`
+	Password *passwordDetails `json:"password,omitempty"`
+}
+
+// responseWriter is a http.ResponseWriter which knows if a response is already started.
+type responseWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+// WriteHeader sends HTTP response header.
+func (w *responseWriter) WriteHeader(code int) {
+	w.started = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write writes the data to the connection.
+func (w *responseWriter) Write(p []byte) (int, error) {
+	w.started = true
+	return w.ResponseWriter.Write(p)
+}
+
+// Handler is a HTTP handler which can return an error.
+type Handler func(w http.ResponseWriter, r *http.Request, cfg *config.Config) error
+
+// handler is a wrapper for Handler to write errors and logs.
+type handler struct {
+	cfg    *config.Config
+	h      Handler
+	method []string
+}
+
+// ServeHTTP implements http.Handler interface.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var (
+		start = time.Now()
+		code  = http.StatusOK
+		rw    = &responseWriter{ResponseWriter: w}
+		err   error
+	)
+	defer func() {
+		log.Printf("%-5v %v\t%-12v\t%v\t%v", r.Method, code, time.Since(start), r.URL.Path, err)
+	}()
+	if !h.allowed(r.Method) {
+		code = http.StatusMethodNotAllowed
+		err = fmt.Errorf("method %s is not allowed", r.Method)
+		writeError(rw, code, err)
+		return
+	}
+	if err = h.h(rw, r, h.cfg); err != nil {
+		code = errorCode(err)
+		if rw.started {
+			// a response is partially sent, it's impossible to change it
+			return
+		}
+		writeError(rw, code, err)
+	}
+}
+
+// allowed checks that HTTP method is allowed for the handler.
+func (h *handler) allowed(method string) bool {
+	for _, m := range h.method {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// errorCode returns HTTP status code for the error.
+func errorCode(err error) int {
+	var (
+		e    *httpError
+		weak *weakPasswordError
+	)
+	switch {
+	case errors.As(err, &e):
+		return e.code
+	case errors.As(err, &weak):
+		return http.StatusBadRequest
+	case errors.Is(err, db.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, db.ErrLimit):
+		return http.StatusGone
+	case errors.Is(err, encrypt.ErrSecret):
+		return http.StatusForbidden
+	case errors.Is(err, encrypt.ErrFormat):
+		return http.StatusBadRequest
+	case errors.Is(err, db.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, config.ErrSizeLimit), errors.Is(err, errFileSize), errors.Is(err, encrypt.ErrUploadSize):
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusInternalServerError
+}
+
+// writeError writes JSON error response, internal errors details are hidden.
+// Prepared headers of file content are removed. Rejected weak passwords are explained by found patterns.
+func writeError(w http.ResponseWriter, code int, err error) {
+	var (
+		weak *weakPasswordError
+		resp = &errorResponse{Error: errorMessage(code, err)}
+	)
+	clearHeaders(w.Header())
+	if errors.As(err, &weak) {
+		resp.Password = &passwordDetails{
+			Entropy:  math.Round(weak.strength.Entropy*10) / 10,
+			Required: weak.min,
+			Patterns: weak.strength.Patterns,
+			Warning:  weak.strength.Warning,
+		}
+	}
+	writeJSON(w, code, resp)
+}
+
+// clearHeaders removes prepared headers of file content.
+func clearHeaders(h http.Header) {
+	for _, header := range []string{"Content-Length", "Content-Disposition", "Cache-Control"} {
+		h.Del(header)
+	}
+}
+
+// errorMessage returns a message of the error for users, internal errors details are hidden.
+func errorMessage(code int, err error) string {
+	if code == http.StatusInternalServerError {
+		return http.StatusText(code)
+	}
+	return err.Error()
+}
+
+// writeJSON writes JSON response.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("failed write json response: %v", err)
+	}
+}
+
+// shareParams are item parameters selected by a user.
+type shareParams struct {
+	ttl      time.Duration
+	times    int
+	password string
+	shares   []string
+	// recipients are public keys to wrap the file key, the password is not returned if it's hidden
+	recipients []*recipient.Recipient
+	hidden     bool
+	// format is a format of stored file, the default one is used if it's empty
+	format string
+}
+
+// newShareParams returns item parameters from form values.
+// Max values from the configuration are used by default, a password is generated if it's not set.
+// User's password is rejected if its estimated strength is less than settings.strength bits.
+// The generator can be selected by its name, the configured one is used by default.
+func newShareParams(cfg *config.Config, values url.Values) (*shareParams, error) {
+	p := &shareParams{ttl: cfg.TTL(), times: cfg.Settings.Times, password: values.Get(passwordField)}
+	switch n := len(p.password); {
+	case n == 0:
+		g, ok := cfg.Generator(values.Get(generatorField))
+		if !ok {
+			return nil, newError(
+				http.StatusBadRequest,
+				fmt.Errorf("field %q must be %q or %q", generatorField, config.SymbolsGenerator, config.WordsGenerator),
+			)
+		}
+		p.password = g.New()
+	case n < cfg.Settings.PassLen || n > maxFieldSize:
+		return nil, newError(
+			http.StatusBadRequest,
+			fmt.Errorf("field %q length must be in range [%d, %d]", passwordField, cfg.Settings.PassLen, maxFieldSize),
+		)
+	default:
+		if s := pwgen.Estimate(p.password); s.Entropy < float64(cfg.Settings.Strength) {
+			return nil, &weakPasswordError{strength: s, min: cfg.Settings.Strength}
+		}
+	}
+	if value := values.Get(ttlField); value != "" {
+		ttl, err := strconv.Atoi(value)
+		if err != nil || ttl < 1 || ttl > cfg.Settings.TTL {
+			return nil, newError(
+				http.StatusBadRequest, fmt.Errorf("field %q must be in range [1, %d]", ttlField, cfg.Settings.TTL),
+			)
+		}
+		p.ttl = time.Duration(ttl) * time.Second
+	}
+	if value := values.Get(timesField); value != "" {
+		times, err := strconv.Atoi(value)
+		if err != nil || times < 1 || times > cfg.Settings.Times {
+			return nil, newError(
+				http.StatusBadRequest, fmt.Errorf("field %q must be in range [1, %d]", timesField, cfg.Settings.Times),
+			)
+		}
+		p.times = times
+	}
+	return p, nil
+}
+
+// New returns HTTP handler with all service routes.
+func New(cfg *config.Config) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(indexPath, &handler{cfg: cfg, h: Index, method: []string{http.MethodGet, http.MethodPost}})
+	mux.Handle(sharePath, &handler{cfg: cfg, h: Share, method: []string{http.MethodGet, http.MethodPost}})
+	mux.Handle("/upload", &handler{cfg: cfg, h: Upload, method: []string{http.MethodPost}})
+	mux.Handle("/note", &handler{cfg: cfg, h: Note, method: []string{http.MethodPost}})
+	mux.Handle("/download", &handler{cfg: cfg, h: Download, method: []string{http.MethodGet, http.MethodPost}})
+	mux.Handle("/delete", &handler{cfg: cfg, h: Delete, method: []string{http.MethodPost}})
+	mux.Handle(rawPath, &handler{cfg: cfg, h: Raw, method: []string{http.MethodGet, http.MethodPost}})
+	mux.Handle(recipientsPath, &handler{cfg: cfg, h: Recipients, method: []string{http.MethodGet}})
+	mux.Handle(e2ePath, &handler{cfg: cfg, h: E2E, method: []string{http.MethodGet}})
+	mux.Handle(staticPath, &handler{cfg: cfg, h: Static, method: []string{http.MethodGet, http.MethodHead}})
+	mux.Handle(tusPath, &handler{
+		cfg: cfg,
+		h:   Tus,
+		method: []string{
+			http.MethodOptions, http.MethodPost, http.MethodHead, http.MethodPatch, http.MethodDelete,
+		},
+	})
+	return mux
+}
@@ -0,0 +1,626 @@
+package handle
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/z0rr0/ssf/config"
+	"github.com/z0rr0/ssf/db"
+	"github.com/z0rr0/ssf/encrypt"
+)
+
+const testConfig = `
+[server]
+host = "localhost"
+port = 8082
+timeout = 30
+debug = true
+
+[storage]
+file = "{dir}/db.sqlite"
+dir = "{dir}/storage"
+size = 2
+
+[settings]
+ttl = 60
+times = 2
+size = 1
+salt = "abc"
+gc = 1
+passlen = 8
+scrypt = 10
+shutdown = 1
+
+[settings.argon]
+time = 1
+memory = 1
+threads = 1
+`
+
+func newConfig(t *testing.T) *config.Config {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "config.toml")
+	content := strings.ReplaceAll(testConfig, "{dir}", dir)
+	if err := os.WriteFile(fileName, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.New(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if e := cfg.Close(); e != nil {
+			t.Error(e)
+		}
+	})
+	return cfg
+}
+
+func uploadFile(t *testing.T, server *httptest.Server, content []byte) (*http.Response, *uploadResponse) {
+	return uploadFileWith(t, server, content, nil)
+}
+
+func uploadFileWith(
+	t *testing.T, server *httptest.Server, content []byte, fields url.Values,
+) (*http.Response, *uploadResponse) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for name, values := range fields {
+		for _, value := range values {
+			if err := w.WriteField(name, value); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	part, err := w.CreateFormFile(fileField, "test.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = part.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(server.URL+"/upload", w.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	result := &uploadResponse{}
+	if resp.StatusCode == http.StatusCreated {
+		if err = json.NewDecoder(resp.Body).Decode(result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp, result
+}
+
+func download(t *testing.T, server *httptest.Server, id, password string) (*http.Response, []byte) {
+	return downloadWith(t, server, id, password, nil)
+}
+
+func downloadWith(t *testing.T, server *httptest.Server, id, password string, header http.Header) (*http.Response, []byte) {
+	values := url.Values{"id": {id}, "password": {password}}
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/download", strings.NewReader(values.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key := range header {
+		req.Header.Set(key, header.Get(key))
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+func TestUploadDownload(t *testing.T) {
+	cfg := newConfig(t)
+	server := httptest.NewServer(New(cfg))
+	defer server.Close()
+
+	content := []byte("some secret content")
+	resp, result := uploadFile(t, server, content)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("failed upload status=%d", resp.StatusCode)
+	}
+	if result.ID == "" || len(result.Password) != cfg.Settings.PassLen {
+		t.Errorf("failed upload result=%+v", result)
+	}
+
+	resp, body := download(t, server, result.ID, result.Password)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed download status=%d", resp.StatusCode)
+	}
+	if !bytes.Equal(body, content) {
+		t.Errorf("failed download content=%s", body)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Errorf("failed content type=%s", ct)
+	}
+	if cd := resp.Header.Get("Content-Disposition"); cd != `attachment; filename=test.txt` {
+		t.Errorf("failed content disposition=%s", cd)
+	}
+	if n := resp.ContentLength; n != int64(len(content)) {
+		t.Errorf("failed content length=%d", n)
+	}
+	// GET request with query parameters
+	values := url.Values{"id": {result.ID}, "password": {result.Password}}
+	getResp, err := http.Get(server.URL + "/download?" + values.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err = io.ReadAll(getResp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = getResp.Body.Close(); err != nil {
+		t.Error(err)
+	}
+	if !bytes.Equal(body, content) {
+		t.Errorf("failed download content=%s", body)
+	}
+}
+
+func TestUploadMeta(t *testing.T) {
+	cfg := newConfig(t)
+	server := httptest.NewServer(New(cfg))
+	defer server.Close()
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="file"; filename="../dir/отчёт.csv"`)
+	header.Set("Content-Type", "text/csv")
+	part, err := w.CreatePart(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = part.Write([]byte("a,b\n1,2\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(server.URL+"/upload", w.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := &uploadResponse{}
+	if err = json.NewDecoder(resp.Body).Decode(result); err != nil {
+		t.Fatal(err)
+	}
+	if err = resp.Body.Close(); err != nil {
+		t.Error(err)
+	}
+	var meta string
+	err = cfg.Storage.Db.QueryRow("SELECT `meta` FROM `ssf` WHERE `id`=?;", result.ID).Scan(&meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta == "" || strings.Contains(meta, "csv") {
+		t.Errorf("failed stored meta=%s", meta)
+	}
+	resp, _ = download(t, server, result.ID, result.Password)
+	if ct := resp.Header.Get("Content-Type"); ct != "text/csv" {
+		t.Errorf("failed content type=%s", ct)
+	}
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := params["filename"]; name != "отчёт.csv" {
+		t.Errorf("failed file name=%s", name)
+	}
+}
+
+func TestDownloadError(t *testing.T) {
+	cfg := newConfig(t)
+	server := httptest.NewServer(New(cfg))
+	defer server.Close()
+
+	_, result := uploadFile(t, server, []byte("content"))
+	cases := []struct {
+		id, password string
+		code         int
+	}{
+		{id: result.ID, password: "bad", code: http.StatusForbidden},
+		{id: "unknown", password: result.Password, code: http.StatusNotFound},
+		{id: result.ID, password: "", code: http.StatusBadRequest},
+	}
+	for i, c := range cases {
+		resp, body := download(t, server, c.id, c.password)
+		if resp.StatusCode != c.code {
+			t.Errorf("failed case=%d status=%d, body=%s", i, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestUploadError(t *testing.T) {
+	cfg := newConfig(t)
+	server := httptest.NewServer(New(cfg))
+	defer server.Close()
+
+	if resp, _ := uploadFile(t, server, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("failed empty file status=%d", resp.StatusCode)
+	}
+	big := bytes.Repeat([]byte{'a'}, cfg.MaxFileSize()+1)
+	if resp, _ := uploadFile(t, server, big); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("failed big file status=%d", resp.StatusCode)
+	}
+	entries, err := os.ReadDir(cfg.Storage.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(entries); n != 0 {
+		t.Errorf("unexpected files=%d", n)
+	}
+	resp, err := http.Get(server.URL + "/upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = resp.Body.Close(); err != nil {
+		t.Error(err)
+	}
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("failed method status=%d", resp.StatusCode)
+	}
+}
+
+func TestDownloadLimit(t *testing.T) {
+	const n = 5
+	cfg := newConfig(t)
+	server := httptest.NewServer(New(cfg))
+	defer server.Close()
+
+	content := []byte("burn after reading")
+	resp, result := uploadFileWith(t, server, content, url.Values{timesField: {"1"}, ttlField: {"10"}})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("failed upload status=%d", resp.StatusCode)
+	}
+	if result.Times != 1 || time.Until(result.Expired) > 10*time.Second {
+		t.Errorf("failed upload result=%+v", result)
+	}
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		success int
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, body := download(t, server, result.ID, result.Password)
+			switch r.StatusCode {
+			case http.StatusOK:
+				if !bytes.Equal(body, content) {
+					t.Errorf("failed download content=%s", body)
+				}
+				mu.Lock()
+				success++
+				mu.Unlock()
+			case http.StatusNotFound, http.StatusGone:
+			default:
+				t.Errorf("unexpected status=%d", r.StatusCode)
+			}
+		}()
+	}
+	wg.Wait()
+	if success != 1 {
+		t.Errorf("failed success downloads=%d", success)
+	}
+	entries, err := os.ReadDir(cfg.Storage.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k := len(entries); k != 0 {
+		t.Errorf("used file is not deleted, files=%d", k)
+	}
+	if limit, reserved := cfg.Storage.Used(); limit != 0 || reserved != 0 {
+		t.Errorf("failed storage limit=%d, reserved=%d", limit, reserved)
+	}
+}
+
+func TestUploadParamsError(t *testing.T) {
+	cfg := newConfig(t)
+	server := httptest.NewServer(New(cfg))
+	defer server.Close()
+
+	cases := []url.Values{
+		{ttlField: {"0"}},
+		{ttlField: {fmt.Sprint(cfg.Settings.TTL + 1)}},
+		{timesField: {"0"}},
+		{timesField: {fmt.Sprint(cfg.Settings.Times + 1)}},
+		{timesField: {"abc"}},
+		{passwordField: {"short"}},
+		{passwordField: {strings.Repeat("a", maxFieldSize+1)}},
+	}
+	for i, c := range cases {
+		if resp, _ := uploadFileWith(t, server, []byte("content"), c); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("failed status=%d for case=%d", resp.StatusCode, i)
+		}
+	}
+}
+
+func TestDownloadModified(t *testing.T) {
+	cfg := newConfig(t)
+	server := httptest.NewServer(New(cfg))
+	defer server.Close()
+
+	_, result := uploadFile(t, server, bytes.Repeat([]byte("content"), 100))
+	entries, err := os.ReadDir(cfg.Storage.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(entries); n != 1 {
+		t.Fatalf("unexpected files=%d", n)
+	}
+	fileName := filepath.Join(cfg.Storage.Dir, entries[0].Name())
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 1
+	if err = os.WriteFile(fileName, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	resp, body := download(t, server, result.ID, result.Password)
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("failed status=%d", resp.StatusCode)
+	}
+	if cd := resp.Header.Get("Content-Disposition"); cd != "" {
+		t.Errorf("unexpected content disposition=%s", cd)
+	}
+	if !bytes.Contains(body, []byte("error")) {
+		t.Errorf("unexpected body=%s", body)
+	}
+	// not served content is not counted as a download
+	item, err := db.Get(context.Background(), cfg.Storage.Db, result.ID, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Number != 0 {
+		t.Errorf("failed download number=%d", item.Number)
+	}
+}
+
+func TestUploadPassword(t *testing.T) {
+	const password = "user password"
+	cfg := newConfig(t)
+	server := httptest.NewServer(New(cfg))
+	defer server.Close()
+
+	content := []byte("content")
+	resp, result := uploadFileWith(t, server, content, url.Values{passwordField: {password}})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("failed upload status=%d", resp.StatusCode)
+	}
+	if result.Password != password {
+		t.Errorf("failed password=%q", result.Password)
+	}
+	r, body := download(t, server, result.ID, password)
+	if r.StatusCode != http.StatusOK || !bytes.Equal(body, content) {
+		t.Errorf("failed download status=%d", r.StatusCode)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	cfg := newConfig(t)
+	server := httptest.NewServer(New(cfg))
+	defer server.Close()
+
+	resp, result := uploadFile(t, server, []byte("content"))
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("failed upload status=%d", resp.StatusCode)
+	}
+	remove := func(id, password string) int {
+		r, err := http.PostForm(server.URL+"/delete", url.Values{"id": {id}, "password": {password}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = r.Body.Close(); err != nil {
+			t.Error(err)
+		}
+		return r.StatusCode
+	}
+	if code := remove(result.ID, ""); code != http.StatusBadRequest {
+		t.Errorf("failed status=%d without password", code)
+	}
+	if code := remove(result.ID, "bad password"); code != http.StatusForbidden {
+		t.Errorf("failed status=%d for wrong password", code)
+	}
+	if code := remove(result.ID, result.Password); code != http.StatusNoContent {
+		t.Errorf("failed delete status=%d", code)
+	}
+	if code := remove(result.ID, result.Password); code != http.StatusNotFound {
+		t.Errorf("failed status=%d for deleted item", code)
+	}
+	entries, err := os.ReadDir(cfg.Storage.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k := len(entries); k != 0 {
+		t.Errorf("deleted file is not removed, files=%d", k)
+	}
+	// notes can be deleted too
+	resp, result = createNote(t, server, "note")
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("failed note status=%d", resp.StatusCode)
+	}
+	if code := remove(result.ID, result.Password); code != http.StatusNoContent {
+		t.Errorf("failed delete status=%d", code)
+	}
+}
+
+func TestUploadGenerator(t *testing.T) {
+	cfg := newConfig(t)
+	server := httptest.NewServer(New(cfg))
+	defer server.Close()
+
+	content := []byte("content")
+	resp, result := uploadFileWith(t, server, content, url.Values{generatorField: {"words"}})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("failed upload status=%d", resp.StatusCode)
+	}
+	if words := strings.Split(result.Password, "-"); len(words) != 6 {
+		t.Errorf("failed passphrase=%q", result.Password)
+	}
+	r, body := download(t, server, result.ID, result.Password)
+	if r.StatusCode != http.StatusOK || !bytes.Equal(body, content) {
+		t.Errorf("failed download status=%d", r.StatusCode)
+	}
+	resp, _ = uploadFileWith(t, server, content, url.Values{generatorField: {"unknown"}})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("failed status=%d for unknown generator", resp.StatusCode)
+	}
+	resp, result = uploadFileWith(t, server, content, url.Values{generatorField: {"symbols"}})
+	if resp.StatusCode != http.StatusCreated || len(result.Password) != cfg.Settings.PassLen {
+		t.Errorf("failed upload status=%d, password=%q", resp.StatusCode, result.Password)
+	}
+}
+
+func TestUploadWeakPassword(t *testing.T) {
+	cfg := newConfig(t)
+	cfg.Settings.Strength = 30
+	server := httptest.NewServer(New(cfg))
+	defer server.Close()
+
+	content := []byte("content")
+	for _, password := range []string{"password123", "qwertyuiop", "13.05.1987", "abcabcabcabc"} {
+		resp, _ := uploadFileWith(t, server, content, url.Values{passwordField: {password}})
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("failed status=%d for weak password %q", resp.StatusCode, password)
+		}
+	}
+	resp, result := uploadFileWith(t, server, content, url.Values{passwordField: {"kZ7vQp2x-W9rT4mNs"}})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("failed upload status=%d", resp.StatusCode)
+	}
+	// generated passwords are not checked
+	if resp, _ = uploadFile(t, server, content); resp.StatusCode != http.StatusCreated {
+		t.Errorf("failed upload status=%d with generated password", resp.StatusCode)
+	}
+	r, body := download(t, server, result.ID, "kZ7vQp2x-W9rT4mNs")
+	if r.StatusCode != http.StatusOK || !bytes.Equal(body, content) {
+		t.Errorf("failed download status=%d", r.StatusCode)
+	}
+}
+
+func TestMasterKeys(t *testing.T) {
+	t.Setenv(config.MasterKeysEnv, "k1:000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	cfg := newConfig(t)
+	server := httptest.NewServer(New(cfg))
+	defer server.Close()
+
+	content := []byte("some secret content")
+	resp, result := uploadFile(t, server, content)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("failed upload status=%d", resp.StatusCode)
+	}
+	resp, noteResult := createNote(t, server, "note")
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("failed note status=%d", resp.StatusCode)
+	}
+	for _, id := range []string{result.ID, noteResult.ID} {
+		item, err := db.Get(context.Background(), cfg.Storage.Db, id, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(item.Msg.WrappedKey, "k1:") || (id == result.ID) != (item.Meta != nil && strings.HasPrefix(item.Meta.WrappedKey, "k1:")) {
+			t.Errorf("failed wrapped keys of item=%+v", item)
+		}
+	}
+	if resp, _ = download(t, server, result.ID, "bad"); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("failed status=%d for bad password", resp.StatusCode)
+	}
+	resp, body := downloadWith(t, server, result.ID, result.Password, http.Header{"Range": {"bytes=5-10"}})
+	if resp.StatusCode != http.StatusPartialContent || !bytes.Equal(body, content[5:11]) {
+		t.Errorf("failed range status=%d, content=%s", resp.StatusCode, body)
+	}
+	if resp, body = download(t, server, result.ID, result.Password); resp.StatusCode != http.StatusOK || !bytes.Equal(body, content) {
+		t.Errorf("failed download status=%d, content=%s", resp.StatusCode, body)
+	}
+	if resp, body = download(t, server, noteResult.ID, noteResult.Password); resp.StatusCode != http.StatusOK || string(body) != "note" {
+		t.Errorf("failed note status=%d, content=%s", resp.StatusCode, body)
+	}
+	r, err := http.PostForm(server.URL+"/delete", url.Values{"id": {noteResult.ID}, "password": {noteResult.Password}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Body.Close(); err != nil {
+		t.Error(err)
+	}
+	if r.StatusCode != http.StatusNoContent {
+		t.Errorf("failed delete status=%d", r.StatusCode)
+	}
+}
+
+func TestUploadCompression(t *testing.T) {
+	cfg := newConfig(t)
+	server := httptest.NewServer(New(cfg))
+	defer server.Close()
+
+	text := bytes.Repeat([]byte("2022-01-01 12:00:00 INFO request is handled\n"), 1000)
+	image := append([]byte("\x89PNG\r\n\x1a\n"), text...)
+	cases := map[string]struct {
+		content  []byte
+		expected encrypt.Compression
+	}{
+		"text":  {content: text, expected: encrypt.CompressionGzip},
+		"image": {content: image, expected: encrypt.CompressionNone},
+	}
+	for name, c := range cases {
+		resp, result := uploadFile(t, server, c.content)
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("failed %s upload status=%d", name, resp.StatusCode)
+		}
+		item, err := db.Get(context.Background(), cfg.Storage.Db, result.ID, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item.Msg.Compression != c.expected {
+			t.Errorf("failed %s compression=%v", name, item.Msg.Compression)
+		}
+		info, err := os.Stat(filepath.Join(cfg.Storage.Dir, item.File))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if compressed := info.Size() < int64(len(c.content)); compressed != (c.expected != encrypt.CompressionNone) {
+			t.Errorf("failed %s stored size=%d", name, info.Size())
+		}
+		r, body := downloadWith(t, server, result.ID, result.Password, http.Header{"Range": {"bytes=100-199"}})
+		if r.StatusCode != http.StatusPartialContent || !bytes.Equal(body, c.content[100:200]) {
+			t.Errorf("failed %s range status=%d", name, r.StatusCode)
+		}
+		if r, body = download(t, server, result.ID, result.Password); r.StatusCode != http.StatusOK || !bytes.Equal(body, c.content) {
+			t.Errorf("failed %s download status=%d", name, r.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package handle
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/z0rr0/ssf/config"
+	"github.com/z0rr0/ssf/db"
+	"github.com/z0rr0/ssf/encrypt"
+)
+
+const (
+	// noteField is a name of form field with note text.
+	noteField = "text"
+	// maxNoteSize is a max size of note text.
+	maxNoteSize = 64 << 10
+	// maxNoteBody is a max size of request body with a note, the form data can be URL-encoded.
+	maxNoteBody = 3*maxNoteSize + multipartOverhead
+)
+
+// errNoteSize is an error when note text is too big.
+var errNoteSize = fmt.Errorf("note size limit %d bytes is reached", maxNoteSize)
+
+// Note encrypts a text note from the form and stores it.
+// It returns item ID and the password, the note can be read by Download handler.
+func Note(w http.ResponseWriter, r *http.Request, cfg *config.Config) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxNoteBody)
+	value := r.PostFormValue(noteField)
+	switch n := len(value); {
+	case n == 0:
+		return newError(http.StatusBadRequest, fmt.Errorf("empty form field %q", noteField))
+	case n > maxNoteSize:
+		return newError(http.StatusRequestEntityTooLarge, errNoteSize)
+	}
+	if len(r.Form[recipientField]) > 0 {
+		return newError(http.StatusBadRequest, errNoteRecipients)
+	}
+	if r.Form.Get(formatField) != "" {
+		return newError(http.StatusBadRequest, errNoteFormat)
+	}
+	params, err := newShareParams(cfg, r.Form)
+	if err != nil {
+		return err
+	}
+	if err = params.split(r.Form); err != nil {
+		return err
+	}
+	id := uuid.New().String()
+	m, err := encrypt.Text(cfg.Secret(params.password), value, options(cfg, id))
+	if err != nil {
+		return err
+	}
+	item := db.NewNote(id, m, time.Now(), params.ttl, params.times)
+	if err = item.Save(r.Context(), cfg.Storage.Db); err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusCreated, newUploadResponse(item, params))
+	return nil
+}
+
+// readNote writes decrypted note text.
+// The note is deleted when it is read max number of times.
+func readNote(w http.ResponseWriter, r *http.Request, cfg *config.Config, item *db.Item, secret string) error {
+	value, err := encrypt.DecryptText(secret, item.Msg, options(cfg, item.ID))
+	if err != nil {
+		return err
+	}
+	last, err := db.Use(r.Context(), cfg.Storage.Db, item.ID, time.Now())
+	if err != nil {
+		return err
+	}
+	if last {
+		removeUsed(cfg, item)
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	_, err = w.Write([]byte(value))
+	return err
+}
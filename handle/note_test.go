@@ -0,0 +1,116 @@
+package handle
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func createNote(t *testing.T, server *httptest.Server, text string) (*http.Response, *uploadResponse) {
+	resp, err := http.PostForm(server.URL+"/note", url.Values{noteField: {text}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	result := &uploadResponse{}
+	if resp.StatusCode == http.StatusCreated {
+		if err = json.NewDecoder(resp.Body).Decode(result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp, result
+}
+
+func TestNote(t *testing.T) {
+	const text = "login: user\npassword: secret"
+	cfg := newConfig(t)
+	server := httptest.NewServer(New(cfg))
+	defer server.Close()
+
+	resp, result := createNote(t, server, text)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("failed create status=%d", resp.StatusCode)
+	}
+	var stored string
+	err := cfg.Storage.Db.QueryRow("SELECT `file` FROM `ssf` WHERE `id`=?;", result.ID).Scan(&stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(stored, "secret") {
+		t.Errorf("note is stored as plain text: %s", stored)
+	}
+	if resp, _ = download(t, server, result.ID, "bad"); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("failed status=%d for bad password", resp.StatusCode)
+	}
+	for i := 0; i < cfg.Settings.Times; i++ {
+		resp, body := download(t, server, result.ID, result.Password)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("failed read=%d status=%d", i, resp.StatusCode)
+		}
+		if s := string(body); s != text {
+			t.Errorf("failed read=%d note=%s", i, s)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+			t.Errorf("failed content type=%s", ct)
+		}
+	}
+	// the note is deleted after max number of reads
+	if resp, _ = download(t, server, result.ID, result.Password); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("failed status=%d for deleted note", resp.StatusCode)
+	}
+}
+
+func TestNoteError(t *testing.T) {
+	cfg := newConfig(t)
+	server := httptest.NewServer(New(cfg))
+	defer server.Close()
+
+	if resp, _ := createNote(t, server, ""); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("failed empty note status=%d", resp.StatusCode)
+	}
+	big := strings.Repeat("a", maxNoteSize+1)
+	if resp, _ := createNote(t, server, big); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("failed big note status=%d", resp.StatusCode)
+	}
+}
+
+func TestNoteWeakPassword(t *testing.T) {
+	cfg := newConfig(t)
+	cfg.Settings.Strength = 30
+	server := httptest.NewServer(New(cfg))
+	defer server.Close()
+
+	resp, err := http.PostForm(server.URL+"/note", url.Values{noteField: {"text"}, passwordField: {"iloveyou2020"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("failed status=%d", resp.StatusCode)
+	}
+	result := &errorResponse{}
+	if err = json.NewDecoder(resp.Body).Decode(result); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(result.Error, "weak password: estimated strength") {
+		t.Errorf("failed error=%q", result.Error)
+	}
+	details := result.Password
+	if details == nil {
+		t.Fatal("no password details")
+	}
+	if details.Required != 30 || details.Entropy >= 30 || details.Warning == "" {
+		t.Errorf("failed details=%+v", details)
+	}
+	patterns := strings.Join(details.Patterns, ",")
+	if !strings.Contains(patterns, "common password") || !strings.Contains(patterns, "date") {
+		t.Errorf("failed patterns=%v", details.Patterns)
+	}
+}
@@ -0,0 +1,114 @@
+package handle
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/z0rr0/ssf/db"
+	"github.com/z0rr0/ssf/encrypt"
+)
+
+// rangeUnit is the only supported unit of HTTP Range header.
+const rangeUnit = "bytes="
+
+// errRange is an error when requested range is not satisfiable.
+var errRange = errors.New("range is not satisfiable")
+
+// byteRange is a requested part of file content.
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+// contentRange returns Content-Range header value for content with the size.
+func (br *byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", br.start, br.start+br.length-1, size)
+}
+
+// parseRange returns a single byte range from Range header value s for content with the size.
+// Nil range is returned if the header should be ignored and full content is expected:
+// it is empty, invalid, has several ranges or covers the whole content.
+func parseRange(s string, size int64) (*byteRange, error) {
+	if !strings.HasPrefix(s, rangeUnit) {
+		return nil, nil
+	}
+	s = strings.TrimSpace(s[len(rangeUnit):])
+	i := strings.Index(s, "-")
+	if i < 0 || strings.Contains(s, ",") {
+		return nil, nil
+	}
+	first, last := strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:])
+	br := &byteRange{}
+	if first == "" {
+		// suffix range "-n" is last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return nil, nil
+		}
+		if n == 0 || size == 0 {
+			return nil, errRange
+		}
+		if n > size {
+			n = size
+		}
+		br.start, br.length = size-n, n
+	} else {
+		start, err := strconv.ParseInt(first, 10, 64)
+		if err != nil || start < 0 {
+			return nil, nil
+		}
+		end := size - 1
+		if last != "" {
+			end, err = strconv.ParseInt(last, 10, 64)
+			if err != nil || end < start {
+				return nil, nil
+			}
+		}
+		if start >= size {
+			return nil, errRange
+		}
+		if end >= size {
+			end = size - 1
+		}
+		br.start, br.length = start, end-start+1
+	}
+	if br.start == 0 && br.length == size {
+		return nil, nil
+	}
+	return br, nil
+}
+
+// fileETag returns a strong entity tag of the item file, it's changed only with file content.
+func fileETag(item *db.Item) string {
+	return strconv.Quote(hex.EncodeToString(encrypt.Hash([]byte(item.ID + item.Msg.DataHash))))
+}
+
+// fileRange sets range related response headers and returns requested byte range of the file.
+// Nil range means that full content should be sent, ranges are supported only for items with known size.
+// Range is ignored if If-Range header doesn't match to the current entity tag.
+func fileRange(w http.ResponseWriter, r *http.Request, item *db.Item, meta *encrypt.Meta) (*byteRange, error) {
+	if meta == nil {
+		return nil, nil
+	}
+	etag := fileETag(item)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	value := r.Header.Get("Range")
+	if value == "" {
+		return nil, nil
+	}
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" && ifRange != etag {
+		return nil, nil
+	}
+	br, err := parseRange(value, meta.Size)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", meta.Size))
+		return nil, newError(http.StatusRequestedRangeNotSatisfiable, err)
+	}
+	return br, nil
+}
@@ -0,0 +1,118 @@
+package handle
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	const size = 100
+	cases := []struct {
+		value string
+		br    *byteRange
+		err   error
+	}{
+		{value: ""},
+		{value: "items=0-10"},
+		{value: "bytes=0-10,20-30"},
+		{value: "bytes=abc-10"},
+		{value: "bytes=10-5"},
+		{value: "bytes=0-"},
+		{value: "bytes=0-99"},
+		{value: "bytes=-100"},
+		{value: "bytes=-200"},
+		{value: "bytes=0-10", br: &byteRange{start: 0, length: 11}},
+		{value: "bytes=90-", br: &byteRange{start: 90, length: 10}},
+		{value: "bytes=90-1000", br: &byteRange{start: 90, length: 10}},
+		{value: "bytes=-5", br: &byteRange{start: 95, length: 5}},
+		{value: "bytes= 10 - 19", br: &byteRange{start: 10, length: 10}},
+		{value: "bytes=100-", err: errRange},
+		{value: "bytes=-0", err: errRange},
+	}
+	for _, c := range cases {
+		br, err := parseRange(c.value, size)
+		if !errors.Is(err, c.err) {
+			t.Errorf("unexpected error for %q: %v", c.value, err)
+			continue
+		}
+		switch {
+		case br == nil && c.br == nil:
+		case br == nil || c.br == nil || *br != *c.br:
+			t.Errorf("failed range for %q: %+v", c.value, br)
+		}
+	}
+}
+
+func TestDownloadRange(t *testing.T) {
+	cfg := newConfig(t)
+	server := httptest.NewServer(New(cfg))
+	defer server.Close()
+
+	content := make([]byte, 200<<10)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	resp, result := uploadFile(t, server, content)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("failed upload status=%d", resp.StatusCode)
+	}
+	size := len(content)
+	// partial reads are not counted, configuration allows only 2 downloads
+	for i := 0; i < 3; i++ {
+		header := http.Header{"Range": {"bytes=65530-131080"}}
+		r, body := downloadWith(t, server, result.ID, result.Password, header)
+		if r.StatusCode != http.StatusPartialContent {
+			t.Fatalf("failed status=%d", r.StatusCode)
+		}
+		if !bytes.Equal(body, content[65530:131081]) {
+			t.Error("failed range content")
+		}
+		if v, expected := r.Header.Get("Content-Range"), fmt.Sprintf("bytes 65530-131080/%d", size); v != expected {
+			t.Errorf("failed content range=%q", v)
+		}
+		if r.Header.Get("Accept-Ranges") != "bytes" || r.Header.Get("ETag") == "" {
+			t.Errorf("failed range headers=%v", r.Header)
+		}
+	}
+	r, body := downloadWith(t, server, result.ID, result.Password, http.Header{"Range": {"bytes=-10"}})
+	if r.StatusCode != http.StatusPartialContent || !bytes.Equal(body, content[size-10:]) {
+		t.Errorf("failed suffix range status=%d", r.StatusCode)
+	}
+	etag := r.Header.Get("ETag")
+
+	r, _ = downloadWith(t, server, result.ID, result.Password, http.Header{"Range": {fmt.Sprintf("bytes=%d-", size)}})
+	if r.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("failed not satisfiable range status=%d", r.StatusCode)
+	}
+	if v, expected := r.Header.Get("Content-Range"), fmt.Sprintf("bytes */%d", size); v != expected {
+		t.Errorf("failed content range=%q", v)
+	}
+	r, _ = downloadWith(t, server, result.ID, "bad password", http.Header{"Range": {"bytes=0-10"}})
+	if r.StatusCode != http.StatusForbidden {
+		t.Errorf("failed status=%d for wrong password", r.StatusCode)
+	}
+	// the range is ignored for another entity tag, full download is counted
+	header := http.Header{"Range": {"bytes=0-10"}, "If-Range": {`"other"`}}
+	r, body = downloadWith(t, server, result.ID, result.Password, header)
+	if r.StatusCode != http.StatusOK || !bytes.Equal(body, content) {
+		t.Errorf("failed status=%d for another entity tag", r.StatusCode)
+	}
+	header = http.Header{"Range": {"bytes=0-10"}, "If-Range": {etag}}
+	r, body = downloadWith(t, server, result.ID, result.Password, header)
+	if r.StatusCode != http.StatusPartialContent || !bytes.Equal(body, content[:11]) {
+		t.Errorf("failed status=%d for entity tag", r.StatusCode)
+	}
+	// the last allowed download
+	r, body = download(t, server, result.ID, result.Password)
+	if r.StatusCode != http.StatusOK || !bytes.Equal(body, content) {
+		t.Errorf("failed status=%d", r.StatusCode)
+	}
+	r, _ = downloadWith(t, server, result.ID, result.Password, http.Header{"Range": {"bytes=0-10"}})
+	if r.StatusCode != http.StatusNotFound {
+		t.Errorf("failed status=%d after the last download", r.StatusCode)
+	}
+}
@@ -0,0 +1,148 @@
+package handle
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/z0rr0/ssf/config"
+	"github.com/z0rr0/ssf/db"
+	"github.com/z0rr0/ssf/encrypt"
+	"github.com/z0rr0/ssf/encrypt/age"
+	"github.com/z0rr0/ssf/encrypt/stream"
+)
+
+const (
+	// rawPath is a URL path of files encrypted by clients.
+	rawPath = "/raw"
+	// e2ePath is a URL path of the browser page which encrypts and decrypts raw files.
+	e2ePath = "/e2e"
+)
+
+// errRawItem is an error when a file encrypted by a client is requested with a password.
+var errRawItem = errors.New("item is encrypted by a client, use " + rawPath + " without password")
+
+// Raw stores a file encrypted by a client (POST) or returns it by ID (GET).
+// The server doesn't know the key, it only checks the stream or age format and controls TTL and downloads.
+// Stored files are returned unchanged.
+func Raw(w http.ResponseWriter, r *http.Request, cfg *config.Config) error {
+	if r.Method == http.MethodPost {
+		return rawUpload(w, r, cfg)
+	}
+	return rawDownload(w, r, cfg)
+}
+
+// rawUpload saves request body as an encrypted stream. Optional item parameters (ttl and times)
+// can be set as URL query parameters. It returns item ID without a password.
+func rawUpload(w http.ResponseWriter, r *http.Request, cfg *config.Config) error {
+	body := bufio.NewReader(r.Body)
+	maxSize, err := rawMaxSize(cfg, body)
+	if err != nil {
+		return err
+	}
+	if r.ContentLength > maxSize {
+		return errFileSize
+	}
+	values := r.URL.Query()
+	values.Del(passwordField)
+	params, err := newShareParams(cfg, values)
+	if err != nil {
+		return err
+	}
+	reservation, err := cfg.Storage.Reserve(reserveSize(r, maxSize))
+	if err != nil {
+		return err
+	}
+	defer reservation.Rollback()
+
+	m, err := encrypt.Raw(&limitReader{r: body, n: maxSize}, cfg.Storage.Dir)
+	if err != nil {
+		return err
+	}
+	size, err := fileSize(m.Value)
+	if err != nil {
+		return removeFile(m.Value, err)
+	}
+	if err = reservation.Commit(size); err != nil {
+		return removeFile(m.Value, err)
+	}
+	item := db.NewRaw(uuid.New().String(), m, time.Now(), params.ttl, params.times)
+	if err = item.Save(r.Context(), cfg.Storage.Db); err != nil {
+		return releaseFile(cfg, m.Value, size, err)
+	}
+	writeJSON(w, http.StatusCreated, newUploadResponse(item, nil))
+	return nil
+}
+
+// rawMaxSize returns a max size of the file encrypted by a client, its format is detected by the first bytes.
+// Age files can have headers up to age.MaxHeaderSize.
+func rawMaxSize(cfg *config.Config, body *bufio.Reader) (int64, error) {
+	size := int64(cfg.MaxFileSize())
+	prefix, err := body.Peek(len(age.Magic))
+	if err != nil && err != io.EOF {
+		return 0, fmt.Errorf("read body: %w", err)
+	}
+	if string(prefix) == age.Magic {
+		return age.EncryptedSize(age.MaxHeaderSize, size), nil
+	}
+	return stream.EncryptedSize(size), nil
+}
+
+// rawDownload writes encrypted stream of the item by its ID, every download is counted.
+// Files with recipients are returned too, their keys are wrapped to the recipients.
+// If such file has a password, it's required in Ssf-Password header.
+func rawDownload(w http.ResponseWriter, r *http.Request, cfg *config.Config) error {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		return newError(http.StatusBadRequest, errors.New("id is required"))
+	}
+	item, err := db.Get(r.Context(), cfg.Storage.Db, id, time.Now())
+	if err != nil {
+		return err
+	}
+	switch {
+	case item.Kind == db.KindRaw, item.Kind == db.KindRecipients && hasRecipients(cfg, item):
+	case hasRecipients(cfg, item):
+		password := r.Header.Get(passwordHeader)
+		if password == "" {
+			return newError(http.StatusBadRequest, fmt.Errorf("header %s is required", passwordHeader))
+		}
+		err = encrypt.CheckSecret(cfg.Secret(password), item.FileMsg(cfg.Storage.Dir), options(cfg, item.ID))
+		if err != nil {
+			return err
+		}
+	default:
+		return db.ErrNotFound
+	}
+	// the file is opened before the download is counted, it can be read after the item removal
+	f, err := os.Open(item.FileMsg(cfg.Storage.Dir).Value)
+	if err != nil {
+		return openError(fmt.Errorf("open raw file: %w", err))
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("stat raw file: %w", err)
+	}
+	last, err := db.Use(r.Context(), cfg.Storage.Db, item.ID, time.Now())
+	if err != nil {
+		return err
+	}
+	if last {
+		defer removeUsed(cfg, item)
+	}
+	w.Header().Set("Content-Type", defaultContentType)
+	w.Header().Set("Content-Length", fmt.Sprint(info.Size()))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	_, err = io.Copy(w, f)
+	return err
+}
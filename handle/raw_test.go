@@ -0,0 +1,232 @@
+package handle
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/z0rr0/ssf/db"
+	"github.com/z0rr0/ssf/encrypt/stream"
+)
+
+func uploadRaw(t *testing.T, server *httptest.Server, body []byte, values url.Values) (*http.Response, *uploadResponse) {
+	resp, err := http.Post(server.URL+rawPath+"?"+values.Encode(), defaultContentType, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	result := &uploadResponse{}
+	if resp.StatusCode == http.StatusCreated {
+		if err = json.NewDecoder(resp.Body).Decode(result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp, result
+}
+
+func downloadRaw(t *testing.T, server *httptest.Server, id string) (*http.Response, []byte) {
+	return downloadRawWith(t, server, id, "")
+}
+
+func downloadRawWith(t *testing.T, server *httptest.Server, id, password string) (*http.Response, []byte) {
+	req, err := http.NewRequest(http.MethodGet, server.URL+rawPath+"?id="+url.QueryEscape(id), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if password != "" {
+		req.Header.Set(passwordHeader, password)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+func encryptRaw(t *testing.T, content, key []byte) []byte {
+	var buf bytes.Buffer
+	if err := stream.Encrypt(bytes.NewReader(content), &buf, key); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRaw(t *testing.T) {
+	cfg := newConfig(t)
+	server := httptest.NewServer(New(cfg))
+	defer server.Close()
+
+	key := bytes.Repeat([]byte{7}, 32)
+	content := bytes.Repeat([]byte("raw content "), 10000)
+	encrypted := encryptRaw(t, content, key)
+
+	resp, result := uploadRaw(t, server, encrypted, url.Values{"times": {"2"}, "password": {"ignored password"}})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("failed upload status=%d", resp.StatusCode)
+	}
+	if result.Password != "" || result.Times != 2 {
+		t.Errorf("failed response %+v", result)
+	}
+	item, err := db.Get(context.Background(), cfg.Storage.Db, result.ID, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Msg != nil || item.Meta != nil {
+		t.Errorf("failed raw item=%+v", item)
+	}
+	// the item has no password
+	if resp, _ = download(t, server, result.ID, "password"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("failed download status=%d", resp.StatusCode)
+	}
+	resp, err = http.PostForm(server.URL+"/delete", url.Values{"id": {result.ID}, "password": {"password"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = resp.Body.Close(); err != nil {
+		t.Error(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("failed delete status=%d", resp.StatusCode)
+	}
+	for i := 0; i < 2; i++ {
+		resp, body := downloadRaw(t, server, result.ID)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("failed download=%d status=%d", i, resp.StatusCode)
+		}
+		if !bytes.Equal(body, encrypted) {
+			t.Errorf("failed download=%d content", i)
+		}
+		var plain bytes.Buffer
+		if err = stream.Decrypt(bytes.NewReader(body), &plain, key); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(plain.Bytes(), content) {
+			t.Errorf("failed download=%d decrypted content", i)
+		}
+	}
+	if resp, _ = downloadRaw(t, server, result.ID); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("failed status=%d for used item", resp.StatusCode)
+	}
+	files, err := filepath.Glob(filepath.Join(cfg.Storage.Dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("failed files %v", files)
+	}
+}
+
+func TestRawError(t *testing.T) {
+	cfg := newConfig(t)
+	server := httptest.NewServer(New(cfg))
+	defer server.Close()
+
+	key := bytes.Repeat([]byte{7}, 32)
+	encrypted := encryptRaw(t, []byte("content"), key)
+	empty := encryptRaw(t, nil, key)
+	big := make([]byte, stream.EncryptedSize(int64(cfg.MaxFileSize()))+1)
+	copy(big, encrypted[:stream.HeaderSize])
+
+	testCases := []struct {
+		name   string
+		body   []byte
+		values url.Values
+		code   int
+	}{
+		{name: "empty body", code: http.StatusBadRequest},
+		{name: "plain text", body: []byte("plain text content"), code: http.StatusBadRequest},
+		{name: "empty plaintext", body: empty, code: http.StatusBadRequest},
+		{name: "truncated", body: encrypted[:stream.HeaderSize+5], code: http.StatusBadRequest},
+		{name: "big", body: big, code: http.StatusRequestEntityTooLarge},
+		{name: "ttl", body: encrypted, values: url.Values{"ttl": {"0"}}, code: http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if resp, _ := uploadRaw(t, server, tc.body, tc.values); resp.StatusCode != tc.code {
+				t.Errorf("failed status=%d, expected %d", resp.StatusCode, tc.code)
+			}
+		})
+	}
+	files, err := os.ReadDir(cfg.Storage.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("failed files count %d", len(files))
+	}
+	// notes and password protected files are not returned as raw ones
+	_, result := createNote(t, server, "text")
+	if resp, _ := downloadRaw(t, server, result.ID); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("failed status=%d for note", resp.StatusCode)
+	}
+	if resp, _ := downloadRaw(t, server, ""); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("failed status=%d for empty id", resp.StatusCode)
+	}
+}
+
+func TestStatic(t *testing.T) {
+	cfg := newConfig(t)
+	server := httptest.NewServer(New(cfg))
+	defer server.Close()
+
+	testCases := []struct {
+		path        string
+		code        int
+		contentType string
+		contains    string
+	}{
+		{path: e2ePath + "?id=abc", code: http.StatusOK, contentType: "text/html", contains: "/static/e2e.js"},
+		{path: staticPath + "e2e.js", code: http.StatusOK, contentType: "text/javascript", contains: "ssf stream"},
+		{path: staticPath + "style.css", code: http.StatusOK, contentType: "text/css"},
+		{path: staticPath, code: http.StatusNotFound},
+		{path: staticPath + "unknown.js", code: http.StatusNotFound},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			resp, err := http.Get(server.URL + tc.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = resp.Body.Close()
+			}()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tc.code {
+				t.Fatalf("failed status=%d, expected %d", resp.StatusCode, tc.code)
+			}
+			if tc.code != http.StatusOK {
+				return
+			}
+			if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, tc.contentType) {
+				t.Errorf("failed content type=%s", ct)
+			}
+			if csp := resp.Header.Get("Content-Security-Policy"); csp != contentSecurityPolicy {
+				t.Errorf("failed content security policy=%s", csp)
+			}
+			if !bytes.Contains(body, []byte(tc.contains)) {
+				t.Errorf("failed body, it doesn't contain %q", tc.contains)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package handle
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/z0rr0/ssf/config"
+	"github.com/z0rr0/ssf/db"
+	"github.com/z0rr0/ssf/encrypt"
+	"github.com/z0rr0/ssf/encrypt/recipient"
+)
+
+const (
+	// recipientsPath is a URL path of recipient stanzas of files.
+	recipientsPath = "/recipients"
+	// recipientField is a name of form field with X25519 public key "age1...", it can be repeated.
+	recipientField = "recipient"
+	// maxRecipients is a max number of recipients of one file.
+	maxRecipients = 16
+)
+
+// errNoteRecipients is an error when recipients are set for a note.
+var errNoteRecipients = fmt.Errorf("field %q can be used only for files", recipientField)
+
+// addRecipients sets public keys to wrap the file key. If there are no password, generator or shares fields,
+// only recipients can decrypt the file, its password is random and it's not returned.
+func (p *shareParams) addRecipients(values url.Values) error {
+	keys := values[recipientField]
+	if len(keys) == 0 {
+		return nil
+	}
+	if len(keys) > maxRecipients {
+		return newError(
+			http.StatusBadRequest, fmt.Errorf("too many %q fields, max is %d", recipientField, maxRecipients),
+		)
+	}
+	p.recipients = make([]*recipient.Recipient, len(keys))
+	for i, key := range keys {
+		r, err := recipient.ParseRecipient(key)
+		if err != nil {
+			return newError(http.StatusBadRequest, err)
+		}
+		p.recipients[i] = r
+	}
+	if p.shares != nil || values.Get(passwordField) != "" || values.Get(generatorField) != "" {
+		return nil
+	}
+	key, err := encrypt.Random(dataKeySize)
+	if err != nil {
+		return fmt.Errorf("random data key: %w", err)
+	}
+	p.password, p.hidden = hex.EncodeToString(key), true
+	return nil
+}
+
+// storedSize returns a size of the encrypted file with its recipient stanzas.
+func storedSize(name string) (int64, error) {
+	size, err := fileSize(name)
+	if err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(encrypt.RecipientsFile(name))
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return size, nil
+	case err != nil:
+		return 0, fmt.Errorf("stat recipients file: %w", err)
+	}
+	return size + info.Size(), nil
+}
+
+// hasRecipients returns true if the item is a file with recipient stanzas.
+func hasRecipients(cfg *config.Config, item *db.Item) bool {
+	if item.Kind != db.KindFile && item.Kind != db.KindRecipients {
+		return false
+	}
+	_, err := os.Stat(encrypt.RecipientsFile(item.FileMsg(cfg.Storage.Dir).Value))
+	return err == nil
+}
+
+// Recipients writes recipient stanzas of the file by its ID, they are not counted as downloads.
+// A recipient unwraps the file key by the private key and decrypts the stream from Raw handler.
+func Recipients(w http.ResponseWriter, r *http.Request, cfg *config.Config) error {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		return newError(http.StatusBadRequest, errors.New("id is required"))
+	}
+	item, err := db.Get(r.Context(), cfg.Storage.Db, id, time.Now())
+	if err != nil {
+		return err
+	}
+	if item.Kind != db.KindFile && item.Kind != db.KindRecipients {
+		return db.ErrNotFound
+	}
+	stanzas, err := os.ReadFile(encrypt.RecipientsFile(item.FileMsg(cfg.Storage.Dir).Value))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return db.ErrNotFound
+		}
+		return fmt.Errorf("read recipients file: %w", err)
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	_, err = w.Write(stanzas)
+	return err
+}
@@ -0,0 +1,167 @@
+package handle
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/z0rr0/ssf/encrypt/recipient"
+	"github.com/z0rr0/ssf/encrypt/stream"
+)
+
+func getRecipients(t *testing.T, server *httptest.Server, id string) (*http.Response, []*recipient.Stanza) {
+	resp, err := http.Get(server.URL + recipientsPath + "?id=" + url.QueryEscape(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+	stanzas, err := recipient.Unmarshal(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, stanzas
+}
+
+// decryptRecipient returns the item content decrypted by the identity, the password is required if the item has it.
+func decryptRecipient(t *testing.T, server *httptest.Server, id, password string, identity *recipient.Identity) []byte {
+	resp, stanzas := getRecipients(t, server, id)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed recipients status=%d", resp.StatusCode)
+	}
+	key, err := recipient.Unwrap(stanzas, []*recipient.Identity{identity})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, body := downloadRawWith(t, server, id, password)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed raw download status=%d", resp.StatusCode)
+	}
+	var dst bytes.Buffer
+	if err = stream.Decrypt(bytes.NewReader(body), &dst, key); err != nil {
+		t.Fatal(err)
+	}
+	return dst.Bytes()
+}
+
+func TestUploadRecipients(t *testing.T) {
+	cfg := newConfig(t)
+	server := httptest.NewServer(New(cfg))
+	defer server.Close()
+
+	identities := make([]*recipient.Identity, 3)
+	for i := range identities {
+		identity, err := recipient.GenerateIdentity()
+		if err != nil {
+			t.Fatal(err)
+		}
+		identities[i] = identity
+	}
+	content := []byte("content for recipients")
+	fields := url.Values{
+		recipientField: {identities[0].Recipient().String(), identities[1].Recipient().String()},
+		timesField:     {"2"},
+	}
+	resp, result := uploadFileWith(t, server, content, fields)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("failed upload status=%d", resp.StatusCode)
+	}
+	if result.Password != "" || result.Recipients != 2 {
+		t.Fatalf("failed password=%q, recipients=%d", result.Password, result.Recipients)
+	}
+	for i, identity := range identities[:2] {
+		if b := decryptRecipient(t, server, result.ID, "", identity); !bytes.Equal(b, content) {
+			t.Errorf("failed content=%d: %q", i, b)
+		}
+	}
+	// the file is removed after max number of downloads with its recipients
+	if resp, _ = getRecipients(t, server, result.ID); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("failed recipients status=%d for used item", resp.StatusCode)
+	}
+	entries, err := os.ReadDir(cfg.Storage.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(entries); n != 0 {
+		t.Errorf("failed storage files number=%d", n)
+	}
+	if limit, reserved := cfg.Storage.Used(); limit != 0 || reserved != 0 {
+		t.Errorf("failed storage limit=%d, reserved=%d", limit, reserved)
+	}
+	// password and recipients together
+	fields = url.Values{recipientField: {identities[2].Recipient().String()}, passwordField: {"user password"}}
+	resp, result = uploadFileWith(t, server, content, fields)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("failed upload status=%d", resp.StatusCode)
+	}
+	if result.Password != "user password" || result.Recipients != 1 {
+		t.Fatalf("failed password=%q, recipients=%d", result.Password, result.Recipients)
+	}
+	// the stored file is not returned without the password, and it's not counted
+	if r, _ := downloadRaw(t, server, result.ID); r.StatusCode != http.StatusBadRequest {
+		t.Errorf("failed raw download status=%d without password", r.StatusCode)
+	}
+	if r, _ := downloadRawWith(t, server, result.ID, "bad password"); r.StatusCode != http.StatusForbidden {
+		t.Errorf("failed raw download status=%d for wrong password", r.StatusCode)
+	}
+	if b := decryptRecipient(t, server, result.ID, result.Password, identities[2]); !bytes.Equal(b, content) {
+		t.Errorf("failed recipient content: %q", b)
+	}
+	r, body := download(t, server, result.ID, result.Password)
+	if r.StatusCode != http.StatusOK || !bytes.Equal(body, content) {
+		t.Errorf("failed download status=%d", r.StatusCode)
+	}
+}
+
+func TestRecipientsError(t *testing.T) {
+	cfg := newConfig(t)
+	server := httptest.NewServer(New(cfg))
+	defer server.Close()
+
+	content := []byte("content")
+	identity, err := recipient.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []url.Values{
+		{recipientField: {"age1invalid"}},
+		{recipientField: {identity.String()}},
+	}
+	for i, fields := range cases {
+		if resp, _ := uploadFileWith(t, server, content, fields); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("failed case=%d, status=%d", i, resp.StatusCode)
+		}
+	}
+	values := url.Values{noteField: {"text"}, recipientField: {identity.Recipient().String()}}
+	resp, err := http.PostForm(server.URL+"/note", values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = resp.Body.Close(); err != nil {
+		t.Error(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("failed note status=%d", resp.StatusCode)
+	}
+	// files without recipients are not available without the password
+	resp, result := uploadFile(t, server, content)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("failed upload status=%d", resp.StatusCode)
+	}
+	if resp, _ = getRecipients(t, server, result.ID); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("failed recipients status=%d", resp.StatusCode)
+	}
+	if resp, _ = downloadRaw(t, server, result.ID); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("failed raw download status=%d", resp.StatusCode)
+	}
+	if resp, _ = getRecipients(t, server, ""); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("failed recipients status=%d without id", resp.StatusCode)
+	}
+}
@@ -0,0 +1,99 @@
+package handle
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/z0rr0/ssf/encrypt"
+	"github.com/z0rr0/ssf/encrypt/shamir"
+)
+
+const (
+	// sharesField is a name of form field with a number of secret shares, the password is not returned then.
+	sharesField = "shares"
+	// thresholdField is a name of form field with a number of shares to restore the secret.
+	thresholdField = "threshold"
+	// shareField is a name of form field with a share token, it can be repeated.
+	shareField = "share"
+	// maxShares is a max number of shares of one item.
+	maxShares = 16
+	// dataKeySize is a size of random data key which is split into shares.
+	dataKeySize = 32
+)
+
+// errSharesPassword is an error when user's password is set together with shares.
+var errSharesPassword = fmt.Errorf("fields %q and %q can't be used together", passwordField, sharesField)
+
+// split replaces the password by a random data key which is split into shares if their number is set.
+// Any threshold shares restore the key, all shares are required by default.
+func (p *shareParams) split(values url.Values) error {
+	value := values.Get(sharesField)
+	if value == "" {
+		return nil
+	}
+	if values.Get(passwordField) != "" {
+		return newError(http.StatusBadRequest, errSharesPassword)
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < shamir.MinThreshold || n > maxShares {
+		return newError(
+			http.StatusBadRequest,
+			fmt.Errorf("field %q must be in range [%d, %d]", sharesField, shamir.MinThreshold, maxShares),
+		)
+	}
+	k := n
+	if value = values.Get(thresholdField); value != "" {
+		k, err = strconv.Atoi(value)
+		if err != nil || k < shamir.MinThreshold || k > n {
+			return newError(
+				http.StatusBadRequest,
+				fmt.Errorf("field %q must be in range [%d, %d]", thresholdField, shamir.MinThreshold, n),
+			)
+		}
+	}
+	key, err := encrypt.Random(dataKeySize)
+	if err != nil {
+		return fmt.Errorf("random data key: %w", err)
+	}
+	shares, err := shamir.Split(key, n, k)
+	if err != nil {
+		return err
+	}
+	p.password, p.shares = hex.EncodeToString(key), make([]string, n)
+	for i, share := range shares {
+		p.shares[i] = base64.RawURLEncoding.EncodeToString(share)
+	}
+	return nil
+}
+
+// requestPassword returns user's password from form values or restores it from share tokens.
+// An empty string is returned if there are no password and tokens.
+func requestPassword(values url.Values) (string, error) {
+	if password := values.Get(passwordField); password != "" {
+		return password, nil
+	}
+	tokens := values[shareField]
+	if len(tokens) == 0 {
+		return "", nil
+	}
+	if len(tokens) > maxShares {
+		return "", newError(http.StatusBadRequest, fmt.Errorf("too many %q fields, max is %d", shareField, maxShares))
+	}
+	shares := make([][]byte, len(tokens))
+	for i, token := range tokens {
+		share, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil || len(share) != dataKeySize+1 {
+			return "", newError(http.StatusBadRequest, fmt.Errorf("invalid %q field %d", shareField, i+1))
+		}
+		shares[i] = share
+	}
+	key, err := shamir.Combine(shares)
+	if err != nil {
+		return "", newError(http.StatusBadRequest, err)
+	}
+	return hex.EncodeToString(key), nil
+}
@@ -0,0 +1,123 @@
+package handle
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func postShares(t *testing.T, server *httptest.Server, path, id string, shares ...string) (*http.Response, []byte) {
+	resp, err := http.PostForm(server.URL+path, url.Values{"id": {id}, shareField: shares})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+func TestUploadShares(t *testing.T) {
+	cfg := newConfig(t)
+	server := httptest.NewServer(New(cfg))
+	defer server.Close()
+
+	content := []byte("approved content")
+	fields := url.Values{sharesField: {"3"}, thresholdField: {"2"}, timesField: {"2"}}
+	resp, result := uploadFileWith(t, server, content, fields)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("failed upload status=%d", resp.StatusCode)
+	}
+	if result.Password != "" || len(result.Shares) != 3 {
+		t.Fatalf("failed password=%q, shares=%q", result.Password, result.Shares)
+	}
+	shares := result.Shares
+	if shares[0] == shares[1] || shares[1] == shares[2] {
+		t.Errorf("not unique shares %q", shares)
+	}
+	if r, _ := postShares(t, server, "/download", result.ID, shares[0]); r.StatusCode != http.StatusBadRequest {
+		t.Errorf("failed status=%d for one share", r.StatusCode)
+	}
+	if r, _ := postShares(t, server, "/download", result.ID, shares[0], "bad"); r.StatusCode != http.StatusBadRequest {
+		t.Errorf("failed status=%d for invalid share", r.StatusCode)
+	}
+	if r, _ := postShares(t, server, "/download", result.ID, shares[0], shares[0]); r.StatusCode != http.StatusBadRequest {
+		t.Errorf("failed status=%d for duplicate shares", r.StatusCode)
+	}
+	// shares of other item restore a wrong key
+	_, other := uploadFileWith(t, server, content, fields)
+	r, _ := postShares(t, server, "/download", result.ID, other.Shares[0], other.Shares[1])
+	if r.StatusCode != http.StatusForbidden {
+		t.Errorf("failed status=%d for shares of other item", r.StatusCode)
+	}
+	for _, pair := range [][]string{{shares[2], shares[0]}, {shares[0], shares[1], shares[2]}} {
+		r, body := postShares(t, server, "/download", result.ID, pair...)
+		if r.StatusCode != http.StatusOK || !bytes.Equal(body, content) {
+			t.Errorf("failed download status=%d, content=%q", r.StatusCode, body)
+		}
+	}
+	if r, _ := postShares(t, server, "/download", result.ID, shares[1], shares[2]); r.StatusCode != http.StatusNotFound {
+		t.Errorf("failed status=%d for used item", r.StatusCode)
+	}
+	if r, _ := postShares(t, server, "/delete", other.ID, other.Shares[1]); r.StatusCode != http.StatusBadRequest {
+		t.Errorf("failed delete status=%d for one share", r.StatusCode)
+	}
+	r, _ = postShares(t, server, "/delete", other.ID, other.Shares[1], other.Shares[2])
+	if r.StatusCode != http.StatusNoContent {
+		t.Errorf("failed delete status=%d", r.StatusCode)
+	}
+}
+
+func TestNoteShares(t *testing.T) {
+	const text = "approved note"
+	cfg := newConfig(t)
+	server := httptest.NewServer(New(cfg))
+	defer server.Close()
+
+	resp, err := http.PostForm(server.URL+"/note", url.Values{noteField: {text}, sharesField: {"2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := &uploadResponse{}
+	if err = json.NewDecoder(resp.Body).Decode(result); err != nil {
+		t.Fatal(err)
+	}
+	if err = resp.Body.Close(); err != nil {
+		t.Error(err)
+	}
+	if resp.StatusCode != http.StatusCreated || len(result.Shares) != 2 {
+		t.Fatalf("failed status=%d, shares=%q", resp.StatusCode, result.Shares)
+	}
+	if r, body := postShares(t, server, "/download", result.ID, result.Shares...); string(body) != text {
+		t.Errorf("failed download status=%d, note=%q", r.StatusCode, body)
+	}
+}
+
+func TestSharesError(t *testing.T) {
+	cfg := newConfig(t)
+	server := httptest.NewServer(New(cfg))
+	defer server.Close()
+
+	content := []byte("content")
+	cases := []url.Values{
+		{sharesField: {"1"}},
+		{sharesField: {"17"}},
+		{sharesField: {"many"}},
+		{sharesField: {"3"}, thresholdField: {"4"}},
+		{sharesField: {"3"}, thresholdField: {"1"}},
+		{sharesField: {"3"}, passwordField: {"user password"}},
+	}
+	for i, fields := range cases {
+		if resp, _ := uploadFileWith(t, server, content, fields); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("failed case=%d, status=%d", i, resp.StatusCode)
+		}
+	}
+}
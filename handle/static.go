@@ -0,0 +1,54 @@
+package handle
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"github.com/z0rr0/ssf"
+	"github.com/z0rr0/ssf/config"
+)
+
+const (
+	// staticPath is a URL path prefix of embedded static files.
+	staticPath = "/static/"
+	// contentSecurityPolicy allows only own scripts, styles, forms and requests.
+	contentSecurityPolicy = "default-src 'none'; script-src 'self'; style-src 'self'; img-src 'self'; " +
+		"connect-src 'self'; form-action 'self'; base-uri 'none'; frame-ancestors 'none'"
+)
+
+// staticFiles serves embedded files, the URL path prefix is the same as the directory name.
+var staticFiles = http.FileServer(http.FS(ssf.Static))
+
+// pageHeaders sets security headers of browser pages and their resources.
+func pageHeaders(h http.Header) {
+	h.Set("Content-Security-Policy", contentSecurityPolicy)
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("X-Frame-Options", "DENY")
+	h.Set("Referrer-Policy", "no-referrer")
+}
+
+// Static writes embedded static files, directory listings are not allowed.
+func Static(w http.ResponseWriter, r *http.Request, _ *config.Config) error {
+	if strings.HasSuffix(r.URL.Path, "/") {
+		return newError(http.StatusNotFound, fmt.Errorf("unknown static file %q", r.URL.Path))
+	}
+	pageHeaders(w.Header())
+	staticFiles.ServeHTTP(w, r)
+	return nil
+}
+
+// E2E writes the browser page which encrypts files before upload and decrypts them after download.
+// The key is kept in URL fragment, so it's never sent to the server.
+func E2E(w http.ResponseWriter, _ *http.Request, _ *config.Config) error {
+	content, err := fs.ReadFile(ssf.Static, "static/e2e.html")
+	if err != nil {
+		return fmt.Errorf("read page: %w", err)
+	}
+	pageHeaders(w.Header())
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	_, err = w.Write(content)
+	return err
+}
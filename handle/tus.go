@@ -0,0 +1,339 @@
+package handle
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/z0rr0/ssf/config"
+	"github.com/z0rr0/ssf/db"
+	"github.com/z0rr0/ssf/encrypt"
+	"github.com/z0rr0/ssf/encrypt/stream"
+	"github.com/z0rr0/ssf/gc"
+)
+
+const (
+	// tusPath is a URL path prefix of resumable uploads.
+	tusPath = "/files/"
+	// tusVersion is a supported version of tus protocol.
+	tusVersion = "1.0.0"
+	// tusExtension is a list of supported tus protocol extensions.
+	tusExtension = "creation,termination"
+	// tusContentType is a content type of upload parts.
+	tusContentType = "application/offset+octet-stream"
+	// passwordHeader is a header with upload password, it's set on creation and required to change the upload.
+	passwordHeader = "Ssf-Password"
+	// nameKey is an upload meta data key with a file name.
+	nameKey = "filename"
+	// typeKey is an upload meta data key with a file content type.
+	typeKey = "filetype"
+)
+
+// uploadLocks is a set of uploads which are changed by active requests.
+type uploadLocks struct {
+	sync.Mutex
+	ids map[string]struct{}
+}
+
+// lock marks the upload as changed, it returns false if it's already changed by another request.
+func (l *uploadLocks) lock(id string) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	if _, ok := l.ids[id]; ok {
+		return false
+	}
+	l.ids[id] = struct{}{}
+	return true
+}
+
+// unlock marks the upload as not changed.
+func (l *uploadLocks) unlock(id string) {
+	l.Lock()
+	defer l.Unlock()
+	delete(l.ids, id)
+}
+
+// activeUploads are uploads which are changed now, only one request can change an upload.
+var activeUploads = &uploadLocks{ids: make(map[string]struct{})}
+
+// Tus handles resumable uploads by tus 1.0.0 core protocol with creation and termination extensions.
+// A new upload returns its generated password in Ssf-Password header,
+// it's required to send parts or to terminate the upload. The item ID is the same as the upload ID.
+func Tus(w http.ResponseWriter, r *http.Request, cfg *config.Config) error {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", tusExtension)
+		w.Header().Set("Tus-Max-Size", strconv.Itoa(cfg.MaxFileSize()))
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	if v := r.Header.Get("Tus-Resumable"); v != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		return newError(http.StatusPreconditionFailed, fmt.Errorf("unsupported tus version %q", v))
+	}
+	id := strings.TrimPrefix(r.URL.Path, tusPath)
+	if id == "" {
+		if r.Method != http.MethodPost {
+			return newError(http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		}
+		return tusCreate(w, r, cfg)
+	}
+	if strings.Contains(id, "/") {
+		return newError(http.StatusNotFound, errors.New("unknown upload path"))
+	}
+	switch r.Method {
+	case http.MethodHead:
+		return tusHead(w, r, cfg, id)
+	case http.MethodPatch:
+		return tusPatch(w, r, cfg, id)
+	case http.MethodDelete:
+		return tusDelete(w, r, cfg, id)
+	}
+	return newError(http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+}
+
+// tusCreate creates a new upload by its length and meta data.
+// Optional item parameters (ttl, times, password and generator) can be set as URL query parameters
+// or upload meta data.
+func tusCreate(w http.ResponseWriter, r *http.Request, cfg *config.Config) error {
+	if r.Header.Get("Upload-Defer-Length") != "" {
+		return newError(http.StatusBadRequest, errors.New("deferred upload length is not supported"))
+	}
+	size, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	switch {
+	case err != nil || size < 0:
+		return newError(http.StatusBadRequest, errors.New("invalid header Upload-Length"))
+	case size == 0:
+		return newError(http.StatusBadRequest, errEmptyFile)
+	case size > int64(cfg.MaxFileSize()):
+		return errFileSize
+	}
+	metadata, err := parseMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		return newError(http.StatusBadRequest, err)
+	}
+	values := r.URL.Query()
+	for _, key := range []string{ttlField, timesField, passwordField, generatorField} {
+		if value, ok := metadata[key]; ok {
+			values.Set(key, value)
+		}
+	}
+	params, err := newShareParams(cfg, values)
+	if err != nil {
+		return err
+	}
+	// the storage should have space for the whole file, but only the header is written now
+	reservation, err := cfg.Storage.Reserve(size)
+	if err != nil {
+		return err
+	}
+	defer reservation.Rollback()
+
+	id, password := uuid.New().String(), params.password
+	secret, opts := cfg.Secret(password), options(cfg, id)
+	meta := &encrypt.Meta{Name: baseName(metadata[nameKey]), Type: mediaType(metadata[typeKey])}
+
+	m, err := encrypt.NewUpload(secret, cfg.Storage.Dir, size, meta, opts)
+	if err != nil {
+		return err
+	}
+	if err = reservation.Commit(int64(stream.HeaderSize)); err != nil {
+		return removeFile(m.Msg.Value, err)
+	}
+	now := time.Now()
+	u := db.NewUpload(id, m, now, now.Add(cfg.TTL()), params.ttl, params.times)
+	if err = u.Save(r.Context(), cfg.Storage.Db); err != nil {
+		return releaseFile(cfg, m.Msg.Value, int64(stream.HeaderSize), err)
+	}
+	w.Header().Set("Location", tusPath+id)
+	w.Header().Set(passwordHeader, password)
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+// tusHead writes the upload offset and length.
+func tusHead(w http.ResponseWriter, r *http.Request, cfg *config.Config, id string) error {
+	u, err := db.GetUpload(r.Context(), cfg.Storage.Db, id, time.Now())
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(u.Size, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// tusPatch encrypts a received upload part. Storage space is reserved for every part,
+// after the last one the upload is checked and saved as a new item.
+func tusPatch(w http.ResponseWriter, r *http.Request, cfg *config.Config, id string) error {
+	if v := r.Header.Get("Content-Type"); v != tusContentType {
+		return newError(http.StatusUnsupportedMediaType, fmt.Errorf("unsupported content type %q", v))
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return newError(http.StatusBadRequest, errors.New("invalid header Upload-Offset"))
+	}
+	u, secret, err := lockUpload(r, cfg, id)
+	if err != nil {
+		return err
+	}
+	defer activeUploads.unlock(id)
+
+	if offset != u.Offset {
+		return newError(http.StatusConflict, fmt.Errorf("upload offset is %d", u.Offset))
+	}
+	remaining := u.Size - u.Offset
+	if r.ContentLength > remaining {
+		return errFileSize
+	}
+	n := remaining
+	if r.ContentLength >= 0 {
+		n = r.ContentLength
+	}
+	// the previous not written tail and segments overhead are added to the part size
+	reservation, err := cfg.Storage.Reserve(n + stream.SegmentSize + (n/stream.SegmentSize+2)*stream.Overhead)
+	if err != nil {
+		return err
+	}
+	defer reservation.Rollback()
+
+	state, opts := u.State(cfg.Storage.Dir), options(cfg, id)
+	before, err := fileSize(state.Msg.Value)
+	if err != nil {
+		return err
+	}
+	received, writeErr := state.Write(secret, offset, r.Body, opts)
+	if received > 0 {
+		// the state is saved before completion, so a failed completion can be repeated by an empty part
+		now := time.Now()
+		err = u.Update(r.Context(), cfg.Storage.Db, state, now, now.Add(cfg.TTL()))
+	}
+	if err == nil && writeErr == nil && state.Offset == state.Size {
+		writeErr = tusComplete(r, cfg, u, state, secret)
+	}
+	after, e := fileSize(state.Msg.Value)
+	if e != nil {
+		return e
+	}
+	if e = reservation.Commit(after - before); e != nil {
+		return e
+	}
+	switch {
+	case err != nil:
+		return err
+	case errors.Is(writeErr, encrypt.ErrUploadState):
+		// the file doesn't match the saved state, its segments can't be written again
+		if e = gc.RemoveUpload(r.Context(), cfg, u); e != nil {
+			return fmt.Errorf("%v, remove upload: %w", writeErr, e)
+		}
+		return newError(http.StatusGone, writeErr)
+	case writeErr != nil:
+		return writeErr
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(state.Offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// tusComplete saves completed upload as a new item.
+func tusComplete(r *http.Request, cfg *config.Config, u *db.Upload, state *encrypt.Upload, secret string) error {
+	opts := options(cfg, u.ID)
+	m, meta, err := state.Finish(secret, opts)
+	if err != nil {
+		return err
+	}
+	metaMsg, err := meta.Encrypt(secret, opts)
+	if err != nil {
+		return err
+	}
+	item := db.NewItem(u.ID, m, time.Now(), u.TTL, u.Times)
+	item.Meta = metaMsg
+	return u.Complete(r.Context(), cfg.Storage.Db, item)
+}
+
+// tusDelete terminates the upload, its file is deleted.
+func tusDelete(w http.ResponseWriter, r *http.Request, cfg *config.Config, id string) error {
+	u, secret, err := lockUpload(r, cfg, id)
+	if err != nil {
+		return err
+	}
+	defer activeUploads.unlock(id)
+
+	if err = encrypt.CheckSecret(secret, &u.State(cfg.Storage.Dir).Msg, options(cfg, id)); err != nil {
+		return err
+	}
+	if err = gc.RemoveUpload(r.Context(), cfg, u); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// lockUpload returns the upload and a secret from the request password.
+// The upload is locked for the request, it must be unlocked if there is no error.
+func lockUpload(r *http.Request, cfg *config.Config, id string) (*db.Upload, string, error) {
+	password := r.Header.Get(passwordHeader)
+	if password == "" {
+		return nil, "", newError(http.StatusBadRequest, fmt.Errorf("header %s is required", passwordHeader))
+	}
+	if !activeUploads.lock(id) {
+		return nil, "", newError(http.StatusLocked, errors.New("upload is changed by another request"))
+	}
+	u, err := db.GetUpload(r.Context(), cfg.Storage.Db, id, time.Now())
+	if err != nil {
+		activeUploads.unlock(id)
+		return nil, "", err
+	}
+	return u, cfg.Secret(password), nil
+}
+
+// parseMetadata returns decoded values of Upload-Metadata header.
+// It's a comma separated list of keys with optional base64 encoded values.
+func parseMetadata(value string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if value == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(value, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 || len(fields) > 2 {
+			return nil, fmt.Errorf("invalid upload metadata %q", pair)
+		}
+		key := fields[0]
+		if _, ok := metadata[key]; ok {
+			return nil, fmt.Errorf("duplicate upload metadata key %q", key)
+		}
+		metadata[key] = ""
+		if len(fields) == 2 {
+			b, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("upload metadata key %q: %w", key, err)
+			}
+			if len(b) > maxFieldSize && key != nameKey {
+				return nil, fmt.Errorf("upload metadata key %q is too long", key)
+			}
+			metadata[key] = string(b)
+		}
+	}
+	return metadata, nil
+}
+
+// fileSize returns a size of the file.
+func fileSize(name string) (int64, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return 0, fmt.Errorf("stat file: %w", err)
+	}
+	return info.Size(), nil
+}
@@ -0,0 +1,273 @@
+package handle
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func tusRequest(t *testing.T, method, url string, header http.Header, body []byte) *http.Response {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Tus-Resumable", tusVersion)
+	for key := range header {
+		req.Header.Set(key, header.Get(key))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.Copy(io.Discard, resp.Body); err != nil {
+		t.Error(err)
+	}
+	if err = resp.Body.Close(); err != nil {
+		t.Error(err)
+	}
+	return resp
+}
+
+func tusCreateUpload(t *testing.T, server *httptest.Server, size int, metadata string) (string, string) {
+	header := http.Header{"Upload-Length": {strconv.Itoa(size)}, "Upload-Metadata": {metadata}}
+	resp := tusRequest(t, http.MethodPost, server.URL+tusPath, header, nil)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("failed create status=%d", resp.StatusCode)
+	}
+	location, password := resp.Header.Get("Location"), resp.Header.Get(passwordHeader)
+	if location == "" || password == "" {
+		t.Fatalf("failed create headers=%v", resp.Header)
+	}
+	return server.URL + location, password
+}
+
+func tusPatchUpload(t *testing.T, location, password string, offset int, part []byte) *http.Response {
+	header := http.Header{
+		"Content-Type":  {tusContentType},
+		"Upload-Offset": {strconv.Itoa(offset)},
+		passwordHeader:  {password},
+	}
+	return tusRequest(t, http.MethodPatch, location, header, part)
+}
+
+func TestTusOptions(t *testing.T) {
+	cfg := newConfig(t)
+	server := httptest.NewServer(New(cfg))
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodOptions, server.URL+tusPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = resp.Body.Close(); err != nil {
+		t.Error(err)
+	}
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("failed status=%d", resp.StatusCode)
+	}
+	if v := resp.Header.Get("Tus-Extension"); v != tusExtension {
+		t.Errorf("failed extensions=%q", v)
+	}
+	if v := resp.Header.Get("Tus-Max-Size"); v != strconv.Itoa(cfg.MaxFileSize()) {
+		t.Errorf("failed max size=%q", v)
+	}
+	resp = tusRequest(t, http.MethodPost, server.URL+tusPath, http.Header{"Tus-Resumable": {"0.2.2"}}, nil)
+	if resp.StatusCode != http.StatusPreconditionFailed || resp.Header.Get("Tus-Version") != tusVersion {
+		t.Errorf("failed status=%d for unsupported version", resp.StatusCode)
+	}
+}
+
+func TestTusUpload(t *testing.T) {
+	cfg := newConfig(t)
+	server := httptest.NewServer(New(cfg))
+	defer server.Close()
+
+	content := make([]byte, 150<<10)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	metadata := nameKey + " " + base64.StdEncoding.EncodeToString([]byte("data.bin")) +
+		"," + timesField + " " + base64.StdEncoding.EncodeToString([]byte("1"))
+	location, password := tusCreateUpload(t, server, len(content), metadata)
+
+	resp := tusRequest(t, http.MethodHead, location, nil, nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Upload-Offset") != "0" {
+		t.Errorf("failed head status=%d, offset=%q", resp.StatusCode, resp.Header.Get("Upload-Offset"))
+	}
+	if v := resp.Header.Get("Upload-Length"); v != strconv.Itoa(len(content)) {
+		t.Errorf("failed length=%q", v)
+	}
+	if resp = tusPatchUpload(t, location, "bad password", 0, content[:10]); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("failed status=%d for wrong password", resp.StatusCode)
+	}
+	parts := []int{0, 1000, 70 << 10, len(content)}
+	for i := 1; i < len(parts); i++ {
+		resp = tusPatchUpload(t, location, password, parts[i-1], content[parts[i-1]:parts[i]])
+		if resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("failed patch status=%d", resp.StatusCode)
+		}
+		if v := resp.Header.Get("Upload-Offset"); v != strconv.Itoa(parts[i]) {
+			t.Errorf("failed offset=%q", v)
+		}
+		if i == 1 {
+			// a repeated part is rejected
+			resp = tusPatchUpload(t, location, password, parts[i-1], content[parts[i-1]:parts[i]])
+			if resp.StatusCode != http.StatusConflict {
+				t.Errorf("failed status=%d for wrong offset", resp.StatusCode)
+			}
+		}
+	}
+	// the completed upload is a new item
+	if resp = tusRequest(t, http.MethodHead, location, nil, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("failed head status=%d", resp.StatusCode)
+	}
+	id := location[len(server.URL+tusPath):]
+	r, body := download(t, server, id, password)
+	if r.StatusCode != http.StatusOK {
+		t.Fatalf("failed download status=%d", r.StatusCode)
+	}
+	if !bytes.Equal(body, content) {
+		t.Error("failed downloaded content")
+	}
+	if v := r.Header.Get("Content-Disposition"); v != `attachment; filename=data.bin` {
+		t.Errorf("failed content disposition=%q", v)
+	}
+	if r, _ = download(t, server, id, password); r.StatusCode != http.StatusNotFound {
+		t.Errorf("failed status=%d after the last download", r.StatusCode)
+	}
+	if limit, reserved := cfg.Storage.Used(); limit != 0 || reserved != 0 {
+		t.Errorf("failed storage limit=%d, reserved=%d", limit, reserved)
+	}
+}
+
+func TestTusError(t *testing.T) {
+	cfg := newConfig(t)
+	server := httptest.NewServer(New(cfg))
+	defer server.Close()
+
+	cases := []struct {
+		name   string
+		header http.Header
+		code   int
+	}{
+		{name: "no length", code: http.StatusBadRequest},
+		{name: "empty", header: http.Header{"Upload-Length": {"0"}}, code: http.StatusBadRequest},
+		{name: "deferred", header: http.Header{"Upload-Defer-Length": {"1"}}, code: http.StatusBadRequest},
+		{
+			name:   "big",
+			header: http.Header{"Upload-Length": {strconv.Itoa(cfg.MaxFileSize() + 1)}},
+			code:   http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:   "metadata",
+			header: http.Header{"Upload-Length": {"10"}, "Upload-Metadata": {"filename !!!"}},
+			code:   http.StatusBadRequest,
+		},
+		{
+			name:   "ttl",
+			header: http.Header{"Upload-Length": {"10"}, "Upload-Metadata": {"ttl " + base64.StdEncoding.EncodeToString([]byte("0"))}},
+			code:   http.StatusBadRequest,
+		},
+	}
+	for _, c := range cases {
+		if resp := tusRequest(t, http.MethodPost, server.URL+tusPath, c.header, nil); resp.StatusCode != c.code {
+			t.Errorf("failed status=%d for case %q", resp.StatusCode, c.name)
+		}
+	}
+	content := []byte("some content")
+	location, password := tusCreateUpload(t, server, len(content), "")
+	if resp := tusPatchUpload(t, location, password, 0, append(content, 'x')); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("failed status=%d for extra data", resp.StatusCode)
+	}
+	header := http.Header{"Upload-Offset": {"0"}, passwordHeader: {password}}
+	if resp := tusRequest(t, http.MethodPatch, location, header, content); resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("failed status=%d for content type", resp.StatusCode)
+	}
+	if resp := tusPatchUpload(t, location, password, 0, content[:5]); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("failed status=%d", resp.StatusCode)
+	}
+	// termination
+	if resp := tusRequest(t, http.MethodDelete, location, http.Header{passwordHeader: {"bad"}}, nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("failed status=%d for wrong password", resp.StatusCode)
+	}
+	if resp := tusRequest(t, http.MethodDelete, location, http.Header{passwordHeader: {password}}, nil); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("failed delete status=%d", resp.StatusCode)
+	}
+	if resp := tusPatchUpload(t, location, password, 5, content[5:]); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("failed status=%d after termination", resp.StatusCode)
+	}
+	entries, err := os.ReadDir(cfg.Storage.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k := len(entries); k != 0 {
+		t.Errorf("terminated upload file is not deleted, files=%d", k)
+	}
+	if limit, reserved := cfg.Storage.Used(); limit != 0 || reserved != 0 {
+		t.Errorf("failed storage limit=%d, reserved=%d", limit, reserved)
+	}
+}
+
+func TestTusWrittenSegments(t *testing.T) {
+	cfg := newConfig(t)
+	server := httptest.NewServer(New(cfg))
+	defer server.Close()
+
+	content := bytes.Repeat([]byte("some content"), 10<<10)
+	location, password := tusCreateUpload(t, server, len(content), "")
+	entries, err := os.ReadDir(cfg.Storage.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("failed files=%d", len(entries))
+	}
+	// a segment is written after the saved state, as if the state update was lost
+	f, err := os.OpenFile(filepath.Join(cfg.Storage.Dir, entries[0].Name()), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Write(make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if resp := tusPatchUpload(t, location, password, 0, content); resp.StatusCode != http.StatusGone {
+		t.Errorf("failed status=%d for written segments", resp.StatusCode)
+	}
+	if resp := tusRequest(t, http.MethodHead, location, nil, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("failed head status=%d", resp.StatusCode)
+	}
+	if entries, err = os.ReadDir(cfg.Storage.Dir); err != nil || len(entries) != 0 {
+		t.Errorf("failed upload file is not deleted, files=%d, error=%v", len(entries), err)
+	}
+}
+
+func TestParseMetadata(t *testing.T) {
+	metadata, err := parseMetadata("filename ZmlsZS50eHQ=,is_confidential, ttl MTA=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata[nameKey] != "file.txt" || metadata[ttlField] != "10" {
+		t.Errorf("failed metadata=%v", metadata)
+	}
+	if v, ok := metadata["is_confidential"]; !ok || v != "" {
+		t.Errorf("failed metadata=%v", metadata)
+	}
+	for _, value := range []string{"a b c", "a,a", "a !!!", ","} {
+		if _, err = parseMetadata(value); err == nil {
+			t.Errorf("expected error for %q", value)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package handle
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/z0rr0/ssf"
+	"github.com/z0rr0/ssf/config"
+)
+
+const (
+	// indexPath is a URL path of the upload page.
+	indexPath = "/"
+	// sharePath is a URL path of the download page, it's a base of share links.
+	sharePath = "/share"
+)
+
+var (
+	// pages are HTML templates of browser pages.
+	pages = template.Must(template.ParseFS(ssf.Templates, "templates/*.html"))
+	// ttlChoices are suggested item time to live values in seconds.
+	ttlChoices = []int{5 * 60, 60 * 60, 24 * 60 * 60, 7 * 24 * 60 * 60}
+	// timesChoices are suggested max numbers of downloads.
+	timesChoices = []int{1, 2, 5, 10}
+)
+
+// option is a value of select element.
+type option struct {
+	Value    int
+	Label    string
+	Selected bool
+}
+
+// generatorOption is a password generator choice with its entropy estimate in bits.
+type generatorOption struct {
+	Name     string
+	Entropy  int
+	Selected bool
+}
+
+// pageData is a data of HTML templates.
+type pageData struct {
+	Title      string
+	Error      string
+	TTL        []option
+	Times      []option
+	PassLen    int
+	MaxPassLen int
+	Strength   int
+	Generators []generatorOption
+	MaxSize    string
+	Item       *uploadResponse
+	Link       string
+	ID         string
+}
+
+// newPageData returns template data with upload form parameters.
+func newPageData(cfg *config.Config, title string) *pageData {
+	return &pageData{
+		Title:      title,
+		TTL:        choices(ttlChoices, cfg.Settings.TTL, ttlLabel),
+		Times:      choices(timesChoices, cfg.Settings.Times, timesLabel),
+		PassLen:    cfg.Settings.PassLen,
+		MaxPassLen: maxFieldSize,
+		Strength:   cfg.Settings.Strength,
+		Generators: generators(cfg),
+		MaxSize:    fmt.Sprintf("%d MiB", cfg.Settings.Size),
+	}
+}
+
+// generators returns choices of password generators, the configured one is selected.
+func generators(cfg *config.Config) []generatorOption {
+	names := []string{config.SymbolsGenerator, config.WordsGenerator}
+	result := make([]generatorOption, 0, len(names))
+	for _, name := range names {
+		if g, ok := cfg.Generator(name); ok {
+			result = append(result, generatorOption{
+				Name:     name,
+				Entropy:  int(g.Entropy()),
+				Selected: name == cfg.Settings.Password.Generator,
+			})
+		}
+	}
+	return result
+}
+
+// choices returns select options of the values which are less than max one.
+// The max value is always added and selected, it's the default value of API requests.
+func choices(values []int, max int, label func(int) string) []option {
+	result := make([]option, 0, len(values)+1)
+	for _, v := range values {
+		if v < max {
+			result = append(result, option{Value: v, Label: label(v)})
+		}
+	}
+	return append(result, option{Value: max, Label: label(max), Selected: true})
+}
+
+// plural returns the number with the noun in singular or plural form.
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// ttlLabel returns human-readable time to live in seconds.
+func ttlLabel(seconds int) string {
+	switch {
+	case seconds%(24*60*60) == 0:
+		return plural(seconds/(24*60*60), "day")
+	case seconds%(60*60) == 0:
+		return plural(seconds/(60*60), "hour")
+	case seconds%60 == 0:
+		return plural(seconds/60, "minute")
+	}
+	return plural(seconds, "second")
+}
+
+// timesLabel returns human-readable max number of downloads.
+func timesLabel(times int) string {
+	return plural(times, "download")
+}
+
+// Index writes the upload page (GET) or saves a file from its form and writes the result page (POST).
+// Form fields are the same as Upload handler ones, the page works without JavaScript.
+func Index(w http.ResponseWriter, r *http.Request, cfg *config.Config) error {
+	if r.URL.Path != indexPath {
+		return newError(http.StatusNotFound, fmt.Errorf("unknown path %q", r.URL.Path))
+	}
+	data := newPageData(cfg, "upload")
+	if r.Method == http.MethodGet {
+		return render(w, http.StatusOK, "index.html", data)
+	}
+	result, err := receiveUpload(w, r, cfg)
+	if err != nil {
+		return renderError(w, "index.html", data, err)
+	}
+	data.Title, data.Item, data.Link = "uploaded", result, shareLink(r, result.ID)
+	return render(w, http.StatusOK, "result.html", data)
+}
+
+// Share writes the download page with a password form (GET).
+// The form is handled by Download handler (POST), its errors are shown on the page.
+func Share(w http.ResponseWriter, r *http.Request, cfg *config.Config) error {
+	data := &pageData{Title: "download", ID: r.FormValue("id")}
+	if r.Method == http.MethodGet {
+		return render(w, http.StatusOK, "share.html", data)
+	}
+	rw := &responseWriter{ResponseWriter: w}
+	err := Download(rw, r, cfg)
+	if err == nil || rw.started {
+		return err
+	}
+	return renderError(w, "share.html", data, err)
+}
+
+// shareLink returns absolute URL of the download page for the item.
+func shareLink(r *http.Request, id string) string {
+	scheme := "http"
+	if r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+		scheme = "https"
+	}
+	u := &url.URL{Scheme: scheme, Host: r.Host, Path: sharePath, RawQuery: url.Values{"id": {id}}.Encode()}
+	return u.String()
+}
+
+// render writes HTML page from the template with security headers.
+func render(w http.ResponseWriter, code int, name string, data *pageData) error {
+	var buf bytes.Buffer
+	if err := pages.ExecuteTemplate(&buf, name, data); err != nil {
+		return fmt.Errorf("render %s: %w", name, err)
+	}
+	pageHeaders(w.Header())
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// renderError writes the page with the error message and returns the error,
+// so it's logged as a usual handler error.
+func renderError(w http.ResponseWriter, name string, data *pageData, err error) error {
+	code := errorCode(err)
+	clearHeaders(w.Header())
+	data.Error = errorMessage(code, err)
+	if e := render(w, code, name, data); e != nil {
+		log.Printf("failed render error page: %v", e)
+	}
+	return err
+}
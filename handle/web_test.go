@@ -0,0 +1,229 @@
+package handle
+
+import (
+	"bytes"
+	"html"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// passwordValue is a pattern of generated password on the result page.
+var passwordValue = regexp.MustCompile(`Password <input type="text" value="([^"]+)"`)
+
+func readPage(t *testing.T, resp *http.Response) string {
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("failed content type=%s", ct)
+	}
+	if csp := resp.Header.Get("Content-Security-Policy"); csp != contentSecurityPolicy {
+		t.Errorf("failed content security policy=%s", csp)
+	}
+	return string(body)
+}
+
+func uploadForm(t *testing.T, server *httptest.Server, fields url.Values, content []byte) *http.Response {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for _, name := range []string{ttlField, timesField, passwordField} {
+		if err := w.WriteField(name, fields.Get(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	part, err := w.CreateFormFile(fileField, "test.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = part.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(server.URL+indexPath, w.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestIndex(t *testing.T) {
+	cfg := newConfig(t)
+	server := httptest.NewServer(New(cfg))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := readPage(t, resp)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed status=%d", resp.StatusCode)
+	}
+	for _, s := range []string{
+		`enctype="multipart/form-data"`,
+		`<option value="60" selected>1 minute</option>`,
+		`<option value="1">1 download</option>`,
+		`<option value="2" selected>2 downloads</option>`,
+		`minlength="8"`,
+		`<input type="file" name="file" required>`,
+	} {
+		if !strings.Contains(page, s) {
+			t.Errorf("failed page, it doesn't contain %q", s)
+		}
+	}
+	if strings.Contains(page, "<script") {
+		t.Error("failed page, it contains a script")
+	}
+	resp, err = http.Get(server.URL + "/unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = resp.Body.Close(); err != nil {
+		t.Error(err)
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("failed status=%d for unknown path", resp.StatusCode)
+	}
+}
+
+func TestIndexUpload(t *testing.T) {
+	cfg := newConfig(t)
+	server := httptest.NewServer(New(cfg))
+	defer server.Close()
+
+	content := []byte("web form content")
+	resp := uploadForm(t, server, url.Values{ttlField: {"60"}, timesField: {"1"}}, content)
+	page := readPage(t, resp)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed status=%d", resp.StatusCode)
+	}
+	match := passwordValue.FindStringSubmatch(page)
+	if match == nil {
+		t.Fatalf("failed page, no password: %s", page)
+	}
+	password := html.UnescapeString(match[1])
+	link := regexp.MustCompile(`Share link <input type="text" value="([^"]+)"`).FindStringSubmatch(page)
+	if link == nil || !strings.HasPrefix(link[1], server.URL+sharePath+"?id=") {
+		t.Fatalf("failed page, no share link: %s", page)
+	}
+	shareURL := strings.ReplaceAll(link[1], "&amp;", "&")
+	id := strings.TrimPrefix(shareURL, server.URL+sharePath+"?id=")
+
+	resp, err := http.Get(shareURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page = readPage(t, resp)
+	if !strings.Contains(page, `name="id" value="`+id+`"`) {
+		t.Errorf("failed share page: %s", page)
+	}
+	// a wrong password is shown on the page
+	resp, err = http.PostForm(server.URL+sharePath, url.Values{"id": {id}, "password": {"wrong password"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	page = readPage(t, resp)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("failed status=%d for wrong password", resp.StatusCode)
+	}
+	if !strings.Contains(page, `<p class="error">failed secret</p>`) {
+		t.Errorf("failed error page: %s", page)
+	}
+	resp, err = http.PostForm(server.URL+sharePath, url.Values{"id": {id}, "password": {password}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = resp.Body.Close(); err != nil {
+		t.Error(err)
+	}
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(body, content) {
+		t.Errorf("failed download status=%d, content=%q", resp.StatusCode, body)
+	}
+	if cd := resp.Header.Get("Content-Disposition"); cd != `attachment; filename=test.txt` {
+		t.Errorf("failed content disposition=%s", cd)
+	}
+	// the file was downloaded max number of times
+	resp, err = http.PostForm(server.URL+sharePath, url.Values{"id": {id}, "password": {password}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page = readPage(t, resp); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("failed status=%d for used item: %s", resp.StatusCode, page)
+	}
+}
+
+func TestIndexUploadError(t *testing.T) {
+	cfg := newConfig(t)
+	server := httptest.NewServer(New(cfg))
+	defer server.Close()
+
+	testCases := []struct {
+		name    string
+		fields  url.Values
+		content []byte
+		code    int
+		message string
+	}{
+		{name: "empty", code: http.StatusBadRequest, message: errEmptyFile.Error()},
+		{
+			name:    "short password",
+			fields:  url.Values{passwordField: {"short"}},
+			content: []byte("content"),
+			code:    http.StatusBadRequest,
+			message: `field &#34;password&#34; length must be in range [8, 64]`,
+		},
+		{
+			name:    "ttl",
+			fields:  url.Values{ttlField: {"1000"}},
+			content: []byte("content"),
+			code:    http.StatusBadRequest,
+			message: `field &#34;ttl&#34; must be in range [1, 60]`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := uploadForm(t, server, tc.fields, tc.content)
+			page := readPage(t, resp)
+			if resp.StatusCode != tc.code {
+				t.Errorf("failed status=%d, expected %d", resp.StatusCode, tc.code)
+			}
+			if !strings.Contains(page, tc.message) || !strings.Contains(page, `<form method="post"`) {
+				t.Errorf("failed page: %s", page)
+			}
+		})
+	}
+}
+
+func TestTTLLabel(t *testing.T) {
+	testCases := map[int]string{
+		1:          "1 second",
+		90:         "90 seconds",
+		300:        "5 minutes",
+		3600:       "1 hour",
+		7200:       "2 hours",
+		86400:      "1 day",
+		7 * 86400:  "7 days",
+		86400 + 60: "1441 minutes",
+	}
+	for seconds, expected := range testCases {
+		if label := ttlLabel(seconds); label != expected {
+			t.Errorf("failed label for %d: %q, expected %q", seconds, label, expected)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package ssf
+
+// Package ssf contains common resources of the safe share files service.
+
+import "embed"
+
+// Schema is SQL script to initialize the database.
+//
+//go:embed db.sql
+var Schema string
+
+// Static contains files of browser pages, they are inside "static" directory.
+//
+//go:embed static
+var Static embed.FS
+
+// Templates contains HTML templates of browser pages, they are inside "templates" directory.
+//
+//go:embed templates
+var Templates embed.FS
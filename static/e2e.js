@@ -0,0 +1,179 @@
+'use strict';
+
+// Files are encrypted by the same stream format as the service uses (encrypt/stream package):
+// header = magic "\x89SSF" | version 2 | 7 bytes random nonce prefix,
+// every 64 KiB plaintext segment is sealed by AES-256-GCM with nonce = prefix | uint32 BE counter | last flag
+// and the header as associated data. Segment key is HKDF-SHA256(key, salt=header, info="ssf stream").
+(function () {
+    const MAGIC = [0x89, 0x53, 0x53, 0x46];
+    const VERSION = 2;
+    const HEADER_SIZE = 12;
+    const PREFIX_OFFSET = 5;
+    const SEGMENT_SIZE = 64 * 1024;
+    const OVERHEAD = 16;
+    const KEY_SIZE = 32;
+    const INFO = new TextEncoder().encode('ssf stream');
+
+    const byId = (id) => document.getElementById(id);
+
+    function status(message, error) {
+        const el = byId('status');
+        el.textContent = message;
+        el.classList.toggle('error', Boolean(error));
+    }
+
+    function encodeKey(key) {
+        let s = '';
+        key.forEach((b) => {
+            s += String.fromCharCode(b);
+        });
+        return btoa(s).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+    }
+
+    function decodeKey(value) {
+        const s = atob(value.replace(/-/g, '+').replace(/_/g, '/'));
+        const key = Uint8Array.from(s, (c) => c.charCodeAt(0));
+        if (key.length !== KEY_SIZE) {
+            throw new Error('invalid key length');
+        }
+        return key;
+    }
+
+    async function segmentKey(key, header, usage) {
+        const base = await crypto.subtle.importKey('raw', key, 'HKDF', false, ['deriveKey']);
+        return crypto.subtle.deriveKey(
+            {name: 'HKDF', hash: 'SHA-256', salt: header, info: INFO},
+            base,
+            {name: 'AES-GCM', length: 256},
+            false,
+            [usage],
+        );
+    }
+
+    function segmentNonce(header, counter, last) {
+        const nonce = new Uint8Array(12);
+        nonce.set(header.subarray(PREFIX_OFFSET, HEADER_SIZE));
+        new DataView(nonce.buffer).setUint32(7, counter);
+        nonce[11] = last ? 1 : 0;
+        return nonce;
+    }
+
+    async function encrypt(file, key) {
+        const header = new Uint8Array(HEADER_SIZE);
+        header.set(MAGIC);
+        header[MAGIC.length] = VERSION;
+        crypto.getRandomValues(header.subarray(PREFIX_OFFSET));
+
+        const aes = await segmentKey(key, header, 'encrypt');
+        const count = Math.max(1, Math.ceil(file.size / SEGMENT_SIZE));
+        const parts = [header];
+        for (let i = 0; i < count; i++) {
+            const plain = await file.slice(i * SEGMENT_SIZE, (i + 1) * SEGMENT_SIZE).arrayBuffer();
+            const params = {name: 'AES-GCM', iv: segmentNonce(header, i, i === count - 1), additionalData: header};
+            parts.push(await crypto.subtle.encrypt(params, aes, plain));
+            status(`Encrypted ${Math.floor((i + 1) * 100 / count)}%`);
+        }
+        return new Blob(parts, {type: 'application/octet-stream'});
+    }
+
+    async function decrypt(data, key) {
+        const header = new Uint8Array(data, 0, Math.min(HEADER_SIZE, data.byteLength));
+        if (header.length !== HEADER_SIZE || !MAGIC.every((b, i) => header[i] === b) || header[4] !== VERSION) {
+            throw new Error('invalid stream format');
+        }
+        const aes = await segmentKey(key, header, 'decrypt');
+        const sealed = SEGMENT_SIZE + OVERHEAD;
+        const count = Math.max(1, Math.ceil((data.byteLength - HEADER_SIZE) / sealed));
+        const parts = [];
+        for (let i = 0; i < count; i++) {
+            const start = HEADER_SIZE + i * sealed;
+            const end = Math.min(start + sealed, data.byteLength);
+            const params = {name: 'AES-GCM', iv: segmentNonce(header, i, i === count - 1), additionalData: header};
+            parts.push(await crypto.subtle.decrypt(params, aes, new Uint8Array(data, start, end - start)));
+        }
+        return new Blob(parts, {type: 'application/octet-stream'});
+    }
+
+    async function readError(resp) {
+        try {
+            const body = await resp.json();
+            return new Error(body.error || resp.statusText);
+        } catch (e) {
+            return new Error(resp.statusText);
+        }
+    }
+
+    async function upload(event) {
+        event.preventDefault();
+        const file = byId('file').files[0];
+        if (!file) {
+            return;
+        }
+        const key = crypto.getRandomValues(new Uint8Array(KEY_SIZE));
+        const blob = await encrypt(file, key);
+
+        const params = new URLSearchParams();
+        for (const name of ['ttl', 'times']) {
+            const value = byId(name).value;
+            if (value) {
+                params.set(name, value);
+            }
+        }
+        status('Uploading...');
+        const resp = await fetch('/raw?' + params.toString(), {
+            method: 'POST',
+            headers: {'Content-Type': 'application/octet-stream'},
+            body: blob,
+        });
+        if (resp.status !== 201) {
+            throw await readError(resp);
+        }
+        const item = await resp.json();
+        const link = `${location.origin}/e2e?id=${encodeURIComponent(item.id)}#${encodeKey(key)}`;
+        byId('link').href = link;
+        byId('link').textContent = link;
+        byId('share').hidden = false;
+        status(`Expires ${new Date(item.expired).toLocaleString()}, max downloads ${item.times}.`);
+    }
+
+    async function download(id, key) {
+        byId('get').disabled = true;
+        status('Downloading...');
+        const resp = await fetch('/raw?id=' + encodeURIComponent(id), {cache: 'no-store'});
+        if (resp.status !== 200) {
+            throw await readError(resp);
+        }
+        const data = await resp.arrayBuffer();
+        status('Decrypting...');
+        const blob = await decrypt(data, key);
+        const save = byId('save');
+        save.href = URL.createObjectURL(blob);
+        save.download = id;
+        byId('result').hidden = false;
+        save.click();
+        status('The file is decrypted.');
+    }
+
+    function fail(err) {
+        status(err.message || String(err), true);
+    }
+
+    document.addEventListener('DOMContentLoaded', () => {
+        const id = new URLSearchParams(location.search).get('id');
+        const fragment = location.hash.slice(1);
+        if (!id || !fragment) {
+            byId('upload-form').addEventListener('submit', (event) => upload(event).catch(fail));
+            return;
+        }
+        let key;
+        try {
+            key = decodeKey(fragment);
+        } catch (err) {
+            fail(new Error('invalid key in the link'));
+            return;
+        }
+        byId('upload').hidden = true;
+        byId('download').hidden = false;
+        byId('get').addEventListener('click', () => download(id, key).catch(fail));
+    });
+})();